@@ -2,8 +2,7 @@ package bencode
 
 import (
 	"bytes"
-	"fmt"
-	"sort"
+	"reflect"
 	"strconv"
 	"unicode"
 )
@@ -11,22 +10,34 @@ import (
 // decoder is the data structure that will hold the data and position of the
 // reader throughout the package
 type decoder struct {
-	data []byte
-	pos  int
+	data  []byte
+	pos   int
+	base  int        // offset of data within the whole input, used for spans
+	depth int        // how many lists and dictionaries we're currently inside of
+	path  []pathElem // where we are in the document, for error messages
+	opts  DecodeOptions
 }
 
 // Unmarshal is the entry point for the bencode package, it accepts bencoded
-// data and returns it as a Go interface. It's the exported counterpart of the
-// decode function.
-// TODO the interface{} part is also a remnant of the codecrafters challenge,
-// might want to revisit this as it complicates things with type assertions
-func Unmarshal(data []byte) (interface{}, error) {
+// data and stores the result in the value pointed to by v. Structs are filled
+// in using their `bencode:"name,omitempty"` tags, and an *interface{} target
+// gets the dynamic representation (string, int, []interface{} and
+// map[string]interface{}), which is handy when the shape isn't known upfront.
+// Unmarshal is lenient about non-canonical input, see DecodeOptions for a
+// stricter alternative.
+func Unmarshal(data []byte, v interface{}) error {
+	return DecodeOptions{}.Unmarshal(data, v)
+}
+
+// unmarshal is shared between Unmarshal and the streaming Decoder, which
+// already has the bytes of a single value in hand
+func (d *decoder) unmarshal(v interface{}) error {
 
-	if len(data) == 0 {
-		return nil, fmt.Errorf("bencode: cannot unmarshal empty data")
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return &InvalidUnmarshalError{Type: reflect.TypeOf(v)}
 	}
-	d := &decoder{data: data, pos: 0}
-	return d.decode()
+	return d.value(rv.Elem())
 }
 
 // decode is the unexported function that matches the bencoded data with its
@@ -34,7 +45,7 @@ func Unmarshal(data []byte) (interface{}, error) {
 func (d *decoder) decode() (interface{}, error) {
 
 	if d.pos >= len(d.data) {
-		return nil, fmt.Errorf("bencode: unexpected end of input")
+		return nil, d.syntaxError(d.pos, "value", "unexpected end of input")
 	}
 
 	switch d.data[d.pos] {
@@ -48,78 +59,119 @@ func (d *decoder) decode() (interface{}, error) {
 		if unicode.IsDigit(rune(d.data[d.pos])) {
 			return d.decodeString()
 		}
-		return nil, fmt.Errorf("bencode: invalid character '%c' at position %d", d.data[d.pos], d.pos)
+		return nil, d.syntaxError(d.pos, "'i', 'l', 'd' or a string length", "invalid character '%c'", d.data[d.pos])
 	}
 }
 
 // decodeString function deals with bencoded strings
 func (d *decoder) decodeString() (string, error) {
 
+	str, err := d.readString()
+	return string(str), err
+}
+
+// readString does the actual work for decodeString, returning the contents of
+// the string without copying them out of the input
+func (d *decoder) readString() ([]byte, error) {
+
 	// we detect and save where the first colon is, as this is the delimiter
 	// for bencoded strings
 	colonIndex := bytes.IndexByte(d.data[d.pos:], ':')
 	if colonIndex == -1 {
-		return "", fmt.Errorf("bencode: invalid string format, missing colon")
+		return nil, d.syntaxError(d.pos, "':'", "invalid string format, missing colon")
 	}
 	colonIndex += d.pos
 
 	// the string we want to extract
 	lengthStr := string(d.data[d.pos:colonIndex])
+	if err := d.checkLength(lengthStr); err != nil {
+		return nil, err
+	}
 	// this is to extract the integer that codifies the string
 	length, err := strconv.Atoi(lengthStr)
-	if err != nil {
-		return "", fmt.Errorf("bencode: invalid string length '%s'", lengthStr)
+	if err != nil || length < 0 {
+		return nil, d.syntaxError(d.pos, "string length", "invalid string length '%s'", lengthStr)
+	}
+
+	if err := d.checkStringLength(length); err != nil {
+		return nil, err
 	}
 
 	start := colonIndex + 1
 	end := start + length
 	if end > len(d.data) {
-		return "", fmt.Errorf("bencode: string length exceeds data boundary")
+		return nil, d.syntaxError(len(d.data), "string data", "string length %d exceeds data boundary", length)
 	}
 
 	// the pos argument is updated with the full size of the string
 	d.pos = end
-	return string(d.data[start:end]), nil
+	return d.data[start:end], nil
 }
 
 // decodeInt function deals with bencoded integers
 func (d *decoder) decodeInt() (int, error) {
+
+	start := d.pos + 1
+	intStr, err := d.readInt()
+	if err != nil {
+		return 0, err
+	}
+
+	val, err := strconv.Atoi(intStr)
+	if err != nil {
+		return 0, d.syntaxError(start, "integer", "invalid integer value '%s'", intStr)
+	}
+	return val, nil
+}
+
+// readInt consumes a bencoded integer and returns the text between the 'i' and
+// the 'e' delimiters, leaving the conversion to the caller so that it can pick
+// the right width
+func (d *decoder) readInt() (string, error) {
 	d.pos++                                          // Skip 'i'
 	endIndex := bytes.IndexByte(d.data[d.pos:], 'e') // find the delimiter
 	if endIndex == -1 {
-		return 0, fmt.Errorf("bencode: invalid integer format, missing 'e'")
+		return "", d.syntaxError(len(d.data), "'e'", "invalid integer format, missing 'e'")
 	}
 	endIndex += d.pos // the integer is between the start and end parameters
 
 	intStr := string(d.data[d.pos:endIndex])
-	val, err := strconv.Atoi(intStr)
-	if err != nil {
-		return 0, fmt.Errorf("bencode: invalid integer value '%s'", intStr)
+	if err := d.checkInt(intStr); err != nil {
+		return "", err
 	}
 
 	// move the pos argument one to the right so that we ignore the last char
 	d.pos = endIndex + 1
-	return val, nil
+	return intStr, nil
 }
 
 // decodeList function deals with bencoded lists
 func (d *decoder) decodeList() ([]interface{}, error) {
 	d.pos++ // Skip 'l'
+	if err := d.enter(); err != nil {
+		return nil, err
+	}
 	var list []interface{}
 
 	// while not out of bounds and we haven't found the char, keep going
 	for d.pos < len(d.data) && d.data[d.pos] != 'e' {
+		if err := d.checkElements(len(list)); err != nil {
+			return nil, err
+		}
+		d.pushIndex(len(list))
 		val, err := d.decode()
 		if err != nil {
 			return nil, err
 		}
+		d.pop()
 		list = append(list, val)
 	}
 
 	if d.pos >= len(d.data) || d.data[d.pos] != 'e' {
-		return nil, fmt.Errorf("bencode: invalid list format, missing 'e'")
+		return nil, d.syntaxError(d.pos, "'e'", "invalid list format, missing 'e'")
 	}
 
+	d.depth--
 	d.pos++ // Skip 'e'
 	return list, nil
 }
@@ -127,85 +179,46 @@ func (d *decoder) decodeList() ([]interface{}, error) {
 // decodeDict function deals with bencoded dictionaries
 func (d *decoder) decodeDict() (map[string]interface{}, error) {
 	d.pos++ // Skip 'd'
+	if err := d.enter(); err != nil {
+		return nil, err
+	}
 	dict := make(map[string]interface{})
 
-	// var lastKey string // not needed if we're not checking for key ordering
+	var lastKey string
 
-	for d.pos < len(d.data) && d.data[d.pos] != 'e' {
+	for n := 0; d.pos < len(d.data) && d.data[d.pos] != 'e'; n++ {
+		if err := d.checkElements(n); err != nil {
+			return nil, err
+		}
+		keyPos := d.pos
 		key, err := d.decodeString()
 		if err != nil {
 			return nil, err
 		}
 
 		// The BitTorrent spec wants us to ensure lexicographical key order, but
-		// this creates more problems than it solves. Need to find a better way
-		// to deal with this
-
-		//if lastKey != "" && keyRaw < lastKey {
-		//	fmt.Println(fmt.Errorf("dictionary keys not in lex order: %q < %q", keyRaw, lastKey))
-		//}
-		//lastKey = keyRaw
+		// plenty of torrents in the wild get this wrong, so it's only enforced
+		// in strict mode
+		if err := d.checkKey(key, lastKey, n, keyPos); err != nil {
+			return nil, err
+		}
+		lastKey = key
 
+		d.pushKey(key)
 		val, err := d.decode()
 		if err != nil {
 			return nil, err
 		}
+		d.pop()
 		dict[key] = val
 	}
 
 	// we're out of bounds or we never found the delimiter, so we error out
 	if d.pos >= len(d.data) || d.data[d.pos] != 'e' {
-		return nil, fmt.Errorf("bencode: invalid dictionary format, missing 'e'")
+		return nil, d.syntaxError(d.pos, "'e'", "invalid dictionary format, missing 'e'")
 	}
 
+	d.depth--
 	d.pos++ // Skip 'e'
 	return dict, nil
 }
-
-// Marshal converts a Go interface into Bencoded data - this is the exported
-// shim around marshalTo
-func Marshal(v interface{}) ([]byte, error) {
-	var buf bytes.Buffer
-	err := marshalTo(&buf, v)
-	return buf.Bytes(), err
-}
-
-// marshalTo does the heavy lifting of marshaling the data depending on the type
-// assertion match
-func marshalTo(buf *bytes.Buffer, v interface{}) error {
-	switch val := v.(type) {
-	case string:
-		fmt.Fprintf(buf, "%d:%s", len(val), val)
-	case int:
-		fmt.Fprintf(buf, "i%de", val)
-	case []interface{}:
-		buf.WriteByte('l')
-		for _, item := range val {
-			if err := marshalTo(buf, item); err != nil {
-				return err
-			}
-		}
-		buf.WriteByte('e')
-	case map[string]interface{}:
-		buf.WriteByte('d')
-		// Keys must be sorted
-		keys := make([]string, 0, len(val))
-		for k := range val {
-			keys = append(keys, k)
-		}
-		sort.Strings(keys)
-
-		for _, k := range keys {
-			// Marshal key (must be a string)
-			fmt.Fprintf(buf, "%d:%s", len(k), k)
-			// Marshal value
-			if err := marshalTo(buf, val[k]); err != nil {
-				return err
-			}
-		}
-		buf.WriteByte('e')
-	default:
-		return fmt.Errorf("bencode: unsupported type for marshaling: %T", v)
-	}
-	return nil
-}
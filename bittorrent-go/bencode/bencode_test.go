@@ -18,7 +18,8 @@ func TestUnmarshalString(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result, err := Unmarshal([]byte(tt.input))
+			var result interface{}
+			err := Unmarshal([]byte(tt.input), &result)
 			if err != nil {
 				t.Fatalf("unexpected error: %v", err)
 			}
@@ -42,7 +43,8 @@ func TestUnmarshalInteger(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result, err := Unmarshal([]byte(tt.input))
+			var result interface{}
+			err := Unmarshal([]byte(tt.input), &result)
 			if err != nil {
 				t.Fatalf("unexpected error: %v", err)
 			}
@@ -66,7 +68,8 @@ func TestUnmarshalList(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result, err := Unmarshal([]byte(tt.input))
+			var result interface{}
+			err := Unmarshal([]byte(tt.input), &result)
 			if err != nil {
 				t.Fatalf("unexpected error: %v", err)
 			}
@@ -99,7 +102,8 @@ func TestUnmarshalDict(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result, err := Unmarshal([]byte(tt.input))
+			var result interface{}
+			err := Unmarshal([]byte(tt.input), &result)
 			if err != nil {
 				t.Fatalf("unexpected error: %v", err)
 			}
@@ -125,7 +129,8 @@ func TestUnmarshalErrors(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			_, err := Unmarshal([]byte(tt.input))
+			var result interface{}
+			err := Unmarshal([]byte(tt.input), &result)
 			if err == nil {
 				t.Errorf("expected error for input %q", tt.input)
 			}
@@ -179,7 +184,8 @@ func TestRoundTrip(t *testing.T) {
 			if err != nil {
 				t.Fatalf("marshal error: %v", err)
 			}
-			decoded, err := Unmarshal(encoded)
+			var decoded interface{}
+			err = Unmarshal(encoded, &decoded)
 			if err != nil {
 				t.Fatalf("unmarshal error: %v", err)
 			}
@@ -0,0 +1,615 @@
+package bencode
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Format selects what Convert turns bencoded data into.
+type Format int
+
+const (
+	// FormatJSON is plain JSON for people and tools to read. Strings that
+	// aren't valid UTF-8, like piece hashes or compact peer lists, become
+	// hex or base64 strings, so there's no telling them apart from text.
+	FormatJSON Format = iota
+	// FormatRaw is a reversible JSON form that FromRaw turns back into
+	// bencode. Binary strings become {"$hex": ...} or {"$base64": ...}
+	// objects and dictionary keys keep their original order. Dictionaries
+	// that would be mistaken for one of those, or that have binary keys,
+	// are written as {"$dict": [[key, value], ...]}.
+	FormatRaw
+	// FormatTree is an indented, human readable tree. Long binary strings
+	// are cut short.
+	FormatTree
+)
+
+// ParseFormat maps the names used on the command line, "json", "raw" and
+// "tree", to a Format.
+func ParseFormat(name string) (Format, error) {
+	switch name {
+	case "json":
+		return FormatJSON, nil
+	case "raw":
+		return FormatRaw, nil
+	case "tree":
+		return FormatTree, nil
+	}
+	return 0, fmt.Errorf("bencode: unknown format %q", name)
+}
+
+// BinaryEncoding selects how strings that aren't valid UTF-8 are shown.
+type BinaryEncoding int
+
+const (
+	Hex BinaryEncoding = iota
+	Base64
+)
+
+// ConvertOptions configures Convert.
+type ConvertOptions struct {
+	Format Format
+	Binary BinaryEncoding
+	// Indent, if set, is used to indent the JSON formats, one level per
+	// nesting level. The tree format is always indented.
+	Indent string
+}
+
+// treeBinaryLimit is how many bytes of a binary string the tree format shows
+const treeBinaryLimit = 20
+
+// Convert reads a single bencoded value from data and writes it to w in the
+// given format, followed by a newline. The input is walked in place, so keys
+// come out in the order they were found in.
+func Convert(w io.Writer, data []byte, opts ConvertOptions) error {
+
+	if len(data) == 0 {
+		return fmt.Errorf("bencode: cannot convert empty data")
+	}
+
+	var out bytes.Buffer
+	d := &decoder{data: data}
+	var err error
+	switch opts.Format {
+	case FormatJSON, FormatRaw:
+		err = d.jsonValue(&out, opts)
+	case FormatTree:
+		err = d.treeValue(&treeWriter{buf: &out}, 0, opts)
+	default:
+		err = fmt.Errorf("bencode: unknown format %d", opts.Format)
+	}
+	if err != nil {
+		return err
+	}
+
+	if opts.Indent != "" && opts.Format != FormatTree {
+		var indented bytes.Buffer
+		if err := json.Indent(&indented, out.Bytes(), "", opts.Indent); err != nil {
+			return err
+		}
+		out = indented
+	}
+	out.WriteByte('\n')
+
+	_, err = out.WriteTo(w)
+	return err
+}
+
+// jsonValue writes the next value as JSON, for both JSON formats
+func (d *decoder) jsonValue(buf writer, opts ConvertOptions) error {
+
+	if d.pos >= len(d.data) {
+		return d.syntaxError(d.pos, "value", "unexpected end of input")
+	}
+
+	switch d.data[d.pos] {
+	case 'i':
+		n, err := d.bigInt()
+		if err != nil {
+			return err
+		}
+		buf.WriteString(n)
+	case 'l':
+		if err := d.enter(); err != nil {
+			return err
+		}
+		d.pos++ // Skip 'l'
+		buf.WriteByte('[')
+		for n := 0; d.pos < len(d.data) && d.data[d.pos] != 'e'; n++ {
+			if err := d.checkElements(n); err != nil {
+				return err
+			}
+			if n > 0 {
+				buf.WriteByte(',')
+			}
+			d.pushIndex(n)
+			if err := d.jsonValue(buf, opts); err != nil {
+				return err
+			}
+			d.pop()
+		}
+		if d.pos >= len(d.data) {
+			return d.syntaxError(d.pos, "'e'", "invalid list format, missing 'e'")
+		}
+		d.depth--
+		d.pos++ // Skip 'e'
+		buf.WriteByte(']')
+	case 'd':
+		return d.jsonDict(buf, opts)
+	default:
+		if !unicode.IsDigit(rune(d.data[d.pos])) {
+			return d.syntaxError(d.pos, "'i', 'l', 'd' or a string length", "invalid character '%c'", d.data[d.pos])
+		}
+		str, err := d.readString()
+		if err != nil {
+			return err
+		}
+		writeJSONBytes(buf, str, opts)
+	}
+	return nil
+}
+
+// jsonDict writes a dictionary as a JSON object, or in the escaped $dict
+// form when the reversible format needs it
+func (d *decoder) jsonDict(buf writer, opts ConvertOptions) error {
+
+	escape := false
+	if opts.Format == FormatRaw {
+		var err error
+		if escape, err = d.needsDictEscape(); err != nil {
+			return err
+		}
+	}
+
+	if err := d.enter(); err != nil {
+		return err
+	}
+	d.pos++ // Skip 'd'
+
+	if escape {
+		buf.WriteString(`{"$dict":[`)
+	} else {
+		buf.WriteByte('{')
+	}
+
+	for n := 0; d.pos < len(d.data) && d.data[d.pos] != 'e'; n++ {
+		if err := d.checkElements(n); err != nil {
+			return err
+		}
+		key, err := d.readString()
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			buf.WriteByte(',')
+		}
+
+		if escape {
+			buf.WriteByte('[')
+			writeJSONBytes(buf, key, opts)
+			buf.WriteByte(',')
+		} else if utf8.Valid(key) {
+			writeJSONString(buf, string(key))
+			buf.WriteByte(':')
+		} else {
+			// only plain JSON gets here, the key is shown encoded
+			writeJSONString(buf, encodeBinary(key, opts.Binary))
+			buf.WriteByte(':')
+		}
+
+		d.pushKey(string(key))
+		if err := d.jsonValue(buf, opts); err != nil {
+			return err
+		}
+		d.pop()
+		if escape {
+			buf.WriteByte(']')
+		}
+	}
+
+	if d.pos >= len(d.data) {
+		return d.syntaxError(d.pos, "'e'", "invalid dictionary format, missing 'e'")
+	}
+	d.depth--
+	d.pos++ // Skip 'e'
+
+	if escape {
+		buf.WriteString("]}")
+	} else {
+		buf.WriteByte('}')
+	}
+	return nil
+}
+
+// needsDictEscape looks ahead at the keys of the dictionary starting at
+// d.pos, and reports whether writing it as a plain JSON object would lose
+// information: either a key isn't valid UTF-8, or its first key is one that
+// FromRaw would take for one of the escapes, however many keys follow it.
+func (d *decoder) needsDictEscape() (bool, error) {
+
+	ahead := &decoder{data: d.data, pos: d.pos + 1, base: d.base, opts: d.opts}
+	first := true
+	for ahead.pos < len(ahead.data) && ahead.data[ahead.pos] != 'e' {
+		key, err := ahead.readString()
+		if err != nil {
+			// the error is reported properly once we get to it for real
+			return false, nil
+		}
+		if !utf8.Valid(key) {
+			return true, nil
+		}
+		if first {
+			switch string(key) {
+			case "$hex", "$base64", "$dict":
+				return true, nil
+			}
+			first = false
+		}
+		if err := ahead.skip(); err != nil {
+			return false, nil
+		}
+	}
+	return false, nil
+}
+
+// bigInt reads an integer of any size and returns it in its canonical form,
+// which is also a valid JSON number
+func (d *decoder) bigInt() (string, error) {
+
+	start := d.pos + 1
+	intStr, err := d.readInt()
+	if err != nil {
+		return "", err
+	}
+	n, ok := new(big.Int).SetString(intStr, 10)
+	if !ok {
+		return "", d.syntaxError(start, "integer", "invalid integer value '%s'", intStr)
+	}
+	return n.String(), nil
+}
+
+// writeJSONBytes writes a bencoded string as JSON. Valid UTF-8 is written as
+// a JSON string, anything else is encoded, and for the reversible format
+// wrapped so that FromRaw knows to decode it.
+func writeJSONBytes(buf writer, str []byte, opts ConvertOptions) {
+
+	if utf8.Valid(str) {
+		writeJSONString(buf, string(str))
+		return
+	}
+
+	encoded := encodeBinary(str, opts.Binary)
+	if opts.Format != FormatRaw {
+		writeJSONString(buf, encoded)
+		return
+	}
+
+	if opts.Binary == Base64 {
+		buf.WriteString(`{"$base64":`)
+	} else {
+		buf.WriteString(`{"$hex":`)
+	}
+	writeJSONString(buf, encoded)
+	buf.WriteByte('}')
+}
+
+// writeJSONString writes s as a JSON string, without the HTML escaping that
+// json.Marshal does, since these end up on a terminal and not in a web page
+func writeJSONString(buf writer, s string) {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s) // a string can't fail to encode
+	buf.Write(bytes.TrimSuffix(b.Bytes(), []byte("\n")))
+}
+
+// encodeBinary encodes binary data for display
+func encodeBinary(b []byte, enc BinaryEncoding) string {
+	if enc == Base64 {
+		return base64.StdEncoding.EncodeToString(b)
+	}
+	return hex.EncodeToString(b)
+}
+
+// treeWriter writes the tree format, one line per element
+type treeWriter struct {
+	buf *bytes.Buffer
+}
+
+// line starts a new line at the given indentation level, after whatever
+// prefix, like "- " or "key: ", the element before it left on the previous
+// one. The very first line doesn't need a line break.
+func (tw *treeWriter) line(indent int) {
+	if tw.buf.Len() > 0 {
+		tw.buf.WriteByte('\n')
+	}
+	tw.buf.WriteString(strings.Repeat("  ", indent))
+}
+
+// treePrefix writes what goes in front of a list element or dictionary value,
+// leaving out the trailing space when the value itself starts on a new line
+func (d *decoder) treePrefix(tw *treeWriter, prefix string) {
+	tw.buf.WriteString(prefix)
+	if d.pos+1 < len(d.data) && (d.data[d.pos] == 'l' || d.data[d.pos] == 'd') && d.data[d.pos+1] != 'e' {
+		return
+	}
+	tw.buf.WriteByte(' ')
+}
+
+// treeValue writes the next value in the tree format. Scalars go on the
+// current line, the elements of lists and dictionaries each get their own.
+func (d *decoder) treeValue(tw *treeWriter, indent int, opts ConvertOptions) error {
+
+	if d.pos >= len(d.data) {
+		return d.syntaxError(d.pos, "value", "unexpected end of input")
+	}
+	switch d.data[d.pos] {
+	case 'i':
+		n, err := d.bigInt()
+		if err != nil {
+			return err
+		}
+		tw.buf.WriteString(n)
+	case 'l':
+		if err := d.enter(); err != nil {
+			return err
+		}
+		d.pos++ // Skip 'l'
+		if d.pos < len(d.data) && d.data[d.pos] == 'e' {
+			tw.buf.WriteString("[]")
+		}
+		for n := 0; d.pos < len(d.data) && d.data[d.pos] != 'e'; n++ {
+			if err := d.checkElements(n); err != nil {
+				return err
+			}
+			tw.line(indent)
+			d.treePrefix(tw, "-")
+			d.pushIndex(n)
+			if err := d.treeValue(tw, indent+1, opts); err != nil {
+				return err
+			}
+			d.pop()
+		}
+		if d.pos >= len(d.data) {
+			return d.syntaxError(d.pos, "'e'", "invalid list format, missing 'e'")
+		}
+		d.depth--
+		d.pos++ // Skip 'e'
+	case 'd':
+		if err := d.enter(); err != nil {
+			return err
+		}
+		d.pos++ // Skip 'd'
+		if d.pos < len(d.data) && d.data[d.pos] == 'e' {
+			tw.buf.WriteString("{}")
+		}
+		for n := 0; d.pos < len(d.data) && d.data[d.pos] != 'e'; n++ {
+			if err := d.checkElements(n); err != nil {
+				return err
+			}
+			key, err := d.readString()
+			if err != nil {
+				return err
+			}
+			tw.line(indent)
+			if isPrintable(key) {
+				tw.buf.Write(key)
+			} else {
+				tw.buf.WriteString(treeBinary(key, opts.Binary))
+			}
+			d.treePrefix(tw, ":")
+			d.pushKey(string(key))
+			if err := d.treeValue(tw, indent+1, opts); err != nil {
+				return err
+			}
+			d.pop()
+		}
+		if d.pos >= len(d.data) {
+			return d.syntaxError(d.pos, "'e'", "invalid dictionary format, missing 'e'")
+		}
+		d.depth--
+		d.pos++ // Skip 'e'
+	default:
+		if !unicode.IsDigit(rune(d.data[d.pos])) {
+			return d.syntaxError(d.pos, "'i', 'l', 'd' or a string length", "invalid character '%c'", d.data[d.pos])
+		}
+		str, err := d.readString()
+		if err != nil {
+			return err
+		}
+		if isPrintable(str) {
+			tw.buf.WriteString(strconv.Quote(string(str)))
+		} else {
+			tw.buf.WriteString(treeBinary(str, opts.Binary))
+		}
+	}
+	return nil
+}
+
+// treeBinary shows a binary string in the tree format, e.g.
+// <40 bytes> 0123456789abcdef0123456789abcdef01234567...
+func treeBinary(b []byte, enc BinaryEncoding) string {
+	shown := b
+	if len(shown) > treeBinaryLimit {
+		shown = shown[:treeBinaryLimit]
+	}
+	s := fmt.Sprintf("<%d bytes> %s", len(b), encodeBinary(shown, enc))
+	if len(shown) < len(b) {
+		s += "..."
+	}
+	return s
+}
+
+// isPrintable reports whether b is UTF-8 text without control characters
+func isPrintable(b []byte) bool {
+	if !utf8.Valid(b) {
+		return false
+	}
+	for _, r := range string(b) {
+		if !unicode.IsPrint(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// FromRaw turns the reversible JSON produced by Convert with FormatRaw back
+// into bencode. For input that Strict decoding accepts, the result is
+// identical to what was converted in the first place.
+func FromRaw(data []byte) ([]byte, error) {
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var buf bytes.Buffer
+	if err := rawJSONValue(dec, &buf); err != nil {
+		return nil, fmt.Errorf("bencode: invalid raw JSON: %w", err)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("bencode: invalid raw JSON: trailing data after value")
+	}
+	return buf.Bytes(), nil
+}
+
+// rawJSONValue reads one JSON value off dec and writes its bencoded form
+func rawJSONValue(dec *json.Decoder, buf *bytes.Buffer) error {
+
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+
+	switch tok := tok.(type) {
+	case json.Number:
+		n, ok := new(big.Int).SetString(tok.String(), 10)
+		if !ok {
+			return fmt.Errorf("%s is not an integer", tok)
+		}
+		buf.WriteString("i" + n.String() + "e")
+	case string:
+		writeString(buf, tok)
+	case json.Delim:
+		if tok == '[' {
+			buf.WriteByte('l')
+			for dec.More() {
+				if err := rawJSONValue(dec, buf); err != nil {
+					return err
+				}
+			}
+			dec.Token() // ']'
+			buf.WriteByte('e')
+			return nil
+		}
+		return rawJSONObject(dec, buf)
+	default:
+		return fmt.Errorf("unexpected %v", tok)
+	}
+	return nil
+}
+
+// rawJSONObject deals with JSON objects, which are either dictionaries or one
+// of the $hex, $base64 and $dict escapes. The object's opening brace has
+// already been read.
+func rawJSONObject(dec *json.Decoder, buf *bytes.Buffer) error {
+
+	if !dec.More() {
+		dec.Token() // '}'
+		buf.WriteString("de")
+		return nil
+	}
+
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	first := tok.(string) // object keys are always strings
+
+	switch first {
+	case "$hex", "$base64":
+		var encoded string
+		if err := dec.Decode(&encoded); err != nil {
+			return err
+		}
+		b, err := decodeBinary(first, encoded)
+		if err != nil {
+			return err
+		}
+		writeString(buf, string(b))
+		return closeObject(dec)
+	case "$dict":
+		buf.WriteByte('d')
+		if tok, err := dec.Token(); err != nil || tok != json.Delim('[') {
+			return errors.New("$dict must hold a list of pairs")
+		}
+		for dec.More() {
+			if tok, err := dec.Token(); err != nil || tok != json.Delim('[') {
+				return errors.New("$dict must hold a list of pairs")
+			}
+			var key bytes.Buffer
+			if err := rawJSONValue(dec, &key); err != nil {
+				return err
+			}
+			if key.Len() == 0 || key.Bytes()[0] < '0' || key.Bytes()[0] > '9' {
+				return errors.New("$dict keys must be strings")
+			}
+			buf.Write(key.Bytes())
+			if err := rawJSONValue(dec, buf); err != nil {
+				return err
+			}
+			if tok, err := dec.Token(); err != nil || tok != json.Delim(']') {
+				return errors.New("$dict pairs must have exactly two elements")
+			}
+		}
+		dec.Token() // ']'
+		buf.WriteByte('e')
+		return closeObject(dec)
+	}
+
+	// a plain dictionary, with the first key already in hand
+	buf.WriteByte('d')
+	writeString(buf, first)
+	if err := rawJSONValue(dec, buf); err != nil {
+		return err
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		writeString(buf, tok.(string))
+		if err := rawJSONValue(dec, buf); err != nil {
+			return err
+		}
+	}
+	dec.Token() // '}'
+	buf.WriteByte('e')
+	return nil
+}
+
+// closeObject consumes the closing brace of an escape object, which must not
+// have any other keys
+func closeObject(dec *json.Decoder) error {
+	if dec.More() {
+		return errors.New("escape objects must have a single key")
+	}
+	_, err := dec.Token()
+	return err
+}
+
+// decodeBinary undoes a $hex or $base64 escape
+func decodeBinary(escape, encoded string) ([]byte, error) {
+	if escape == "$base64" {
+		return base64.StdEncoding.DecodeString(encoded)
+	}
+	return hex.DecodeString(encoded)
+}
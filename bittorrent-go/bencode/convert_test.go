@@ -0,0 +1,210 @@
+package bencode
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestConvertJSON(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		opts     ConvertOptions
+		expected string
+	}{
+		{"string", "4:spam", ConvertOptions{}, `"spam"`},
+		{"integer", "i-42e", ConvertOptions{}, `-42`},
+		{"big integer", "i123456789012345678901234567890e", ConvertOptions{}, `123456789012345678901234567890`},
+		{"list", "l4:spami42ee", ConvertOptions{}, `["spam",42]`},
+		{"empty list", "le", ConvertOptions{}, `[]`},
+		{"empty dict", "de", ConvertOptions{}, `{}`},
+		{"key order kept", "d1:bi1e1:ai2ee", ConvertOptions{}, `{"b":1,"a":2}`},
+		{"no html escaping", "10:a<b>&c=d/e", ConvertOptions{}, `"a<b>&c=d/e"`},
+		{"binary as hex", "d6:pieces4:\x00\xff\x01\x02e", ConvertOptions{}, `{"pieces":"00ff0102"}`},
+		{"binary as base64", "d6:pieces4:\x00\xff\x01\x02e", ConvertOptions{Binary: Base64}, `{"pieces":"AP8BAg=="}`},
+		{"binary key", "d2:\xff\xfei1ee", ConvertOptions{}, `{"fffe":1}`},
+		{"indented", "d1:ali1eee", ConvertOptions{Indent: " "}, "{\n \"a\": [\n  1\n ]\n}"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := Convert(&out, []byte(tt.input), tt.opts); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if out.String() != tt.expected+"\n" {
+				t.Errorf("expected %q, got %q", tt.expected+"\n", out.String())
+			}
+		})
+	}
+}
+
+func TestConvertRaw(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		binary   BinaryEncoding
+		expected string
+	}{
+		{"text stays plain", "d4:name3:abce", Hex, `{"name":"abc"}`},
+		{"binary as hex", "4:\x00\xff\x01\x02", Hex, `{"$hex":"00ff0102"}`},
+		{"binary as base64", "4:\x00\xff\x01\x02", Base64, `{"$base64":"AP8BAg=="}`},
+		{"binary key", "d2:\xff\xfei1ee", Hex, `{"$dict":[[{"$hex":"fffe"},1]]}`},
+		{"dict that looks like an escape", "d4:$hex4:abcde", Hex, `{"$dict":[["$hex","abcd"]]}`},
+		{"escape key first among others", "d4:$hex1:a1:bi1ee", Hex, `{"$dict":[["$hex","a"],["b",1]]}`},
+		{"escape key after others", "d1:bi1e4:$hex1:ae", Hex, `{"b":1,"$hex":"a"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := Convert(&out, []byte(tt.input), ConvertOptions{Format: FormatRaw, Binary: tt.binary}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if out.String() != tt.expected+"\n" {
+				t.Errorf("expected %q, got %q", tt.expected+"\n", out.String())
+			}
+		})
+	}
+}
+
+func TestFromRawRoundTrip(t *testing.T) {
+	inputs := []string{
+		"4:spam",
+		"i-42e",
+		"i123456789012345678901234567890e",
+		"le",
+		"de",
+		"d1:bi1e1:ai2ee", // not sorted, which must survive the trip
+		"d6:pieces40:" + strings.Repeat("\x00\xff", 20) + "4:name4:teste",
+		"d2:\xff\xfei1e1:al0:ee",
+		"d5:$dictdee",
+		"ld4:$hex0:ed7:$base640:ee",
+		"d4:$hex2:ab1:bi1ee",
+		"d4:$hexi1e1:bi1ee",
+	}
+
+	for _, input := range inputs {
+		for _, binary := range []BinaryEncoding{Hex, Base64} {
+			var out bytes.Buffer
+			opts := ConvertOptions{Format: FormatRaw, Binary: binary, Indent: "  "}
+			if err := Convert(&out, []byte(input), opts); err != nil {
+				t.Fatalf("unexpected error converting %q: %v", input, err)
+			}
+			back, err := FromRaw(out.Bytes())
+			if err != nil {
+				t.Fatalf("unexpected error converting %q back: %v", out.String(), err)
+			}
+			if string(back) != input {
+				t.Errorf("expected %q, got %q", input, back)
+			}
+		}
+	}
+}
+
+func TestFromRawErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"float", `1.5`},
+		{"bool", `true`},
+		{"null", `null`},
+		{"bad hex", `{"$hex":"zz"}`},
+		{"escape with extra keys", `{"$hex":"00","a":1}`},
+		{"dict of non pairs", `{"$dict":[1]}`},
+		{"dict with integer key", `{"$dict":[[1,2]]}`},
+		{"dict pair too long", `{"$dict":[["a",1,2]]}`},
+		{"trailing data", `1 2`},
+		{"truncated", `[1,`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := FromRaw([]byte(tt.input)); err == nil {
+				t.Errorf("expected error for %s", tt.input)
+			}
+		})
+	}
+}
+
+func TestConvertTree(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"scalar", "i3e", "3"},
+		{"string", "5:hello", `"hello"`},
+		{
+			"nested",
+			"d4:infod6:lengthi10e4:name1:xe5:listsll1:ai1eeleee",
+			"info:\n  length: 10\n  name: \"x\"\nlists:\n  -\n    - \"a\"\n    - 1\n  - []",
+		},
+		{"empty dict value", "d1:adee", "a: {}"},
+		{"short binary", "3:\x00\x01\x02", "<3 bytes> 000102"},
+		{
+			"long binary is cut short",
+			"d6:pieces40:" + strings.Repeat("\xab", 40) + "e",
+			"pieces: <40 bytes> " + strings.Repeat("ab", 20) + "...",
+		},
+		{"control characters", "2:a\n", "<2 bytes> 610a"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := Convert(&out, []byte(tt.input), ConvertOptions{Format: FormatTree}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if out.String() != tt.expected+"\n" {
+				t.Errorf("expected\n%s\ngot\n%s", tt.expected, out.String())
+			}
+		})
+	}
+}
+
+func TestConvertErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		offset int
+	}{
+		{"unterminated list", "l4:spam", 7},
+		{"invalid character", "d1:ax", 4},
+		{"bad integer", "i1x2e", 1},
+	}
+
+	for _, format := range []Format{FormatJSON, FormatRaw, FormatTree} {
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				err := Convert(&bytes.Buffer{}, []byte(tt.input), ConvertOptions{Format: format})
+				var syntaxErr *SyntaxError
+				if !errors.As(err, &syntaxErr) {
+					t.Fatalf("expected *SyntaxError, got %v", err)
+				}
+				if syntaxErr.Offset != tt.offset {
+					t.Errorf("expected offset %d, got %d", tt.offset, syntaxErr.Offset)
+				}
+			})
+		}
+	}
+
+	if err := Convert(&bytes.Buffer{}, nil, ConvertOptions{}); err == nil {
+		t.Error("expected error for empty input")
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	for name, expected := range map[string]Format{"json": FormatJSON, "raw": FormatRaw, "tree": FormatTree} {
+		format, err := ParseFormat(name)
+		if err != nil || format != expected {
+			t.Errorf("expected %d for %q, got %d (%v)", expected, name, format, err)
+		}
+	}
+	if _, err := ParseFormat("xml"); err == nil {
+		t.Error("expected error for unknown format")
+	}
+}
@@ -0,0 +1,376 @@
+package bencode
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"unicode"
+)
+
+// InvalidUnmarshalError is returned when Unmarshal is handed something other
+// than a non-nil pointer, since there would be nowhere to store the result.
+type InvalidUnmarshalError struct {
+	Type reflect.Type
+}
+
+func (e *InvalidUnmarshalError) Error() string {
+	if e.Type == nil {
+		return "bencode: Unmarshal(nil)"
+	}
+	if e.Type.Kind() != reflect.Pointer {
+		return "bencode: Unmarshal(non-pointer " + e.Type.String() + ")"
+	}
+	return "bencode: Unmarshal(nil " + e.Type.String() + ")"
+}
+
+// UnmarshalTypeError describes a bencoded value that can't be stored in the
+// Go value it was matched against, e.g. a list going into a string field.
+type UnmarshalTypeError struct {
+	Value  string       // kind of bencoded value: "string", "integer", "list" or "dictionary"
+	Type   reflect.Type // type of the Go value it could not be assigned to
+	Offset int          // where the value starts in the input
+	Path   string       // logical location, as in SyntaxError
+}
+
+func (e *UnmarshalTypeError) Error() string {
+	s := "bencode: cannot unmarshal " + e.Value + " into Go value of type " + e.Type.String()
+	if e.Path != "" {
+		s += " at " + e.Path
+	}
+	return s
+}
+
+// typeError builds an *UnmarshalTypeError for a value starting at pos
+func (d *decoder) typeError(value string, t reflect.Type, pos int) *UnmarshalTypeError {
+	return &UnmarshalTypeError{Value: value, Type: t, Offset: d.base + pos, Path: d.pathString()}
+}
+
+// value decodes the next bencoded value straight into v, which must be
+// settable. It's the reflective counterpart of decode.
+func (d *decoder) value(v reflect.Value) error {
+
+	if d.pos >= len(d.data) {
+		return d.syntaxError(d.pos, "value", "unexpected end of input")
+	}
+
+	// follow pointers all the way down, unless something along the way knows
+	// how to decode itself
+	u, tu, v := indirect(v)
+	if u != nil {
+		return d.unmarshalerValue(u)
+	}
+	if tu != nil {
+		return d.textUnmarshalerValue(tu)
+	}
+
+	// an empty interface gets the same dynamic values that decode produces
+	if v.Kind() == reflect.Interface && v.NumMethod() == 0 {
+		val, err := d.decode()
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(val))
+		return nil
+	}
+
+	switch d.data[d.pos] {
+	case 'i':
+		return d.intValue(v)
+	case 'l':
+		return d.listValue(v)
+	case 'd':
+		return d.dictValue(v)
+	default:
+		if unicode.IsDigit(rune(d.data[d.pos])) {
+			return d.stringValue(v)
+		}
+		return d.syntaxError(d.pos, "'i', 'l', 'd' or a string length", "invalid character '%c'", d.data[d.pos])
+	}
+}
+
+// intValue stores a bencoded integer into any of the integer kinds, checking
+// that it actually fits, or into a bool, which is encoded as 0 or 1
+func (d *decoder) intValue(v reflect.Value) error {
+
+	start := d.pos + 1
+	intStr, err := d.readInt()
+	if err != nil {
+		return err
+	}
+
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(intStr, 10, 64)
+		if err != nil {
+			return d.syntaxError(start, "integer", "invalid integer value '%s'", intStr)
+		}
+		if v.OverflowInt(n) {
+			return d.typeError("integer "+intStr, v.Type(), start)
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, err := strconv.ParseUint(intStr, 10, 64)
+		if err != nil {
+			if _, serr := strconv.ParseInt(intStr, 10, 64); serr == nil {
+				return d.typeError("integer "+intStr, v.Type(), start)
+			}
+			return d.syntaxError(start, "integer", "invalid integer value '%s'", intStr)
+		}
+		if v.OverflowUint(n) {
+			return d.typeError("integer "+intStr, v.Type(), start)
+		}
+		v.SetUint(n)
+	case reflect.Bool:
+		switch intStr {
+		case "0":
+			v.SetBool(false)
+		case "1":
+			v.SetBool(true)
+		default:
+			return d.typeError("integer "+intStr, v.Type(), start)
+		}
+	default:
+		return d.typeError("integer", v.Type(), start)
+	}
+	return nil
+}
+
+// stringValue stores a bencoded string into a string kind, a byte slice or a
+// byte array of exactly the right length
+func (d *decoder) stringValue(v reflect.Value) error {
+
+	start := d.pos
+	str, err := d.readString()
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case v.Kind() == reflect.String:
+		v.SetString(string(str))
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8:
+		v.SetBytes(append([]byte{}, str...))
+	case v.Kind() == reflect.Array && v.Type().Elem().Kind() == reflect.Uint8:
+		if len(str) != v.Len() {
+			return d.typeError(fmt.Sprintf("string of length %d", len(str)), v.Type(), start)
+		}
+		reflect.Copy(v, reflect.ValueOf(str))
+	default:
+		return d.typeError("string", v.Type(), start)
+	}
+	return nil
+}
+
+// listValue fills a slice or an array with the elements of a bencoded list.
+// Arrays take as many elements as they can hold and the rest are skipped.
+func (d *decoder) listValue(v reflect.Value) error {
+
+	kind := v.Kind()
+	if kind != reflect.Slice && kind != reflect.Array {
+		return d.typeError("list", v.Type(), d.pos)
+	}
+
+	d.pos++ // Skip 'l'
+	if err := d.enter(); err != nil {
+		return err
+	}
+	elemType := v.Type().Elem()
+	var slice reflect.Value
+	if kind == reflect.Slice {
+		slice = reflect.MakeSlice(v.Type(), 0, 0)
+	}
+	i := 0
+
+	for n := 0; d.pos < len(d.data) && d.data[d.pos] != 'e'; n++ {
+		if err := d.checkElements(n); err != nil {
+			return err
+		}
+		if kind == reflect.Array && i >= v.Len() {
+			d.pushIndex(n)
+			if err := d.skip(); err != nil {
+				return err
+			}
+			d.pop()
+			continue
+		}
+
+		elem := reflect.New(elemType).Elem()
+		d.pushIndex(n)
+		if err := d.value(elem); err != nil {
+			return err
+		}
+		d.pop()
+		if kind == reflect.Array {
+			v.Index(i).Set(elem)
+		} else {
+			slice = reflect.Append(slice, elem)
+		}
+		i++
+	}
+
+	if d.pos >= len(d.data) || d.data[d.pos] != 'e' {
+		return d.syntaxError(d.pos, "'e'", "invalid list format, missing 'e'")
+	}
+	d.depth--
+	d.pos++ // Skip 'e'
+
+	if kind == reflect.Slice {
+		v.Set(slice)
+		return nil
+	}
+	// zero whatever the list didn't reach
+	for ; i < v.Len(); i++ {
+		v.Index(i).SetZero()
+	}
+	return nil
+}
+
+// dictValue fills either a map with string keys or a struct, matching the
+// dictionary keys against the struct's field names. Keys that don't match any
+// field are skipped.
+func (d *decoder) dictValue(v reflect.Value) error {
+
+	var fields structFields
+	switch v.Kind() {
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return d.typeError("dictionary", v.Type(), d.pos)
+		}
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+	case reflect.Struct:
+		fields = cachedFields(v.Type())
+	default:
+		return d.typeError("dictionary", v.Type(), d.pos)
+	}
+
+	d.pos++ // Skip 'd'
+	if err := d.enter(); err != nil {
+		return err
+	}
+	var lastKey string
+
+	for n := 0; d.pos < len(d.data) && d.data[d.pos] != 'e'; n++ {
+		if err := d.checkElements(n); err != nil {
+			return err
+		}
+		keyPos := d.pos
+		key, err := d.decodeString()
+		if err != nil {
+			return err
+		}
+		if err := d.checkKey(key, lastKey, n, keyPos); err != nil {
+			return err
+		}
+		lastKey = key
+
+		d.pushKey(key)
+		if v.Kind() == reflect.Map {
+			elem := reflect.New(v.Type().Elem()).Elem()
+			if err := d.value(elem); err != nil {
+				return err
+			}
+			d.pop()
+			v.SetMapIndex(reflect.ValueOf(key).Convert(v.Type().Key()), elem)
+			continue
+		}
+
+		start := d.pos
+		if f, ok := fields.byName[key]; ok {
+			err = d.value(v.FieldByIndex(f.index))
+		} else {
+			err = d.skip()
+		}
+		if err != nil {
+			return err
+		}
+		d.pop()
+
+		// fill in any span fields that asked for this key
+		for _, index := range fields.spans[key] {
+			span := Span{Start: d.base + start, End: d.base + d.pos}
+			v.FieldByIndex(index).Set(reflect.ValueOf(span))
+		}
+	}
+
+	if d.pos >= len(d.data) || d.data[d.pos] != 'e' {
+		return d.syntaxError(d.pos, "'e'", "invalid dictionary format, missing 'e'")
+	}
+
+	d.depth--
+	d.pos++ // Skip 'e'
+	return nil
+}
+
+// skip moves past the next value without building anything out of it
+func (d *decoder) skip() error {
+
+	if d.pos >= len(d.data) {
+		return d.syntaxError(d.pos, "value", "unexpected end of input")
+	}
+
+	switch d.data[d.pos] {
+	case 'i':
+		_, err := d.readInt()
+		return err
+	case 'l':
+		d.pos++ // Skip 'l'
+		if err := d.enter(); err != nil {
+			return err
+		}
+		for n := 0; d.pos < len(d.data) && d.data[d.pos] != 'e'; n++ {
+			if err := d.checkElements(n); err != nil {
+				return err
+			}
+			d.pushIndex(n)
+			if err := d.skip(); err != nil {
+				return err
+			}
+			d.pop()
+		}
+		if d.pos >= len(d.data) {
+			return d.syntaxError(d.pos, "'e'", "invalid list format, missing 'e'")
+		}
+		d.depth--
+		d.pos++ // Skip 'e'
+		return nil
+	case 'd':
+		d.pos++ // Skip 'd'
+		if err := d.enter(); err != nil {
+			return err
+		}
+		var lastKey string
+		for n := 0; d.pos < len(d.data) && d.data[d.pos] != 'e'; n++ {
+			if err := d.checkElements(n); err != nil {
+				return err
+			}
+			keyPos := d.pos
+			key, err := d.decodeString()
+			if err != nil {
+				return err
+			}
+			if err := d.checkKey(key, lastKey, n, keyPos); err != nil {
+				return err
+			}
+			lastKey = key
+			d.pushKey(key)
+			if err := d.skip(); err != nil {
+				return err
+			}
+			d.pop()
+		}
+		if d.pos >= len(d.data) {
+			return d.syntaxError(d.pos, "'e'", "invalid dictionary format, missing 'e'")
+		}
+		d.depth--
+		d.pos++ // Skip 'e'
+		return nil
+	default:
+		if unicode.IsDigit(rune(d.data[d.pos])) {
+			_, err := d.decodeString()
+			return err
+		}
+		return d.syntaxError(d.pos, "'i', 'l', 'd' or a string length", "invalid character '%c'", d.data[d.pos])
+	}
+}
@@ -0,0 +1,150 @@
+package bencode
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type testFile struct {
+	Length int64    `bencode:"length"`
+	Path   []string `bencode:"path"`
+}
+
+type testInfo struct {
+	Name        string     `bencode:"name"`
+	PieceLength int        `bencode:"piece length"`
+	Files       []testFile `bencode:"files,omitempty"`
+	Private     *int       `bencode:"private,omitempty"`
+}
+
+type testTorrent struct {
+	Announce string            `bencode:"announce"`
+	Info     testInfo          `bencode:"info"`
+	Extra    map[string]string `bencode:"extra,omitempty"`
+	Ignored  string            `bencode:"-"`
+}
+
+func TestUnmarshalStruct(t *testing.T) {
+	input := "d8:announce3:url5:extrad1:a1:be7:ignored1:x4:infod" +
+		"5:filesld6:lengthi5e4:pathl1:a1:beee4:name4:test" +
+		"12:piece lengthi16384e7:privatei1e7:unknownli1ei2eeee"
+
+	var got testTorrent
+	if err := Unmarshal([]byte(input), &got); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	private := 1
+	expected := testTorrent{
+		Announce: "url",
+		Info: testInfo{
+			Name:        "test",
+			PieceLength: 16384,
+			Files:       []testFile{{Length: 5, Path: []string{"a", "b"}}},
+			Private:     &private,
+		},
+		Extra: map[string]string{"a": "b"},
+	}
+
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %+v, got %+v", expected, got)
+	}
+}
+
+func TestUnmarshalEmbedded(t *testing.T) {
+	type inner struct {
+		A int `bencode:"a"`
+		B int `bencode:"b"`
+	}
+	type outer struct {
+		inner
+		B string `bencode:"b"`
+	}
+
+	var got outer
+	if err := Unmarshal([]byte("d1:ai1e1:b1:xe"), &got); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.A != 1 || got.B != "x" || got.inner.B != 0 {
+		t.Errorf("unexpected result %+v", got)
+	}
+}
+
+func TestUnmarshalInterfaceField(t *testing.T) {
+	var got struct {
+		Any interface{} `bencode:"any"`
+	}
+	if err := Unmarshal([]byte("d3:anyl1:ai1eee"), &got); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []interface{}{"a", 1}
+	if !reflect.DeepEqual(got.Any, expected) {
+		t.Errorf("expected %v, got %v", expected, got.Any)
+	}
+}
+
+func TestUnmarshalArray(t *testing.T) {
+	var got [2]int
+	if err := Unmarshal([]byte("li1ei2ei3ee"), &got); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != [2]int{1, 2} {
+		t.Errorf("expected [1 2], got %v", got)
+	}
+}
+
+func TestUnmarshalTypeErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		target interface{}
+	}{
+		{"string into int", "4:spam", new(int)},
+		{"int into string", "i42e", new(string)},
+		{"list into map", "le", new(map[string]int)},
+		{"dict into slice", "de", new([]int)},
+		{"dict into int keyed map", "d1:ai1ee", new(map[int]int)},
+		{"wrong field type", "d4:name4:spame", &struct {
+			Name int `bencode:"name"`
+		}{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Unmarshal([]byte(tt.input), tt.target)
+			var typeErr *UnmarshalTypeError
+			if !errors.As(err, &typeErr) {
+				t.Errorf("expected *UnmarshalTypeError, got %v", err)
+			}
+		})
+	}
+}
+
+func TestUnmarshalOverflow(t *testing.T) {
+	var v int8
+	if err := Unmarshal([]byte("i300e"), &v); err == nil {
+		t.Error("expected overflow error")
+	}
+}
+
+func TestUnmarshalInvalidTarget(t *testing.T) {
+	tests := []struct {
+		name   string
+		target interface{}
+	}{
+		{"nil", nil},
+		{"non-pointer", 42},
+		{"nil pointer", (*int)(nil)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Unmarshal([]byte("i42e"), tt.target)
+			var invalidErr *InvalidUnmarshalError
+			if !errors.As(err, &invalidErr) {
+				t.Errorf("expected *InvalidUnmarshalError, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,192 @@
+package bencode
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+// Marshal converts a Go value into Bencoded data - this is the exported shim
+// around marshalTo. Besides the dynamic types that Unmarshal produces, it
+// accepts strings, byte slices and arrays (as strings), integers of any width,
+// bools (as 0 or 1), slices, arrays, maps with string keys, pointers and
+// structs tagged with `bencode:"name,omitempty"`. Types implementing Marshaler
+// or encoding.TextMarshaler are in charge of their own encoding.
+func Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := marshalTo(&buf, v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writer is what the encoder writes into. Both *bytes.Buffer, for Marshal, and
+// *bufio.Writer, for the streaming Encoder, satisfy it.
+type writer interface {
+	io.Writer
+	io.ByteWriter
+	io.StringWriter
+}
+
+// marshalTo does the heavy lifting of marshaling the data, dispatching on the
+// kind of the value
+func marshalTo(buf writer, v interface{}) error {
+	return marshalValue(buf, reflect.ValueOf(v))
+}
+
+// marshalValue is the reflective core of the encoder
+func marshalValue(buf writer, v reflect.Value) error {
+
+	if v.IsValid() {
+		if m, tm := marshalers(v); m != nil || tm != nil {
+			return marshalerValue(buf, m, tm)
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.Invalid:
+		return fmt.Errorf("bencode: cannot marshal nil value")
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return fmt.Errorf("bencode: cannot marshal nil %s", v.Type())
+		}
+		return marshalValue(buf, v.Elem())
+	case reflect.String:
+		writeString(buf, v.String())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		buf.WriteByte('i')
+		buf.WriteString(strconv.FormatInt(v.Int(), 10))
+		buf.WriteByte('e')
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		buf.WriteByte('i')
+		buf.WriteString(strconv.FormatUint(v.Uint(), 10))
+		buf.WriteByte('e')
+	case reflect.Bool:
+		if v.Bool() {
+			buf.WriteString("i1e")
+		} else {
+			buf.WriteString("i0e")
+		}
+	case reflect.Slice, reflect.Array:
+		// byte slices and arrays are strings as far as bencode is concerned
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			writeBytes(buf, v)
+			return nil
+		}
+		buf.WriteByte('l')
+		for i := 0; i < v.Len(); i++ {
+			if err := marshalValue(buf, v.Index(i)); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('e')
+	case reflect.Map:
+		return marshalMap(buf, v)
+	case reflect.Struct:
+		return marshalStruct(buf, v)
+	default:
+		return fmt.Errorf("bencode: unsupported type for marshaling: %s", v.Type())
+	}
+	return nil
+}
+
+// marshalMap writes a map with string keys as a dictionary. Bencode has no
+// null, so nil values are left out altogether.
+func marshalMap(buf writer, v reflect.Value) error {
+
+	if v.Type().Key().Kind() != reflect.String {
+		return fmt.Errorf("bencode: unsupported map key type for marshaling: %s", v.Type().Key())
+	}
+
+	// Keys must be sorted
+	keys := make([]string, 0, v.Len())
+	for _, k := range v.MapKeys() {
+		keys = append(keys, k.String())
+	}
+	sort.Strings(keys)
+
+	buf.WriteByte('d')
+	for _, k := range keys {
+		val := v.MapIndex(reflect.ValueOf(k).Convert(v.Type().Key()))
+		if isNil(val) {
+			continue
+		}
+		writeString(buf, k)
+		if err := marshalValue(buf, val); err != nil {
+			return err
+		}
+	}
+	buf.WriteByte('e')
+	return nil
+}
+
+// marshalStruct writes a struct as a dictionary, using the keys worked out by
+// typeFields, which already come sorted
+func marshalStruct(buf writer, v reflect.Value) error {
+
+	buf.WriteByte('d')
+	for _, f := range cachedFields(v.Type()).list {
+		fv := v.FieldByIndex(f.index)
+		if isNil(fv) || (f.omitEmpty && isEmptyValue(fv)) {
+			continue
+		}
+		writeString(buf, f.name)
+		if err := marshalValue(buf, fv); err != nil {
+			return err
+		}
+	}
+	buf.WriteByte('e')
+	return nil
+}
+
+// writeBytes writes a byte slice or array as a bencoded string
+func writeBytes(buf writer, v reflect.Value) {
+	buf.WriteString(strconv.Itoa(v.Len()))
+	buf.WriteByte(':')
+	if v.Kind() == reflect.Slice {
+		buf.Write(v.Bytes())
+		return
+	}
+	for i := 0; i < v.Len(); i++ {
+		buf.WriteByte(byte(v.Index(i).Uint()))
+	}
+}
+
+// writeString writes s as a bencoded string
+func writeString(buf writer, s string) {
+	buf.WriteString(strconv.Itoa(len(s)))
+	buf.WriteByte(':')
+	buf.WriteString(s)
+}
+
+// isNil reports whether v is a nil pointer or interface, which have no
+// bencoded form
+func isNil(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		return v.IsNil()
+	}
+	return false
+}
+
+// isEmptyValue is what omitempty checks against, same as in encoding/json
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Pointer:
+		return v.IsNil()
+	}
+	return false
+}
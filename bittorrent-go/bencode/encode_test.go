@@ -0,0 +1,108 @@
+package bencode
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMarshalStruct(t *testing.T) {
+	private := 1
+	input := testTorrent{
+		Announce: "url",
+		Info: testInfo{
+			Name:        "test",
+			PieceLength: 16384,
+			Private:     &private,
+		},
+		Ignored: "not encoded",
+	}
+
+	// omitempty drops files and extra, "-" drops ignored, and the keys come
+	// out sorted
+	expected := "d8:announce3:url4:infod4:name4:test12:piece lengthi16384e7:privatei1eee"
+
+	result, err := Marshal(input)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(result) != expected {
+		t.Errorf("expected %q, got %q", expected, string(result))
+	}
+}
+
+func TestMarshalTypedValues(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    interface{}
+		expected string
+	}{
+		{"int64", int64(1) << 40, "i1099511627776e"},
+		{"negative int8", int8(-5), "i-5e"},
+		{"typed slice", []string{"a", "b"}, "l1:a1:be"},
+		{"array", [2]int{1, 2}, "li1ei2ee"},
+		{"typed map", map[string]int{"b": 2, "a": 1}, "d1:ai1e1:bi2ee"},
+		{"pointer", &[]int{1}, "li1ee"},
+		{"nil map value", map[string]interface{}{"a": nil, "b": 1}, "d1:bi1ee"},
+		{"nil slice", []int(nil), "le"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := Marshal(tt.input)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(result) != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, string(result))
+			}
+		})
+	}
+}
+
+func TestMarshalErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input interface{}
+	}{
+		{"nil", nil},
+		{"nil pointer", (*int)(nil)},
+		{"int keyed map", map[int]string{1: "a"}},
+		{"unsupported element", []interface{}{1.5}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Marshal(tt.input); err == nil {
+				t.Errorf("expected error for %v", tt.input)
+			}
+		})
+	}
+}
+
+func TestStructRoundTrip(t *testing.T) {
+	input := testTorrent{
+		Announce: "http://tracker.example.com/announce",
+		Info: testInfo{
+			Name:        "dir",
+			PieceLength: 262144,
+			Files: []testFile{
+				{Length: 1 << 33, Path: []string{"a", "b.txt"}},
+				{Length: 0, Path: []string{"c.txt"}},
+			},
+		},
+		Extra: map[string]string{"k": "v"},
+	}
+
+	encoded, err := Marshal(input)
+	if err != nil {
+		t.Fatalf("marshal error: %v", err)
+	}
+
+	var decoded testTorrent
+	if err := Unmarshal(encoded, &decoded); err != nil {
+		t.Fatalf("unmarshal error: %v", err)
+	}
+	if !reflect.DeepEqual(input, decoded) {
+		t.Errorf("roundtrip failed: expected %+v, got %+v", input, decoded)
+	}
+}
@@ -0,0 +1,76 @@
+package bencode
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SyntaxError describes malformed bencoded input: where it went wrong, what
+// the decoder expected to find there, and where in the structure of the
+// document that was.
+type SyntaxError struct {
+	Offset   int    // byte offset in the input
+	Expected string // what should have been there, e.g. "'e'" or "string length"
+	Path     string // logical location, e.g. "info.files[3].length", empty at the top level
+	msg      string // description of the problem
+}
+
+func (e *SyntaxError) Error() string {
+	s := fmt.Sprintf("bencode: %s at offset %d", e.msg, e.Offset)
+	if e.Path != "" {
+		s += " in " + e.Path
+	}
+	return s
+}
+
+// pathElem is one step into the document, either a dictionary key or, when
+// isIndex is set, a list index
+type pathElem struct {
+	key     string
+	index   int
+	isIndex bool
+}
+
+// pushKey and pushIndex record that we're stepping into a dictionary value or
+// a list element, pop that we're done with it. On error nothing gets popped,
+// so the path still points at whatever failed.
+func (d *decoder) pushKey(key string) {
+	d.path = append(d.path, pathElem{key: key})
+}
+
+func (d *decoder) pushIndex(i int) {
+	d.path = append(d.path, pathElem{index: i, isIndex: true})
+}
+
+func (d *decoder) pop() {
+	d.path = d.path[:len(d.path)-1]
+}
+
+// pathString renders the current path, e.g. info.files[3].length
+func (d *decoder) pathString() string {
+
+	var sb strings.Builder
+	for _, p := range d.path {
+		if p.isIndex {
+			sb.WriteString("[" + strconv.Itoa(p.index) + "]")
+			continue
+		}
+		if sb.Len() > 0 {
+			sb.WriteByte('.')
+		}
+		sb.WriteString(p.key)
+	}
+	return sb.String()
+}
+
+// syntaxError builds a *SyntaxError for the given position in d.data, which
+// is turned into an offset within the whole input
+func (d *decoder) syntaxError(pos int, expected, format string, args ...interface{}) *SyntaxError {
+	return &SyntaxError{
+		Offset:   d.base + pos,
+		Expected: expected,
+		Path:     d.pathString(),
+		msg:      fmt.Sprintf(format, args...),
+	}
+}
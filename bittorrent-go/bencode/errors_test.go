@@ -0,0 +1,124 @@
+package bencode
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSyntaxError(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		offset   int
+		expected string
+		path     string
+	}{
+		{"unexpected end", "l", 1, "'e'", ""},
+		{"invalid character", "lx", 1, "'i', 'l', 'd' or a string length", "[0]"},
+		{"missing colon", "4spam", 0, "':'", ""},
+		{"string too long", "d3:cow10:mooe", 13, "string data", "cow"},
+		{"invalid integer", "d1:ai4xe", 5, "integer", "a"},
+		{"unterminated integer", "li1ei2", 6, "'e'", "[1]"},
+		{
+			"nested path",
+			"d4:infod5:filesld6:lengthi1eed6:lengthi1eed6:lengthi1eed6:lengthixeeeee",
+			65, "integer", "info.files[3].length",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var v interface{}
+			err := Unmarshal([]byte(tt.input), &v)
+
+			var syntaxErr *SyntaxError
+			if !errors.As(err, &syntaxErr) {
+				t.Fatalf("expected *SyntaxError, got %v", err)
+			}
+			if syntaxErr.Offset != tt.offset {
+				t.Errorf("expected offset %d, got %d", tt.offset, syntaxErr.Offset)
+			}
+			if syntaxErr.Expected != tt.expected {
+				t.Errorf("expected %q to be expected, got %q", tt.expected, syntaxErr.Expected)
+			}
+			if syntaxErr.Path != tt.path {
+				t.Errorf("expected path %q, got %q", tt.path, syntaxErr.Path)
+			}
+		})
+	}
+}
+
+func TestSyntaxErrorPathThroughStructs(t *testing.T) {
+	input := "d4:infod5:filesld6:lengthi1eed6:lengthi+1eeeee"
+
+	var v struct {
+		Info struct {
+			Files []struct {
+				Length int `bencode:"length"`
+			} `bencode:"files"`
+		} `bencode:"info"`
+	}
+	err := DecodeOptions{Strict: true}.Unmarshal([]byte(input), &v)
+
+	var syntaxErr *SyntaxError
+	if !errors.As(err, &syntaxErr) {
+		t.Fatalf("expected *SyntaxError, got %v", err)
+	}
+	if syntaxErr.Path != "info.files[1].length" {
+		t.Errorf("expected path info.files[1].length, got %q", syntaxErr.Path)
+	}
+	expected := "bencode: integer '+1' has a plus sign at offset 39 in info.files[1].length"
+	if err.Error() != expected {
+		t.Errorf("expected %q, got %q", expected, err.Error())
+	}
+}
+
+func TestSyntaxErrorPathThroughSkip(t *testing.T) {
+	var v struct{}
+	err := Unmarshal([]byte("d7:unknownl1:ax1:bee"), &v)
+
+	var syntaxErr *SyntaxError
+	if !errors.As(err, &syntaxErr) {
+		t.Fatalf("expected *SyntaxError, got %v", err)
+	}
+	if syntaxErr.Path != "unknown[1]" {
+		t.Errorf("expected path unknown[1], got %q", syntaxErr.Path)
+	}
+}
+
+func TestTypeErrorPath(t *testing.T) {
+	var v struct {
+		Info struct {
+			Name int `bencode:"name"`
+		} `bencode:"info"`
+	}
+	err := Unmarshal([]byte("d4:infod4:name4:testee"), &v)
+
+	var typeErr *UnmarshalTypeError
+	if !errors.As(err, &typeErr) {
+		t.Fatalf("expected *UnmarshalTypeError, got %v", err)
+	}
+	if typeErr.Path != "info.name" || typeErr.Offset != 14 {
+		t.Errorf("expected info.name at offset 14, got %q at %d", typeErr.Path, typeErr.Offset)
+	}
+}
+
+func TestSyntaxErrorFromDecoder(t *testing.T) {
+	dec := NewDecoder(strings.NewReader("i1ed1:ai4xee"))
+
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	err := dec.Decode(&v)
+	var syntaxErr *SyntaxError
+	if !errors.As(err, &syntaxErr) {
+		t.Fatalf("expected *SyntaxError, got %v", err)
+	}
+	// offsets are relative to the start of the stream
+	if syntaxErr.Offset != 8 || syntaxErr.Path != "a" {
+		t.Errorf("expected offset 8 in a, got %d in %q", syntaxErr.Offset, syntaxErr.Path)
+	}
+}
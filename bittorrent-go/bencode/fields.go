@@ -0,0 +1,125 @@
+package bencode
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// field describes a single struct field that takes part in the encoding, as
+// worked out from its `bencode` tag
+type field struct {
+	name      string // dictionary key
+	index     []int  // index sequence for reflect.Value.FieldByIndex
+	omitEmpty bool
+}
+
+// structFields holds the fields of a struct type both sorted by key, which is
+// the order the encoder has to write them in, and indexed by key for the
+// decoder. Span fields are kept apart, since they're only ever filled in by
+// the decoder and never encoded.
+type structFields struct {
+	list   []field
+	byName map[string]field
+	spans  map[string][][]int
+}
+
+// fieldCache keeps the result of typeFields per struct type, since walking
+// the fields with reflection on every call would be wasteful
+var fieldCache sync.Map // map[reflect.Type]structFields
+
+// cachedFields is typeFields with the cache in front of it
+func cachedFields(t reflect.Type) structFields {
+	if f, ok := fieldCache.Load(t); ok {
+		return f.(structFields)
+	}
+	f, _ := fieldCache.LoadOrStore(t, typeFields(t))
+	return f.(structFields)
+}
+
+// typeFields works out which fields of a struct type are encoded and under
+// which key. The rules are a trimmed down version of encoding/json's:
+//   - unexported fields and fields tagged "-" are ignored
+//   - the key is the tag name if there is one, otherwise the field name
+//   - untagged embedded structs have their fields promoted, unless a field of
+//     the outer struct already uses the same key
+//   - fields of type Span with the span option record the position of the
+//     value under their key instead of the value itself
+func typeFields(t reflect.Type) structFields {
+
+	var list []field
+	seen := make(map[string]bool)
+	var embedded []reflect.StructField
+	spans := make(map[string][][]int)
+
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag := sf.Tag.Get("bencode")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+
+		if sf.Anonymous && name == "" && sf.Type.Kind() == reflect.Struct {
+			embedded = append(embedded, sf)
+			continue
+		}
+		if !sf.IsExported() {
+			continue
+		}
+
+		if name == "" {
+			name = sf.Name
+		}
+		if sf.Type == spanType && hasOption(opts, "span") {
+			spans[name] = append(spans[name], []int{i})
+			continue
+		}
+		seen[name] = true
+		list = append(list, field{
+			name:      name,
+			index:     []int{i},
+			omitEmpty: hasOption(opts, "omitempty"),
+		})
+	}
+
+	// the outer fields are all in by now, so the promoted ones can only fill
+	// in the gaps
+	for _, sf := range embedded {
+		inner := typeFields(sf.Type)
+		for _, f := range inner.list {
+			if seen[f.name] {
+				continue
+			}
+			seen[f.name] = true
+			f.index = append([]int{sf.Index[0]}, f.index...)
+			list = append(list, f)
+		}
+		for name, indexes := range inner.spans {
+			for _, index := range indexes {
+				spans[name] = append(spans[name], append([]int{sf.Index[0]}, index...))
+			}
+		}
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].name < list[j].name })
+
+	byName := make(map[string]field, len(list))
+	for _, f := range list {
+		byName[f.name] = f
+	}
+	return structFields{list: list, byName: byName, spans: spans}
+}
+
+// hasOption reports whether the comma separated tag options contain opt
+func hasOption(opts, opt string) bool {
+	for opts != "" {
+		var o string
+		o, opts, _ = strings.Cut(opts, ",")
+		if o == opt {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,182 @@
+package bencode
+
+import (
+	"encoding"
+	"fmt"
+	"reflect"
+)
+
+// Marshaler is implemented by types that know how to encode themselves. The
+// returned bytes must hold exactly one valid bencoded value.
+type Marshaler interface {
+	MarshalBencode() ([]byte, error)
+}
+
+// Unmarshaler is implemented by types that know how to decode themselves.
+// UnmarshalBencode gets the raw bytes of a single bencoded value, and must
+// copy them if it wants to hold on to them after returning.
+type Unmarshaler interface {
+	UnmarshalBencode([]byte) error
+}
+
+var (
+	marshalerType     = reflect.TypeOf((*Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// indirect follows v through any pointers, allocating them as it goes, until
+// it either gets to something that implements Unmarshaler or
+// encoding.TextUnmarshaler, or runs out of pointers to follow. Methods are
+// looked up on the address of each value too, as that's where they usually
+// are.
+func indirect(v reflect.Value) (Unmarshaler, encoding.TextUnmarshaler, reflect.Value) {
+
+	for {
+		if v.CanAddr() {
+			if u, tu := unmarshalers(v.Addr()); u != nil || tu != nil {
+				return u, tu, reflect.Value{}
+			}
+		}
+		if v.Kind() != reflect.Pointer {
+			return nil, nil, v
+		}
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+}
+
+// unmarshalers returns whichever of the unmarshaling interfaces p implements,
+// Unmarshaler taking precedence
+func unmarshalers(p reflect.Value) (Unmarshaler, encoding.TextUnmarshaler) {
+
+	if p.Type().NumMethod() == 0 || !p.CanInterface() {
+		return nil, nil
+	}
+	switch u := p.Interface().(type) {
+	case Unmarshaler:
+		return u, nil
+	case encoding.TextUnmarshaler:
+		return nil, u
+	}
+	return nil, nil
+}
+
+// unmarshalerValue hands the raw bytes of the next value to an Unmarshaler
+func (d *decoder) unmarshalerValue(u Unmarshaler) error {
+
+	start := d.pos
+	if err := d.skip(); err != nil {
+		return err
+	}
+	if err := u.UnmarshalBencode(d.data[start:d.pos]); err != nil {
+		return fmt.Errorf("bencode: %s: %w", d.describe(start), err)
+	}
+	return nil
+}
+
+// textUnmarshalerValue hands the contents of the next value, which must be a
+// string, to an encoding.TextUnmarshaler
+func (d *decoder) textUnmarshalerValue(tu encoding.TextUnmarshaler) error {
+
+	start := d.pos
+	if d.data[d.pos] < '0' || d.data[d.pos] > '9' {
+		return d.typeError(d.kindAt(start), reflect.TypeOf(tu), start)
+	}
+	str, err := d.readString()
+	if err != nil {
+		return err
+	}
+	if err := tu.UnmarshalText(str); err != nil {
+		return fmt.Errorf("bencode: %s: %w", d.describe(start), err)
+	}
+	return nil
+}
+
+// kindAt names the kind of value starting at pos, for error messages
+func (d *decoder) kindAt(pos int) string {
+	switch d.data[pos] {
+	case 'i':
+		return "integer"
+	case 'l':
+		return "list"
+	case 'd':
+		return "dictionary"
+	}
+	return "string"
+}
+
+// describe says where the value starting at pos is, for wrapping errors
+// coming back from Unmarshaler implementations
+func (d *decoder) describe(pos int) string {
+	if path := d.pathString(); path != "" {
+		return fmt.Sprintf("value at offset %d in %s", d.base+pos, path)
+	}
+	return fmt.Sprintf("value at offset %d", d.base+pos)
+}
+
+// marshalers returns whichever of the marshaling interfaces v implements,
+// either directly or through its address, Marshaler taking precedence. Nil
+// pointers are left alone, those are dealt with like any other nil.
+func marshalers(v reflect.Value) (Marshaler, encoding.TextMarshaler) {
+
+	if v.Kind() == reflect.Pointer && v.IsNil() {
+		return nil, nil
+	}
+	if !v.Type().Implements(marshalerType) && !v.Type().Implements(textMarshalerType) {
+		if !v.CanAddr() {
+			return nil, nil
+		}
+		v = v.Addr()
+	}
+	if !v.CanInterface() {
+		return nil, nil
+	}
+	switch m := v.Interface().(type) {
+	case Marshaler:
+		return m, nil
+	case encoding.TextMarshaler:
+		return nil, m
+	}
+	return nil, nil
+}
+
+// marshalerValue writes out whatever a Marshaler or encoding.TextMarshaler
+// produces, the former as is and the latter as a string
+func marshalerValue(buf writer, m Marshaler, tm encoding.TextMarshaler) error {
+
+	if m != nil {
+		raw, err := m.MarshalBencode()
+		if err != nil {
+			return fmt.Errorf("bencode: error calling MarshalBencode for type %T: %w", m, err)
+		}
+		return writeRaw(buf, raw)
+	}
+
+	text, err := tm.MarshalText()
+	if err != nil {
+		return fmt.Errorf("bencode: error calling MarshalText for type %T: %w", tm, err)
+	}
+	writeString(buf, string(text))
+	return nil
+}
+
+// writeRaw writes already encoded bytes verbatim, after making sure they hold
+// exactly one well formed value so that they can't corrupt the surrounding
+// output
+func writeRaw(buf writer, raw []byte) error {
+
+	if len(raw) == 0 {
+		return fmt.Errorf("bencode: invalid raw value: empty")
+	}
+	d := &decoder{data: raw}
+	if err := d.skip(); err != nil {
+		return fmt.Errorf("bencode: invalid raw value: %w", err)
+	}
+	if d.pos != len(raw) {
+		return fmt.Errorf("bencode: invalid raw value: trailing data after value")
+	}
+	_, err := buf.Write(raw)
+	return err
+}
@@ -0,0 +1,205 @@
+package bencode
+
+import (
+	"bytes"
+	"errors"
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// bitfield is a domain type with its own wire form: a list of the indexes
+// of the bits that are set
+type bitfield []bool
+
+func (b bitfield) MarshalBencode() ([]byte, error) {
+	var set []int
+	for i, bit := range b {
+		if bit {
+			set = append(set, i)
+		}
+	}
+	return Marshal(set)
+}
+
+func (b *bitfield) UnmarshalBencode(data []byte) error {
+	var set []int
+	if err := Unmarshal(data, &set); err != nil {
+		return err
+	}
+	*b = nil
+	for _, i := range set {
+		if i < 0 || i > 1024 {
+			return errors.New("bit index out of range")
+		}
+		for len(*b) <= i {
+			*b = append(*b, false)
+		}
+		(*b)[i] = true
+	}
+	return nil
+}
+
+// badMarshaler produces something that isn't bencode
+type badMarshaler struct{}
+
+func (badMarshaler) MarshalBencode() ([]byte, error) {
+	return []byte("not bencode"), nil
+}
+
+func TestMarshalBroaderTypes(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    interface{}
+		expected string
+	}{
+		{"byte slice", []byte{0x00, 0xff, 'a'}, "3:\x00\xffa"},
+		{"byte array", [4]byte{'s', 'p', 'a', 'm'}, "4:spam"},
+		{"uint8", uint8(255), "i255e"},
+		{"uint64", uint64(1) << 63, "i9223372036854775808e"},
+		{"true", true, "i1e"},
+		{"false", false, "i0e"},
+		{"text marshaler", netip.MustParseAddr("10.0.0.1"), "8:10.0.0.1"},
+		{"marshaler", bitfield{true, false, true}, "li0ei2ee"},
+		{"marshaler in map", map[string]bitfield{"have": {false, true}}, "d4:haveli1eee"},
+		{"raw message", RawMessage("i42e"), "i42e"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := Marshal(tt.input)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(result) != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, string(result))
+			}
+		})
+	}
+}
+
+func TestMarshalPointerReceiver(t *testing.T) {
+	// *bitfield has no MarshalBencode of its own, but it gets bitfield's
+	b := &bitfield{false, true}
+	result, err := Marshal(b)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(result) != "li1ee" {
+		t.Errorf("expected %q, got %q", "li1ee", string(result))
+	}
+}
+
+func TestMarshalInvalidMarshalerOutput(t *testing.T) {
+	if _, err := Marshal(badMarshaler{}); err == nil {
+		t.Error("expected error for invalid MarshalBencode output")
+	}
+}
+
+type broad struct {
+	Data    []byte         `bencode:"data"`
+	Hash    [4]byte        `bencode:"hash"`
+	Port    uint16         `bencode:"port"`
+	Private bool           `bencode:"private"`
+	Addr    netip.Addr     `bencode:"addr"`
+	Have    bitfield       `bencode:"have"`
+	HavePtr *bitfield      `bencode:"have ptr"`
+	Counts  map[string]int `bencode:"counts"`
+}
+
+func TestBroaderTypesRoundTrip(t *testing.T) {
+	have := bitfield{true, false, false, true}
+	input := broad{
+		Data:    []byte{0, 1, 2, 0xff},
+		Hash:    [4]byte{9, 8, 7, 6},
+		Port:    6881,
+		Private: true,
+		Addr:    netip.MustParseAddr("::1"),
+		Have:    bitfield{false, true},
+		HavePtr: &have,
+		Counts:  map[string]int{"a": 1},
+	}
+
+	encoded, err := Marshal(input)
+	if err != nil {
+		t.Fatalf("marshal error: %v", err)
+	}
+
+	var decoded broad
+	if err := Unmarshal(encoded, &decoded); err != nil {
+		t.Fatalf("unmarshal error: %v", err)
+	}
+	if !reflect.DeepEqual(input, decoded) {
+		t.Errorf("roundtrip failed: expected %+v, got %+v", input, decoded)
+	}
+}
+
+func TestUnmarshalBytesCopies(t *testing.T) {
+	input := []byte("4:spam")
+
+	var b []byte
+	if err := Unmarshal(input, &b); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	input[2] = 'x'
+	if !bytes.Equal(b, []byte("spam")) {
+		t.Errorf("byte slice shares memory with the input: %q", b)
+	}
+}
+
+func TestUnmarshalBroaderTypeErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		target interface{}
+	}{
+		{"negative into uint", "i-1e", new(uint)},
+		{"overflowing uint8", "i256e", new(uint8)},
+		{"bool out of range", "i2e", new(bool)},
+		{"byte array wrong length", "3:abc", new([4]byte)},
+		{"text unmarshaler from int", "i1e", new(netip.Addr)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Unmarshal([]byte(tt.input), tt.target)
+			var typeErr *UnmarshalTypeError
+			if !errors.As(err, &typeErr) {
+				t.Errorf("expected *UnmarshalTypeError, got %v", err)
+			}
+		})
+	}
+}
+
+func TestUnmarshalerErrorsAreWrapped(t *testing.T) {
+	var v struct {
+		Have bitfield   `bencode:"have"`
+		Addr netip.Addr `bencode:"addr"`
+	}
+
+	err := Unmarshal([]byte("d4:haveli5000eee"), &v)
+	if err == nil || !strings.Contains(err.Error(), "bit index out of range") || !strings.Contains(err.Error(), "have") {
+		t.Errorf("expected wrapped UnmarshalBencode error mentioning the path, got %v", err)
+	}
+
+	err = Unmarshal([]byte("d4:addr7:bad addre"), &v)
+	if err == nil || !strings.Contains(err.Error(), "addr") {
+		t.Errorf("expected wrapped UnmarshalText error, got %v", err)
+	}
+}
+
+func TestUnmarshalerSeesExactBytes(t *testing.T) {
+	// unsorted keys and all, the Unmarshaler gets what was in the input
+	input := "d4:infod1:bi1e1:ai2eee"
+
+	var v struct {
+		Info RawMessage `bencode:"info"`
+	}
+	if err := Unmarshal([]byte(input), &v); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(v.Info) != "d1:bi1e1:ai2ee" {
+		t.Errorf("expected exact bytes, got %q", string(v.Info))
+	}
+}
@@ -0,0 +1,180 @@
+package bencode
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// DecodeOptions configures how bencoded data is decoded. The zero value is
+// what Unmarshal and NewDecoder use.
+type DecodeOptions struct {
+	// Strict only accepts input in the canonical form the BitTorrent spec
+	// asks for: dictionary keys sorted and unique, integers and string
+	// lengths without signs or leading zeros, no negative zero, and, for
+	// Unmarshal, nothing after the value. This is meant for validation, as
+	// plenty of torrents in the wild break at least one of these rules.
+	Strict bool
+
+	// The limits below are there for decoding untrusted input, like tracker
+	// responses or peer extension messages. Going over any of them fails the
+	// decoding with a *LimitError. Zero means no limit, except for MaxDepth,
+	// where it means DefaultMaxDepth, since the decoder is recursive.
+
+	// MaxDepth is how deeply lists and dictionaries may be nested.
+	MaxDepth int
+	// MaxStringLength is the longest string accepted, in bytes.
+	MaxStringLength int
+	// MaxElements is the most elements a single list, or keys a single
+	// dictionary, may hold.
+	MaxElements int
+	// MaxInputSize is the largest input accepted, in bytes. For a Decoder it
+	// applies to each value read from the stream.
+	MaxInputSize int
+}
+
+// DefaultMaxDepth is the nesting limit applied when DecodeOptions.MaxDepth is
+// left at zero. It's far deeper than any legitimate torrent or tracker
+// response will ever go.
+const DefaultMaxDepth = 1000
+
+// LimitError is returned when the input goes over one of the limits set in
+// DecodeOptions.
+type LimitError struct {
+	Limit  string // "depth", "string length", "elements" or "input size"
+	Max    int    // the configured limit
+	Offset int    // where in the input it was exceeded
+	Path   string // logical location, as in SyntaxError
+}
+
+func (e *LimitError) Error() string {
+	s := fmt.Sprintf("bencode: %s limit of %d exceeded at offset %d", e.Limit, e.Max, e.Offset)
+	if e.Path != "" {
+		s += " in " + e.Path
+	}
+	return s
+}
+
+// maxDepth is MaxDepth with the default filled in
+func (o DecodeOptions) maxDepth() int {
+	if o.MaxDepth == 0 {
+		return DefaultMaxDepth
+	}
+	return o.MaxDepth
+}
+
+// Unmarshal works like the package level Unmarshal, with the options applied.
+func (o DecodeOptions) Unmarshal(data []byte, v interface{}) error {
+
+	if len(data) == 0 {
+		return fmt.Errorf("bencode: cannot unmarshal empty data")
+	}
+	if o.MaxInputSize > 0 && len(data) > o.MaxInputSize {
+		return &LimitError{Limit: "input size", Max: o.MaxInputSize, Offset: o.MaxInputSize}
+	}
+
+	d := &decoder{data: data, pos: 0, opts: o}
+	if err := d.unmarshal(v); err != nil {
+		return err
+	}
+
+	if o.Strict && d.pos != len(data) {
+		return d.syntaxError(d.pos, "end of input", "trailing data after value")
+	}
+	return nil
+}
+
+// NewDecoder works like the package level NewDecoder, with the options
+// applied to every value read from r.
+func (o DecodeOptions) NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: bufio.NewReader(r), opts: o}
+}
+
+// checkInt enforces the canonical integer form in strict mode. Whatever else
+// is wrong with the integer gets caught when it's converted.
+func (d *decoder) checkInt(intStr string) error {
+
+	if !d.opts.Strict {
+		return nil
+	}
+
+	digits := intStr
+	if len(digits) > 0 && digits[0] == '-' {
+		digits = digits[1:]
+	}
+
+	switch {
+	case intStr == "":
+		return d.syntaxError(d.pos, "digit", "empty integer")
+	case intStr[0] == '+':
+		return d.syntaxError(d.pos, "'-' or digit", "integer '%s' has a plus sign", intStr)
+	case intStr == "-0":
+		return d.syntaxError(d.pos, "non-zero digit", "negative zero")
+	case len(digits) > 1 && digits[0] == '0':
+		return d.syntaxError(d.pos, "non-zero digit", "integer '%s' has leading zeros", intStr)
+	}
+	return nil
+}
+
+// checkLength enforces the canonical string length form in strict mode
+func (d *decoder) checkLength(lengthStr string) error {
+
+	if !d.opts.Strict || lengthStr == "" {
+		return nil
+	}
+
+	switch {
+	case lengthStr[0] == '+' || lengthStr[0] == '-':
+		return d.syntaxError(d.pos, "digit", "string length '%s' has a sign", lengthStr)
+	case len(lengthStr) > 1 && lengthStr[0] == '0':
+		return d.syntaxError(d.pos, "non-zero digit", "string length '%s' has leading zeros", lengthStr)
+	}
+	return nil
+}
+
+// checkKey enforces, in strict mode, that dictionary keys are sorted as raw
+// byte strings and never repeated. n is the number of keys seen so far in the
+// dictionary, and lastKey the previous one.
+func (d *decoder) checkKey(key, lastKey string, n, keyPos int) error {
+
+	if !d.opts.Strict || n == 0 {
+		return nil
+	}
+
+	switch {
+	case key == lastKey:
+		return d.syntaxError(keyPos, "unique key", "duplicate dictionary key %q", key)
+	case key < lastKey:
+		return d.syntaxError(keyPos, fmt.Sprintf("key sorted after %q", lastKey), "dictionary key %q is not sorted after %q", key, lastKey)
+	}
+	return nil
+}
+
+// enter is called on the way into a list or dictionary, and keeps track of
+// the nesting depth. The matching decrement happens when the closing 'e' is
+// consumed.
+func (d *decoder) enter() error {
+	d.depth++
+	if max := d.opts.maxDepth(); d.depth > max {
+		return &LimitError{Limit: "depth", Max: max, Offset: d.base + d.pos - 1, Path: d.pathString()}
+	}
+	return nil
+}
+
+// checkElements is called before each element of a list or dictionary is
+// read, n being the number of elements read so far
+func (d *decoder) checkElements(n int) error {
+	if d.opts.MaxElements > 0 && n >= d.opts.MaxElements {
+		return &LimitError{Limit: "elements", Max: d.opts.MaxElements, Offset: d.base + d.pos, Path: d.pathString()}
+	}
+	return nil
+}
+
+// checkStringLength is called as soon as a string's length is known, before
+// anything is done with its contents
+func (d *decoder) checkStringLength(length int) error {
+	if d.opts.MaxStringLength > 0 && length > d.opts.MaxStringLength {
+		return &LimitError{Limit: "string length", Max: d.opts.MaxStringLength, Offset: d.base + d.pos, Path: d.pathString()}
+	}
+	return nil
+}
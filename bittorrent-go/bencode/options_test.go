@@ -0,0 +1,211 @@
+package bencode
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestStrictRejects(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		message string
+	}{
+		{"unsorted keys", "d3:cowi1e3:abci2ee", "not sorted"},
+		{"duplicate keys", "d3:cowi1e3:cowi2ee", "duplicate"},
+		{"nested unsorted keys", "l" + "d1:bi1e1:ai2ee" + "e", "not sorted"},
+		{"leading zero", "i03e", "leading zeros"},
+		{"negative leading zero", "i-03e", "leading zeros"},
+		{"negative zero", "i-0e", "negative zero"},
+		{"plus sign", "i+5e", "plus sign"},
+		{"empty integer", "ie", "empty integer"},
+		{"length with leading zero", "04:spam", "leading zeros"},
+		{"length with plus sign", "d+4:spami1ee", "has a sign"},
+		{"trailing data", "i1ei2e", "trailing data"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var v interface{}
+			err := DecodeOptions{Strict: true}.Unmarshal([]byte(tt.input), &v)
+			if err == nil {
+				t.Fatalf("expected error for input %q", tt.input)
+			}
+			if !strings.Contains(err.Error(), tt.message) {
+				t.Errorf("expected error mentioning %q, got %v", tt.message, err)
+			}
+		})
+	}
+}
+
+func TestStrictAppliesToStructsAndSkippedValues(t *testing.T) {
+	// the offending dict sits under a key the struct doesn't know about, so
+	// it only ever goes through skip
+	input := "d4:name4:test7:unknownd1:bi1e1:ai2eee"
+
+	var v struct {
+		Name string `bencode:"name"`
+	}
+	if err := Unmarshal([]byte(input), &v); err != nil {
+		t.Fatalf("lenient mode should accept the input: %v", err)
+	}
+	if err := (DecodeOptions{Strict: true}).Unmarshal([]byte(input), &v); err == nil {
+		t.Error("strict mode should reject unsorted keys in skipped values")
+	}
+
+	var m struct {
+		B int `bencode:"b"`
+		A int `bencode:"a"`
+	}
+	if err := (DecodeOptions{Strict: true}).Unmarshal([]byte("d1:bi1e1:ai2ee"), &m); err == nil {
+		t.Error("strict mode should reject unsorted keys in structs")
+	}
+}
+
+func TestStrictAccepts(t *testing.T) {
+	tests := []string{
+		"i0e",
+		"i-42e",
+		"i10e",
+		"0:",
+		"10:0123456789",
+		"d0:i1e1:ai2e2:aai3e1:bi4ee",
+		"l4:spami42ed3:cow3:mooee",
+	}
+
+	for _, input := range tests {
+		t.Run(input, func(t *testing.T) {
+			var v interface{}
+			if err := (DecodeOptions{Strict: true}).Unmarshal([]byte(input), &v); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestLenientAcceptsNonCanonical(t *testing.T) {
+	tests := []string{
+		"d3:cowi1e3:abci2ee",
+		"i03e",
+		"i-0e",
+		"i+5e",
+		"04:spam",
+		"i1ei2e",
+	}
+
+	for _, input := range tests {
+		t.Run(input, func(t *testing.T) {
+			var v interface{}
+			if err := Unmarshal([]byte(input), &v); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestNegativeStringLength(t *testing.T) {
+	var v interface{}
+	if err := Unmarshal([]byte("-1:a"), &v); err == nil {
+		t.Error("expected error for negative string length")
+	}
+	if err := Unmarshal([]byte("d-1:ai1ee"), &v); err == nil {
+		t.Error("expected error for negative key length")
+	}
+}
+
+func TestStrictDecoder(t *testing.T) {
+	dec := DecodeOptions{Strict: true}.NewDecoder(strings.NewReader("i1ei03e"))
+
+	var v int
+	if err := dec.Decode(&v); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := dec.Decode(&v); err == nil {
+		t.Error("expected error for leading zeros")
+	}
+}
+
+func TestLimits(t *testing.T) {
+	tests := []struct {
+		name  string
+		opts  DecodeOptions
+		input string
+		limit string
+	}{
+		{"depth", DecodeOptions{MaxDepth: 2}, "lllleeee", "depth"},
+		{"depth in dict", DecodeOptions{MaxDepth: 1}, "d1:ad1:bi1eee", "depth"},
+		{"default depth", DecodeOptions{}, strings.Repeat("l", DefaultMaxDepth+1) + strings.Repeat("e", DefaultMaxDepth+1), "depth"},
+		{"string length", DecodeOptions{MaxStringLength: 3}, "4:spam", "string length"},
+		{"key length", DecodeOptions{MaxStringLength: 3}, "d4:spami1ee", "string length"},
+		{"list elements", DecodeOptions{MaxElements: 2}, "li1ei2ei3ee", "elements"},
+		{"dict elements", DecodeOptions{MaxElements: 1}, "d1:ai1e1:bi2ee", "elements"},
+		{"input size", DecodeOptions{MaxInputSize: 5}, "6:abcdef", "input size"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			check := func(err error) {
+				t.Helper()
+				var limitErr *LimitError
+				if !errors.As(err, &limitErr) {
+					t.Fatalf("expected *LimitError, got %v", err)
+				}
+				if limitErr.Limit != tt.limit {
+					t.Errorf("expected %s limit, got %s", tt.limit, limitErr.Limit)
+				}
+			}
+
+			var v interface{}
+			check(tt.opts.Unmarshal([]byte(tt.input), &v))
+
+			// struct targets go through the reflective decoder and skip
+			var s struct {
+				X int `bencode:"x"`
+			}
+			if tt.input[0] == 'd' {
+				check(tt.opts.Unmarshal([]byte(tt.input), &s))
+			}
+
+			check(tt.opts.NewDecoder(strings.NewReader(tt.input)).Decode(&v))
+		})
+	}
+}
+
+func TestLimitsAllowValuesWithinBounds(t *testing.T) {
+	opts := DecodeOptions{MaxDepth: 2, MaxStringLength: 4, MaxElements: 2, MaxInputSize: 32}
+	input := "d1:ali1ei2ee1:b4:spame"
+
+	var v interface{}
+	if err := opts.Unmarshal([]byte(input), &v); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := opts.NewDecoder(strings.NewReader(input)).Decode(&v); err != nil {
+		t.Errorf("unexpected error from Decoder: %v", err)
+	}
+}
+
+func TestDecoderStopsReadingHugeString(t *testing.T) {
+	// the stream claims a gigabyte long string, the decoder must refuse
+	// before trying to read it
+	opts := DecodeOptions{MaxStringLength: 1 << 10}
+	r := io.MultiReader(strings.NewReader("1073741824:"), neverEnding('a'))
+
+	var v interface{}
+	err := opts.NewDecoder(r).Decode(&v)
+	var limitErr *LimitError
+	if !errors.As(err, &limitErr) {
+		t.Fatalf("expected *LimitError, got %v", err)
+	}
+}
+
+// neverEnding is an endless stream of the same byte
+type neverEnding byte
+
+func (b neverEnding) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = byte(b)
+	}
+	return len(p), nil
+}
@@ -0,0 +1,164 @@
+package bencode
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrNotFound is returned by Get when there's nothing at the requested path.
+var ErrNotFound = errors.New("bencode: path not found")
+
+// Get finds the value at path in the bencoded document data without decoding
+// the rest of it. Each step of the path is either a string, a dictionary key,
+// or an int, a list index, e.g.
+//
+//	Get(data, "info", "files", 0, "path")
+//
+// The raw bytes of the value are returned as a slice of data, not a copy, so
+// they can be passed on to Unmarshal or hashed as they are, along with where
+// they were found. Only the values the scan has to step over are looked at,
+// and none of them is decoded.
+func Get(data []byte, path ...interface{}) (RawMessage, Span, error) {
+
+	if len(data) == 0 {
+		return nil, Span{}, fmt.Errorf("bencode: cannot query empty data")
+	}
+
+	d := &decoder{data: data}
+	for _, step := range path {
+		var err error
+		switch step := step.(type) {
+		case string:
+			err = d.findKey(step)
+		case int:
+			err = d.findIndex(step)
+		default:
+			return nil, Span{}, fmt.Errorf("bencode: invalid path element %v of type %T", step, step)
+		}
+		if err != nil {
+			return nil, Span{}, err
+		}
+	}
+
+	start := d.pos
+	if err := d.skip(); err != nil {
+		return nil, Span{}, err
+	}
+	return RawMessage(data[start:d.pos]), Span{Start: start, End: d.pos}, nil
+}
+
+// findKey moves d.pos to the value under key in the dictionary at d.pos
+func (d *decoder) findKey(key string) error {
+
+	if d.pos >= len(d.data) {
+		return d.syntaxError(d.pos, "value", "unexpected end of input")
+	}
+	if d.data[d.pos] != 'd' {
+		return d.notFound(fmt.Sprintf("key %q", key))
+	}
+	if err := d.enter(); err != nil {
+		return err
+	}
+	d.pos++ // Skip 'd'
+
+	for n := 0; d.pos < len(d.data) && d.data[d.pos] != 'e'; n++ {
+		if err := d.checkElements(n); err != nil {
+			return err
+		}
+		k, err := d.readString()
+		if err != nil {
+			return err
+		}
+		if string(k) == key {
+			d.pushKey(key)
+			return nil
+		}
+		if err := d.skip(); err != nil {
+			return err
+		}
+	}
+	if d.pos >= len(d.data) {
+		return d.syntaxError(d.pos, "'e'", "invalid dictionary format, missing 'e'")
+	}
+	return d.notFound(fmt.Sprintf("key %q", key))
+}
+
+// findIndex moves d.pos to the element at index i of the list at d.pos
+func (d *decoder) findIndex(i int) error {
+
+	if d.pos >= len(d.data) {
+		return d.syntaxError(d.pos, "value", "unexpected end of input")
+	}
+	if d.data[d.pos] != 'l' || i < 0 {
+		return d.notFound(fmt.Sprintf("index %d", i))
+	}
+	if err := d.enter(); err != nil {
+		return err
+	}
+	d.pos++ // Skip 'l'
+
+	for n := 0; d.pos < len(d.data) && d.data[d.pos] != 'e'; n++ {
+		if err := d.checkElements(n); err != nil {
+			return err
+		}
+		if n == i {
+			d.pushIndex(i)
+			return nil
+		}
+		if err := d.skip(); err != nil {
+			return err
+		}
+	}
+	if d.pos >= len(d.data) {
+		return d.syntaxError(d.pos, "'e'", "invalid list format, missing 'e'")
+	}
+	return d.notFound(fmt.Sprintf("index %d", i))
+}
+
+// notFound wraps ErrNotFound with what was being looked for and where
+func (d *decoder) notFound(what string) error {
+	if path := d.pathString(); path != "" {
+		return fmt.Errorf("%w: no %s in %s", ErrNotFound, what, path)
+	}
+	return fmt.Errorf("%w: no %s at the top level", ErrNotFound, what)
+}
+
+// ParsePath turns a path written the way errors show them, such as
+// info.files[0].path, into the steps Get expects. Keys are separated by dots
+// and list indexes go in brackets. Keys can hold spaces, so "info.piece
+// length" works, but not dots or brackets.
+func ParsePath(s string) ([]interface{}, error) {
+
+	var path []interface{}
+	if s == "" {
+		return path, nil // the whole document
+	}
+
+	for _, part := range strings.Split(s, ".") {
+		key, indexes, hasIndex := strings.Cut(part, "[")
+		if strings.Contains(key, "]") || (key == "" && !hasIndex) {
+			return nil, fmt.Errorf("bencode: invalid path %q", s)
+		}
+		if key != "" {
+			path = append(path, key)
+		}
+		if !hasIndex {
+			continue
+		}
+
+		// indexes is everything after the first '[', e.g. "0]" or "0][1]"
+		if !strings.HasSuffix(indexes, "]") {
+			return nil, fmt.Errorf("bencode: invalid path %q: missing ']'", s)
+		}
+		for _, idx := range strings.Split(strings.TrimSuffix(indexes, "]"), "][") {
+			i, err := strconv.Atoi(idx)
+			if err != nil || i < 0 {
+				return nil, fmt.Errorf("bencode: invalid path %q: bad index %q", s, idx)
+			}
+			path = append(path, i)
+		}
+	}
+	return path, nil
+}
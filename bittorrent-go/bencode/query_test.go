@@ -0,0 +1,137 @@
+package bencode
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestGet(t *testing.T) {
+	data := []byte("d8:announce3:url4:infod5:filesld6:lengthi1e4:pathl1:a1:beed6:lengthi2e4:pathl1:ceee4:name3:dir12:piece lengthi16384eee")
+
+	tests := []struct {
+		name     string
+		path     []interface{}
+		expected string
+	}{
+		{"whole document", nil, string(data)},
+		{"top level key", []interface{}{"announce"}, "3:url"},
+		{"nested key", []interface{}{"info", "name"}, "3:dir"},
+		{"key with a space", []interface{}{"info", "piece length"}, "i16384e"},
+		{"list element", []interface{}{"info", "files", 1}, "d6:lengthi2e4:pathl1:cee"},
+		{"deep", []interface{}{"info", "files", 0, "path"}, "l1:a1:be"},
+		{"deeper", []interface{}{"info", "files", 0, "path", 1}, "1:b"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw, span, err := Get(data, tt.path...)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(raw) != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, raw)
+			}
+			if string(data[span.Start:span.End]) != tt.expected {
+				t.Errorf("span %v doesn't match the value, got %q", span, data[span.Start:span.End])
+			}
+			// zero copy, the value points into data
+			if len(raw) > 0 && &raw[0] != &data[span.Start] {
+				t.Error("expected the value to share memory with the input")
+			}
+		})
+	}
+}
+
+func TestGetNotFound(t *testing.T) {
+	data := []byte("d4:infod5:filesld6:lengthi1eeeee")
+
+	tests := []struct {
+		name     string
+		path     []interface{}
+		expected string
+	}{
+		{"missing key", []interface{}{"announce"}, `bencode: path not found: no key "announce" at the top level`},
+		{"missing nested key", []interface{}{"info", "name"}, `bencode: path not found: no key "name" in info`},
+		{"index out of range", []interface{}{"info", "files", 1}, "bencode: path not found: no index 1 in info.files"},
+		{"negative index", []interface{}{"info", "files", -1}, "bencode: path not found: no index -1 in info.files"},
+		{"key into list", []interface{}{"info", "files", "x"}, `bencode: path not found: no key "x" in info.files`},
+		{"index into dict", []interface{}{"info", 0}, "bencode: path not found: no index 0 in info"},
+		{"into integer", []interface{}{"info", "files", 0, "length", 0}, "bencode: path not found: no index 0 in info.files[0].length"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := Get(data, tt.path...)
+			if !errors.Is(err, ErrNotFound) {
+				t.Fatalf("expected ErrNotFound, got %v", err)
+			}
+			if err.Error() != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, err.Error())
+			}
+		})
+	}
+}
+
+func TestGetErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  string
+		path  []interface{}
+		isSyn bool
+	}{
+		{"empty data", "", nil, false},
+		{"bad path element", "de", []interface{}{1.5}, false},
+		{"malformed value skipped over", "d1:ax1:bi1ee", []interface{}{"b"}, true},
+		{"unterminated dict", "d1:ai1e", []interface{}{"b"}, true},
+		{"truncated target", "d1:a5:abe", []interface{}{"a"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := Get([]byte(tt.data), tt.path...)
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			var syntaxErr *SyntaxError
+			if errors.As(err, &syntaxErr) != tt.isSyn {
+				t.Errorf("expected syntax error to be %v, got %v", tt.isSyn, err)
+			}
+		})
+	}
+}
+
+func TestParsePath(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []interface{}
+	}{
+		{"", []interface{}{}},
+		{"info", []interface{}{"info"}},
+		{"info.piece length", []interface{}{"info", "piece length"}},
+		{"info.files[0].path", []interface{}{"info", "files", 0, "path"}},
+		{"a[1][2]", []interface{}{"a", 1, 2}},
+		{"[3].x", []interface{}{3, "x"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			path, err := ParsePath(tt.input)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(path) == 0 && len(tt.expected) == 0 {
+				return
+			}
+			if !reflect.DeepEqual(path, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, path)
+			}
+		})
+	}
+
+	for _, invalid := range []string{"a..b", ".a", "a.", "a[", "a[x]", "a[-1]", "a]", "a[1]b"} {
+		if _, err := ParsePath(invalid); err == nil {
+			t.Errorf("expected error for %q", invalid)
+		}
+	}
+}
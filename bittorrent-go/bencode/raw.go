@@ -0,0 +1,43 @@
+package bencode
+
+import (
+	"errors"
+	"reflect"
+)
+
+// RawMessage is a raw bencoded value. Decoding into a RawMessage keeps a copy
+// of the exact bytes the value was made of, and encoding one writes them back
+// out untouched. This is what hashing needs: the info hash is computed over
+// the info dictionary exactly as it appears in the .torrent file, which is not
+// necessarily what re-encoding the decoded dictionary would produce.
+type RawMessage []byte
+
+// Span locates a value within the input it was decoded from, as the half open
+// byte range [Start, End). A struct field of type Span tagged with the span
+// option, e.g. `bencode:"info,span"`, records where the value under that key
+// was found, alongside (or instead of) decoding it into another field.
+type Span struct {
+	Start int
+	End   int
+}
+
+// Len returns the length of the span in bytes.
+func (s Span) Len() int {
+	return s.End - s.Start
+}
+
+// MarshalBencode returns m as is.
+func (m RawMessage) MarshalBencode() ([]byte, error) {
+	if len(m) == 0 {
+		return nil, errors.New("cannot marshal empty RawMessage")
+	}
+	return m, nil
+}
+
+// UnmarshalBencode sets *m to a copy of data.
+func (m *RawMessage) UnmarshalBencode(data []byte) error {
+	*m = append((*m)[0:0], data...)
+	return nil
+}
+
+var spanType = reflect.TypeOf(Span{})
@@ -0,0 +1,149 @@
+package bencode
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUnmarshalRawMessage(t *testing.T) {
+	// keys are deliberately out of order, the raw bytes must be kept as is
+	input := "d4:infod4:spami1e3:cowi2ee4:name4:teste"
+
+	var got struct {
+		Info RawMessage `bencode:"info"`
+		Name string     `bencode:"name"`
+	}
+	if err := Unmarshal([]byte(input), &got); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := "d4:spami1e3:cowi2ee"
+	if string(got.Info) != expected {
+		t.Errorf("expected %q, got %q", expected, string(got.Info))
+	}
+	if got.Name != "test" {
+		t.Errorf("expected name 'test', got %q", got.Name)
+	}
+}
+
+func TestUnmarshalRawMessageCopies(t *testing.T) {
+	input := []byte("l4:spame")
+
+	var raw RawMessage
+	if err := Unmarshal(input, &raw); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	input[0] = 'x'
+	if string(raw) != "l4:spame" {
+		t.Errorf("RawMessage shares memory with the input: %q", string(raw))
+	}
+}
+
+func TestMarshalRawMessage(t *testing.T) {
+	input := map[string]interface{}{
+		"info": RawMessage("d4:spami1e3:cowi2ee"),
+		"name": "test",
+	}
+
+	result, err := Marshal(input)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := "d4:infod4:spami1e3:cowi2ee4:name4:teste"
+	if string(result) != expected {
+		t.Errorf("expected %q, got %q", expected, string(result))
+	}
+}
+
+func TestMarshalInvalidRawMessage(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  RawMessage
+	}{
+		{"empty", RawMessage{}},
+		{"truncated", RawMessage("l4:spam")},
+		{"trailing data", RawMessage("i1ei2e")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Marshal(tt.raw); err == nil {
+				t.Errorf("expected error for %q", string(tt.raw))
+			}
+		})
+	}
+}
+
+type spanned struct {
+	Info     map[string]interface{} `bencode:"info"`
+	InfoSpan Span                   `bencode:"info,span"`
+	URLSpan  Span                   `bencode:"url,span"`
+}
+
+func TestUnmarshalSpan(t *testing.T) {
+	input := "d4:infod1:ai1ee3:url4:spame"
+
+	var got spanned
+	if err := Unmarshal([]byte(input), &got); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if s := input[got.InfoSpan.Start:got.InfoSpan.End]; s != "d1:ai1ee" {
+		t.Errorf("info span points at %q", s)
+	}
+	if s := input[got.URLSpan.Start:got.URLSpan.End]; s != "4:spam" {
+		t.Errorf("url span points at %q", s)
+	}
+	if got.Info["a"] != 1 {
+		t.Errorf("info was not decoded alongside its span: %v", got.Info)
+	}
+	if got.URLSpan.Len() != 6 {
+		t.Errorf("expected span length 6, got %d", got.URLSpan.Len())
+	}
+}
+
+func TestDecoderSpanOffsets(t *testing.T) {
+	first := "i42e"
+	second := "d4:infod1:ai1ee3:url4:spame"
+	dec := NewDecoder(strings.NewReader(first + second))
+
+	var n int
+	if err := dec.Decode(&n); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if dec.InputOffset() != int64(len(first)) {
+		t.Errorf("expected offset %d, got %d", len(first), dec.InputOffset())
+	}
+
+	var got spanned
+	if err := dec.Decode(&got); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// spans are relative to the start of the stream
+	stream := first + second
+	if s := stream[got.InfoSpan.Start:got.InfoSpan.End]; s != "d1:ai1ee" {
+		t.Errorf("info span points at %q", s)
+	}
+	if dec.InputOffset() != int64(len(stream)) {
+		t.Errorf("expected offset %d, got %d", len(stream), dec.InputOffset())
+	}
+}
+
+func TestMarshalSkipsSpanFields(t *testing.T) {
+	input := spanned{
+		Info:     map[string]interface{}{"a": 1},
+		InfoSpan: Span{Start: 1, End: 2},
+	}
+
+	result, err := Marshal(input)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := "d4:infod1:ai1eee"
+	if string(result) != expected {
+		t.Errorf("expected %q, got %q", expected, string(result))
+	}
+}
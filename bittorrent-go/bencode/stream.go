@@ -0,0 +1,198 @@
+package bencode
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"unicode"
+)
+
+// Decoder reads bencoded values one at a time from an input stream, so
+// several values can follow each other on the same stream. It buffers its
+// input, so when the values are followed by something else altogether, like
+// a peer wire message, the start of it is found with Buffered.
+type Decoder struct {
+	r      *bufio.Reader
+	buf    bytes.Buffer // raw bytes of the value currently being read
+	offset int          // bytes of the stream consumed by previous values
+	opts   DecodeOptions
+}
+
+// NewDecoder returns a Decoder reading from r. The Decoder does its own
+// buffering, so it may read past the end of the last value it returns.
+func NewDecoder(r io.Reader) *Decoder {
+	return DecodeOptions{}.NewDecoder(r)
+}
+
+// Decode reads the next bencoded value from the stream and stores it in the
+// value pointed to by v, following the same rules as Unmarshal. It returns
+// io.EOF once the stream is exhausted, and io.ErrUnexpectedEOF if it ends
+// half way through a value.
+func (dec *Decoder) Decode(v interface{}) error {
+
+	if err := dec.readValue(); err != nil {
+		return err
+	}
+	d := &decoder{data: dec.buf.Bytes(), base: dec.offset, opts: dec.opts}
+	dec.offset += dec.buf.Len()
+	return d.unmarshal(v)
+}
+
+// InputOffset returns the offset in the stream right after the last value
+// returned by Decode. Spans recorded while decoding from a Decoder are also
+// relative to the start of the stream.
+func (dec *Decoder) InputOffset() int64 {
+	return int64(dec.offset)
+}
+
+// Buffered returns a reader of the data left in the Decoder's buffer, read
+// from the stream but not part of any value returned by Decode yet. The
+// reader is only valid until the next call to Decode.
+func (dec *Decoder) Buffered() io.Reader {
+	b, _ := dec.r.Peek(dec.r.Buffered())
+	return bytes.NewReader(b)
+}
+
+// readValue copies exactly one bencoded value from the stream into dec.buf.
+// It only checks as much of the structure as it needs to find where the value
+// ends, the rest is left to the decoder proper. The depth, string length and
+// input size limits are enforced here too though, so that a hostile stream
+// is turned away before we've buffered it.
+func (dec *Decoder) readValue() error {
+
+	dec.buf.Reset()
+	depth := 0
+
+	for {
+		c, err := dec.r.ReadByte()
+		if err != nil {
+			if err == io.EOF && dec.buf.Len() > 0 {
+				return io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		dec.buf.WriteByte(c)
+
+		switch {
+		case c == 'i':
+			if err := dec.readUntil('e'); err != nil {
+				return err
+			}
+		case c == 'l' || c == 'd':
+			depth++
+			if max := dec.opts.maxDepth(); depth > max {
+				return &LimitError{Limit: "depth", Max: max, Offset: dec.offset + dec.buf.Len() - 1}
+			}
+			if err := dec.checkSize(0); err != nil {
+				return err
+			}
+			continue
+		case c == 'e' && depth > 0:
+			depth--
+		case unicode.IsDigit(rune(c)):
+			if err := dec.readString(); err != nil {
+				return err
+			}
+		default:
+			return &SyntaxError{
+				Offset:   dec.offset + dec.buf.Len() - 1,
+				Expected: "'i', 'l', 'd' or a string length",
+				msg:      fmt.Sprintf("invalid character '%c'", c),
+			}
+		}
+
+		if err := dec.checkSize(0); err != nil {
+			return err
+		}
+		if depth == 0 {
+			return nil
+		}
+	}
+}
+
+// checkSize makes sure that the value being read, plus the n bytes we're
+// about to read, stays within MaxInputSize
+func (dec *Decoder) checkSize(n int64) error {
+	max := dec.opts.MaxInputSize
+	if max > 0 && int64(dec.buf.Len())+n > int64(max) {
+		return &LimitError{Limit: "input size", Max: max, Offset: dec.offset + max}
+	}
+	return nil
+}
+
+// readUntil copies everything up to and including delim into dec.buf
+func (dec *Decoder) readUntil(delim byte) error {
+
+	chunk, err := dec.r.ReadSlice(delim)
+	for errors.Is(err, bufio.ErrBufferFull) {
+		dec.buf.Write(chunk)
+		if err := dec.checkSize(0); err != nil {
+			return err
+		}
+		chunk, err = dec.r.ReadSlice(delim)
+	}
+	dec.buf.Write(chunk)
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// readString copies the rest of a string whose first length digit was just
+// read. The contents are copied in chunks, so a bogus length can't make us
+// allocate more than what the stream actually holds.
+func (dec *Decoder) readString() error {
+
+	start := dec.buf.Len() - 1
+	if err := dec.readUntil(':'); err != nil {
+		return err
+	}
+
+	lengthStr := string(dec.buf.Bytes()[start : dec.buf.Len()-1])
+	length, err := strconv.ParseInt(lengthStr, 10, 64)
+	if err != nil || length < 0 {
+		return &SyntaxError{
+			Offset:   dec.offset + start,
+			Expected: "string length",
+			msg:      fmt.Sprintf("invalid string length '%s'", lengthStr),
+		}
+	}
+	if max := dec.opts.MaxStringLength; max > 0 && length > int64(max) {
+		return &LimitError{Limit: "string length", Max: max, Offset: dec.offset + start}
+	}
+	if err := dec.checkSize(length); err != nil {
+		return err
+	}
+
+	n, err := io.CopyN(&dec.buf, dec.r, length)
+	if n < length && (err == nil || err == io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// Encoder writes bencoded values to an output stream.
+type Encoder struct {
+	w io.Writer
+}
+
+// NewEncoder returns an Encoder writing to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Encode writes the bencoding of v to the stream, following the same rules as
+// Marshal. The value is written out as it's being encoded rather than built up
+// in memory first, which means that if v turns out to hold something that
+// can't be marshaled, part of it may already have been written.
+func (enc *Encoder) Encode(v interface{}) error {
+
+	bw := bufio.NewWriter(enc.w)
+	if err := marshalTo(bw, v); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
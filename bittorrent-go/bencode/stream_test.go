@@ -0,0 +1,181 @@
+package bencode
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestDecoderMultipleValues(t *testing.T) {
+	input := "4:spami42el4:spami-3eed3:cow3:mooe"
+	expected := []interface{}{
+		"spam",
+		42,
+		[]interface{}{"spam", -3},
+		map[string]interface{}{"cow": "moo"},
+	}
+
+	// OneByteReader makes sure values split across reads are put back together
+	dec := NewDecoder(iotest.OneByteReader(strings.NewReader(input)))
+	for i, want := range expected {
+		var got interface{}
+		if err := dec.Decode(&got); err != nil {
+			t.Fatalf("value %d: unexpected error: %v", i, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("value %d: expected %v, got %v", i, want, got)
+		}
+	}
+
+	var extra interface{}
+	if err := dec.Decode(&extra); err != io.EOF {
+		t.Errorf("expected io.EOF at end of stream, got %v", err)
+	}
+}
+
+func TestDecoderStruct(t *testing.T) {
+	input := "d8:announce3:url4:infod4:name4:test12:piece lengthi16384eee"
+
+	var got testTorrent
+	if err := NewDecoder(strings.NewReader(input)).Decode(&got); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.Announce != "url" || got.Info.Name != "test" || got.Info.PieceLength != 16384 {
+		t.Errorf("unexpected result %+v", got)
+	}
+}
+
+func TestDecoderLeavesTrailingData(t *testing.T) {
+	r := strings.NewReader("i1etrailing")
+	dec := NewDecoder(r)
+
+	var v int
+	if err := dec.Decode(&v); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if v != 1 {
+		t.Errorf("expected 1, got %d", v)
+	}
+
+	// the next value is garbage, which should only show up now
+	if err := dec.Decode(&v); err == nil {
+		t.Error("expected error for trailing garbage")
+	}
+}
+
+func TestDecoderBuffered(t *testing.T) {
+	dec := NewDecoder(strings.NewReader("i1e4:spamtrailing"))
+
+	var v interface{}
+	for range 2 {
+		if err := dec.Decode(&v); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	rest, err := io.ReadAll(dec.Buffered())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(rest) != "trailing" {
+		t.Errorf("expected %q to be left, got %q", "trailing", rest)
+	}
+	if dec.InputOffset() != 9 {
+		t.Errorf("expected offset 9, got %d", dec.InputOffset())
+	}
+}
+
+func TestDecoderErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected error
+	}{
+		{"truncated string", "10:spam", io.ErrUnexpectedEOF},
+		{"truncated integer", "i42", io.ErrUnexpectedEOF},
+		{"truncated list", "l4:spam", io.ErrUnexpectedEOF},
+		{"truncated dict", "d3:cow", io.ErrUnexpectedEOF},
+		{"invalid character", "x", nil},
+		{"stray end", "e", nil},
+		{"invalid length", "4x:spam", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var v interface{}
+			err := NewDecoder(strings.NewReader(tt.input)).Decode(&v)
+			if err == nil {
+				t.Fatalf("expected error for input %q", tt.input)
+			}
+			if tt.expected != nil && !errors.Is(err, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+func TestEncoder(t *testing.T) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+
+	values := []interface{}{
+		"spam",
+		42,
+		map[string]interface{}{"cow": "moo"},
+	}
+	for _, v := range values {
+		if err := enc.Encode(v); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	expected := "4:spami42ed3:cow3:mooe"
+	if buf.String() != expected {
+		t.Errorf("expected %q, got %q", expected, buf.String())
+	}
+}
+
+func TestEncoderUnsupportedType(t *testing.T) {
+	var buf bytes.Buffer
+	if err := NewEncoder(&buf).Encode(3.14); err == nil {
+		t.Error("expected error for unsupported type")
+	}
+}
+
+func TestEncoderWriteError(t *testing.T) {
+	if err := NewEncoder(errWriter{}).Encode("spam"); err == nil {
+		t.Error("expected error from failing writer")
+	}
+}
+
+// errWriter fails every write
+type errWriter struct{}
+
+func (errWriter) Write([]byte) (int, error) {
+	return 0, errors.New("write failed")
+}
+
+func TestStreamRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	dec := NewDecoder(&buf)
+
+	input := []interface{}{"hello", 42, []interface{}{"a", 1}}
+	for _, v := range input {
+		if err := enc.Encode(v); err != nil {
+			t.Fatalf("encode error: %v", err)
+		}
+	}
+	for _, want := range input {
+		var got interface{}
+		if err := dec.Decode(&got); err != nil {
+			t.Fatalf("decode error: %v", err)
+		}
+		if !reflect.DeepEqual(want, got) {
+			t.Errorf("roundtrip failed: expected %v, got %v", want, got)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"time"
+
+	"github.com/lourencovales/codecrafters/bittorrent-go/tracker"
+)
+
+// stopTimeout bounds how long Close waits on the trackers, since nobody's
+// waiting for their answers to the stopped announce.
+const stopTimeout = 10 * time.Second
+
+// startAnnouncing starts an Announcer for each of the hashes the torrent goes
+// by, so that hybrid torrents get peers from both the v1 and v2 swarms, and
+// adds the peers they hand out to the client's. It only fails if none of them
+// could be started.
+func (c *Client) startAnnouncing(port uint16) error {
+
+	var errs []error
+	for _, hash := range c.TorrentInfo.SwarmHashes() {
+		a := tracker.NewAnnouncer(c.Trackers, hash, c.PeerID, tracker.AnnouncerOptions{
+			Port:    port,
+			Stats:   c.stats,
+			OnPeers: c.addPeers,
+			OnError: func(err error) {
+				fmt.Fprintf(os.Stderr, "Tracker announce for %x: %v.\n", hash, err)
+			},
+		})
+		found, err := a.Start(context.Background())
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		c.announcers = append(c.announcers, a)
+		c.addPeers(found)
+	}
+
+	if len(c.announcers) == 0 {
+		return errors.Join(errs...)
+	}
+	return nil
+}
+
+// stats are what we tell the trackers. We don't upload anything yet.
+func (c *Client) stats() tracker.Stats {
+	return tracker.Stats{
+		Downloaded: c.downloaded.Load(),
+		Left:       int64(c.TorrentInfo.TotalLength) - c.verified.Load(),
+	}
+}
+
+// addPeers adds the peers in found that the client doesn't have yet
+func (c *Client) addPeers(found []netip.AddrPort) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	seen := make(map[netip.AddrPort]bool, len(c.Peers))
+	for _, p := range c.Peers {
+		seen[p] = true
+	}
+	for _, p := range found {
+		if !seen[p] {
+			seen[p] = true
+			c.Peers = append(c.Peers, p)
+		}
+	}
+}
+
+// peers returns a copy of the peers we know of so far
+func (c *Client) peers() []netip.AddrPort {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]netip.AddrPort(nil), c.Peers...)
+}
+
+// Close leaves the swarm, sending the trackers a stopped announce. It gives
+// up on trackers that don't answer within stopTimeout.
+func (c *Client) Close() error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), stopTimeout)
+	defer cancel()
+
+	var errs []error
+	for _, a := range c.announcers {
+		if err := a.Stop(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	c.announcers = nil
+	return errors.Join(errs...)
+}
@@ -0,0 +1,79 @@
+package client
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/lourencovales/codecrafters/bittorrent-go/torrent"
+	"github.com/lourencovales/codecrafters/bittorrent-go/tracker"
+)
+
+func TestAnnounceLifecycle(t *testing.T) {
+	data := bytes.Repeat([]byte("announce"), 5000)
+	info := &torrent.TorrentInfo{PieceLength: 16384, TotalLength: len(data)}
+	for start := 0; start < len(data); start += info.PieceLength {
+		end := min(start+info.PieceLength, len(data))
+		info.PieceHashes = append(info.PieceHashes, sha1.Sum(data[start:end]))
+	}
+	seeder := servePieces(t, info, data)
+
+	// the tracker hands out the seeder, and passes on what it's told
+	queries := make(chan url.Values, 8)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries <- r.URL.Query()
+		ip := seeder.Addr().As4()
+		peers := append(ip[:], byte(seeder.Port()>>8), byte(seeder.Port()))
+		w.Write([]byte("d5:peers6:" + string(peers) + "e"))
+	}))
+	defer srv.Close()
+
+	c := &Client{TorrentInfo: info, Trackers: tracker.NewTierList(&torrent.TorrentInfo{AnnounceURL: srv.URL})}
+	if err := c.startAnnouncing(6881); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(c.Peers, []netip.AddrPort{seeder}) {
+		t.Errorf("expected the tracker's peers, got %v", c.Peers)
+	}
+
+	expectAnnounce := func(event string, downloaded, left int) {
+		t.Helper()
+		select {
+		case q := <-queries:
+			if q.Get("event") != event || q.Get("downloaded") != strconv.Itoa(downloaded) || q.Get("left") != strconv.Itoa(left) {
+				t.Errorf("expected %s announce with %d downloaded and %d left, got %v", event, downloaded, left, q)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for the %s announce", event)
+		}
+	}
+	expectAnnounce("started", 0, len(data))
+
+	if err := c.DownloadFile(filepath.Join(t.TempDir(), "out.bin")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expectAnnounce("completed", len(data), 0)
+	expectAnnounce("stopped", len(data), 0)
+}
+
+func TestAddPeers(t *testing.T) {
+	a := netip.MustParseAddrPort("10.0.0.1:6881")
+	b := netip.MustParseAddrPort("[2001:db8::1]:6881")
+
+	c := &Client{Peers: []netip.AddrPort{a}}
+	c.addPeers([]netip.AddrPort{b, a, b})
+	if expected := []netip.AddrPort{a, b}; !reflect.DeepEqual(c.peers(), expected) {
+		t.Errorf("expected %v, got %v", expected, c.peers())
+	}
+}
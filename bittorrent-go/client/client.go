@@ -1,14 +1,16 @@
 package client
 
 import (
-	"bytes"
 	"crypto/rand"
-	"crypto/sha1"
 	"errors"
 	"fmt"
 	"io"
 	"net"
+	"net/netip"
 	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/lourencovales/codecrafters/bittorrent-go/peer"
@@ -20,13 +22,40 @@ import (
 // BitTorrent download session.
 type Client struct {
 	TorrentInfo *torrent.TorrentInfo
-	Peers       []string
+	Trackers    *tracker.TierList
+	Peers       []netip.AddrPort
 	PeerID      [20]byte
+
+	// mu guards Peers, which the announcers add to as they go
+	mu         sync.Mutex
+	announcers []*tracker.Announcer
+	// downloaded counts the bytes of every piece we got, good or not, and
+	// verified those of the pieces that checked out, for the trackers
+	downloaded atomic.Int64
+	verified   atomic.Int64
+	// pex is what we know for exchanging peers with the ones we connect to
+	pex pexState
+}
+
+// Options holds the settings a Client is created with. The zero value is
+// ready to use.
+type Options struct {
+	// Tracker talks to the torrent's trackers, tracker.DefaultClient when
+	// nil. Its Timeout bounds how long each tracker gets to answer.
+	Tracker *tracker.Client
+}
+
+// newTierList builds the tier list for metaInfo, talking to the trackers
+// the way opts says
+func (opts Options) newTierList(metaInfo *torrent.TorrentInfo) *tracker.TierList {
+	tl := tracker.NewTierList(metaInfo)
+	tl.Client = opts.Tracker
+	return tl
 }
 
 // New is the factory function that creates a new Client instance for any given
 // torrent file.
-func New(torrFile string) (*Client, error) {
+func New(torrFile string, opts Options) (*Client, error) {
 
 	metaInfo, err := torrent.ParseFile(torrFile)
 	if err != nil {
@@ -40,35 +69,118 @@ func New(torrFile string) (*Client, error) {
 
 	const listenPort uint16 = 6881 // TODO we might want to make this settable
 
-	peers, err := tracker.GetPeers(metaInfo, peerID, listenPort)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get peers from tracker: %w", err)
-	}
-
-	return &Client{
+	c := &Client{
 		TorrentInfo: metaInfo,
-		Peers:       peers,
+		Trackers:    opts.newTierList(metaInfo),
 		PeerID:      peerID,
-	}, nil
+	}
+	var trackerErr error
+	if len(metaInfo.AnnounceList) > 0 {
+		// every tracker of every tier gets a chance before we give up
+		err := c.startAnnouncing(listenPort)
+		if err == nil {
+			return c, nil
+		}
+		trackerErr = fmt.Errorf("failed to get peers from tracker: %w", err)
+		if metaInfo.Private {
+			return nil, trackerErr
+		}
+	}
+	// trackerless torrents, and those whose trackers are all down, are left
+	// to the DHT
+	if err := c.useDHT(); err != nil {
+		return nil, errors.Join(trackerErr, err)
+	}
+	return c, nil
 }
 
-// DownloadFile is the function that orchestrates the download of the file
+// DownloadFile is the function that orchestrates the download of the file.
+// For multi-file torrents outFile is the directory the files are written to,
+// following the paths in the torrent. Each piece is written out as soon as
+// it's been downloaded and verified, split across the files it spans.
 func (c *Client) DownloadFile(outFile string) error {
 
-	fileData := make([]byte, c.TorrentInfo.TotalLength)
-	pieceCount := len(c.TorrentInfo.PieceHashes)
+	files, err := c.createFiles(outFile)
+	if err != nil {
+		return err
+	}
+	defer closeAll(files)
 
+	pieceCount := c.TorrentInfo.PieceCount()
 	for i := 0; i < pieceCount; i++ {
 		fmt.Printf("Downloading piece %d of %d...\n", i+1, pieceCount)
 		pieceData, err := c.downloadPiece(i)
 		if err != nil {
 			return fmt.Errorf("failed to download piece %d: %w", i, err)
 		}
-		start := i * c.TorrentInfo.PieceLength
-		copy(fileData[start:], pieceData)
+		for _, s := range c.TorrentInfo.PieceSections(i) {
+			if files[s.File] == nil {
+				continue // padding
+			}
+			section := pieceData[s.PieceOffset : s.PieceOffset+s.Length]
+			if _, err := files[s.File].WriteAt(section, int64(s.FileOffset)); err != nil {
+				return fmt.Errorf("failed to write piece %d: %w", i, err)
+			}
+		}
+	}
+
+	for _, f := range files {
+		if f == nil {
+			continue
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
 	}
 
-	return os.WriteFile(outFile, fileData, 0644)
+	for _, a := range c.announcers {
+		a.Completed()
+	}
+	return nil
+}
+
+// createFiles creates the files the torrent will be written to, with their
+// final size, and any directories they need. Single file torrents are written
+// straight to outFile. Padding files aren't created, they're left as nil.
+func (c *Client) createFiles(outFile string) ([]*os.File, error) {
+
+	var files []*os.File
+	for _, tf := range c.TorrentInfo.FileList() {
+		if tf.Padding {
+			files = append(files, nil)
+			continue
+		}
+		path := outFile
+		if c.TorrentInfo.MultiFile {
+			path = filepath.Join(append([]string{outFile}, tf.Path...)...)
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				closeAll(files)
+				return nil, err
+			}
+		}
+
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+		if err != nil {
+			closeAll(files)
+			return nil, err
+		}
+		files = append(files, f)
+		if err := f.Truncate(int64(tf.Length)); err != nil {
+			closeAll(files)
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// closeAll closes files, for when something went wrong half way through
+// opening them
+func closeAll(files []*os.File) {
+	for _, f := range files {
+		if f != nil {
+			f.Close()
+		}
+	}
 }
 
 // DownloadPiece is the exported function that orchestrates the download of a single
@@ -88,20 +200,25 @@ func (c *Client) DownloadPiece(outFile string, pieceIndex int) error {
 // downloading a single piece by running through the list of available peers
 func (c *Client) downloadPiece(pieceIndex int) ([]byte, error) {
 
-	for _, peerAddr := range c.Peers {
+	if pieceIndex < 0 || pieceIndex >= c.TorrentInfo.PieceCount() {
+		return nil, fmt.Errorf("piece %d out of range", pieceIndex)
+	}
+
+	for _, peerAddr := range c.peers() {
 		pieceData, err := c.tryDl(peerAddr, pieceIndex)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Failed to download from peer %s: %v. Trying next peer.\n", peerAddr, err)
 			continue
 		}
+		c.downloaded.Add(int64(len(pieceData)))
 
-		expectedHash := c.TorrentInfo.PieceHashes[pieceIndex]
-		actualHash := sha1.Sum(pieceData)
-		if !bytes.Equal(expectedHash[:], actualHash[:]) {
-			fmt.Fprintf(os.Stderr, "Piece hash mismatch for piece %d from peer %s. Trying next peer.\n", pieceIndex, peerAddr)
+		// SHA-1 for v1, the merkle tree for v2, and both for hybrids
+		if err := c.TorrentInfo.VerifyPiece(pieceIndex, pieceData); err != nil {
+			fmt.Fprintf(os.Stderr, "Piece hash mismatch for piece %d from peer %s: %v. Trying next peer.\n", pieceIndex, peerAddr, err)
 			continue
 		}
 
+		c.verified.Add(int64(len(pieceData)))
 		return pieceData, nil
 	}
 
@@ -110,20 +227,15 @@ func (c *Client) downloadPiece(pieceIndex int) ([]byte, error) {
 
 // tryDL is an unexported function that contains the logic for downloading a
 // piece from a single peer
-func (c *Client) tryDl(peerAddr string, pieceIndex int) ([]byte, error) {
+func (c *Client) tryDl(peerAddr netip.AddrPort, pieceIndex int) ([]byte, error) {
 
-	conn, err := net.DialTimeout("tcp", peerAddr, 5*time.Second)
+	conn, err := c.connect(peerAddr)
 	if err != nil {
 		return nil, err
 	}
 	defer conn.Close()
 
-	_, err = peer.Handshake(conn, c.TorrentInfo.InfoHash, c.PeerID)
-	if err != nil {
-		return nil, err
-	}
-
-	bitMsg, err := peer.ReadMsg(conn)
+	bitMsg, err := c.readMsg(conn)
 	if err != nil || bitMsg.ID != peer.MsgBitfield {
 		return nil, errors.New("expected bitfield message")
 	}
@@ -136,18 +248,12 @@ func (c *Client) tryDl(peerAddr string, pieceIndex int) ([]byte, error) {
 		return nil, err
 	}
 
-	unchokeMsg, err := peer.ReadMsg(conn)
+	unchokeMsg, err := c.readMsg(conn)
 	if err != nil || unchokeMsg.ID != peer.MsgUnchoke {
 		return nil, errors.New("unexpected unchoke message")
 	}
 
-	pieceSize := c.TorrentInfo.PieceLength
-	if pieceIndex == len(c.TorrentInfo.PieceHashes)-1 {
-		pieceSize = c.TorrentInfo.TotalLength % c.TorrentInfo.PieceLength
-		if pieceSize == 0 {
-			pieceSize = c.TorrentInfo.PieceLength
-		}
-	}
+	pieceSize := c.TorrentInfo.PieceSize(pieceIndex)
 
 	pieceData := make([]byte, pieceSize)
 	bytesDownloaded := 0
@@ -164,7 +270,7 @@ func (c *Client) tryDl(peerAddr string, pieceIndex int) ([]byte, error) {
 			return nil, err
 		}
 
-		pieceMsg, err := peer.ReadMsg(conn)
+		pieceMsg, err := c.readMsg(conn)
 		if err != nil || pieceMsg.ID != peer.MsgPiece {
 			return nil, errors.New("unexpected piece message")
 		}
@@ -176,3 +282,26 @@ func (c *Client) tryDl(peerAddr string, pieceIndex int) ([]byte, error) {
 
 	return pieceData, nil
 }
+
+// connect dials a peer and does the handshake, saying we support the
+// extension protocol. Peers of hybrid torrents may be in either swarm, and
+// hang up on a hash they don't know, so each of the torrent's hashes is tried
+// in turn.
+func (c *Client) connect(peerAddr netip.AddrPort) (*peerConn, error) {
+
+	var err error
+	for _, hash := range c.TorrentInfo.SwarmHashes() {
+		var conn net.Conn
+		conn, err = net.DialTimeout("tcp", peerAddr.String(), 5*time.Second)
+		if err != nil {
+			break
+		}
+		if _, _, err = peer.HandshakeExtended(conn, hash, c.PeerID); err == nil {
+			c.pex.connected(peerAddr, time.Now())
+			return &peerConn{Conn: conn, addr: peerAddr}, nil
+		}
+		conn.Close()
+	}
+	c.pex.failed(peerAddr)
+	return nil, err
+}
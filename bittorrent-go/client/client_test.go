@@ -1,11 +1,24 @@
 package client
 
 import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/netip"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/lourencovales/codecrafters/bittorrent-go/bencode"
+	"github.com/lourencovales/codecrafters/bittorrent-go/peer"
 	"github.com/lourencovales/codecrafters/bittorrent-go/torrent"
+	"github.com/lourencovales/codecrafters/bittorrent-go/tracker"
 )
 
 func createTestTorrentFile(t *testing.T) string {
@@ -44,7 +57,7 @@ func createTestTorrentFile(t *testing.T) string {
 }
 
 func TestNewClientInvalidTorrent(t *testing.T) {
-	_, err := New("nonexistent.torrent")
+	_, err := New("nonexistent.torrent", Options{})
 	if err == nil {
 		t.Error("expected error for non-existent torrent file")
 	}
@@ -53,10 +66,11 @@ func TestNewClientInvalidTorrent(t *testing.T) {
 func TestNewClientValidTorrent(t *testing.T) {
 	tmpFile := createTestTorrentFile(t)
 	defer os.Remove(tmpFile)
+	useTestDHT(t)
 
 	// This test will fail because it tries to contact a real tracker
 	// but that's expected behavior for this type of integration test
-	client, err := New(tmpFile)
+	client, err := New(tmpFile, Options{})
 	if err != nil {
 		// This is expected since the tracker doesn't exist
 		t.Logf("expected error contacting tracker: %v", err)
@@ -76,6 +90,31 @@ func TestNewClientValidTorrent(t *testing.T) {
 	}
 }
 
+func TestNewTrackerTimeout(t *testing.T) {
+	silent, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer silent.Close()
+
+	// private, so that there's no falling back to the DHT
+	rawInfo := strings.Replace(string(testInfo(t, []byte("timeout"))), "6:pieces", "7:privatei1e6:pieces", 1)
+	announce := "udp://" + silent.LocalAddr().String()
+	path := filepath.Join(t.TempDir(), "silent.torrent")
+	if err := os.WriteFile(path, []byte("d8:announce"+strconv.Itoa(len(announce))+":"+announce+"4:info"+rawInfo+"e"), 0o644); err != nil {
+		t.Fatalf("failed to write torrent: %v", err)
+	}
+
+	start := time.Now()
+	_, err = New(path, Options{Tracker: &tracker.Client{Timeout: 100 * time.Millisecond}})
+	if err == nil || !strings.Contains(err.Error(), "failed to get peers from tracker") {
+		t.Errorf("expected the tracker to fail, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("expected the tracker's timeout to be used, took %v", elapsed)
+	}
+}
+
 func TestClientStructure(t *testing.T) {
 	// Test that we can create a client struct manually
 	// This tests the structure without network calls
@@ -89,7 +128,7 @@ func TestClientStructure(t *testing.T) {
 		TotalLength: 1000000,
 	}
 
-	testPeers := []string{"192.168.1.1:6881", "10.0.0.1:6882"}
+	testPeers := []netip.AddrPort{netip.MustParseAddrPort("192.168.1.1:6881"), netip.MustParseAddrPort("[2001:db8::1]:6882")}
 	testPeerID := [20]byte{41, 42, 43, 44, 45, 46, 47, 48, 49, 50, 51, 52, 53, 54, 55, 56, 57, 58, 59, 60}
 
 	client := &Client{
@@ -118,7 +157,7 @@ func TestDownloadFileInvalidFile(t *testing.T) {
 			PieceHashes: [][20]byte{},
 			TotalLength: 0,
 		},
-		Peers:  []string{},
+		Peers:  []netip.AddrPort{},
 		PeerID: [20]byte{},
 	}
 
@@ -138,7 +177,7 @@ func TestDownloadPieceInvalidIndex(t *testing.T) {
 			PieceLength: 262144,
 			TotalLength: 1000000,
 		},
-		Peers:  []string{},
+		Peers:  []netip.AddrPort{},
 		PeerID: [20]byte{},
 	}
 
@@ -166,7 +205,7 @@ func TestDownloadPieceIndexOutOfRange(t *testing.T) {
 			PieceLength: 262144,
 			TotalLength: 1000000,
 		},
-		Peers:  []string{"192.168.1.1:6881"},
+		Peers:  []netip.AddrPort{netip.MustParseAddrPort("192.168.1.1:6881")},
 		PeerID: [20]byte{},
 	}
 
@@ -182,4 +221,210 @@ func TestDownloadPieceIndexOutOfRange(t *testing.T) {
 	if err == nil {
 		t.Error("expected error for piece index out of range")
 	}
-}
\ No newline at end of file
+}
+
+// servePieces runs a peer on the loopback interface that has every piece of
+// data, and returns its address
+func servePieces(t *testing.T, info *torrent.TorrentInfo, data []byte) netip.AddrPort {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	return servePiecesOn(t, ln, info, data)
+}
+
+func servePiecesOn(t *testing.T, ln net.Listener, info *torrent.TorrentInfo, data []byte) netip.AddrPort {
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go seed(conn, info, data)
+		}
+	}()
+	return ln.Addr().(*net.TCPAddr).AddrPort()
+}
+
+// seed answers a single client connection, until the client hangs up
+func seed(conn net.Conn, info *torrent.TorrentInfo, data []byte) {
+	defer conn.Close()
+
+	handshake := make([]byte, 68)
+	if _, err := io.ReadFull(conn, handshake); err != nil {
+		return
+	}
+	conn.Write(handshake) // echoing it back is as good as any
+	seedPieces(conn, info, data)
+}
+
+// seedPieces is the part of seed that comes after the handshake
+func seedPieces(conn net.Conn, info *torrent.TorrentInfo, data []byte) {
+
+	bitfield := make([]byte, (info.PieceCount()+7)/8)
+	for i := range bitfield {
+		bitfield[i] = 0xff
+	}
+	peer.SendMsg(conn, peer.MsgBitfield, bitfield)
+	if _, err := peer.ReadMsg(conn); err != nil { // interested
+		return
+	}
+	peer.SendMsg(conn, peer.MsgUnchoke, nil)
+
+	for {
+		msg, err := peer.ReadMsg(conn)
+		if err != nil || msg.ID != peer.MsgRequest {
+			return
+		}
+		index := binary.BigEndian.Uint32(msg.Payload[0:4])
+		begin := binary.BigEndian.Uint32(msg.Payload[4:8])
+		length := binary.BigEndian.Uint32(msg.Payload[8:12])
+
+		start := int(index)*info.PieceLength + int(begin)
+		payload := append(append([]byte{}, msg.Payload[0:8]...), data[start:start+int(length)]...)
+		peer.SendMsg(conn, peer.MsgPiece, payload)
+	}
+}
+
+func TestDownloadFileMultiFile(t *testing.T) {
+	// 40000 bytes over three files, one of them empty, in 16 KiB pieces so
+	// that pieces straddle file boundaries
+	data := make([]byte, 40000)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	info := &torrent.TorrentInfo{
+		PieceLength: 16384,
+		TotalLength: len(data),
+		Name:        "dir",
+		Files: []torrent.File{
+			{Path: []string{"a.bin"}, Length: 20000, Offset: 0},
+			{Path: []string{"empty"}, Length: 0, Offset: 20000},
+			{Path: []string{"sub", "b.bin"}, Length: 20000, Offset: 20000},
+		},
+		MultiFile: true,
+	}
+	for start := 0; start < len(data); start += info.PieceLength {
+		end := min(start+info.PieceLength, len(data))
+		info.PieceHashes = append(info.PieceHashes, sha1.Sum(data[start:end]))
+	}
+
+	client := &Client{TorrentInfo: info, Peers: []netip.AddrPort{servePieces(t, info, data)}}
+	outDir := filepath.Join(t.TempDir(), "out")
+	if err := client.DownloadFile(outDir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, f := range info.Files {
+		got, err := os.ReadFile(filepath.Join(append([]string{outDir}, f.Path...)...))
+		if err != nil {
+			t.Fatalf("failed to read %v: %v", f.Path, err)
+		}
+		if !bytes.Equal(got, data[f.Offset:f.Offset+f.Length]) {
+			t.Errorf("contents of %v don't match", f.Path)
+		}
+	}
+}
+
+func TestDownloadFileSingleFile(t *testing.T) {
+	data := bytes.Repeat([]byte("single"), 5000)
+	info := &torrent.TorrentInfo{
+		PieceLength: 16384,
+		TotalLength: len(data),
+	}
+	for start := 0; start < len(data); start += info.PieceLength {
+		end := min(start+info.PieceLength, len(data))
+		info.PieceHashes = append(info.PieceHashes, sha1.Sum(data[start:end]))
+	}
+
+	client := &Client{TorrentInfo: info, Peers: []netip.AddrPort{servePieces(t, info, data)}}
+	outFile := filepath.Join(t.TempDir(), "out.bin")
+	if err := client.DownloadFile(outFile); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got, err := os.ReadFile(outFile)
+	if err != nil {
+		t.Fatalf("failed to read output: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("downloaded file doesn't match")
+	}
+}
+
+func TestDownloadFileIPv6(t *testing.T) {
+	ln, err := net.Listen("tcp", "[::1]:0")
+	if err != nil {
+		t.Skipf("no IPv6 loopback: %v", err)
+	}
+
+	data := bytes.Repeat([]byte("six"), 10000)
+	info := &torrent.TorrentInfo{PieceLength: 16384, TotalLength: len(data)}
+	for start := 0; start < len(data); start += info.PieceLength {
+		end := min(start+info.PieceLength, len(data))
+		info.PieceHashes = append(info.PieceHashes, sha1.Sum(data[start:end]))
+	}
+
+	client := &Client{TorrentInfo: info, Peers: []netip.AddrPort{servePiecesOn(t, ln, info, data)}}
+	outFile := filepath.Join(t.TempDir(), "out.bin")
+	if err := client.DownloadFile(outFile); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got, err := os.ReadFile(outFile)
+	if err != nil {
+		t.Fatalf("failed to read output: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("downloaded file doesn't match")
+	}
+}
+
+func TestDownloadFileV2(t *testing.T) {
+	// files of a single block each, so their pieces roots are just the hash
+	// of their contents, with padding in between as BEP 52 lays them out
+	a := bytes.Repeat([]byte("a"), 10000)
+	b := bytes.Repeat([]byte("b"), 3000)
+	data := append(append(append([]byte{}, a...), make([]byte, 16384-len(a))...), b...)
+
+	info := &torrent.TorrentInfo{
+		MetaVersion: 2,
+		PieceLength: 16384,
+		TotalLength: len(data),
+		Name:        "v2",
+		Files: []torrent.File{
+			{Path: []string{"a"}, Length: len(a), Offset: 0, PiecesRoot: sha256.Sum256(a)},
+			{Path: []string{".pad", "6384"}, Length: 16384 - len(a), Offset: len(a), Padding: true},
+			{Path: []string{"b"}, Length: len(b), Offset: 16384, PiecesRoot: sha256.Sum256(b)},
+		},
+		MultiFile: true,
+	}
+
+	client := &Client{TorrentInfo: info, Peers: []netip.AddrPort{servePieces(t, info, data)}}
+	outDir := filepath.Join(t.TempDir(), "out")
+	if err := client.DownloadFile(outDir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for name, expected := range map[string][]byte{"a": a, "b": b} {
+		got, err := os.ReadFile(filepath.Join(outDir, name))
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", name, err)
+		}
+		if !bytes.Equal(got, expected) {
+			t.Errorf("contents of %s don't match", name)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(outDir, ".pad")); !os.IsNotExist(err) {
+		t.Error("expected padding files not to be written")
+	}
+
+	// a peer sending bad data doesn't get past the merkle check
+	bad := append([]byte{}, data...)
+	bad[len(bad)-1] = 'x'
+	client = &Client{TorrentInfo: info, Peers: []netip.AddrPort{servePieces(t, info, bad)}}
+	if err := client.DownloadFile(filepath.Join(t.TempDir(), "bad")); err == nil {
+		t.Error("expected error for corrupted data")
+	}
+}
@@ -0,0 +1,100 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/lourencovales/codecrafters/bittorrent-go/dht"
+)
+
+// dhtTimeout bounds how long looking up peers on the DHT takes, joining it
+// included.
+const dhtTimeout = 30 * time.Second
+
+// DHTConfig configures the DHT node peers are looked up on when the trackers
+// have none. When StateFile is empty the routing table is kept in the user's
+// cache directory, so that later runs don't need the bootstrap nodes.
+var DHTConfig dht.Config
+
+// dhtStateFile returns where the routing table is kept by default, or "" when
+// there's no cache directory to keep it in
+func dhtStateFile() string {
+
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	dir = filepath.Join(dir, "bittorrent-go")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "dht.dat")
+}
+
+// dhtPeers looks up the peers of each of hashes on the DHT. nodes are joined
+// from on top of the bootstrap nodes, for trackerless torrents that come with
+// some. Finding no peers at all is an error.
+func dhtPeers(hashes [][20]byte, nodes []string) ([]netip.AddrPort, error) {
+
+	cfg := DHTConfig
+	if cfg.StateFile == "" {
+		cfg.StateFile = dhtStateFile()
+	}
+	if cfg.Bootstrap == nil {
+		cfg.Bootstrap = dht.DefaultBootstrap
+	}
+	cfg.Bootstrap = append(slices.Clip(cfg.Bootstrap), nodes...)
+
+	node, err := dht.Listen(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start the DHT node: %w", err)
+	}
+	defer node.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), dhtTimeout)
+	defer cancel()
+
+	var peers []netip.AddrPort
+	var errs []error
+	for _, hash := range hashes {
+		found, err := node.GetPeers(ctx, hash)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		for _, p := range found {
+			if !slices.Contains(peers, p) {
+				peers = append(peers, p)
+			}
+		}
+	}
+
+	if len(peers) == 0 {
+		if len(errs) > 0 {
+			return nil, fmt.Errorf("failed to get peers from the DHT: %w", errors.Join(errs...))
+		}
+		return nil, errors.New("no peers found on the DHT")
+	}
+	return peers, nil
+}
+
+// useDHT adds the peers of the torrent found on the DHT to the client's.
+// Private torrents only get peers from their trackers (BEP 27), so they're
+// turned down.
+func (c *Client) useDHT() error {
+
+	if c.TorrentInfo.Private {
+		return errors.New("private torrents can't use the DHT")
+	}
+	found, err := dhtPeers(c.TorrentInfo.SwarmHashes(), c.TorrentInfo.Nodes)
+	if err != nil {
+		return err
+	}
+	c.addPeers(found)
+	return nil
+}
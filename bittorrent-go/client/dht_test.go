@@ -0,0 +1,146 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lourencovales/codecrafters/bittorrent-go/dht"
+)
+
+// useTestDHT keeps DHT lookups to the loopback interface for the rest of the
+// test, joining from bootstrap, or from nowhere when there's none
+func useTestDHT(t *testing.T, bootstrap ...string) {
+	old := DHTConfig
+	DHTConfig = dht.Config{
+		Addr:      "127.0.0.1:0",
+		Bootstrap: append([]string{}, bootstrap...),
+		StateFile: filepath.Join(t.TempDir(), "dht.dat"),
+		Timeout:   200 * time.Millisecond,
+	}
+	t.Cleanup(func() { DHTConfig = old })
+}
+
+// startTestDHT starts a DHT of a few nodes on the loopback interface, where
+// seeder is announced for infoHash, and returns the address of one of them
+func startTestDHT(t *testing.T, infoHash [20]byte, seeder netip.AddrPort) string {
+	var nodes []*dht.Node
+	for i := range 4 {
+		cfg := dht.Config{Addr: "127.0.0.1:0", Bootstrap: []string{}, Timeout: 200 * time.Millisecond}
+		if i > 0 {
+			cfg.Bootstrap = []string{nodes[0].Addr().String()}
+		}
+		n, err := dht.Listen(cfg)
+		if err != nil {
+			t.Fatalf("failed to start DHT node: %v", err)
+		}
+		t.Cleanup(func() { n.Close() })
+		if i > 0 {
+			if err := n.Bootstrap(context.Background()); err != nil {
+				t.Fatalf("failed to join the DHT: %v", err)
+			}
+		}
+		nodes = append(nodes, n)
+	}
+
+	if seeder.IsValid() {
+		if _, err := nodes[len(nodes)-1].Announce(context.Background(), infoHash, seeder.Port()); err != nil {
+			t.Fatalf("failed to announce: %v", err)
+		}
+	}
+	return nodes[0].Addr().String()
+}
+
+// writeTrackerless writes a torrent without trackers, with a DHT node to join
+// from instead, and returns its path
+func writeTrackerless(t *testing.T, rawInfo []byte, node string) string {
+	host, port, _ := strings.Cut(node, ":")
+	file := "d4:info" + string(rawInfo) + "5:nodesll" + strconv.Itoa(len(host)) + ":" + host + "i" + port + "eeee"
+	path := filepath.Join(t.TempDir(), "trackerless.torrent")
+	if err := os.WriteFile(path, []byte(file), 0o644); err != nil {
+		t.Fatalf("failed to write torrent: %v", err)
+	}
+	return path
+}
+
+func TestNewTrackerless(t *testing.T) {
+	data := bytes.Repeat([]byte("trackerless"), 4000)
+	rawInfo := testInfo(t, data)
+	seeder := netip.MustParseAddrPort(serveMagnet(t, rawInfo, data))
+	node := startTestDHT(t, sha1.Sum(rawInfo), seeder)
+	useTestDHT(t) // the torrent's node is enough
+
+	c, err := New(writeTrackerless(t, rawInfo, node), Options{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer c.Close()
+	if peers := c.peers(); len(peers) != 1 || peers[0] != seeder {
+		t.Fatalf("expected the seeder from the DHT, got %v", peers)
+	}
+
+	outFile := filepath.Join(t.TempDir(), "out.bin")
+	if err := c.DownloadFile(outFile); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got, err := os.ReadFile(outFile)
+	if err != nil {
+		t.Fatalf("failed to read output: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("downloaded file doesn't match")
+	}
+}
+
+func TestNewTrackerlessErrors(t *testing.T) {
+	data := bytes.Repeat([]byte("trackerless"), 100)
+	rawInfo := testInfo(t, data)
+	hash := sha1.Sum(rawInfo)
+	nobody := startTestDHT(t, hash, netip.AddrPort{})
+
+	private := strings.Replace(string(rawInfo), "6:pieces", "7:privatei1e6:pieces", 1)
+	tests := []struct {
+		name string
+		path string
+		err  string
+	}{
+		{"no peers", writeTrackerless(t, rawInfo, nobody), "no peers found on the DHT"},
+		{"no nodes", writeTrackerless(t, rawInfo, "127.0.0.1:1"), "failed to get peers from the DHT"},
+		{"private", writeTrackerless(t, []byte(private), nobody), "private torrents can't use the DHT"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTestDHT(t) // a routing table of its own
+			_, err := New(tt.path, Options{})
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("expected error containing %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestNewFromMagnetDHT(t *testing.T) {
+	data := bytes.Repeat([]byte("magnetic"), 3000)
+	rawInfo := testInfo(t, data)
+	hash := sha1.Sum(rawInfo)
+	seeder := netip.MustParseAddrPort(serveMagnet(t, rawInfo, data))
+	useTestDHT(t, startTestDHT(t, hash, seeder))
+
+	c, err := NewFromMagnet("magnet:?xt=urn:btih:"+hex.EncodeToString(hash[:]), Options{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer c.Close()
+	if c.TorrentInfo.InfoHash != hash || c.TorrentInfo.Name != "magnet.bin" {
+		t.Errorf("unexpected torrent info %+v", c.TorrentInfo)
+	}
+}
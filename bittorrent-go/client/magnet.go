@@ -0,0 +1,175 @@
+package client
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/lourencovales/codecrafters/bittorrent-go/magnet"
+	"github.com/lourencovales/codecrafters/bittorrent-go/peer"
+	"github.com/lourencovales/codecrafters/bittorrent-go/torrent"
+	"github.com/lourencovales/codecrafters/bittorrent-go/tracker"
+)
+
+// metadataTimeout bounds how long a single peer gets to hand over the info
+// dictionary before we move on to the next one.
+const metadataTimeout = 30 * time.Second
+
+// Open creates a Client for either a .torrent file or a magnet link.
+func Open(source string, opts Options) (*Client, error) {
+	if IsMagnet(source) {
+		return NewFromMagnet(source, opts)
+	}
+	return New(source, opts)
+}
+
+// IsMagnet reports whether source looks like a magnet link rather than a path.
+func IsMagnet(source string) bool {
+	return strings.HasPrefix(source, "magnet:")
+}
+
+// NewFromMagnet creates a Client for a magnet link. As there's no metadata to
+// start with, peers are found with just the info hash, from the link's
+// trackers and its 'x.pe' peers, or on the DHT when those have none, and the
+// info dictionary is fetched from them with the ut_metadata extension.
+func NewFromMagnet(uri string, opts Options) (*Client, error) {
+
+	link, err := magnet.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	var peerID [20]byte
+	if _, err := io.ReadFull(rand.Reader, peerID[:]); err != nil {
+		return nil, fmt.Errorf("failed to generate peer ID: %w", err)
+	}
+
+	const listenPort uint16 = 6881 // TODO we might want to make this settable
+
+	// each of the link's trackers is a tier of its own, so that they're
+	// tried in the order they were given
+	var tiers [][]string
+	for _, tr := range link.Trackers {
+		tiers = append(tiers, []string{tr})
+	}
+
+	peers := resolvePeers(link.Peers)
+	var trackers *tracker.TierList
+	var trackerErr error
+	if len(tiers) > 0 {
+		stub := &torrent.TorrentInfo{AnnounceURL: tiers[0][0], AnnounceList: tiers, InfoHash: link.InfoHash}
+		trackers = opts.newTierList(stub)
+		found, err := trackers.GetPeers(stub, peerID, listenPort)
+		if err != nil {
+			trackerErr = fmt.Errorf("failed to get peers from tracker: %w", err)
+		}
+		for _, p := range found {
+			if !slices.Contains(peers, p) {
+				peers = append(peers, p)
+			}
+		}
+	}
+	if len(peers) == 0 {
+		// the DHT is the last place left to look
+		found, err := dhtPeers([][20]byte{link.InfoHash}, nil)
+		if err != nil {
+			if trackerErr != nil {
+				return nil, errors.Join(trackerErr, err)
+			}
+			return nil, fmt.Errorf("magnet link has no trackers or peers to get the metadata from: %w", err)
+		}
+		peers = found
+	}
+
+	rawInfo, err := fetchMetadata(link, peers, peerID)
+	if err != nil {
+		return nil, err
+	}
+	metaInfo, err := torrent.ParseInfo(rawInfo, tiers)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse metadata: %w", err)
+	}
+
+	c := &Client{
+		TorrentInfo: metaInfo,
+		Trackers:    trackers,
+		Peers:       peers,
+		PeerID:      peerID,
+	}
+	if trackers == nil {
+		c.Trackers = opts.newTierList(metaInfo)
+		return c, nil
+	}
+	// now that the size is known the trackers can be told how much is left;
+	// we have peers already, so this isn't fatal
+	if err := c.startAnnouncing(listenPort); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to announce to trackers: %v.\n", err)
+	}
+	return c, nil
+}
+
+// resolvePeers turns the host:port addresses of a magnet link's peers into
+// IP addresses, looking up host names. Peers that can't be resolved are
+// skipped.
+func resolvePeers(addrs []string) []netip.AddrPort {
+
+	var peers []netip.AddrPort
+	for _, a := range addrs {
+		tcpAddr, err := net.ResolveTCPAddr("tcp", a)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Skipping peer %s: %v.\n", a, err)
+			continue
+		}
+		ap := tcpAddr.AddrPort()
+		ap = netip.AddrPortFrom(ap.Addr().Unmap(), ap.Port())
+		if !slices.Contains(peers, ap) {
+			peers = append(peers, ap)
+		}
+	}
+	return peers
+}
+
+// fetchMetadata asks each peer in turn for the info dictionary, until one of
+// them hands over one that matches the link's info hash.
+func fetchMetadata(link *magnet.Link, peers []netip.AddrPort, peerID [20]byte) ([]byte, error) {
+
+	for _, peerAddr := range peers {
+		rawInfo, err := fetchMetadataFrom(peerAddr, link.InfoHash, peerID)
+		if err == nil {
+			err = link.VerifyInfo(rawInfo)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to get metadata from peer %s: %v. Trying next peer.\n", peerAddr, err)
+			continue
+		}
+		return rawInfo, nil
+	}
+	return nil, errors.New("failed to get metadata from any available peer")
+}
+
+// fetchMetadataFrom gets the info dictionary from a single peer
+func fetchMetadataFrom(peerAddr netip.AddrPort, infoHash [20]byte, peerID [20]byte) ([]byte, error) {
+
+	conn, err := net.DialTimeout("tcp", peerAddr.String(), 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(metadataTimeout))
+
+	_, extended, err := peer.HandshakeExtended(conn, infoHash, peerID)
+	if err != nil {
+		return nil, err
+	}
+	if !extended {
+		return nil, errors.New("peer doesn't support the extension protocol")
+	}
+	return peer.FetchMetadata(conn)
+}
@@ -0,0 +1,188 @@
+package client
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/lourencovales/codecrafters/bittorrent-go/bencode"
+	"github.com/lourencovales/codecrafters/bittorrent-go/peer"
+	"github.com/lourencovales/codecrafters/bittorrent-go/torrent"
+)
+
+// serveMagnet runs a peer on the loopback interface that hands out pieces,
+// and the metadata too to those who speak the extension protocol, and returns
+// its address
+func serveMagnet(t *testing.T, rawInfo []byte, data []byte) string {
+	info, err := torrent.ParseInfo(rawInfo, nil)
+	if err != nil {
+		t.Fatalf("failed to parse test info: %v", err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				handshake := make([]byte, 68)
+				if _, err := io.ReadFull(conn, handshake); err != nil {
+					return
+				}
+				conn.Write(handshake) // extension bit included, if it was set
+				if handshake[25]&0x10 != 0 {
+					seedExtended(conn, rawInfo, info, data)
+				} else {
+					seedPieces(conn, info, data)
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// seedExtended is seedPieces for clients that speak the extension protocol:
+// ut_metadata requests are answered along with those for pieces, until the
+// client hangs up
+func seedExtended(conn net.Conn, rawInfo []byte, info *torrent.TorrentInfo, data []byte) {
+
+	const utMetadata = 3
+	hs := peer.ExtensionHandshake{M: map[string]int{"ut_metadata": utMetadata}, MetadataSize: len(rawInfo)}
+	if err := peer.SendExtensionHandshake(conn, hs); err != nil {
+		return
+	}
+	bitfield := bytes.Repeat([]byte{0xff}, (info.PieceCount()+7)/8)
+	peer.SendMsg(conn, peer.MsgBitfield, bitfield)
+
+	for {
+		msg, err := peer.ReadMsg(conn)
+		if err != nil {
+			return
+		}
+		switch {
+		case msg.ID == peer.MsgInterested:
+			peer.SendMsg(conn, peer.MsgUnchoke, nil)
+
+		case msg.ID == peer.MsgRequest:
+			index := binary.BigEndian.Uint32(msg.Payload[0:4])
+			begin := binary.BigEndian.Uint32(msg.Payload[4:8])
+			length := binary.BigEndian.Uint32(msg.Payload[8:12])
+			start := int(index)*info.PieceLength + int(begin)
+			payload := append(append([]byte{}, msg.Payload[0:8]...), data[start:start+int(length)]...)
+			peer.SendMsg(conn, peer.MsgPiece, payload)
+
+		case msg.ID == peer.MsgExtended && len(msg.Payload) > 0 && msg.Payload[0] == utMetadata:
+			var req struct {
+				Piece int `bencode:"piece"`
+			}
+			if err := bencode.Unmarshal(msg.Payload[1:], &req); err != nil {
+				return
+			}
+			payload, err := peer.MetadataPiece(rawInfo, req.Piece)
+			if err != nil {
+				return
+			}
+			peer.SendExtended(conn, peer.LocalMetadataID, payload)
+		}
+	}
+}
+
+// testInfo returns a bencoded info dictionary for data, large enough that
+// the metadata comes in more than one piece
+func testInfo(t *testing.T, data []byte) []byte {
+	var pieces []byte
+	for start := 0; start < len(data); start += 16384 {
+		h := sha1.Sum(data[start:min(start+16384, len(data))])
+		pieces = append(pieces, h[:]...)
+	}
+	rawInfo, err := bencode.Marshal(map[string]interface{}{
+		"name":         "magnet.bin",
+		"length":       len(data),
+		"piece length": 16384,
+		"pieces":       string(pieces),
+		"padding":      strings.Repeat("x", 20000),
+	})
+	if err != nil {
+		t.Fatalf("failed to marshal info: %v", err)
+	}
+	return rawInfo
+}
+
+func TestNewFromMagnet(t *testing.T) {
+	data := bytes.Repeat([]byte("magnetic"), 6000)
+	rawInfo := testInfo(t, data)
+	hash := sha1.Sum(rawInfo)
+	addr := serveMagnet(t, rawInfo, data)
+
+	uri := "magnet:?xt=urn:btih:" + hex.EncodeToString(hash[:]) + "&dn=whatever&x.pe=" + addr
+	c, err := Open(uri, Options{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if c.TorrentInfo.InfoHash != hash || c.TorrentInfo.Name != "magnet.bin" || c.TorrentInfo.TotalLength != len(data) {
+		t.Errorf("unexpected torrent info %+v", c.TorrentInfo)
+	}
+
+	outFile := filepath.Join(t.TempDir(), "out.bin")
+	if err := c.DownloadFile(outFile); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got, err := os.ReadFile(outFile)
+	if err != nil {
+		t.Fatalf("failed to read output: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("downloaded file doesn't match")
+	}
+}
+
+func TestNewFromMagnetErrors(t *testing.T) {
+	data := bytes.Repeat([]byte("magnetic"), 100)
+	rawInfo := testInfo(t, data)
+	addr := serveMagnet(t, rawInfo, data)
+	useTestDHT(t) // which doesn't know any peers either
+
+	tests := []struct {
+		name string
+		uri  string
+		err  string
+	}{
+		{"invalid link", "magnet:?dn=nothing", "no BitTorrent info hash"},
+		{"nowhere to look", "magnet:?xt=urn:btih:" + strings.Repeat("ab", 20), "no trackers or peers"},
+		{"metadata doesn't match", "magnet:?xt=urn:btih:" + strings.Repeat("ab", 20) + "&x.pe=" + addr, "failed to get metadata"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewFromMagnet(tt.uri, Options{})
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("expected error containing %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestResolvePeers(t *testing.T) {
+	got := resolvePeers([]string{"10.0.0.1:6881", "[::1]:6882", "[::ffff:10.0.0.1]:6881", "no port", "10.0.0.2:99999"})
+	expected := []netip.AddrPort{netip.MustParseAddrPort("10.0.0.1:6881"), netip.MustParseAddrPort("[::1]:6882")}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
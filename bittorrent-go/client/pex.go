@@ -0,0 +1,228 @@
+package client
+
+import (
+	"net"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/lourencovales/codecrafters/bittorrent-go/peer"
+)
+
+// pexLiveFor is how long after connecting to a peer we keep telling others
+// about it. Our connections only last a piece, so a peer counts as connected
+// until it's been that long, or until connecting to it fails.
+const pexLiveFor = 5 * time.Minute
+
+// pexState keeps track of peer exchange (BEP 11) for a client: the peers it's
+// connected to, which is what it tells other peers about, what each peer was
+// told, and when each was last told or heard from, so that neither side
+// floods the other. Its zero value is ready to use, and it's safe to use
+// from several goroutines.
+type pexState struct {
+	mu sync.Mutex
+	// live maps the peers we've connected to lately to when we last did
+	live map[netip.AddrPort]time.Time
+	// told is what each peer has been told about so far, so that later
+	// messages only carry what changed
+	told         map[netip.AddrPort]map[netip.AddrPort]bool
+	lastSent     map[netip.AddrPort]time.Time
+	lastReceived map[netip.AddrPort]time.Time
+}
+
+// connected records that we connected to addr
+func (s *pexState) connected(addr netip.AddrPort, now time.Time) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.live == nil {
+		s.live = make(map[netip.AddrPort]time.Time)
+	}
+	s.live[addr] = now
+}
+
+// failed records that connecting to addr failed, so that it's dropped from
+// what we tell others
+func (s *pexState) failed(addr netip.AddrPort) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.live, addr)
+}
+
+// message returns the ut_pex message to send to addr: the peers we connected
+// to that it wasn't told about yet, and those it was told about that we're no
+// longer connected to, up to peer.MaxPexPeers of each. It reports false when
+// there's nothing new to tell, or when addr was sent one less than
+// peer.PexInterval ago.
+func (s *pexState) message(to netip.AddrPort, now time.Time) (peer.PexMessage, bool) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if last, ok := s.lastSent[to]; ok && now.Sub(last) < peer.PexInterval {
+		return peer.PexMessage{}, false
+	}
+
+	told := s.told[to]
+	var m peer.PexMessage
+	for addr, seen := range s.live {
+		if now.Sub(seen) >= pexLiveFor {
+			delete(s.live, addr)
+			continue
+		}
+		if addr != to && !told[addr] && len(m.Added) < peer.MaxPexPeers {
+			m.Added = append(m.Added, peer.PexPeer{Addr: addr, Flags: peer.PexOutgoing})
+		}
+	}
+	for addr := range told {
+		if _, ok := s.live[addr]; !ok && len(m.Dropped) < peer.MaxPexPeers {
+			m.Dropped = append(m.Dropped, addr)
+		}
+	}
+	if len(m.Added) == 0 && len(m.Dropped) == 0 {
+		return peer.PexMessage{}, false
+	}
+
+	if told == nil {
+		told = make(map[netip.AddrPort]bool)
+		if s.told == nil {
+			s.told = make(map[netip.AddrPort]map[netip.AddrPort]bool)
+		}
+		s.told[to] = told
+	}
+	for _, p := range m.Added {
+		told[p.Addr] = true
+	}
+	for _, addr := range m.Dropped {
+		delete(told, addr)
+	}
+	if s.lastSent == nil {
+		s.lastSent = make(map[netip.AddrPort]time.Time)
+	}
+	s.lastSent[to] = now
+	return m, true
+}
+
+// received reports whether a ut_pex message from addr should be taken in,
+// which it isn't if the last one came less than peer.PexInterval ago
+func (s *pexState) received(from netip.AddrPort, now time.Time) bool {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if last, ok := s.lastReceived[from]; ok && now.Sub(last) < peer.PexInterval {
+		return false
+	}
+	if s.lastReceived == nil {
+		s.lastReceived = make(map[netip.AddrPort]time.Time)
+	}
+	s.lastReceived[from] = now
+	return true
+}
+
+// peerConn is a connection to a peer, along with what we agreed on in the
+// extension handshake
+type peerConn struct {
+	net.Conn
+	addr netip.AddrPort
+	// handshaken is set once we sent our extension handshake, in answer to
+	// the peer's
+	handshaken bool
+	// pexID is the extended message ID the peer wants ut_pex messages sent
+	// with, zero if it doesn't support them
+	pexID uint8
+}
+
+// readMsg reads the next message from a peer, handling the extension
+// messages that come before it
+func (c *Client) readMsg(pc *peerConn) (*peer.Message, error) {
+
+	for {
+		msg, err := peer.ReadMsg(pc)
+		if err != nil {
+			return nil, err
+		}
+		if msg.ID != peer.MsgExtended {
+			c.sendPex(pc)
+			return msg, nil
+		}
+		if err := c.handleExtended(pc, msg.Payload); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// handleExtended handles an extension message. The peer's extension handshake
+// gets ours in answer, so that peers that don't speak the extension protocol
+// never get any; ut_pex messages add the peers they carry to the client's.
+// Private torrents don't do peer exchange, their peers only come from their
+// trackers (BEP 27).
+func (c *Client) handleExtended(pc *peerConn, payload []byte) error {
+
+	if len(payload) == 0 {
+		return nil
+	}
+	private := c.TorrentInfo.Private
+
+	switch payload[0] {
+	case peer.ExtHandshakeID:
+		hs, err := peer.ParseExtensionHandshake(payload[1:])
+		if err != nil {
+			return err
+		}
+		if id := hs.M["ut_pex"]; !private && id > 0 && id <= 255 {
+			pc.pexID = uint8(id)
+		}
+		if !pc.handshaken {
+			ours := peer.ExtensionHandshake{M: map[string]int{}}
+			if !private {
+				ours.M["ut_pex"] = int(peer.LocalPexID)
+			}
+			if err := peer.SendExtensionHandshake(pc, ours); err != nil {
+				return err
+			}
+			pc.handshaken = true
+		}
+		c.sendPex(pc)
+
+	case peer.LocalPexID:
+		if private || !pc.handshaken || !c.pex.received(pc.addr, time.Now()) {
+			return nil
+		}
+		m, err := peer.ParsePex(payload[1:])
+		if err != nil {
+			return nil // not worth dropping the download over
+		}
+		var found []netip.AddrPort
+		for _, p := range m.Added {
+			if p.Addr.IsValid() && p.Addr.Port() != 0 && !p.Addr.Addr().IsUnspecified() && p.Addr != pc.addr && !slices.Contains(found, p.Addr) {
+				found = append(found, p.Addr)
+			}
+		}
+		c.addPeers(found)
+	}
+	return nil
+}
+
+// sendPex sends the peer a ut_pex message, if it supports them and there's
+// something new to tell it. It's not an error if that fails, the download
+// will notice soon enough if the connection is gone.
+func (c *Client) sendPex(pc *peerConn) {
+
+	if pc.pexID == 0 {
+		return
+	}
+	m, ok := c.pex.message(pc.addr, time.Now())
+	if !ok {
+		return
+	}
+	payload, err := peer.EncodePex(m)
+	if err != nil {
+		return
+	}
+	peer.SendExtended(pc, pc.pexID, payload)
+}
@@ -0,0 +1,241 @@
+package client
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/netip"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/lourencovales/codecrafters/bittorrent-go/peer"
+	"github.com/lourencovales/codecrafters/bittorrent-go/torrent"
+)
+
+// addrsOf returns the addresses of peers, sorted
+func addrsOf(peers []peer.PexPeer) []netip.AddrPort {
+	var addrs []netip.AddrPort
+	for _, p := range peers {
+		addrs = append(addrs, p.Addr)
+	}
+	slices.SortFunc(addrs, netip.AddrPort.Compare)
+	return addrs
+}
+
+func TestPexStateMessage(t *testing.T) {
+	a := netip.MustParseAddrPort("10.0.0.1:1")
+	b := netip.MustParseAddrPort("10.0.0.2:2")
+	c := netip.MustParseAddrPort("10.0.0.3:3")
+	d := netip.MustParseAddrPort("10.0.0.4:4")
+	now := time.Now()
+
+	var s pexState
+	if _, ok := s.message(a, now); ok {
+		t.Error("expected nothing to tell yet")
+	}
+	s.connected(a, now)
+	s.connected(b, now)
+	s.connected(c, now)
+
+	m, ok := s.message(a, now)
+	if !ok || !slices.Equal(addrsOf(m.Added), []netip.AddrPort{b, c}) || len(m.Dropped) != 0 {
+		t.Fatalf("expected b and c to be added, got %+v", m)
+	}
+	for _, p := range m.Added {
+		if p.Flags != peer.PexOutgoing {
+			t.Errorf("expected the outgoing flag, got %#x", p.Flags)
+		}
+	}
+
+	// a doesn't get another one within the minute, b gets its own
+	s.failed(b)
+	s.connected(d, now)
+	if _, ok := s.message(a, now.Add(peer.PexInterval/2)); ok {
+		t.Error("expected the message to be held back")
+	}
+	if m, ok := s.message(b, now); !ok || !slices.Equal(addrsOf(m.Added), []netip.AddrPort{a, c, d}) {
+		t.Errorf("expected a, c and d to be added, got %+v", m)
+	}
+
+	// after that, only what changed
+	now = now.Add(peer.PexInterval)
+	m, ok = s.message(a, now)
+	if !ok || !slices.Equal(addrsOf(m.Added), []netip.AddrPort{d}) || !slices.Equal(m.Dropped, []netip.AddrPort{b}) {
+		t.Fatalf("expected d to be added and b dropped, got %+v", m)
+	}
+	if _, ok := s.message(a, now.Add(peer.PexInterval)); ok {
+		t.Error("expected nothing new to tell")
+	}
+
+	// peers we haven't connected to in a while are dropped too
+	m, ok = s.message(a, now.Add(pexLiveFor))
+	if !ok || len(m.Added) != 0 {
+		t.Fatalf("expected only drops, got %+v", m)
+	}
+	slices.SortFunc(m.Dropped, netip.AddrPort.Compare)
+	if !slices.Equal(m.Dropped, []netip.AddrPort{c, d}) {
+		t.Errorf("expected c and d to be dropped, got %v", m.Dropped)
+	}
+}
+
+func TestPexStateLimit(t *testing.T) {
+	now := time.Now()
+	var s pexState
+	for i := range peer.MaxPexPeers + 10 {
+		s.connected(netip.AddrPortFrom(netip.AddrFrom4([4]byte{10, 0, 0, byte(i)}), 6881), now)
+	}
+	to := netip.MustParseAddrPort("10.1.0.1:1")
+
+	m, _ := s.message(to, now)
+	if len(m.Added) != peer.MaxPexPeers {
+		t.Errorf("expected %d peers, got %d", peer.MaxPexPeers, len(m.Added))
+	}
+	// the rest go in the next one
+	m, _ = s.message(to, now.Add(peer.PexInterval))
+	if len(m.Added) != 10 {
+		t.Errorf("expected 10 peers, got %d", len(m.Added))
+	}
+}
+
+func TestPexStateReceived(t *testing.T) {
+	a := netip.MustParseAddrPort("10.0.0.1:1")
+	b := netip.MustParseAddrPort("10.0.0.2:2")
+	now := time.Now()
+
+	var s pexState
+	if !s.received(a, now) {
+		t.Error("expected the first message to be taken")
+	}
+	if s.received(a, now.Add(peer.PexInterval/2)) {
+		t.Error("expected a message within the minute to be ignored")
+	}
+	if !s.received(b, now) {
+		t.Error("expected another peer's message to be taken")
+	}
+	if !s.received(a, now.Add(peer.PexInterval)) {
+		t.Error("expected a message after the minute to be taken")
+	}
+}
+
+// servePex runs a peer that speaks ut_pex: it sends added in a ut_pex message
+// as soon as the client says it supports them, and hands out the ut_pex
+// messages it gets on received. Everything else is as for seed.
+func servePex(t *testing.T, info *torrent.TorrentInfo, data []byte, added []peer.PexPeer, received chan<- *peer.PexMessage) netip.AddrPort {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	const utPex = 7
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		handshake := make([]byte, 68)
+		if _, err := io.ReadFull(conn, handshake); err != nil {
+			return
+		}
+		conn.Write(handshake)
+		peer.SendExtensionHandshake(conn, peer.ExtensionHandshake{M: map[string]int{"ut_pex": utPex}})
+		peer.SendMsg(conn, peer.MsgBitfield, bytes.Repeat([]byte{0xff}, (info.PieceCount()+7)/8))
+
+		for {
+			msg, err := peer.ReadMsg(conn)
+			if err != nil {
+				return
+			}
+			switch {
+			case msg.ID == peer.MsgExtended && msg.Payload[0] == peer.ExtHandshakeID:
+				hs, err := peer.ParseExtensionHandshake(msg.Payload[1:])
+				if err != nil {
+					return
+				}
+				if id := hs.M["ut_pex"]; id > 0 {
+					payload, _ := peer.EncodePex(peer.PexMessage{Added: added})
+					peer.SendExtended(conn, uint8(id), payload)
+				}
+
+			case msg.ID == peer.MsgExtended && msg.Payload[0] == utPex:
+				m, err := peer.ParsePex(msg.Payload[1:])
+				if err != nil {
+					return
+				}
+				received <- m
+
+			case msg.ID == peer.MsgInterested:
+				peer.SendMsg(conn, peer.MsgUnchoke, nil)
+
+			case msg.ID == peer.MsgRequest:
+				index := binary.BigEndian.Uint32(msg.Payload[0:4])
+				begin := binary.BigEndian.Uint32(msg.Payload[4:8])
+				length := binary.BigEndian.Uint32(msg.Payload[8:12])
+				start := int(index)*info.PieceLength + int(begin)
+				payload := append(append([]byte{}, msg.Payload[0:8]...), data[start:start+int(length)]...)
+				peer.SendMsg(conn, peer.MsgPiece, payload)
+			}
+		}
+	}()
+	return ln.Addr().(*net.TCPAddr).AddrPort()
+}
+
+func TestPexExchange(t *testing.T) {
+	learned := netip.MustParseAddrPort("10.0.0.7:6881")
+	learned6 := netip.MustParseAddrPort("[2001:db8::7]:6881")
+	known := netip.MustParseAddrPort("10.0.0.9:6881")
+
+	tests := []struct {
+		name     string
+		private  bool
+		expected []netip.AddrPort
+	}{
+		{"public", false, []netip.AddrPort{learned, learned6}},
+		{"private", true, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := bytes.Repeat([]byte("pex"), 5000)
+			info := &torrent.TorrentInfo{PieceLength: len(data), TotalLength: len(data), Private: tt.private}
+			info.PieceHashes = [][20]byte{sha1.Sum(data)}
+
+			received := make(chan *peer.PexMessage, 1)
+			added := []peer.PexPeer{
+				{Addr: learned, Flags: peer.PexSeed},
+				{Addr: learned6},
+				{Addr: netip.MustParseAddrPort("0.0.0.0:6881")}, // nowhere
+				{Addr: netip.MustParseAddrPort("10.0.0.8:0")},   // no port
+			}
+			addr := servePex(t, info, data, added, received)
+
+			c := &Client{TorrentInfo: info, Peers: []netip.AddrPort{addr}}
+			c.pex.connected(known, time.Now())
+			if _, err := c.downloadPiece(0); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if got := c.peers()[1:]; !slices.Equal(got, tt.expected) {
+				t.Errorf("expected %v to be learned, got %v", tt.expected, got)
+			}
+
+			select {
+			case m := <-received:
+				if tt.private {
+					t.Errorf("expected no ut_pex message for a private torrent, got %+v", m)
+				} else if !slices.Equal(addrsOf(m.Added), []netip.AddrPort{known}) {
+					t.Errorf("expected to be told about %v, got %+v", known, m)
+				}
+			case <-time.After(100 * time.Millisecond):
+				if !tt.private {
+					t.Error("expected a ut_pex message")
+				}
+			}
+		})
+	}
+}
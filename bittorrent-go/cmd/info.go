@@ -0,0 +1,235 @@
+package cmd
+
+import (
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/lourencovales/codecrafters/bittorrent-go/torrent"
+)
+
+// info deals with the info command, which shows what's in a torrent: as a
+// plain summary by default, as JSON, or as a tree of its files.
+func info(args []string) error {
+
+	const usage = "usage: info [--json | --tree] [--timeout=<duration>] [--retries=<n>] <torrent file | magnet link>"
+
+	flags := flag.NewFlagSet("info", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	asJSON := flags.Bool("json", false, "print the metadata as JSON")
+	asTree := flags.Bool("tree", false, "print the files as a tree")
+	clientOpts := trackerFlags(flags)
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 || *asJSON && *asTree {
+		return errors.New(usage)
+	}
+	opts, err := clientOpts()
+	if err != nil {
+		return fmt.Errorf("%w\n%s", err, usage)
+	}
+
+	metaInfo, err := loadTorrentInfo(flags.Arg(0), opts)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case *asJSON:
+		return printJson(newTorrentJSON(metaInfo))
+	case *asTree:
+		_, err := io.WriteString(stdout, fileTree(metaInfo))
+		return err
+	}
+	_, err = fmt.Fprintln(stdout, metaInfo.String())
+	return err
+}
+
+// torrentJSON is what info --json prints. Hashes are hex encoded, and
+// optional fields are left out when the torrent doesn't have them.
+type torrentJSON struct {
+	Name         string     `json:"name"`
+	InfoHash     string     `json:"info_hash"`
+	InfoHashV2   string     `json:"info_hash_v2,omitempty"`
+	MetaVersion  int        `json:"meta_version"`
+	Announce     string     `json:"announce,omitempty"`
+	AnnounceList [][]string `json:"announce_list,omitempty"`
+	WebSeeds     []string   `json:"url_list,omitempty"`
+	Nodes        []string   `json:"nodes,omitempty"`
+	Comment      string     `json:"comment,omitempty"`
+	CreatedBy    string     `json:"created_by,omitempty"`
+	CreationDate string     `json:"creation_date,omitempty"`
+	Private      bool       `json:"private"`
+	Source       string     `json:"source,omitempty"`
+	MultiFile    bool       `json:"multi_file"`
+	TotalLength  int        `json:"total_length"`
+	PieceLength  int        `json:"piece_length"`
+	PieceCount   int        `json:"piece_count"`
+	Files        []fileJSON `json:"files"`
+	PieceHashes  []string   `json:"piece_hashes,omitempty"`
+}
+
+// fileJSON is a file of the torrent, with the pieces it spans. Empty files
+// don't span any.
+type fileJSON struct {
+	Path       string `json:"path"`
+	Length     int    `json:"length"`
+	Offset     int    `json:"offset"`
+	FirstPiece *int   `json:"first_piece,omitempty"`
+	LastPiece  *int   `json:"last_piece,omitempty"`
+	Padding    bool   `json:"padding,omitempty"`
+	PiecesRoot string `json:"pieces_root,omitempty"`
+}
+
+func newTorrentJSON(ti *torrent.TorrentInfo) torrentJSON {
+
+	out := torrentJSON{
+		Name:         ti.Name,
+		InfoHash:     hex.EncodeToString(ti.InfoHash[:]),
+		MetaVersion:  ti.MetaVersion,
+		Announce:     ti.AnnounceURL,
+		AnnounceList: ti.AnnounceList,
+		WebSeeds:     ti.WebSeeds,
+		Nodes:        ti.Nodes,
+		Comment:      ti.Comment,
+		CreatedBy:    ti.CreatedBy,
+		Private:      ti.Private,
+		Source:       ti.Source,
+		MultiFile:    ti.MultiFile,
+		TotalLength:  ti.TotalLength,
+		PieceLength:  ti.PieceLength,
+		PieceCount:   ti.PieceCount(),
+		Files:        []fileJSON{},
+	}
+	if ti.IsV2() {
+		out.InfoHashV2 = hex.EncodeToString(ti.InfoHashV2[:])
+	}
+	if !ti.CreationDate.IsZero() {
+		out.CreationDate = ti.CreationDate.Format(time.RFC3339)
+	}
+
+	for i, f := range ti.FileList() {
+		fj := fileJSON{Path: path.Join(f.Path...), Length: f.Length, Offset: f.Offset, Padding: f.Padding}
+		if first, last, ok := ti.PieceSpan(i); ok {
+			fj.FirstPiece, fj.LastPiece = &first, &last
+		}
+		if f.PiecesRoot != [32]byte{} {
+			fj.PiecesRoot = hex.EncodeToString(f.PiecesRoot[:])
+		}
+		out.Files = append(out.Files, fj)
+	}
+	for _, h := range ti.PieceHashes {
+		out.PieceHashes = append(out.PieceHashes, hex.EncodeToString(h[:]))
+	}
+	return out
+}
+
+// treeNode is a directory or file in the tree printed by info --tree. Only
+// files have an index into FileList().
+type treeNode struct {
+	name     string
+	size     int
+	file     int
+	children []*treeNode
+}
+
+// child finds or adds the directory called name, keeping the order the
+// files come in
+func (n *treeNode) child(name string) *treeNode {
+	for _, c := range n.children {
+		if c.name == name && c.file < 0 {
+			return c
+		}
+	}
+	c := &treeNode{name: name, file: -1}
+	n.children = append(n.children, c)
+	return c
+}
+
+// fileTree draws the files of the torrent as a tree, with their sizes and the
+// pieces they span, e.g.
+//
+//	dir (1.0 MiB, 4 pieces of 256.0 KiB)
+//	├── a.txt (768.0 KiB, pieces 0-2)
+//	└── sub/ (256.0 KiB)
+//	    └── b.txt (256.0 KiB, piece 3)
+//
+// Single file torrents only get the first line. Padding files are left out.
+func fileTree(ti *torrent.TorrentInfo) string {
+
+	root := &treeNode{name: ti.Name, file: -1}
+	for i, f := range ti.FileList() {
+		if f.Padding {
+			continue
+		}
+		node := root
+		if ti.MultiFile {
+			for _, dir := range f.Path[:len(f.Path)-1] {
+				node = node.child(dir)
+				node.size += f.Length
+			}
+			node.children = append(node.children, &treeNode{name: f.Path[len(f.Path)-1], size: f.Length, file: i})
+		}
+		root.size += f.Length
+	}
+
+	var sb strings.Builder
+	pieces := "pieces"
+	if ti.PieceCount() == 1 {
+		pieces = "piece"
+	}
+	fmt.Fprintf(&sb, "%s (%s, %d %s of %s)\n", root.name, formatSize(root.size), ti.PieceCount(), pieces, formatSize(ti.PieceLength))
+	writeTree(&sb, ti, root, "")
+	return sb.String()
+}
+
+// writeTree draws the children of node, each line starting with prefix
+func writeTree(sb *strings.Builder, ti *torrent.TorrentInfo, node *treeNode, prefix string) {
+
+	for i, c := range node.children {
+		branch, indent := "├── ", "│   "
+		if i == len(node.children)-1 {
+			branch, indent = "└── ", "    "
+		}
+		if c.file < 0 {
+			fmt.Fprintf(sb, "%s%s%s/ (%s)\n", prefix, branch, c.name, formatSize(c.size))
+			writeTree(sb, ti, c, prefix+indent)
+			continue
+		}
+		fmt.Fprintf(sb, "%s%s%s (%s)\n", prefix, branch, c.name, fileSummary(ti, c.file))
+	}
+}
+
+// fileSummary gives the size of a file and the pieces it spans
+func fileSummary(ti *torrent.TorrentInfo, index int) string {
+
+	size := formatSize(ti.FileList()[index].Length)
+	first, last, ok := ti.PieceSpan(index)
+	switch {
+	case !ok:
+		return size + ", no pieces"
+	case first == last:
+		return fmt.Sprintf("%s, piece %d", size, first)
+	}
+	return fmt.Sprintf("%s, pieces %d-%d", size, first, last)
+}
+
+// formatSize writes a size in bytes the way people read them, in powers of
+// 1024
+func formatSize(n int) string {
+
+	if n < 1024 {
+		return fmt.Sprintf("%d B", n)
+	}
+	size := float64(n)
+	for _, unit := range []string{"KiB", "MiB", "GiB", "TiB"} {
+		size /= 1024
+		if size < 1024 || unit == "TiB" {
+			return fmt.Sprintf("%.1f %s", size, unit)
+		}
+	}
+	return "" // not reached
+}
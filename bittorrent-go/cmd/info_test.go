@@ -0,0 +1,179 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lourencovales/codecrafters/bittorrent-go/torrent"
+)
+
+// writeInfoTorrent creates a multi-file torrent with every optional field set,
+// and returns its path
+func writeInfoTorrent(t *testing.T) string {
+	dir := t.TempDir()
+	content := filepath.Join(dir, "content")
+	files := map[string]int{"a.txt": 20000, "empty": 0, "sub/b.txt": 20000}
+	for name, size := range files {
+		path := filepath.Join(content, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, bytes.Repeat([]byte("x"), size), 0o644); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+	}
+
+	data, err := torrent.Create(content, torrent.CreateOptions{
+		PieceLength:  16384,
+		Trackers:     [][]string{{"http://a/announce", "http://b/announce"}},
+		WebSeeds:     []string{"http://seed/"},
+		Comment:      "a comment",
+		CreatedBy:    "test",
+		CreationDate: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		Private:      true,
+		Source:       "SRC",
+	})
+	if err != nil {
+		t.Fatalf("failed to create torrent: %v", err)
+	}
+	path := filepath.Join(dir, "test.torrent")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatalf("failed to write torrent: %v", err)
+	}
+	return path
+}
+
+// runInfo runs the info command and returns what it printed
+func runInfo(t *testing.T, args ...string) string {
+	var out bytes.Buffer
+	stdout = &out
+	defer func() { stdout = os.Stdout }()
+
+	if err := Run("info", args); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return out.String()
+}
+
+func TestRunInfoMetadata(t *testing.T) {
+	out := runInfo(t, writeInfoTorrent(t))
+
+	for _, expected := range []string{
+		"Name: content",
+		"Tier 1: http://",
+		"Web Seed: http://seed/",
+		"Comment: a comment",
+		"Created By: test",
+		"Creation Date: 2024-05-01T12:00:00Z",
+		"Private: yes",
+		"Source: SRC",
+		"sub/b.txt (20000 bytes)",
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("expected %q in output:\n%s", expected, out)
+		}
+	}
+}
+
+func TestRunInfoJSON(t *testing.T) {
+	out := runInfo(t, "--json", writeInfoTorrent(t))
+
+	var got torrentJSON
+	if err := json.Unmarshal([]byte(out), &got); err != nil {
+		t.Fatalf("output isn't valid JSON: %v\n%s", err, out)
+	}
+
+	if got.Name != "content" || got.Comment != "a comment" || got.CreatedBy != "test" ||
+		got.CreationDate != "2024-05-01T12:00:00Z" || !got.Private || got.Source != "SRC" {
+		t.Errorf("unexpected metadata %+v", got)
+	}
+	if !reflect.DeepEqual(got.WebSeeds, []string{"http://seed/"}) || len(got.AnnounceList) != 1 {
+		t.Errorf("unexpected trackers %v and web seeds %v", got.AnnounceList, got.WebSeeds)
+	}
+	if got.TotalLength != 40000 || got.PieceCount != 3 || len(got.PieceHashes) != 3 || len(got.InfoHash) != 40 {
+		t.Errorf("unexpected layout %+v", got)
+	}
+
+	span := func(first, last int) []*int { return []*int{&first, &last} }
+	expected := []struct {
+		path  string
+		span  []*int
+		empty bool
+	}{
+		{"a.txt", span(0, 1), false},
+		{"empty", nil, true},
+		{"sub/b.txt", span(1, 2), false},
+	}
+	if len(got.Files) != len(expected) {
+		t.Fatalf("expected %d files, got %d", len(expected), len(got.Files))
+	}
+	for i, e := range expected {
+		f := got.Files[i]
+		if f.Path != e.path {
+			t.Errorf("file %d: expected %s, got %s", i, e.path, f.Path)
+		}
+		if e.empty {
+			if f.FirstPiece != nil || f.LastPiece != nil {
+				t.Errorf("file %d: expected no pieces for an empty file", i)
+			}
+			continue
+		}
+		if f.FirstPiece == nil || *f.FirstPiece != *e.span[0] || *f.LastPiece != *e.span[1] {
+			t.Errorf("file %d: expected pieces %d-%d", i, *e.span[0], *e.span[1])
+		}
+	}
+}
+
+func TestRunInfoTree(t *testing.T) {
+	out := runInfo(t, "--tree", writeInfoTorrent(t))
+
+	expected := "content (39.1 KiB, 3 pieces of 16.0 KiB)\n" +
+		"├── a.txt (19.5 KiB, pieces 0-1)\n" +
+		"├── empty (0 B, no pieces)\n" +
+		"└── sub/ (19.5 KiB)\n" +
+		"    └── b.txt (19.5 KiB, pieces 1-2)\n"
+	if out != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, out)
+	}
+}
+
+func TestRunInfoFlags(t *testing.T) {
+	path := writeInfoTorrent(t)
+	for _, args := range [][]string{
+		{"--json", "--tree", path},
+		{"--yaml", path},
+		{"--json"},
+	} {
+		err := Run("info", args)
+		if err == nil || !strings.Contains(err.Error(), "usage: info") {
+			t.Errorf("%v: expected usage error, got %v", args, err)
+		}
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	tests := []struct {
+		size     int
+		expected string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KiB"},
+		{1536, "1.5 KiB"},
+		{5 << 20, "5.0 MiB"},
+		{3 << 30, "3.0 GiB"},
+		{2 << 40, "2.0 TiB"},
+	}
+
+	for _, tt := range tests {
+		if got := formatSize(tt.size); got != tt.expected {
+			t.Errorf("formatSize(%d): expected %q, got %q", tt.size, tt.expected, got)
+		}
+	}
+}
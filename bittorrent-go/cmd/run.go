@@ -1,18 +1,30 @@
 package cmd
 
 import (
+	"context"
 	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"net"
+	"net/http"
+	"net/netip"
 	"os"
+	"os/signal"
+	"path"
+	"slices"
 	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/lourencovales/codecrafters/bittorrent-go/bencode"
 	"github.com/lourencovales/codecrafters/bittorrent-go/client"
+	"github.com/lourencovales/codecrafters/bittorrent-go/dht"
+	"github.com/lourencovales/codecrafters/bittorrent-go/magnet"
 	"github.com/lourencovales/codecrafters/bittorrent-go/peer"
 	"github.com/lourencovales/codecrafters/bittorrent-go/torrent"
 	"github.com/lourencovales/codecrafters/bittorrent-go/tracker"
@@ -25,48 +37,34 @@ func Run(command string, args []string) error {
 
 	switch command {
 	case "decode":
-		if len(args) != 1 {
-			return errors.New("usage: decode <bencoded string>")
-		}
-		benValue := args[0]
-		decoded, err := bencode.Unmarshal([]byte(benValue))
-		if err != nil {
-			return err
-		}
-		printJson(decoded)
+		return decode(args)
+
+	case "query":
+		return query(args)
+
+	case "create":
+		return create(args)
 
 	case "info":
-		if len(args) != 1 {
-			return errors.New("usage: info <torrent file>")
-		}
-		torrFile := args[0]
-		metaInfo, err := torrent.ParseFile(torrFile)
-		if err != nil {
-			return err
-		}
-		fmt.Println(metaInfo.String())
+		return info(args)
 
-	case "peers":
-		if len(args) != 1 {
-			return errors.New("usage: peers <torrent file>")
-		}
-		torrFile := args[0]
-		metaInfo, err := torrent.ParseFile(torrFile)
-		if err != nil {
-			return err
-		}
+	case "verify":
+		return verify(args)
 
-		var peerID [20]byte
-		if _, err := io.ReadFull(rand.Reader, peerID[:]); err != nil {
-			return fmt.Errorf("failed to generate peer ID: %w", err)
-		}
-		const listenPort uint16 = 6881
+	case "edit":
+		return edit(args)
 
-		peers, err := tracker.GetPeers(metaInfo, peerID, listenPort)
-		if err != nil {
-			return err
-		}
-		printJson(peers)
+	case "scrape":
+		return scrape(args)
+
+	case "tracker":
+		return trackerCmd(args)
+
+	case "dht":
+		return dhtCmd(args)
+
+	case "peers":
+		return peers(args)
 
 	case "handshake":
 		if len(args) != 2 {
@@ -96,49 +94,765 @@ func Run(command string, args []string) error {
 		fmt.Printf("Peer ID: %x\n", recvPeerID)
 
 	case "download_piece":
-		if len(args) != 4 || args[0] != "-o" {
-			return errors.New("usage: download_pieces -o <output file> <torrent file> <piece index>")
+		return downloadPiece(args)
+
+	case "download":
+		return download(args)
+
+	default:
+		return fmt.Errorf("unknown command: %s", command)
+	}
+
+	return nil
+}
+
+// loadTorrentInfo reads a .torrent file, or for a magnet link fetches the
+// metadata from the swarm
+func loadTorrentInfo(source string, opts client.Options) (*torrent.TorrentInfo, error) {
+
+	if !client.IsMagnet(source) {
+		return torrent.ParseFile(source)
+	}
+	c, err := client.NewFromMagnet(source, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	return c.TorrentInfo, nil
+}
+
+// stdin, stdout and stderr are where commands read from and write to, swapped
+// out in the tests
+var (
+	stdin  io.Reader = os.Stdin
+	stdout io.Writer = os.Stdout
+	stderr io.Writer = os.Stderr
+)
+
+// interrupted returns a context that's done once the commands that run until
+// stopped should stop, swapped out in the tests
+var interrupted = func() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+}
+
+// peers deals with the peers command, which prints the peers the torrent's
+// trackers hand out.
+func peers(args []string) error {
+
+	const usage = "usage: peers [--timeout=<duration>] [--retries=<n>] <torrent file>"
+
+	flags := flag.NewFlagSet("peers", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	clientOpts := trackerFlags(flags)
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 {
+		return errors.New(usage)
+	}
+	opts, err := clientOpts()
+	if err != nil {
+		return fmt.Errorf("%w\n%s", err, usage)
+	}
+
+	metaInfo, err := torrent.ParseFile(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	var peerID [20]byte
+	if _, err := io.ReadFull(rand.Reader, peerID[:]); err != nil {
+		return fmt.Errorf("failed to generate peer ID: %w", err)
+	}
+	const listenPort uint16 = 6881
+
+	trackers := tracker.NewTierList(metaInfo)
+	trackers.Client = opts.Tracker
+	found, err := trackers.GetPeers(metaInfo, peerID, listenPort)
+	if err != nil {
+		return err
+	}
+	return printJson(found)
+}
+
+// downloadPiece deals with the download_piece command, which downloads a
+// single piece of a torrent.
+func downloadPiece(args []string) error {
+
+	const usage = "usage: download_piece [--timeout=<duration>] [--retries=<n>] -o <output file> " +
+		"<torrent file | magnet link> <piece index>"
+
+	flags := flag.NewFlagSet("download_piece", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	outFile := flags.String("o", "", "where to write the piece")
+	clientOpts := trackerFlags(flags)
+	if err := flags.Parse(args); err != nil || flags.NArg() != 2 || *outFile == "" {
+		return errors.New(usage)
+	}
+	opts, err := clientOpts()
+	if err != nil {
+		return fmt.Errorf("%w\n%s", err, usage)
+	}
+	pieceIndex, err := strconv.Atoi(flags.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	c, err := client.Open(flags.Arg(0), opts)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if err := c.DownloadPiece(*outFile, pieceIndex); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "Piece %d downloaded to %s.\n", pieceIndex, *outFile)
+	return nil
+}
+
+// download deals with the download command, which downloads a whole torrent.
+func download(args []string) error {
+
+	const usage = "usage: download [--timeout=<duration>] [--retries=<n>] -o <output file> <torrent file | magnet link>"
+
+	flags := flag.NewFlagSet("download", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	outFile := flags.String("o", "", "where to write the download")
+	clientOpts := trackerFlags(flags)
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 || *outFile == "" {
+		return errors.New(usage)
+	}
+	opts, err := clientOpts()
+	if err != nil {
+		return fmt.Errorf("%w\n%s", err, usage)
+	}
+
+	torrFile := flags.Arg(0)
+	c, err := client.Open(torrFile, opts)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if err := c.DownloadFile(*outFile); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "Downloaded %s to %s.\n", torrFile, *outFile)
+	return nil
+}
+
+// decode deals with the decode command, which turns a bencoded value into
+// something readable. The value can be given literally, as the path to a file,
+// or as "-" to read it from stdin.
+func decode(args []string) error {
+
+	const usage = "usage: decode [--format=json|tree|raw] [--binary=hex|base64] <bencoded string | file | ->"
+
+	flags := flag.NewFlagSet("decode", flag.ContinueOnError)
+	flags.SetOutput(io.Discard) // we print our own usage
+	convertOpts := convertFlags(flags)
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 {
+		return errors.New(usage)
+	}
+
+	opts, err := convertOpts()
+	if err != nil {
+		return fmt.Errorf("%w\n%s", err, usage)
+	}
+
+	input, err := readInput(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	return convert(input, opts)
+}
+
+// query deals with the query command, which prints a single value out of a
+// bencoded document, found by its path, e.g. info.files[0].path. Only the
+// bytes leading up to the value are scanned, nothing else is decoded.
+func query(args []string) error {
+
+	const usage = "usage: query [--format=json|tree|raw] [--binary=hex|base64] [--span] <bencoded string | file | -> <path>"
+
+	flags := flag.NewFlagSet("query", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	convertOpts := convertFlags(flags)
+	showSpan := flags.Bool("span", false, "print where the value is instead of the value")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 2 {
+		return errors.New(usage)
+	}
+
+	opts, err := convertOpts()
+	if err != nil {
+		return fmt.Errorf("%w\n%s", err, usage)
+	}
+	path, err := bencode.ParsePath(flags.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	input, err := readInput(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	value, span, err := bencode.Get(input, path...)
+	if err != nil {
+		var syntaxErr *bencode.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			return fmt.Errorf("%w\n%s", err, errorSnippet(input, syntaxErr))
 		}
-		outFile, torrFile := args[1], args[2]
-		pieceIndex, err := strconv.Atoi(args[3])
-		if err != nil {
-			return err
+		return err
+	}
+
+	if *showSpan {
+		fmt.Fprintf(stdout, "%d-%d (%d bytes)\n", span.Start, span.End, span.Len())
+		return nil
+	}
+	return convert(value, opts)
+}
+
+// create deals with the create command, which builds a .torrent for a file or
+// a directory
+func create(args []string) error {
+
+	const usage = "usage: create -o <output file> [--tracker=<url>[,<url>...]]... [--web-seed=<url>]... " +
+		"[--piece-length=<bytes>] [--comment=<text>] [--source=<tag>] [--name=<name>] [--private] [--no-date] <path>"
+
+	var opts torrent.CreateOptions
+	var trackers, webSeeds stringList
+
+	flags := flag.NewFlagSet("create", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	outFile := flags.String("o", "", "output file")
+	flags.Var(&trackers, "tracker", "tracker URL, comma separated URLs share a tier")
+	flags.Var(&webSeeds, "web-seed", "web seed URL")
+	flags.IntVar(&opts.PieceLength, "piece-length", 0, "piece length in bytes")
+	flags.StringVar(&opts.Comment, "comment", "", "comment")
+	flags.StringVar(&opts.Source, "source", "", "source tag")
+	flags.StringVar(&opts.Name, "name", "", "torrent name")
+	flags.BoolVar(&opts.Private, "private", false, "private torrent")
+	noDate := flags.Bool("no-date", false, "leave out the creation date")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 || *outFile == "" {
+		return errors.New(usage)
+	}
+
+	for _, tier := range trackers {
+		opts.Trackers = append(opts.Trackers, strings.Split(tier, ","))
+	}
+	opts.WebSeeds = webSeeds
+	opts.CreatedBy = "bittorrent-go"
+	if !*noDate {
+		opts.CreationDate = time.Now()
+	}
+
+	data, err := torrent.Create(flags.Arg(0), opts)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(*outFile, data, 0644); err != nil {
+		return err
+	}
+
+	// reading it back doubles as a check that what we wrote makes sense
+	metaInfo, err := torrent.ParseFile(*outFile)
+	if err != nil {
+		return fmt.Errorf("created torrent doesn't parse: %w", err)
+	}
+	fmt.Fprintf(stdout, "Created %s with %d pieces, info hash %x.\n", *outFile, metaInfo.PieceCount(), metaInfo.InfoHash)
+	return nil
+}
+
+// edit deals with the edit command, which changes the trackers, comment or web
+// seeds of a torrent without touching its info dictionary, so that it keeps
+// the same info hash. The torrent is rewritten in place unless -o is given.
+func edit(args []string) error {
+
+	const usage = "usage: edit [-o <output file>] [--set-tracker=<url>[,<url>...]]... [--add-tracker=<url>]... " +
+		"[--remove-tracker=<url>]... [--replace-tracker=<old>=<new>]... [--comment=<text>] " +
+		"[--add-web-seed=<url>]... [--remove-web-seed=<url>]... <torrent file>"
+
+	var opts torrent.EditOptions
+	var setTrackers, addTrackers, removeTrackers, replaceTrackers, addSeeds, removeSeeds stringList
+
+	flags := flag.NewFlagSet("edit", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	outFile := flags.String("o", "", "output file, by default the torrent itself")
+	flags.Var(&setTrackers, "set-tracker", "replace all trackers, comma separated URLs share a tier")
+	flags.Var(&addTrackers, "add-tracker", "tracker URL to add")
+	flags.Var(&removeTrackers, "remove-tracker", "tracker URL to remove")
+	flags.Var(&replaceTrackers, "replace-tracker", "old=new tracker URLs")
+	comment := flags.String("comment", "", "new comment, empty to remove it")
+	flags.Var(&addSeeds, "add-web-seed", "web seed URL to add")
+	flags.Var(&removeSeeds, "remove-web-seed", "web seed URL to remove")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 {
+		return errors.New(usage)
+	}
+
+	for _, tier := range setTrackers {
+		opts.SetTrackers = append(opts.SetTrackers, strings.Split(tier, ","))
+	}
+	for _, r := range replaceTrackers {
+		old, replacement, ok := strings.Cut(r, "=")
+		if !ok || old == "" || replacement == "" {
+			return fmt.Errorf("invalid --replace-tracker %q, expected <old>=<new>\n%s", r, usage)
+		}
+		if opts.ReplaceTrackers == nil {
+			opts.ReplaceTrackers = make(map[string]string)
+		}
+		opts.ReplaceTrackers[old] = replacement
+	}
+	opts.AddTrackers = addTrackers
+	opts.RemoveTrackers = removeTrackers
+	opts.AddWebSeeds = addSeeds
+	opts.RemoveWebSeeds = removeSeeds
+	flags.Visit(func(f *flag.Flag) {
+		if f.Name == "comment" {
+			opts.Comment = comment
+		}
+	})
+
+	torrFile := flags.Arg(0)
+	if *outFile == "" {
+		*outFile = torrFile
+	}
+
+	data, err := os.ReadFile(torrFile)
+	if err != nil {
+		return fmt.Errorf("failed to read torrent file: %w", err)
+	}
+	before, err := torrent.Parse(data)
+	if err != nil {
+		return err
+	}
+	edited, err := torrent.Edit(data, opts)
+	if err != nil {
+		return err
+	}
+	after, err := torrent.Parse(edited)
+	if err != nil {
+		return fmt.Errorf("edited torrent doesn't parse: %w", err)
+	}
+	if after.InfoHash != before.InfoHash {
+		return errors.New("edit would change the info hash") // can't happen, but better safe
+	}
+
+	// write next to the target and rename, so a failure can't leave a
+	// half written torrent behind
+	tmp := *outFile + ".tmp"
+	if err := os.WriteFile(tmp, edited, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, *outFile); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	fmt.Fprintf(stdout, "Wrote %s, info hash %x unchanged.\n", *outFile, after.InfoHash)
+	return nil
+}
+
+// verify deals with the verify command, which checks data already on disk
+// against a torrent. It fails when anything's missing or corrupt, after
+// saying what.
+func verify(args []string) error {
+
+	const usage = "usage: verify [--workers=<n>] [--timeout=<duration>] [--retries=<n>] <torrent file | magnet link> <path>"
+
+	flags := flag.NewFlagSet("verify", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	workers := flags.Int("workers", 0, "pieces hashed in parallel")
+	clientOpts := trackerFlags(flags)
+	if err := flags.Parse(args); err != nil || flags.NArg() != 2 {
+		return errors.New(usage)
+	}
+	opts, err := clientOpts()
+	if err != nil {
+		return fmt.Errorf("%w\n%s", err, usage)
+	}
+
+	metaInfo, err := loadTorrentInfo(flags.Arg(0), opts)
+	if err != nil {
+		return err
+	}
+	result, err := torrent.Verify(metaInfo, flags.Arg(1), torrent.VerifyOptions{Workers: *workers})
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdout, "Pieces: %d of %d good (%.1f%% complete)\n", result.GoodPieces, result.PieceCount, result.Percent())
+	fmt.Fprintf(stdout, "Bitfield: %x\n", result.Bitfield)
+	if len(result.AffectedFiles) > 0 && metaInfo.MultiFile {
+		fmt.Fprintln(stdout, "Affected files:")
+		files := metaInfo.FileList()
+		for _, i := range result.AffectedFiles {
+			fmt.Fprintf(stdout, "  %s\n", path.Join(files[i].Path...))
+		}
+	}
+
+	if !result.Complete() {
+		return fmt.Errorf("%d pieces failed verification", result.PieceCount-result.GoodPieces)
+	}
+	return nil
+}
+
+// scrape deals with the scrape command, which asks each of the torrent's
+// trackers how its swarm is doing. Hybrid torrents are scraped under both of
+// their hashes. It only fails if none of the trackers answered.
+func scrape(args []string) error {
+
+	const usage = "usage: scrape [--timeout=<duration>] [--retries=<n>] <torrent file | magnet link>"
+
+	flags := flag.NewFlagSet("scrape", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	clientOpts := trackerFlags(flags)
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 {
+		return errors.New(usage)
+	}
+	opts, err := clientOpts()
+	if err != nil {
+		return fmt.Errorf("%w\n%s", err, usage)
+	}
+	metaInfo, err := loadTorrentInfo(flags.Arg(0), opts)
+	if err != nil {
+		return err
+	}
+
+	tiers := metaInfo.AnnounceList
+	if len(tiers) == 0 && metaInfo.AnnounceURL != "" {
+		tiers = [][]string{{metaInfo.AnnounceURL}}
+	}
+	hashes := metaInfo.SwarmHashes()
+
+	answered := false
+	for _, tier := range tiers {
+		for _, announceURL := range tier {
+			ctx, cancel := context.WithTimeout(context.Background(), opts.Tracker.Timeout)
+			stats, err := opts.Tracker.Scrape(ctx, announceURL, hashes)
+			cancel()
+			if err != nil {
+				fmt.Fprintf(stderr, "%s: %v\n", announceURL, err)
+				continue
+			}
+			answered = true
+			for i, s := range stats {
+				label := announceURL
+				if len(hashes) > 1 {
+					label += fmt.Sprintf(" (%x)", hashes[i])
+				}
+				fmt.Fprintf(stdout, "%s: %d seeders, %d leechers, %d downloaded\n", label, s.Seeders, s.Leechers, s.Completed)
+			}
 		}
-		c, err := client.New(torrFile)
+	}
+
+	if !answered {
+		return errors.New("no tracker answered the scrape")
+	}
+	return nil
+}
+
+// trackerCmd deals with the tracker command, whose only subcommand, serve,
+// runs an HTTP tracker until interrupted. Torrents can be given to --allow as
+// a hex info hash or a .torrent file, and when any are, they're the only ones
+// tracked.
+func trackerCmd(args []string) error {
+
+	const usage = "usage: tracker serve [--listen=<address>] [--interval=<duration>] [--peer-ttl=<duration>] " +
+		"[--numwant=<n>] [--trust-ip] [--allow=<info hash | torrent file>]..."
+
+	if len(args) == 0 || args[0] != "serve" {
+		return errors.New(usage)
+	}
+
+	var opts tracker.ServerOptions
+	var allow stringList
+
+	flags := flag.NewFlagSet("tracker serve", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	listen := flags.String("listen", ":6969", "address to listen on")
+	flags.DurationVar(&opts.Interval, "interval", 30*time.Minute, "how often peers should announce")
+	flags.DurationVar(&opts.PeerTTL, "peer-ttl", 0, "how long peers last without announcing, twice the interval by default")
+	flags.IntVar(&opts.NumWant, "numwant", 50, "peers handed out per announce, by default")
+	flags.BoolVar(&opts.TrustIP, "trust-ip", false, "take the address peers give over the one they announce from")
+	flags.Var(&allow, "allow", "torrent to track, a hex info hash or a .torrent file")
+	if err := flags.Parse(args[1:]); err != nil || flags.NArg() != 0 || opts.Interval <= 0 || opts.PeerTTL < 0 || opts.NumWant <= 0 {
+		return errors.New(usage)
+	}
+
+	for _, a := range allow {
+		hashes, err := allowedHashes(a)
 		if err != nil {
 			return err
 		}
-		if err := c.DownloadPiece(outFile, pieceIndex); err != nil {
-			return err
+		opts.Allowlist = append(opts.Allowlist, hashes...)
+	}
+
+	ln, err := net.Listen("tcp", *listen)
+	if err != nil {
+		return err
+	}
+	srv := tracker.NewServer(opts)
+	httpSrv := &http.Server{Handler: srv, ReadHeaderTimeout: 10 * time.Second}
+	served := make(chan error, 1)
+	go func() { served <- httpSrv.Serve(ln) }()
+	fmt.Fprintf(stdout, "Tracker listening on http://%s/announce\n", ln.Addr())
+
+	ctx, stop := interrupted()
+	defer stop()
+	select {
+	case err := <-served:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := httpSrv.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	stats := srv.Stats()
+	fmt.Fprintf(stdout, "Tracker stopped: %d swarms, %d seeders, %d leechers, %d announces, %d scrapes, %d failures.\n",
+		stats.Swarms, stats.Seeders, stats.Leechers, stats.Announces, stats.Scrapes, stats.Failures)
+	return nil
+}
+
+// allowedHashes turns an --allow value into info hashes: a hex info hash is
+// taken as is, anything else is read as a .torrent, whose hashes all go in
+func allowedHashes(value string) ([][20]byte, error) {
+
+	if b, err := hex.DecodeString(value); err == nil && len(b) == 20 {
+		return [][20]byte{[20]byte(b)}, nil
+	}
+	metaInfo, err := torrent.ParseFile(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --allow %q, neither an info hash nor a torrent: %w", value, err)
+	}
+	return metaInfo.SwarmHashes(), nil
+}
+
+// dhtCmd deals with the dht command, whose only subcommand, peers, looks up the
+// peers of a torrent on the DHT and prints them. --bootstrap replaces the
+// usual bootstrap nodes, and trackerless torrents add the nodes they come with.
+func dhtCmd(args []string) error {
+
+	const usage = "usage: dht peers [--bootstrap=<host:port>]... [--state=<file>] [--timeout=<duration>] " +
+		"<torrent file | magnet link | info hash>"
+
+	if len(args) == 0 || args[0] != "peers" {
+		return errors.New(usage)
+	}
+
+	var bootstrap stringList
+
+	flags := flag.NewFlagSet("dht peers", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	flags.Var(&bootstrap, "bootstrap", "DHT node to join from")
+	stateFile := flags.String("state", "", "file to keep the routing table in between runs")
+	timeout := flags.Duration("timeout", 30*time.Second, "how long the lookup can take, joining the DHT included")
+	if err := flags.Parse(args[1:]); err != nil || flags.NArg() != 1 || *timeout <= 0 {
+		return errors.New(usage)
+	}
+
+	hashes, nodes, err := dhtTargets(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	cfg := dht.Config{StateFile: *stateFile}
+	if len(bootstrap) > 0 {
+		cfg.Bootstrap = bootstrap
+	}
+	if len(nodes) > 0 {
+		if cfg.Bootstrap == nil {
+			cfg.Bootstrap = dht.DefaultBootstrap
 		}
-		fmt.Printf("Piece %d downloaded to %s.\n", pieceIndex, outFile)
+		cfg.Bootstrap = append(slices.Clip(cfg.Bootstrap), nodes...)
+	}
 
-	case "download":
-		if len(args) != 3 || args[0] != "-o" {
-			return errors.New("usage: download -o <output file> <torrent file>")
+	node, err := dht.Listen(cfg)
+	if err != nil {
+		return err
+	}
+	defer node.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	peers := []netip.AddrPort{}
+	var errs []error
+	for _, hash := range hashes {
+		found, err := node.GetPeers(ctx, hash)
+		if err != nil {
+			errs = append(errs, err)
 		}
-		outFile, torrFile := args[1], args[2]
-		c, err := client.New(torrFile)
+		for _, p := range found {
+			if !slices.Contains(peers, p) {
+				peers = append(peers, p)
+			}
+		}
+	}
+	if len(peers) == 0 && len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	return printJson(peers)
+}
+
+// dhtTargets returns the info hashes to look up for a dht command argument,
+// and the DHT nodes of a trackerless torrent. Magnet links are only parsed for
+// their info hash, there's no need for the metadata.
+func dhtTargets(value string) ([][20]byte, []string, error) {
+
+	if b, err := hex.DecodeString(value); err == nil && len(b) == 20 {
+		return [][20]byte{[20]byte(b)}, nil, nil
+	}
+	if client.IsMagnet(value) {
+		link, err := magnet.Parse(value)
 		if err != nil {
-			return err
+			return nil, nil, err
 		}
-		if err := c.DownloadFile(outFile); err != nil {
-			return err
+		return [][20]byte{link.InfoHash}, nil, nil
+	}
+	metaInfo, err := torrent.ParseFile(value)
+	if err != nil {
+		return nil, nil, err
+	}
+	if metaInfo.Private {
+		return nil, nil, errors.New("private torrents can't use the DHT")
+	}
+	return metaInfo.SwarmHashes(), metaInfo.Nodes, nil
+}
+
+// stringList is a flag that can be given more than once
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, " ")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// trackerFlags sets up the flags that say how trackers are talked to, shared
+// by the commands that contact them. The returned function gets the client
+// options once the flags have been parsed.
+func trackerFlags(flags *flag.FlagSet) func() (client.Options, error) {
+
+	timeout := flags.Duration("timeout", 15*time.Second, "how long to give each tracker, retries included")
+	retries := flags.Int("retries", 0, "how many more times to ask an HTTP tracker that didn't answer")
+
+	return func() (client.Options, error) {
+		if *timeout <= 0 {
+			return client.Options{}, fmt.Errorf("invalid timeout %v", *timeout)
+		}
+		if *retries < 0 {
+			return client.Options{}, fmt.Errorf("invalid number of retries %d", *retries)
 		}
-		fmt.Printf("Downloaded %s to %s.\n", torrFile, outFile)
+		return client.Options{Tracker: &tracker.Client{Timeout: *timeout, Retries: *retries}}, nil
+	}
+}
 
-	default:
-		return fmt.Errorf("unknown command: %s", command)
+// convertFlags sets up the flags that pick an output format, shared by the
+// commands that print bencoded values. The returned function gets the options
+// once the flags have been parsed.
+func convertFlags(flags *flag.FlagSet) func() (bencode.ConvertOptions, error) {
+
+	format := flags.String("format", "json", "output format")
+	binary := flags.String("binary", "hex", "encoding for strings that aren't UTF-8")
+
+	return func() (bencode.ConvertOptions, error) {
+		opts := bencode.ConvertOptions{Indent: " "}
+		var err error
+		if opts.Format, err = bencode.ParseFormat(*format); err != nil {
+			return opts, err
+		}
+		switch *binary {
+		case "hex":
+			opts.Binary = bencode.Hex
+		case "base64":
+			opts.Binary = bencode.Base64
+		default:
+			return opts, fmt.Errorf("unknown binary encoding %q", *binary)
+		}
+		return opts, nil
 	}
+}
 
+// convert prints a bencoded value to stdout, with some context around the
+// problem if it turns out to be malformed
+func convert(input []byte, opts bencode.ConvertOptions) error {
+
+	if err := bencode.Convert(stdout, input, opts); err != nil {
+		var syntaxErr *bencode.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			return fmt.Errorf("%w\n%s", err, errorSnippet(input, syntaxErr))
+		}
+		return err
+	}
 	return nil
 }
 
+// readInput gets the bytes a command should work on: "-" reads stdin, the
+// path of an existing file reads that file, and anything else is taken as
+// the input itself
+func readInput(arg string) ([]byte, error) {
+
+	if arg == "-" {
+		input, err := io.ReadAll(stdin)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read stdin: %w", err)
+		}
+		return input, nil
+	}
+
+	if fi, err := os.Stat(arg); err == nil && fi.Mode().IsRegular() {
+		input, err := os.ReadFile(arg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", arg, err)
+		}
+		return input, nil
+	}
+
+	return []byte(arg), nil
+}
+
 // printJson is just a helper to format some output into JSON. It's unexported
 // since it's only used for this package.
 // TODO this is a remnant from the codecrafters challenge, need to revisit later
 func printJson(v interface{}) error {
-	encoder := json.NewEncoder(os.Stdout)
+	encoder := json.NewEncoder(stdout)
 	encoder.SetIndent("", " ")
 	return encoder.Encode(v)
 }
+
+// snippetContext is how many bytes of input are shown on either side of the
+// offending byte in errorSnippet
+const snippetContext = 30
+
+// errorSnippet renders the input around a syntax error with a caret pointing
+// at the offending byte, e.g.
+//
+//	l4:spam
+//	       ^ expected 'e'
+//
+// Anything that isn't printable ASCII is shown as a dot, so that binary
+// strings don't mess up the alignment.
+func errorSnippet(input []byte, syntaxErr *bencode.SyntaxError) string {
+
+	offset := syntaxErr.Offset
+	start := max(0, offset-snippetContext)
+	end := min(len(input), offset+snippetContext)
+
+	var line strings.Builder
+	if start > 0 {
+		line.WriteString("...")
+	}
+	caret := line.Len() + offset - start
+	for _, c := range input[start:end] {
+		if c < 0x20 || c > 0x7e {
+			c = '.'
+		}
+		line.WriteByte(c)
+	}
+	if end < len(input) {
+		line.WriteString("...")
+	}
+
+	return fmt.Sprintf("  %s\n  %s^ expected %s", line.String(), strings.Repeat(" ", caret), syntaxErr.Expected)
+}
@@ -1,10 +1,28 @@
 package cmd
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/lourencovales/codecrafters/bittorrent-go/bencode"
+	"github.com/lourencovales/codecrafters/bittorrent-go/client"
+	"github.com/lourencovales/codecrafters/bittorrent-go/dht"
+	"github.com/lourencovales/codecrafters/bittorrent-go/peer"
+	"github.com/lourencovales/codecrafters/bittorrent-go/torrent"
+	"github.com/lourencovales/codecrafters/bittorrent-go/tracker"
 )
 
 func TestRunUnknownCommand(t *testing.T) {
@@ -48,6 +66,170 @@ func TestRunDecodeInvalidBencode(t *testing.T) {
 	}
 }
 
+func TestRunDecodeFormats(t *testing.T) {
+	input := "d4:name3:abc6:pieces2:\x00\xffe"
+	path := filepath.Join(t.TempDir(), "input.bin")
+	if err := os.WriteFile(path, []byte(input), 0o644); err != nil {
+		t.Fatalf("failed to write input file: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		args     []string
+		stdin    string
+		expected string
+	}{
+		{"literal", []string{"4:spam"}, "", "\"spam\"\n"},
+		{"file", []string{"--format=tree", path}, "", "name: \"abc\"\npieces: <2 bytes> 00ff\n"},
+		{"stdin", []string{"--format=raw", "-"}, input, "{\n \"name\": \"abc\",\n \"pieces\": {\n  \"$hex\": \"00ff\"\n }\n}\n"},
+		{"base64", []string{"--binary=base64", "-"}, input, "{\n \"name\": \"abc\",\n \"pieces\": \"AP8=\"\n}\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			stdin, stdout = strings.NewReader(tt.stdin), &out
+			defer func() { stdin, stdout = os.Stdin, os.Stdout }()
+
+			if err := Run("decode", tt.args); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if out.String() != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, out.String())
+			}
+		})
+	}
+}
+
+func TestRunDecodeInvalidFlags(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"unknown format", []string{"--format=xml", "4:spam"}},
+		{"unknown binary encoding", []string{"--binary=octal", "4:spam"}},
+		{"unknown flag", []string{"--pretty", "4:spam"}},
+		{"flag without value", []string{"4:spam", "--format"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := Run("decode", tt.args); err == nil {
+				t.Error("expected error for invalid flags")
+			}
+		})
+	}
+}
+
+func TestRunQuery(t *testing.T) {
+	input := "d4:infod5:filesld6:lengthi1e4:pathl1:a1:beee12:piece lengthi16384eee"
+
+	tests := []struct {
+		name     string
+		args     []string
+		expected string
+	}{
+		{"key with a space", []string{"-", "info.piece length"}, "16384\n"},
+		{"list element", []string{"-", "info.files[0].path[1]"}, "\"b\"\n"},
+		{"tree", []string{"--format=tree", "-", "info.files[0]"}, "length: 1\npath:\n  - \"a\"\n  - \"b\"\n"},
+		{"span", []string{"--span", "-", "info.piece length"}, "59-66 (7 bytes)\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			stdin, stdout = strings.NewReader(input), &out
+			defer func() { stdin, stdout = os.Stdin, os.Stdout }()
+
+			if err := Run("query", tt.args); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if out.String() != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, out.String())
+			}
+		})
+	}
+}
+
+func TestRunQueryErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"no args", []string{}},
+		{"no path", []string{"de"}},
+		{"too many args", []string{"de", "a", "b"}},
+		{"invalid path", []string{"d1:ai1ee", "a["}},
+		{"missing key", []string{"d1:ai1ee", "b"}},
+		{"malformed input", []string{"d1:ai1e", "b"}},
+		{"unknown format", []string{"--format=xml", "d1:ai1ee", "a"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := Run("query", tt.args); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestRunDecodeSyntaxErrorSnippet(t *testing.T) {
+	err := Run("decode", []string{"l4:spam"})
+	if err == nil {
+		t.Fatal("expected error for unterminated list")
+	}
+
+	var syntaxErr *bencode.SyntaxError
+	if !errors.As(err, &syntaxErr) {
+		t.Errorf("expected the error to wrap *bencode.SyntaxError, got %v", err)
+	}
+
+	expected := "  l4:spam\n         ^ expected 'e'"
+	if !strings.HasSuffix(err.Error(), expected) {
+		t.Errorf("expected snippet %q in error, got %q", expected, err.Error())
+	}
+}
+
+func TestErrorSnippet(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		offset   int
+		expected string
+	}{
+		{
+			"start of input",
+			"x",
+			0,
+			"  x\n  ^ expected 'e'",
+		},
+		{
+			"binary data",
+			"3:\x00\x01\x02x",
+			5,
+			"  3:...x\n       ^ expected 'e'",
+		},
+		{
+			"long input is trimmed",
+			strings.Repeat("a", 40) + "X" + strings.Repeat("b", 40),
+			40,
+			"  ..." + strings.Repeat("a", 30) + "X" + strings.Repeat("b", 29) + "...\n" +
+				"  " + strings.Repeat(" ", 33) + "^ expected 'e'",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			syntaxErr := &bencode.SyntaxError{Offset: tt.offset, Expected: "'e'"}
+			result := errorSnippet([]byte(tt.input), syntaxErr)
+			if result != tt.expected {
+				t.Errorf("expected\n%s\ngot\n%s", tt.expected, result)
+			}
+		})
+	}
+}
+
 func TestRunInfoInvalidArgs(t *testing.T) {
 	tests := []struct {
 		name string
@@ -55,6 +237,7 @@ func TestRunInfoInvalidArgs(t *testing.T) {
 	}{
 		{"no args", []string{}},
 		{"too many args", []string{"file1.torrent", "file2.torrent"}},
+		{"bad timeout", []string{"--timeout=0s", "file.torrent"}},
 	}
 
 	for _, tt := range tests {
@@ -126,6 +309,8 @@ func TestRunPeersInvalidArgs(t *testing.T) {
 	}{
 		{"no args", []string{}},
 		{"too many args", []string{"file1.torrent", "file2.torrent"}},
+		{"bad timeout", []string{"--timeout=soon", "file.torrent"}},
+		{"negative retries", []string{"--retries=-1", "file.torrent"}},
 	}
 
 	for _, tt := range tests {
@@ -149,6 +334,35 @@ func TestRunPeersValid(t *testing.T) {
 	}
 }
 
+func TestRunPeersTimeout(t *testing.T) {
+	silent, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer silent.Close()
+
+	dir := t.TempDir()
+	content := filepath.Join(dir, "content.txt")
+	if err := os.WriteFile(content, []byte("nobody answers"), 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	torrFile := filepath.Join(dir, "test.torrent")
+	stdout = io.Discard
+	defer func() { stdout = os.Stdout }()
+	if err := Run("create", []string{"-o", torrFile, "--tracker=udp://" + silent.LocalAddr().String(), content}); err != nil {
+		t.Fatalf("failed to create torrent: %v", err)
+	}
+
+	start := time.Now()
+	err = Run("peers", []string{"--timeout=100ms", torrFile})
+	if err == nil || !strings.Contains(err.Error(), "all trackers failed") {
+		t.Errorf("expected the tracker to fail, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("expected --timeout to be used, took %v", elapsed)
+	}
+}
+
 func TestRunHandshakeInvalidArgs(t *testing.T) {
 	tests := []struct {
 		name string
@@ -189,6 +403,8 @@ func TestRunDownloadPieceInvalidArgs(t *testing.T) {
 		{"wrong flag", []string{"-x", "out.file", "file.torrent", "0"}},
 		{"too few args", []string{"-o", "out.file", "file.torrent"}},
 		{"too many args", []string{"-o", "out.file", "file.torrent", "0", "extra"}},
+		{"no output", []string{"file.torrent", "0"}},
+		{"negative retries", []string{"--retries=-1", "-o", "out.file", "file.torrent", "0"}},
 	}
 
 	for _, tt := range tests {
@@ -214,6 +430,7 @@ func TestRunDownloadPieceInvalidIndex(t *testing.T) {
 func TestRunDownloadPieceValid(t *testing.T) {
 	tmpFile := createTestTorrentFile(t)
 	defer os.Remove(tmpFile)
+	offlineDHT(t)
 
 	outFile, err := os.CreateTemp("", "piece.out")
 	if err != nil {
@@ -238,6 +455,8 @@ func TestRunDownloadInvalidArgs(t *testing.T) {
 		{"wrong flag", []string{"-x", "out.file", "file.torrent"}},
 		{"too few args", []string{"-o", "out.file"}},
 		{"too many args", []string{"-o", "out.file", "file.torrent", "extra"}},
+		{"no output", []string{"file.torrent"}},
+		{"bad timeout", []string{"--timeout=-1s", "-o", "out.file", "file.torrent"}},
 	}
 
 	for _, tt := range tests {
@@ -253,6 +472,7 @@ func TestRunDownloadInvalidArgs(t *testing.T) {
 func TestRunDownloadValid(t *testing.T) {
 	tmpFile := createTestTorrentFile(t)
 	defer os.Remove(tmpFile)
+	offlineDHT(t)
 
 	outFile, err := os.CreateTemp("", "download.out")
 	if err != nil {
@@ -266,4 +486,557 @@ func TestRunDownloadValid(t *testing.T) {
 	if err == nil {
 		t.Error("expected error for non-existent tracker")
 	}
-}
\ No newline at end of file
+}
+
+// seed serves data, a torrent's only piece, to whoever connects, and runs an
+// HTTP tracker that hands it out as the only peer. It returns the announce
+// URL of the tracker.
+func seed(t *testing.T, data []byte) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				handshake := make([]byte, 68)
+				if _, err := io.ReadFull(conn, handshake); err != nil {
+					return
+				}
+				conn.Write(handshake)
+				peer.SendMsg(conn, peer.MsgBitfield, []byte{0x80})
+				for {
+					msg, err := peer.ReadMsg(conn)
+					if err != nil {
+						return
+					}
+					switch msg.ID {
+					case peer.MsgInterested:
+						peer.SendMsg(conn, peer.MsgUnchoke, nil)
+					case peer.MsgRequest:
+						begin := binary.BigEndian.Uint32(msg.Payload[4:8])
+						length := binary.BigEndian.Uint32(msg.Payload[8:12])
+						block := data[begin : begin+length]
+						peer.SendMsg(conn, peer.MsgPiece, append(append([]byte{}, msg.Payload[0:8]...), block...))
+					}
+				}
+			}()
+		}
+	}()
+
+	addr := ln.Addr().(*net.TCPAddr).AddrPort()
+	compact := binary.BigEndian.AppendUint16(addr.Addr().AsSlice(), addr.Port())
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "d8:intervali1800e5:peers%d:%se", len(compact), compact)
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL + "/announce"
+}
+
+func TestRunDownloadFromTracker(t *testing.T) {
+	offlineDHT(t)
+	data := bytes.Repeat([]byte("download me"), 1000)
+	dir := t.TempDir()
+	content := filepath.Join(dir, "content.txt")
+	if err := os.WriteFile(content, data, 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	var out bytes.Buffer
+	stdout = &out
+	defer func() { stdout = os.Stdout }()
+	torrFile := filepath.Join(dir, "test.torrent")
+	if err := Run("create", []string{"-o", torrFile, "--tracker=" + seed(t, data), content}); err != nil {
+		t.Fatalf("failed to create torrent: %v", err)
+	}
+
+	tests := []struct {
+		command string
+		args    []string
+		output  string
+	}{
+		{"download", []string{torrFile}, "Downloaded " + torrFile + " to %s.\n"},
+		{"download_piece", []string{torrFile, "0"}, "Piece 0 downloaded to %s.\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.command, func(t *testing.T) {
+			outFile := filepath.Join(t.TempDir(), "out")
+			out.Reset()
+			args := append([]string{"--timeout=5s", "--retries=1", "-o", outFile}, tt.args...)
+			if err := Run(tt.command, args); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if expected := fmt.Sprintf(tt.output, outFile); out.String() != expected {
+				t.Errorf("expected %q, got %q", expected, out.String())
+			}
+			got, err := os.ReadFile(outFile)
+			if err != nil || !bytes.Equal(got, data) {
+				t.Errorf("downloaded data doesn't match: %v", err)
+			}
+		})
+	}
+}
+
+func TestRunCreate(t *testing.T) {
+	dir := t.TempDir()
+	content := filepath.Join(dir, "content")
+	if err := os.MkdirAll(filepath.Join(content, "sub"), 0o755); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(content, "sub", "file.txt"), []byte(strings.Repeat("data", 10000)), 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	var out bytes.Buffer
+	stdout = &out
+	defer func() { stdout = os.Stdout }()
+
+	outFile := filepath.Join(dir, "out.torrent")
+	err := Run("create", []string{
+		"-o", outFile,
+		"--tracker=http://a/announce,http://b/announce", "--tracker=http://c/announce",
+		"--web-seed=http://seed/", "--piece-length=16384", "--private", "--no-date",
+		content,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	metaInfo, err := torrent.ParseFile(outFile)
+	if err != nil {
+		t.Fatalf("failed to parse created torrent: %v", err)
+	}
+	if len(metaInfo.AnnounceList) != 2 || len(metaInfo.AnnounceList[0]) != 2 {
+		t.Errorf("expected two tiers, got %v", metaInfo.AnnounceList)
+	}
+	if len(metaInfo.PieceHashes) != 3 {
+		t.Errorf("expected 3 pieces, got %d", len(metaInfo.PieceHashes))
+	}
+	if !strings.Contains(out.String(), fmt.Sprintf("%x", metaInfo.InfoHash)) {
+		t.Errorf("expected the info hash in the output, got %q", out.String())
+	}
+}
+
+func TestRunCreateInvalidArgs(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"no args", []string{}},
+		{"no output", []string{"--tracker=http://a", "path"}},
+		{"no path", []string{"-o", "out.torrent", "--tracker=http://a"}},
+		{"private without tracker", []string{"-o", "out.torrent", "--private", "run.go"}},
+		{"bad piece length", []string{"-o", "out.torrent", "--tracker=http://a", "--piece-length=abc", "run.go"}},
+		{"missing path", []string{"-o", "out.torrent", "--tracker=http://a", "does-not-exist"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := Run("create", tt.args); err == nil {
+				t.Error("expected error for invalid args")
+			}
+		})
+	}
+}
+
+func TestRunVerify(t *testing.T) {
+	dir := t.TempDir()
+	content := filepath.Join(dir, "content")
+	if err := os.MkdirAll(filepath.Join(content, "sub"), 0o755); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+	for name, data := range map[string]string{"a.txt": strings.Repeat("a", 20000), "sub/b.txt": strings.Repeat("b", 20000)} {
+		if err := os.WriteFile(filepath.Join(content, name), []byte(data), 0o644); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+	}
+	var out bytes.Buffer
+	stdout = &out
+	defer func() { stdout = os.Stdout }()
+
+	torrFile := filepath.Join(dir, "test.torrent")
+	if err := Run("create", []string{"-o", torrFile, "--tracker=http://a/announce", "--piece-length=16384", content}); err != nil {
+		t.Fatalf("failed to create torrent: %v", err)
+	}
+	out.Reset()
+
+	if err := Run("verify", []string{torrFile, content}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(out.String(), "Pieces: 3 of 3 good (100.0% complete)") || !strings.Contains(out.String(), "Bitfield: e0") {
+		t.Errorf("unexpected output:\n%s", out.String())
+	}
+
+	out.Reset()
+	if err := os.WriteFile(filepath.Join(content, "sub", "b.txt"), []byte(strings.Repeat("x", 20000)), 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	err := Run("verify", []string{"--workers=2", torrFile, content})
+	if err == nil || !strings.Contains(err.Error(), "2 pieces failed verification") {
+		t.Errorf("expected verification to fail, got %v", err)
+	}
+	for _, expected := range []string{"Pieces: 1 of 3 good (33.3% complete)", "Bitfield: 80", "Affected files:\n  a.txt\n  sub/b.txt\n"} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("expected %q in output:\n%s", expected, out.String())
+		}
+	}
+}
+
+func TestRunVerifyInvalidArgs(t *testing.T) {
+	for _, args := range [][]string{{}, {"only-one"}, {"--workers=x", "a", "b"}, {"--timeout=0s", "a", "b"}} {
+		err := Run("verify", args)
+		if err == nil || !strings.Contains(err.Error(), "usage: verify") {
+			t.Errorf("%v: expected usage error, got %v", args, err)
+		}
+	}
+}
+
+func TestRunEdit(t *testing.T) {
+	dir := t.TempDir()
+	content := filepath.Join(dir, "content.bin")
+	if err := os.WriteFile(content, []byte(strings.Repeat("data", 10000)), 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	var out bytes.Buffer
+	stdout = &out
+	defer func() { stdout = os.Stdout }()
+
+	torrFile := filepath.Join(dir, "test.torrent")
+	err := Run("create", []string{"-o", torrFile, "--tracker=http://a/announce,http://b/announce", "--comment=old", content})
+	if err != nil {
+		t.Fatalf("failed to create torrent: %v", err)
+	}
+	before, err := torrent.ParseFile(torrFile)
+	if err != nil {
+		t.Fatalf("failed to parse torrent: %v", err)
+	}
+
+	// to another file first, then in place
+	edited := filepath.Join(dir, "edited.torrent")
+	err = Run("edit", []string{
+		"-o", edited,
+		"--replace-tracker=http://a/announce=http://new/announce",
+		"--add-tracker=http://c/announce",
+		"--comment=",
+		"--add-web-seed=http://seed/",
+		torrFile,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := Run("edit", []string{"--remove-tracker=http://b/announce", edited}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	after, err := torrent.ParseFile(edited)
+	if err != nil {
+		t.Fatalf("failed to parse edited torrent: %v", err)
+	}
+	if after.InfoHash != before.InfoHash {
+		t.Error("info hash changed")
+	}
+	expectedTiers := [][]string{{"http://new/announce"}, {"http://c/announce"}}
+	if fmt.Sprint(after.AnnounceList) != fmt.Sprint(expectedTiers) {
+		t.Errorf("expected trackers %v, got %v", expectedTiers, after.AnnounceList)
+	}
+	if after.Comment != "" || len(after.WebSeeds) != 1 {
+		t.Errorf("expected no comment and one web seed, got %q %v", after.Comment, after.WebSeeds)
+	}
+	if !strings.Contains(out.String(), fmt.Sprintf("info hash %x unchanged", before.InfoHash)) {
+		t.Errorf("unexpected output:\n%s", out.String())
+	}
+
+	// the original is left alone when writing elsewhere
+	if original, _ := torrent.ParseFile(torrFile); original.Comment != "old" {
+		t.Error("expected the original torrent to be untouched")
+	}
+	if _, err := os.Stat(edited + ".tmp"); !os.IsNotExist(err) {
+		t.Error("expected the temporary file to be gone")
+	}
+}
+
+func TestRunEditErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		err  string
+	}{
+		{"no torrent", []string{"--comment=x"}, "usage: edit"},
+		{"bad replace", []string{"--replace-tracker=nope", "x.torrent"}, "expected <old>=<new>"},
+		{"missing torrent", []string{"--comment=x", "missing.torrent"}, "failed to read torrent file"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Run("edit", tt.args)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("expected error containing %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestRunScrape(t *testing.T) {
+	// answers for whatever torrent it's asked about
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hash := r.URL.Query().Get("info_hash")
+		fmt.Fprintf(w, "d5:filesd20:%sd8:completei7e10:downloadedi40e10:incompletei3eeee", hash)
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	content := filepath.Join(dir, "content.txt")
+	if err := os.WriteFile(content, []byte("scrape me"), 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	var out, errOut bytes.Buffer
+	stdout, stderr = &out, &errOut
+	defer func() { stdout, stderr = os.Stdout, os.Stderr }()
+
+	torrFile := filepath.Join(dir, "test.torrent")
+	good := srv.URL + "/announce"
+	if err := Run("create", []string{"-o", torrFile, "--tracker=" + good, "--tracker=http://127.0.0.1:1/tracker", content}); err != nil {
+		t.Fatalf("failed to create torrent: %v", err)
+	}
+	out.Reset()
+
+	if err := Run("scrape", []string{"--timeout=5s", "--retries=1", torrFile}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := good + ": 7 seeders, 3 leechers, 40 downloaded\n"; out.String() != expected {
+		t.Errorf("expected %q as output, got:\n%s", expected, out.String())
+	}
+	if expected := "http://127.0.0.1:1/tracker: tracker doesn't support scraping\n"; errOut.String() != expected {
+		t.Errorf("expected %q on stderr, got:\n%s", expected, errOut.String())
+	}
+}
+
+func TestRunScrapeErrors(t *testing.T) {
+	dir := t.TempDir()
+	content := filepath.Join(dir, "content.txt")
+	if err := os.WriteFile(content, []byte("scrape me"), 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	stdout, stderr = io.Discard, io.Discard
+	defer func() { stdout, stderr = os.Stdout, os.Stderr }()
+
+	torrFile := filepath.Join(dir, "test.torrent")
+	if err := Run("create", []string{"-o", torrFile, "--tracker=http://127.0.0.1:1/tracker", content}); err != nil {
+		t.Fatalf("failed to create torrent: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		err  string
+	}{
+		{"no args", []string{}, "usage: scrape"},
+		{"too many args", []string{torrFile, torrFile}, "usage: scrape"},
+		{"bad timeout", []string{"--timeout=soon", torrFile}, "usage: scrape"},
+		{"negative retries", []string{"--retries=-1", torrFile}, "usage: scrape"},
+		{"missing torrent", []string{filepath.Join(dir, "missing.torrent")}, "no such file"},
+		{"no tracker answers", []string{torrFile}, "no tracker answered"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Run("scrape", tt.args)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("expected error containing %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestRunTrackerServe(t *testing.T) {
+	dir := t.TempDir()
+	content := filepath.Join(dir, "content.txt")
+	if err := os.WriteFile(content, []byte("track me"), 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	torrFile := filepath.Join(dir, "test.torrent")
+	stdout = io.Discard
+	if err := Run("create", []string{"-o", torrFile, "--tracker=http://127.0.0.1:1/announce", content}); err != nil {
+		t.Fatalf("failed to create torrent: %v", err)
+	}
+	metaInfo, err := torrent.ParseFile(torrFile)
+	if err != nil {
+		t.Fatalf("failed to parse torrent: %v", err)
+	}
+
+	pr, pw := io.Pipe()
+	stdout = pw
+	ctx, cancel := context.WithCancel(context.Background())
+	original := interrupted
+	interrupted = func() (context.Context, context.CancelFunc) { return ctx, cancel }
+	defer func() {
+		stdout = os.Stdout
+		interrupted = original
+	}()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- Run("tracker", []string{"serve", "--listen=127.0.0.1:0", "--allow=" + torrFile})
+		pw.Close()
+	}()
+
+	out := bufio.NewReader(pr)
+	line, err := out.ReadString('\n')
+	if err != nil {
+		t.Fatalf("failed to read the listening address: %v", err)
+	}
+	announceURL := strings.TrimSpace(strings.TrimPrefix(line, "Tracker listening on "))
+
+	c := &tracker.Client{}
+	peerID := [20]byte{'p'}
+	if _, err := c.Announce(context.Background(), announceURL, tracker.AnnounceRequest{InfoHash: metaInfo.InfoHash, PeerID: peerID, Port: 6881, Left: 1}); err != nil {
+		t.Errorf("unexpected error announcing an allowed torrent: %v", err)
+	}
+	if _, err := c.Announce(context.Background(), announceURL, tracker.AnnounceRequest{InfoHash: [20]byte{1}, PeerID: peerID, Port: 6881, Left: 1}); err == nil {
+		t.Error("expected error announcing a torrent that isn't allowed")
+	}
+
+	cancel()
+	rest, _ := io.ReadAll(out)
+	if err := <-done; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := "Tracker stopped: 1 swarms, 0 seeders, 1 leechers, 1 announces, 0 scrapes, 1 failures.\n"; string(rest) != expected {
+		t.Errorf("expected %q, got %q", expected, rest)
+	}
+}
+
+func TestRunTrackerServeErrors(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name string
+		args []string
+		err  string
+	}{
+		{"no subcommand", []string{}, "usage: tracker serve"},
+		{"unknown subcommand", []string{"stop"}, "usage: tracker serve"},
+		{"extra args", []string{"serve", "now"}, "usage: tracker serve"},
+		{"bad interval", []string{"serve", "--interval=0s"}, "usage: tracker serve"},
+		{"bad allow", []string{"serve", "--allow=" + filepath.Join(dir, "missing.torrent")}, "invalid --allow"},
+		{"bad address", []string{"serve", "--listen=127.0.0.1:99999"}, "invalid port"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Run("tracker", tt.args)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("expected error containing %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+// offlineDHT keeps the client from looking for peers on the real DHT, for
+// the rest of the test
+func offlineDHT(t *testing.T) {
+	old := client.DHTConfig
+	client.DHTConfig = dht.Config{Addr: "127.0.0.1:0", Bootstrap: []string{}, StateFile: filepath.Join(t.TempDir(), "dht.dat")}
+	t.Cleanup(func() { client.DHTConfig = old })
+}
+
+// startTestDHT starts a couple of DHT nodes on the loopback interface, the
+// second having announced a peer on port 6881 for infoHash, and returns the
+// address of the first
+func startTestDHT(t *testing.T, infoHash [20]byte) string {
+	first, err := dht.Listen(dht.Config{Addr: "127.0.0.1:0", Bootstrap: []string{}})
+	if err != nil {
+		t.Fatalf("failed to start DHT node: %v", err)
+	}
+	t.Cleanup(func() { first.Close() })
+	second, err := dht.Listen(dht.Config{Addr: "127.0.0.1:0", Bootstrap: []string{first.Addr().String()}})
+	if err != nil {
+		t.Fatalf("failed to start DHT node: %v", err)
+	}
+	t.Cleanup(func() { second.Close() })
+	if _, err := second.Announce(context.Background(), infoHash, 6881); err != nil {
+		t.Fatalf("failed to announce: %v", err)
+	}
+	return first.Addr().String()
+}
+
+func TestRunDHTPeers(t *testing.T) {
+	hash := [20]byte{1, 2, 3}
+	state := filepath.Join(t.TempDir(), "dht.dat")
+
+	tests := []struct {
+		name   string
+		args   []string
+		output string
+	}{
+		{"info hash", []string{"--state=" + state, "--timeout=5s", fmt.Sprintf("%x", hash)}, "[\n \"127.0.0.1:6881\"\n]\n"},
+		{"magnet link", []string{fmt.Sprintf("magnet:?xt=urn:btih:%x", hash)}, "[\n \"127.0.0.1:6881\"\n]\n"},
+		{"nobody announced", []string{strings.Repeat("ab", 20)}, "[]\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// a network of its own, as the nodes of the other cases are gone
+			node := startTestDHT(t, hash)
+			var out bytes.Buffer
+			stdout = &out
+			defer func() { stdout = os.Stdout }()
+
+			if err := Run("dht", append([]string{"peers", "--bootstrap=" + node}, tt.args...)); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if out.String() != tt.output {
+				t.Errorf("expected %q, got %q", tt.output, out.String())
+			}
+		})
+	}
+
+	if _, err := os.Stat(state); err != nil {
+		t.Errorf("expected the routing table to be saved: %v", err)
+	}
+}
+
+func TestRunDHTPeersErrors(t *testing.T) {
+	dir := t.TempDir()
+	private := filepath.Join(dir, "private.torrent")
+	data, err := bencode.Marshal(map[string]interface{}{
+		"info": map[string]interface{}{"name": "a", "length": 1, "piece length": 16384, "pieces": strings.Repeat("x", 20), "private": 1},
+	})
+	if err != nil {
+		t.Fatalf("failed to marshal torrent: %v", err)
+	}
+	if err := os.WriteFile(private, data, 0o644); err != nil {
+		t.Fatalf("failed to write torrent: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		err  string
+	}{
+		{"no subcommand", []string{}, "usage: dht peers"},
+		{"unknown subcommand", []string{"ping"}, "usage: dht peers"},
+		{"no target", []string{"peers"}, "usage: dht peers"},
+		{"bad timeout", []string{"peers", "--timeout=0s", strings.Repeat("ab", 20)}, "usage: dht peers"},
+		{"missing torrent", []string{"peers", filepath.Join(dir, "missing.torrent")}, "failed to read torrent file"},
+		{"bad magnet link", []string{"peers", "magnet:?dn=nothing"}, "no BitTorrent info hash"},
+		{"private torrent", []string{"peers", private}, "private torrents can't use the DHT"},
+		{"nobody to join from", []string{"peers", "--bootstrap=127.0.0.1:1", "--timeout=1s", strings.Repeat("ab", 20)}, "failed to join the DHT"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Run("dht", tt.args)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("expected error containing %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
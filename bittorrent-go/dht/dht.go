@@ -0,0 +1,805 @@
+// Package dht implements a node of the mainline DHT (BEP 5), the Kademlia
+// network BitTorrent clients use to find peers without a tracker.
+package dht
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lourencovales/codecrafters/bittorrent-go/bencode"
+)
+
+// DefaultBootstrap is where nodes that don't know anyone else yet join the
+// DHT from.
+var DefaultBootstrap = []string{
+	"router.bittorrent.com:6881",
+	"dht.transmissionbt.com:6881",
+	"router.utorrent.com:6881",
+}
+
+// alpha is how many queries a lookup has in flight at once.
+const alpha = 3
+
+// These are the defaults of a Config whose fields are left at zero.
+const (
+	defaultTimeout = 2 * time.Second
+)
+
+// refreshInterval is how often the routing table is checked on, and saved.
+const refreshInterval = 5 * time.Minute
+
+// staleAfter is how long a node can go without being heard from before it's
+// pinged to check it's still around.
+const staleAfter = 15 * time.Minute
+
+// ErrClosed is returned for queries cut short by the node being closed.
+var ErrClosed = errors.New("dht: node closed")
+
+// errTimeout is returned for queries that went unanswered.
+var errTimeout = errors.New("dht: query timed out")
+
+// Config configures a Node.
+type Config struct {
+	// ID is the node's ID. When zero, the one saved in StateFile is used,
+	// or a random one if there's none.
+	ID ID
+	// Addr is the UDP address to listen on, any port on every interface
+	// when empty.
+	Addr string
+	// Bootstrap are the host:port addresses of the nodes to join the DHT
+	// from, DefaultBootstrap when nil. An empty list leaves the node to
+	// whatever StateFile holds, or to be found by others.
+	Bootstrap []string
+	// StateFile, when set, is where the node's ID and routing table are
+	// kept between runs: it's read by Listen, and written every so often
+	// and by Close.
+	StateFile string
+	// Timeout is how long to wait for an answer to each query, two seconds
+	// when zero.
+	Timeout time.Duration
+}
+
+// Node is a node of the DHT. It answers the queries of other nodes for as
+// long as it's open, and looks up and announces peers for torrents. It's safe
+// to use from several goroutines.
+type Node struct {
+	id     ID
+	cfg    Config
+	conn   *net.UDPConn
+	table  *table
+	tokens *tokens
+	peers  *peerStore
+	// now is time.Now, swapped out in the tests
+	now func() time.Time
+
+	mu      sync.Mutex
+	pending map[string]*pendingQuery
+	nextT   uint16
+
+	// ctx is cancelled when the node is closed
+	ctx       context.Context
+	cancel    context.CancelFunc
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// pendingQuery is a query waiting for its answer
+type pendingQuery struct {
+	addr   netip.AddrPort
+	answer chan *msg
+}
+
+// Listen opens a node on cfg.Addr. It starts answering queries straight
+// away, but only joins the DHT on Bootstrap, or on the first lookup.
+func Listen(cfg Config) (*Node, error) {
+
+	st, err := loadState(cfg.StateFile)
+	if err != nil {
+		return nil, err
+	}
+	id := cfg.ID
+	if id == (ID{}) {
+		if st != nil {
+			id = st.id
+		} else {
+			id = RandomID()
+		}
+	}
+	if cfg.Bootstrap == nil {
+		cfg.Bootstrap = DefaultBootstrap
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultTimeout
+	}
+
+	addr := cfg.Addr
+	if addr == "" {
+		addr = ":0"
+	}
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DHT address: %w", err)
+	}
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	ctx, cancel := context.WithCancel(context.Background())
+	n := &Node{
+		id:      id,
+		cfg:     cfg,
+		conn:    conn,
+		table:   newTable(id),
+		tokens:  newTokens(now),
+		peers:   newPeerStore(),
+		now:     time.Now,
+		pending: make(map[string]*pendingQuery),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+	// nodes saved under another ID would be in the wrong buckets
+	if st != nil && st.id == id {
+		for _, ni := range st.nodes {
+			// never seen, as far as this run goes, so they get checked on
+			n.table.seen(ni, time.Time{})
+		}
+	}
+
+	n.wg.Add(2)
+	go n.readLoop()
+	go n.maintain()
+	return n, nil
+}
+
+// ID returns the node's ID.
+func (n *Node) ID() ID {
+	return n.id
+}
+
+// Addr returns the address the node listens on.
+func (n *Node) Addr() netip.AddrPort {
+	ap := n.conn.LocalAddr().(*net.UDPAddr).AddrPort()
+	return netip.AddrPortFrom(ap.Addr().Unmap(), ap.Port())
+}
+
+// Nodes returns the nodes in the routing table.
+func (n *Node) Nodes() []NodeInfo {
+	return n.table.nodes()
+}
+
+// Close stops the node, and saves its routing table to Config.StateFile.
+func (n *Node) Close() error {
+
+	var err error
+	n.closeOnce.Do(func() {
+		n.cancel()
+		n.conn.Close()
+		n.wg.Wait()
+		if n.cfg.StateFile != "" {
+			err = n.saveState(n.cfg.StateFile)
+		}
+	})
+	return err
+}
+
+// Bootstrap joins the DHT: the bootstrap nodes, and those in the routing
+// table already, are asked for the nodes closest to our own ID, which fills
+// the table with our neighbours. It fails if no node answered.
+func (n *Node) Bootstrap(ctx context.Context) error {
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var seeds []NodeInfo
+	for _, hostPort := range n.cfg.Bootstrap {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			addrs, err := resolve(ctx, hostPort)
+			if err != nil {
+				return
+			}
+			for _, addr := range addrs {
+				r, err := n.query(ctx, NodeInfo{Addr: addr}, "find_node", msgArgs{Target: string(n.id[:])})
+				if err != nil {
+					continue
+				}
+				mu.Lock()
+				seeds = append(seeds, returnedNodes(r)...)
+				mu.Unlock()
+				return
+			}
+		}()
+	}
+	wg.Wait()
+
+	if _, err := n.lookup(ctx, n.id, "find_node", seeds); err != nil && n.table.len() == 0 {
+		return fmt.Errorf("failed to join the DHT: %w", err)
+	}
+	if n.table.len() == 0 {
+		return errors.New("failed to join the DHT: no node answered")
+	}
+	return nil
+}
+
+// resolve looks up the addresses of a host:port
+func resolve(ctx context.Context, hostPort string) ([]netip.AddrPort, error) {
+
+	host, portStr, err := net.SplitHostPort(hostPort)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port in %q", hostPort)
+	}
+	ips, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return nil, err
+	}
+	addrs := make([]netip.AddrPort, 0, len(ips))
+	for _, ip := range ips {
+		addrs = append(addrs, netip.AddrPortFrom(ip.Unmap(), uint16(port)))
+	}
+	return addrs, nil
+}
+
+// Ping checks that the node at addr is up, and returns its ID.
+func (n *Node) Ping(ctx context.Context, addr netip.AddrPort) (ID, error) {
+
+	r, err := n.query(ctx, NodeInfo{Addr: addr}, "ping", msgArgs{})
+	if err != nil {
+		return ID{}, err
+	}
+	return ID([]byte(r.ID)), nil
+}
+
+// FindNode looks up the nodes closest to target on the DHT, closest first.
+func (n *Node) FindNode(ctx context.Context, target ID) ([]NodeInfo, error) {
+
+	if err := n.join(ctx); err != nil {
+		return nil, err
+	}
+	res, err := n.lookup(ctx, target, "find_node", nil)
+	if err != nil {
+		return nil, err
+	}
+	return res.closest(), nil
+}
+
+// GetPeers looks up the peers of a torrent on the DHT. Finding none isn't an
+// error, just a torrent nobody announced.
+func (n *Node) GetPeers(ctx context.Context, infoHash [20]byte) ([]netip.AddrPort, error) {
+
+	if err := n.join(ctx); err != nil {
+		return nil, err
+	}
+	res, err := n.lookup(ctx, infoHash, "get_peers", nil)
+	if len(res.peers) > 0 {
+		return res.peers, nil // good enough, even if the lookup was cut short
+	}
+	return res.peers, err
+}
+
+// Announce tells the nodes closest to infoHash that we're in its swarm, taking
+// peer connections on port, and returns the peers found on the way. A port of
+// zero has them use the one our queries come from, for peers behind NATs that
+// share it with uTP (BEP 29). It fails if no node took the announce.
+func (n *Node) Announce(ctx context.Context, infoHash [20]byte, port uint16) ([]netip.AddrPort, error) {
+
+	if err := n.join(ctx); err != nil {
+		return nil, err
+	}
+	res, err := n.lookup(ctx, infoHash, "get_peers", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	args := msgArgs{InfoHash: string(infoHash[:]), Port: int(port)}
+	if port == 0 {
+		args.ImpliedPort = 1
+	}
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var errs []error
+	accepted := 0
+	for _, c := range res.answered {
+		if c.token == "" {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a := args
+			a.Token = c.token
+			_, err := n.query(ctx, c.NodeInfo, "announce_peer", a)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, err)
+				return
+			}
+			accepted++
+		}()
+	}
+	wg.Wait()
+
+	if accepted == 0 {
+		return res.peers, fmt.Errorf("no node took the announce: %w", errors.Join(errs...))
+	}
+	return res.peers, nil
+}
+
+// join bootstraps the node if it doesn't know any others yet
+func (n *Node) join(ctx context.Context) error {
+	if n.table.len() > 0 {
+		return nil
+	}
+	return n.Bootstrap(ctx)
+}
+
+// candidate is a node a lookup came across
+type candidate struct {
+	NodeInfo
+	queried bool
+	done    bool // answered or failed
+	failed  bool
+	// token is what the node gave us for announcing to it, in get_peers
+	// lookups
+	token string
+}
+
+// lookupResult is what a lookup found
+type lookupResult struct {
+	// answered are the closest nodes that answered, closest first
+	answered []*candidate
+	peers    []netip.AddrPort
+}
+
+// closest returns the nodes that answered, closest first
+func (r *lookupResult) closest() []NodeInfo {
+	nodes := make([]NodeInfo, len(r.answered))
+	for i, c := range r.answered {
+		nodes[i] = c.NodeInfo
+	}
+	return nodes
+}
+
+// lookup walks the DHT towards target, the Kademlia way: the closest nodes
+// known are asked for nodes closer still, alpha at a time, until the
+// bucketSize closest have all answered or failed. method is find_node or
+// get_peers, and for the latter the peers handed out along the way and the
+// tokens of the nodes are kept too. seeds are tried on top of the routing
+// table.
+func (n *Node) lookup(ctx context.Context, target ID, method string, seeds []NodeInfo) (*lookupResult, error) {
+
+	res := &lookupResult{}
+	cands := make(map[ID]*candidate)
+	var order []*candidate
+	add := func(ni NodeInfo) {
+		if ni.ID == n.id || !ni.Addr.IsValid() || ni.Addr.Port() == 0 || cands[ni.ID] != nil {
+			return
+		}
+		c := &candidate{NodeInfo: ni}
+		cands[ni.ID] = c
+		order = append(order, c)
+	}
+	for _, ni := range seeds {
+		add(ni)
+	}
+	for _, ni := range n.table.closest(target, bucketSize) {
+		add(ni)
+	}
+	if len(order) == 0 {
+		return res, errors.New("no nodes to start the lookup from")
+	}
+
+	args := msgArgs{Target: string(target[:])}
+	if method == "get_peers" {
+		args = msgArgs{InfoHash: string(target[:])}
+	}
+
+	type answer struct {
+		c   *candidate
+		r   *msgReturn
+		err error
+	}
+	// never more than alpha in flight, so none of them blocks if we stop
+	// early
+	answers := make(chan answer, alpha)
+	inFlight := 0
+	seenPeers := make(map[netip.AddrPort]bool)
+
+	for {
+		sortByDistance(target, order)
+		// the bucketSize closest nodes that didn't fail are the ones
+		// that matter
+		considered := 0
+		for _, c := range order {
+			if considered == bucketSize {
+				break
+			}
+			if c.failed {
+				continue
+			}
+			considered++
+			if !c.queried && inFlight < alpha {
+				c.queried = true
+				inFlight++
+				go func() {
+					r, err := n.query(ctx, c.NodeInfo, method, args)
+					answers <- answer{c, r, err}
+				}()
+			}
+		}
+		if inFlight == 0 {
+			break
+		}
+
+		var a answer
+		select {
+		case a = <-answers:
+		case <-ctx.Done():
+			res.answered = answeredOf(order)
+			return res, ctx.Err()
+		}
+		inFlight--
+		a.c.done = true
+		if a.err != nil {
+			a.c.failed = true
+			continue
+		}
+		a.c.token = a.r.Token
+		for _, ni := range returnedNodes(a.r) {
+			add(ni)
+		}
+		for _, p := range parseValues(a.r.Values) {
+			if !seenPeers[p] {
+				seenPeers[p] = true
+				res.peers = append(res.peers, p)
+			}
+		}
+	}
+
+	res.answered = answeredOf(order)
+	if len(res.answered) == 0 {
+		return res, errors.New("no node answered the lookup")
+	}
+	return res, nil
+}
+
+// answeredOf returns the bucketSize closest candidates that answered, out of
+// candidates sorted by distance
+func answeredOf(order []*candidate) []*candidate {
+	var answered []*candidate
+	for _, c := range order {
+		if c.done && !c.failed {
+			answered = append(answered, c)
+			if len(answered) == bucketSize {
+				break
+			}
+		}
+	}
+	return answered
+}
+
+func sortByDistance(target ID, cands []*candidate) {
+	slices.SortFunc(cands, func(a, b *candidate) int { return target.compare(a.ID, b.ID) })
+}
+
+// returnedNodes decodes the nodes in a response, skipping malformed lists
+func returnedNodes(r *msgReturn) []NodeInfo {
+	nodes, _ := parseCompactNodes([]byte(r.Nodes), 4)
+	nodes6, _ := parseCompactNodes([]byte(r.Nodes6), 16)
+	return append(nodes, nodes6...)
+}
+
+// query sends a query to a node and waits for the answer. to.ID can be zero
+// when it isn't known, for bootstrap nodes; otherwise the node is marked as
+// failing in the routing table if it doesn't answer.
+func (n *Node) query(ctx context.Context, to NodeInfo, method string, args msgArgs) (*msgReturn, error) {
+
+	args.ID = string(n.id[:])
+	t, answer := n.register(to.Addr)
+	defer n.unregister(t)
+
+	packet, err := bencode.Marshal(&msg{T: t, Y: "q", Q: method, A: &args})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := n.conn.WriteToUDPAddrPort(packet, to.Addr); err != nil {
+		return nil, err
+	}
+
+	timer := time.NewTimer(n.cfg.Timeout)
+	defer timer.Stop()
+
+	var m *msg
+	select {
+	case m = <-answer:
+	case <-timer.C:
+		if to.ID != (ID{}) {
+			n.table.failed(to.ID)
+		}
+		return nil, errTimeout
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-n.ctx.Done():
+		return nil, ErrClosed
+	}
+
+	if m.Y == "e" {
+		return nil, m.krpcError()
+	}
+	if m.RO == 0 {
+		n.table.seen(NodeInfo{ID: ID([]byte(m.R.ID)), Addr: to.Addr}, n.now())
+	}
+	return m.R, nil
+}
+
+// register sets up a query to addr, and returns its transaction ID and where
+// its answer will come in
+func (n *Node) register(addr netip.AddrPort) (string, chan *msg) {
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for {
+		n.nextT++
+		t := string(binary.BigEndian.AppendUint16(nil, n.nextT))
+		if n.pending[t] == nil {
+			answer := make(chan *msg, 1)
+			n.pending[t] = &pendingQuery{addr: addr, answer: answer}
+			return t, answer
+		}
+	}
+}
+
+func (n *Node) unregister(t string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.pending, t)
+}
+
+// readLoop handles every packet that comes in, until the node is closed
+func (n *Node) readLoop() {
+
+	defer n.wg.Done()
+
+	buf := make([]byte, 64<<10)
+	for {
+		size, from, err := n.conn.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			if n.ctx.Err() != nil {
+				return
+			}
+			continue // e.g. an ICMP error from an earlier write
+		}
+		from = netip.AddrPortFrom(from.Addr().Unmap(), from.Port())
+
+		m, err := parseMsg(buf[:size])
+		if err != nil {
+			continue // not worth answering, we may not even have a 't'
+		}
+		switch m.Y {
+		case "q":
+			n.handleQuery(m, from)
+		default:
+			n.deliver(m, from)
+		}
+	}
+}
+
+// deliver hands an answer to the query waiting for it. Answers nobody's
+// waiting for, or that come from somewhere else than the query went, are
+// dropped.
+func (n *Node) deliver(m *msg, from netip.AddrPort) {
+
+	n.mu.Lock()
+	p := n.pending[m.T]
+	if p != nil && p.addr == from {
+		delete(n.pending, m.T)
+	} else {
+		p = nil
+	}
+	n.mu.Unlock()
+
+	if p != nil {
+		p.answer <- m
+	}
+}
+
+// handleQuery answers a query from another node
+func (n *Node) handleQuery(m *msg, from netip.AddrPort) {
+
+	now := n.now()
+	if m.RO == 0 {
+		n.table.seen(NodeInfo{ID: ID([]byte(m.A.ID)), Addr: from}, now)
+	}
+
+	r := &msgReturn{ID: string(n.id[:])}
+	switch m.Q {
+	case "ping":
+
+	case "find_node":
+		if len(m.A.Target) != 20 {
+			n.sendError(m.T, from, ErrProtocol, "invalid target")
+			return
+		}
+		r.Nodes, r.Nodes6 = compactNodes(n.table.closest(ID([]byte(m.A.Target)), bucketSize))
+
+	case "get_peers":
+		if len(m.A.InfoHash) != 20 {
+			n.sendError(m.T, from, ErrProtocol, "invalid info_hash")
+			return
+		}
+		infoHash := ID([]byte(m.A.InfoHash))
+		r.Token = n.tokens.token(from.Addr(), now)
+		if peers := n.peers.get(infoHash, now); len(peers) > 0 {
+			for _, p := range peers {
+				r.Values = append(r.Values, string(appendCompactAddr(nil, p)))
+			}
+		} else {
+			r.Nodes, r.Nodes6 = compactNodes(n.table.closest(infoHash, bucketSize))
+		}
+
+	case "announce_peer":
+		if len(m.A.InfoHash) != 20 {
+			n.sendError(m.T, from, ErrProtocol, "invalid info_hash")
+			return
+		}
+		if !n.tokens.valid(m.A.Token, from.Addr(), now) {
+			n.sendError(m.T, from, ErrProtocol, "invalid token")
+			return
+		}
+		port := m.A.Port
+		if m.A.ImpliedPort != 0 {
+			port = int(from.Port())
+		}
+		if port <= 0 || port > 65535 {
+			n.sendError(m.T, from, ErrProtocol, "invalid port")
+			return
+		}
+		n.peers.add(ID([]byte(m.A.InfoHash)), netip.AddrPortFrom(from.Addr(), uint16(port)), now)
+
+	default:
+		n.sendError(m.T, from, ErrMethod, "method unknown")
+		return
+	}
+	n.send(from, &msg{T: m.T, Y: "r", R: r})
+}
+
+func (n *Node) sendError(t string, to netip.AddrPort, code int, message string) {
+	n.send(to, &msg{T: t, Y: "e", E: []interface{}{code, message}})
+}
+
+func (n *Node) send(to netip.AddrPort, m *msg) {
+	packet, err := bencode.Marshal(m)
+	if err != nil {
+		return
+	}
+	n.conn.WriteToUDPAddrPort(packet, to)
+}
+
+// maintain looks after the routing table and the peer store every
+// refreshInterval, until the node is closed
+func (n *Node) maintain() {
+
+	defer n.wg.Done()
+
+	ticker := time.NewTicker(refreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-n.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		ctx, cancel := context.WithTimeout(n.ctx, refreshInterval/2)
+		n.refresh(ctx)
+		cancel()
+		n.peers.sweep(n.now())
+		if n.cfg.StateFile != "" {
+			n.saveState(n.cfg.StateFile)
+		}
+	}
+}
+
+// refresh pings the nodes that have gone quiet, which drops the ones that are
+// gone, and joins the DHT again if none are left
+func (n *Node) refresh(ctx context.Context) {
+
+	var wg sync.WaitGroup
+	for _, ni := range n.table.stale(n.now().Add(-staleAfter)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			n.query(ctx, ni, "ping", msgArgs{})
+		}()
+	}
+	wg.Wait()
+
+	if n.table.len() == 0 {
+		n.Bootstrap(ctx)
+	}
+}
+
+// state is what's saved to Config.StateFile: the node's ID, and the nodes of
+// its routing table in their compact form.
+type state struct {
+	ID     string `bencode:"id"`
+	Nodes  string `bencode:"nodes"`
+	Nodes6 string `bencode:"nodes6,omitempty"`
+}
+
+// loadedState is state, decoded
+type loadedState struct {
+	id    ID
+	nodes []NodeInfo
+}
+
+// saveState writes the node's ID and routing table to path, going through a
+// temporary file so that a crash can't leave half of it behind
+func (n *Node) saveState(path string) error {
+
+	st := state{ID: string(n.id[:])}
+	st.Nodes, st.Nodes6 = compactNodes(n.table.nodes())
+	data, err := bencode.Marshal(st)
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("failed to save DHT state: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to save DHT state: %w", err)
+	}
+	return nil
+}
+
+// loadState reads what saveState wrote. There being no file yet isn't an
+// error, and gets a nil state.
+func loadState(path string) (*loadedState, error) {
+
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read DHT state: %w", err)
+	}
+
+	var st state
+	if err := bencode.Unmarshal(data, &st); err != nil {
+		return nil, fmt.Errorf("invalid DHT state in %s: %w", path, err)
+	}
+	if len(st.ID) != 20 {
+		return nil, fmt.Errorf("invalid DHT state in %s: bad node ID", path)
+	}
+	nodes, err := parseCompactNodes([]byte(st.Nodes), 4)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DHT state in %s: %w", path, err)
+	}
+	nodes6, err := parseCompactNodes([]byte(st.Nodes6), 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DHT state in %s: %w", path, err)
+	}
+	return &loadedState{id: ID([]byte(st.ID)), nodes: append(nodes, nodes6...)}, nil
+}
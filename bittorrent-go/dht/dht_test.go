@@ -0,0 +1,334 @@
+package dht
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/lourencovales/codecrafters/bittorrent-go/bencode"
+)
+
+// listenTest opens a node on the loopback interface, closed at the end of the
+// test
+func listenTest(t *testing.T, cfg Config) *Node {
+	t.Helper()
+
+	cfg.Addr = "127.0.0.1:0"
+	if cfg.Bootstrap == nil {
+		cfg.Bootstrap = []string{}
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 500 * time.Millisecond
+	}
+	n, err := Listen(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(func() { n.Close() })
+	return n
+}
+
+// newTestNetwork starts size nodes that all join the DHT through the first
+func newTestNetwork(t *testing.T, size int) []*Node {
+	t.Helper()
+
+	nodes := []*Node{listenTest(t, Config{})}
+	bootstrap := []string{nodes[0].Addr().String()}
+	for range size - 1 {
+		n := listenTest(t, Config{Bootstrap: bootstrap})
+		if err := n.Bootstrap(context.Background()); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		nodes = append(nodes, n)
+	}
+	return nodes
+}
+
+func TestNetwork(t *testing.T) {
+	nodes := newTestNetwork(t, 10)
+	ctx := context.Background()
+	infoHash := [20]byte(RandomID())
+
+	if peers, err := nodes[7].GetPeers(ctx, infoHash); err != nil || len(peers) != 0 {
+		t.Fatalf("expected no peers before the announce, got %v, %v", peers, err)
+	}
+
+	if _, err := nodes[3].Announce(ctx, infoHash, 6881); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := nodes[5].Announce(ctx, infoHash, 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	peers, err := nodes[7].GetPeers(ctx, infoHash)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []netip.AddrPort{
+		netip.MustParseAddrPort("127.0.0.1:6881"),
+		nodes[5].Addr(), // the implied port is the one the queries came from
+	}
+	for _, p := range expected {
+		if !slices.Contains(peers, p) {
+			t.Errorf("expected %v among the peers, got %v", p, peers)
+		}
+	}
+
+	target := nodes[8].ID()
+	found, err := nodes[2].FindNode(ctx, target)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(found) == 0 || found[0].ID != target || found[0].Addr != nodes[8].Addr() {
+		t.Errorf("expected %v first, got %v", nodes[8].Addr(), found)
+	}
+}
+
+func TestPing(t *testing.T) {
+	a := listenTest(t, Config{})
+	b := listenTest(t, Config{})
+
+	id, err := a.Ping(context.Background(), b.Addr())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if id != b.ID() {
+		t.Errorf("expected ID %v, got %v", b.ID(), id)
+	}
+
+	// both ends now know each other
+	if nodes := a.Nodes(); len(nodes) != 1 || nodes[0].ID != b.ID() {
+		t.Errorf("expected %v in the routing table, got %v", b.ID(), nodes)
+	}
+	if nodes := b.Nodes(); len(nodes) != 1 || nodes[0].ID != a.ID() {
+		t.Errorf("expected %v in the routing table, got %v", a.ID(), nodes)
+	}
+}
+
+func TestQueryErrors(t *testing.T) {
+	a := listenTest(t, Config{})
+	b := listenTest(t, Config{})
+	ctx := context.Background()
+	to := NodeInfo{Addr: b.Addr()}
+	infoHash := RandomID()
+
+	tests := []struct {
+		name   string
+		method string
+		args   msgArgs
+		code   int
+	}{
+		{"unknown method", "vote", msgArgs{}, ErrMethod},
+		{"bad target", "find_node", msgArgs{Target: "short"}, ErrProtocol},
+		{"bad info hash", "get_peers", msgArgs{InfoHash: "short"}, ErrProtocol},
+		{"bad token", "announce_peer", msgArgs{InfoHash: string(infoHash[:]), Port: 1, Token: "nope"}, ErrProtocol},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := a.query(ctx, to, tt.method, tt.args)
+			var krpcErr *KRPCError
+			if !errors.As(err, &krpcErr) || krpcErr.Code != tt.code {
+				t.Errorf("expected error %d, got %v", tt.code, err)
+			}
+		})
+	}
+
+	// a token from get_peers is good for announcing
+	r, err := a.query(ctx, to, "get_peers", msgArgs{InfoHash: string(infoHash[:])})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := a.query(ctx, to, "announce_peer", msgArgs{InfoHash: string(infoHash[:]), Port: 1, Token: r.Token}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	r, err = a.query(ctx, to, "get_peers", msgArgs{InfoHash: string(infoHash[:])})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if peers := parseValues(r.Values); len(peers) != 1 || peers[0] != netip.MustParseAddrPort("127.0.0.1:1") {
+		t.Errorf("expected the announced peer, got %v", peers)
+	}
+}
+
+func TestQueryTimeout(t *testing.T) {
+	a := listenTest(t, Config{Timeout: 100 * time.Millisecond})
+
+	// a socket that never answers
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer conn.Close()
+	silent := NodeInfo{ID: ID{1}, Addr: conn.LocalAddr().(*net.UDPAddr).AddrPort()}
+	a.table.seen(silent, time.Now())
+
+	for range maxFailures {
+		if _, err := a.query(context.Background(), silent, "ping", msgArgs{}); !errors.Is(err, errTimeout) {
+			t.Fatalf("expected a timeout, got %v", err)
+		}
+	}
+	if a.table.len() != 0 {
+		t.Error("expected the silent node to be dropped")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := a.query(ctx, silent, "ping", msgArgs{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the query to be cancelled, got %v", err)
+	}
+
+	a.Close()
+	if _, err := a.Ping(context.Background(), silent.Addr); err == nil {
+		t.Error("expected error on a closed node")
+	}
+}
+
+func TestAnswerFromElsewhere(t *testing.T) {
+	a := listenTest(t, Config{Timeout: 200 * time.Millisecond})
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer conn.Close()
+	other, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer other.Close()
+
+	// the answer comes back from another socket than the query went to
+	go func() {
+		buf := make([]byte, 1500)
+		size, from, err := conn.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			return
+		}
+		m, err := parseMsg(buf[:size])
+		if err != nil {
+			return
+		}
+		id := RandomID()
+		packet, _ := bencode.Marshal(&msg{T: m.T, Y: "r", R: &msgReturn{ID: string(id[:])}})
+		other.WriteToUDPAddrPort(packet, from)
+	}()
+
+	if _, err := a.Ping(context.Background(), conn.LocalAddr().(*net.UDPAddr).AddrPort()); !errors.Is(err, errTimeout) {
+		t.Errorf("expected the answer to be dropped, got %v", err)
+	}
+}
+
+func TestBootstrapFails(t *testing.T) {
+	tests := []struct {
+		name      string
+		bootstrap []string
+	}{
+		{"no bootstrap nodes", []string{}},
+		{"nobody home", []string{"127.0.0.1:1"}},
+		{"bad address", []string{"nowhere"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := listenTest(t, Config{Bootstrap: tt.bootstrap, Timeout: 100 * time.Millisecond})
+			if err := n.Bootstrap(context.Background()); err == nil {
+				t.Error("expected error")
+			}
+			if _, err := n.GetPeers(context.Background(), RandomID()); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	nodes := newTestNetwork(t, 4)
+	n := nodes[1]
+	gone := nodes[2]
+	gone.Close()
+
+	// everyone has gone quiet
+	n.table.mu.Lock()
+	for _, bucket := range n.table.buckets {
+		for _, tn := range bucket {
+			tn.lastSeen = time.Time{}
+		}
+	}
+	n.table.mu.Unlock()
+
+	for range maxFailures {
+		n.refresh(context.Background())
+	}
+	for _, ni := range n.Nodes() {
+		if ni.ID == gone.ID() {
+			t.Errorf("expected %v to be dropped", gone.ID())
+		}
+	}
+	if len(n.Nodes()) != 2 {
+		t.Errorf("expected the other 2 nodes to be kept, got %v", n.Nodes())
+	}
+}
+
+func TestState(t *testing.T) {
+	nodes := newTestNetwork(t, 4)
+	path := filepath.Join(t.TempDir(), "dht.dat")
+
+	n := listenTest(t, Config{Bootstrap: []string{nodes[0].Addr().String()}, StateFile: path})
+	if err := n.Bootstrap(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	id, known := n.ID(), n.Nodes()
+	if err := n.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// no bootstrap nodes this time, what was saved is enough
+	n = listenTest(t, Config{StateFile: path})
+	if n.ID() != id {
+		t.Errorf("expected ID %v, got %v", id, n.ID())
+	}
+	if got := n.Nodes(); len(got) != len(known) {
+		t.Errorf("expected %d nodes, got %v", len(known), got)
+	}
+	if _, err := n.FindNode(context.Background(), RandomID()); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// a different ID doesn't get the saved nodes, they'd be in the wrong
+	// buckets
+	other := listenTest(t, Config{ID: RandomID(), StateFile: path})
+	if got := other.Nodes(); len(got) != 0 {
+		t.Errorf("expected no nodes, got %v", got)
+	}
+}
+
+func TestStateInvalid(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"not bencode", "garbage"},
+		{"bad id", "d2:id3:abc5:nodes0:e"},
+		{"truncated nodes", "d2:id20:aaaaaaaaaaaaaaaaaaaa5:nodes3:abce"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.name)
+			if err := os.WriteFile(path, []byte(tt.data), 0o644); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if _, err := Listen(Config{Addr: "127.0.0.1:0", StateFile: path}); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
@@ -0,0 +1,190 @@
+package dht
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/netip"
+
+	"github.com/lourencovales/codecrafters/bittorrent-go/bencode"
+)
+
+// These are the error codes KRPC defines.
+const (
+	ErrGeneric  = 201
+	ErrServer   = 202
+	ErrProtocol = 203
+	ErrMethod   = 204
+)
+
+// KRPCError is the error a node answered a query with.
+type KRPCError struct {
+	Code    int
+	Message string
+}
+
+func (e *KRPCError) Error() string {
+	return fmt.Sprintf("dht error %d: %s", e.Code, e.Message)
+}
+
+// msg is a KRPC message (BEP 5), a bencoded dictionary sent in a single UDP
+// packet. Y says what it is: "q" for a query, with its method in Q and its
+// arguments in A, "r" for a response, in R, and "e" for an error, in E. T is
+// picked by whoever sends the query and echoed back in the answer.
+type msg struct {
+	T string        `bencode:"t"`
+	Y string        `bencode:"y"`
+	Q string        `bencode:"q,omitempty"`
+	A *msgArgs      `bencode:"a,omitempty"`
+	R *msgReturn    `bencode:"r,omitempty"`
+	E []interface{} `bencode:"e,omitempty"`
+	// RO is set by read-only nodes (BEP 43), which shouldn't be queried
+	RO int `bencode:"ro,omitempty"`
+}
+
+// msgArgs are the arguments of a query. Which are set depends on the method.
+type msgArgs struct {
+	ID          string `bencode:"id"`
+	Target      string `bencode:"target,omitempty"`
+	InfoHash    string `bencode:"info_hash,omitempty"`
+	Port        int    `bencode:"port,omitempty"`
+	ImpliedPort int    `bencode:"implied_port,omitempty"`
+	Token       string `bencode:"token,omitempty"`
+}
+
+// msgReturn is the body of a response. Nodes holds IPv4 nodes and Nodes6
+// IPv6 ones (BEP 32), both in their compact form, and Values the compact
+// addresses of peers.
+type msgReturn struct {
+	ID     string   `bencode:"id"`
+	Nodes  string   `bencode:"nodes,omitempty"`
+	Nodes6 string   `bencode:"nodes6,omitempty"`
+	Token  string   `bencode:"token,omitempty"`
+	Values []string `bencode:"values,omitempty"`
+}
+
+// msgLimits bounds what we're ready to decode from a single packet, which
+// can't be any bigger than a UDP datagram anyway.
+var msgLimits = bencode.DecodeOptions{
+	MaxDepth:        4,
+	MaxStringLength: 8 << 10,
+	MaxElements:     256,
+	MaxInputSize:    64 << 10,
+}
+
+// parseMsg decodes a packet, checking it's one of the three kinds of
+// message and has what that kind needs.
+func parseMsg(packet []byte) (*msg, error) {
+
+	var m msg
+	if err := msgLimits.Unmarshal(packet, &m); err != nil {
+		return nil, fmt.Errorf("malformed message: %w", err)
+	}
+
+	switch m.Y {
+	case "q":
+		if m.Q == "" || m.A == nil || len(m.A.ID) != 20 {
+			return nil, errors.New("query without a method or a node ID")
+		}
+	case "r":
+		if m.R == nil || len(m.R.ID) != 20 {
+			return nil, errors.New("response without a node ID")
+		}
+	case "e":
+	default:
+		return nil, fmt.Errorf("unknown message type %q", m.Y)
+	}
+	return &m, nil
+}
+
+// krpcError turns the 'e' list of an error message into an error
+func (m *msg) krpcError() error {
+
+	e := &KRPCError{Code: ErrGeneric, Message: "unknown error"}
+	if len(m.E) > 0 {
+		if code, ok := m.E[0].(int); ok {
+			e.Code = code
+		}
+	}
+	if len(m.E) > 1 {
+		if message, ok := m.E[1].(string); ok {
+			e.Message = message
+		}
+	}
+	return e
+}
+
+// NodeInfo is a node of the DHT, as passed around in find_node and get_peers
+// responses.
+type NodeInfo struct {
+	ID   ID
+	Addr netip.AddrPort
+}
+
+// compactNodes encodes nodes in their compact form, the ID followed by the
+// address and the port in network byte order. IPv4 nodes take up 26 bytes
+// and go in the first string, IPv6 ones 38 bytes and go in the second.
+func compactNodes(nodes []NodeInfo) (string, string) {
+
+	var nodes4, nodes6 []byte
+	for _, n := range nodes {
+		if n.Addr.Addr().Is4() {
+			nodes4 = appendCompactNode(nodes4, n)
+		} else {
+			nodes6 = appendCompactNode(nodes6, n)
+		}
+	}
+	return string(nodes4), string(nodes6)
+}
+
+func appendCompactNode(b []byte, n NodeInfo) []byte {
+	b = append(b, n.ID[:]...)
+	return appendCompactAddr(b, n.Addr)
+}
+
+// parseCompactNodes decodes the compact form of a node list, addrLen being
+// 4 for 'nodes' and 16 for 'nodes6'
+func parseCompactNodes(b []byte, addrLen int) ([]NodeInfo, error) {
+
+	size := 20 + addrLen + 2
+	if len(b)%size != 0 {
+		return nil, fmt.Errorf("compact node list of %d bytes isn't a multiple of %d", len(b), size)
+	}
+
+	nodes := make([]NodeInfo, 0, len(b)/size)
+	for i := 0; i < len(b); i += size {
+		var n NodeInfo
+		copy(n.ID[:], b[i:i+20])
+		n.Addr = parseCompactAddr(b[i+20 : i+size])
+		nodes = append(nodes, n)
+	}
+	return nodes, nil
+}
+
+// appendCompactAddr appends the compact form of a peer address, the address
+// followed by the port in network byte order
+func appendCompactAddr(b []byte, addr netip.AddrPort) []byte {
+	b = append(b, addr.Addr().AsSlice()...)
+	return binary.BigEndian.AppendUint16(b, addr.Port())
+}
+
+// parseCompactAddr decodes a compact peer address of either family. b has to
+// be 6 or 18 bytes long.
+func parseCompactAddr(b []byte) netip.AddrPort {
+	ip, _ := netip.AddrFromSlice(b[:len(b)-2])
+	return netip.AddrPortFrom(ip.Unmap(), binary.BigEndian.Uint16(b[len(b)-2:]))
+}
+
+// parseValues decodes the peers of a get_peers response, skipping anything
+// that isn't a compact address
+func parseValues(values []string) []netip.AddrPort {
+
+	peers := make([]netip.AddrPort, 0, len(values))
+	for _, v := range values {
+		if len(v) != 6 && len(v) != 18 {
+			continue
+		}
+		peers = append(peers, parseCompactAddr([]byte(v)))
+	}
+	return peers
+}
@@ -0,0 +1,130 @@
+package dht
+
+import (
+	"errors"
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/lourencovales/codecrafters/bittorrent-go/bencode"
+)
+
+func TestParseMsg(t *testing.T) {
+	id := strings.Repeat("a", 20)
+	tests := []struct {
+		name   string
+		packet string
+		err    bool
+	}{
+		{"ping query", "d1:ad2:id20:" + id + "e1:q4:ping1:t2:aa1:y1:qe", false},
+		{"response", "d1:rd2:id20:" + id + "e1:t2:aa1:y1:re", false},
+		{"error", "d1:eli201e7:Generice1:t2:aa1:y1:ee", false},
+		{"unknown keys", "d1:rd2:id20:" + id + "5:extrai1ee1:t2:aa1:v4:LT011:y1:re", false},
+		{"not bencode", "hello", true},
+		{"unknown type", "d1:t2:aa1:y1:xe", true},
+		{"query without method", "d1:ad2:id20:" + id + "e1:t2:aa1:y1:qe", true},
+		{"query without id", "d1:ade1:q4:ping1:t2:aa1:y1:qe", true},
+		{"short id", "d1:rd2:id3:abce1:t2:aa1:y1:re", true},
+		{"too deep", "d1:rd2:id20:" + id + "1:xllllleeeeee1:t2:aa1:y1:re", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseMsg([]byte(tt.packet))
+			if tt.err && err == nil {
+				t.Error("expected error")
+			} else if !tt.err && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestMsgRoundTrip(t *testing.T) {
+	m := &msg{T: "xy", Y: "q", Q: "announce_peer", A: &msgArgs{
+		ID:          strings.Repeat("b", 20),
+		InfoHash:    strings.Repeat("c", 20),
+		ImpliedPort: 1,
+		Token:       "tok",
+	}}
+	packet, err := bencode.Marshal(m)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got, err := parseMsg(packet)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(got, m) {
+		t.Errorf("expected %+v, got %+v", m, got)
+	}
+}
+
+func TestKRPCError(t *testing.T) {
+	tests := []struct {
+		name     string
+		e        []interface{}
+		expected KRPCError
+	}{
+		{"full", []interface{}{ErrMethod, "Method Unknown"}, KRPCError{ErrMethod, "Method Unknown"}},
+		{"code only", []interface{}{ErrServer}, KRPCError{ErrServer, "unknown error"}},
+		{"empty", nil, KRPCError{ErrGeneric, "unknown error"}},
+		{"wrong types", []interface{}{"oops", 12}, KRPCError{ErrGeneric, "unknown error"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := (&msg{Y: "e", E: tt.e}).krpcError()
+			var krpcErr *KRPCError
+			if !errors.As(err, &krpcErr) || *krpcErr != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+func TestCompactNodes(t *testing.T) {
+	nodes := []NodeInfo{
+		{ID: ID{1}, Addr: netip.MustParseAddrPort("10.0.0.1:6881")},
+		{ID: ID{2}, Addr: netip.MustParseAddrPort("[2001:db8::2]:6882")},
+		{ID: ID{3}, Addr: netip.MustParseAddrPort("10.0.0.3:6883")},
+	}
+	nodes4, nodes6 := compactNodes(nodes)
+	if len(nodes4) != 52 || len(nodes6) != 38 {
+		t.Fatalf("expected 52 and 38 bytes, got %d and %d", len(nodes4), len(nodes6))
+	}
+
+	got4, err := parseCompactNodes([]byte(nodes4), 4)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got6, err := parseCompactNodes([]byte(nodes6), 16)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := []NodeInfo{nodes[0], nodes[2], nodes[1]}; !reflect.DeepEqual(append(got4, got6...), expected) {
+		t.Errorf("expected %v, got %v", expected, append(got4, got6...))
+	}
+
+	if _, err := parseCompactNodes(make([]byte, 27), 4); err == nil {
+		t.Error("expected error for a truncated node list")
+	}
+}
+
+func TestParseValues(t *testing.T) {
+	values := []string{
+		"\x0a\x00\x00\x01\x1a\xe1",
+		"bad",
+		string(appendCompactAddr(nil, netip.MustParseAddrPort("[::1]:80"))),
+		string(appendCompactAddr(nil, netip.MustParseAddrPort("[::ffff:10.0.0.2]:81"))),
+	}
+	expected := []netip.AddrPort{
+		netip.MustParseAddrPort("10.0.0.1:6881"),
+		netip.MustParseAddrPort("[::1]:80"),
+		netip.MustParseAddrPort("10.0.0.2:81"),
+	}
+	if got := parseValues(values); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
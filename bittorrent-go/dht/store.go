@@ -0,0 +1,170 @@
+package dht
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	mathrand "math/rand/v2"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// tokenRotation is how often the secret behind tokens changes. Tokens made
+// with the one before are still accepted, so a token lasts between one and
+// two rotations.
+const tokenRotation = 5 * time.Minute
+
+// peerTTL is how long an announced peer is kept without announcing again.
+const peerTTL = 30 * time.Minute
+
+// maxInfoHashes is how many torrents the peer store keeps peers for, so that
+// announces can't make it grow without bounds.
+const maxInfoHashes = 10000
+
+// sweepInterval is how often at most a full peer store is swept to make
+// room for a new torrent.
+const sweepInterval = time.Minute
+
+// maxValues is how many peers a get_peers response hands out at most, which
+// keeps it within a single UDP packet.
+const maxValues = 50
+
+// tokens hands out the tokens get_peers responses come with, and checks the
+// ones announce_peer queries bring back. A token is tied to the address it was
+// given to, so that a node can only announce itself.
+type tokens struct {
+	mu      sync.Mutex
+	secret  [16]byte
+	prev    [16]byte
+	rotated time.Time
+}
+
+func newTokens(now time.Time) *tokens {
+	t := &tokens{rotated: now}
+	rand.Read(t.secret[:])
+	t.prev = t.secret
+	return t
+}
+
+// token returns the token for ip
+func (t *tokens) token(ip netip.Addr, now time.Time) string {
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rotate(now)
+	return makeToken(t.secret, ip)
+}
+
+// valid reports whether token was handed out to ip, and is still good
+func (t *tokens) valid(token string, ip netip.Addr, now time.Time) bool {
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rotate(now)
+	return token == makeToken(t.secret, ip) || token == makeToken(t.prev, ip)
+}
+
+// rotate picks a new secret if the current one is old enough. t.mu is held.
+func (t *tokens) rotate(now time.Time) {
+
+	age := now.Sub(t.rotated)
+	if age < tokenRotation {
+		return
+	}
+	t.prev = t.secret
+	rand.Read(t.secret[:])
+	if age >= 2*tokenRotation {
+		t.prev = t.secret // what was handed out before is too old by now
+	}
+	t.rotated = now
+}
+
+func makeToken(secret [16]byte, ip netip.Addr) string {
+	h := sha1.Sum(append(secret[:], ip.Unmap().AsSlice()...))
+	return string(h[:8])
+}
+
+// peerStore holds the peers announced to us, per info hash, until they
+// expire. It's safe to use from several goroutines.
+type peerStore struct {
+	mu    sync.Mutex
+	peers map[ID]map[netip.AddrPort]time.Time
+	// swept is when expired peers were last cleared out of every info hash
+	swept time.Time
+}
+
+func newPeerStore() *peerStore {
+	return &peerStore{peers: make(map[ID]map[netip.AddrPort]time.Time)}
+}
+
+// add records that peer announced itself for infoHash
+func (s *peerStore) add(infoHash ID, peer netip.AddrPort, now time.Time) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	peers := s.peers[infoHash]
+	if peers == nil {
+		// a full store makes room by sweeping, though not more often than
+		// every sweepInterval, or a flood of announces would have us do it
+		// for every single one
+		if len(s.peers) >= maxInfoHashes && now.Sub(s.swept) >= sweepInterval {
+			s.expire(now)
+		}
+		if len(s.peers) >= maxInfoHashes {
+			return
+		}
+		peers = make(map[netip.AddrPort]time.Time)
+		s.peers[infoHash] = peers
+	}
+	peers[peer] = now
+}
+
+// get returns a random pick of up to maxValues peers for infoHash, dropping
+// the ones that expired along the way
+func (s *peerStore) get(infoHash ID, now time.Time) []netip.AddrPort {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var found []netip.AddrPort
+	for peer, announced := range s.peers[infoHash] {
+		if now.Sub(announced) >= peerTTL {
+			delete(s.peers[infoHash], peer)
+			continue
+		}
+		found = append(found, peer)
+	}
+	if len(s.peers[infoHash]) == 0 {
+		delete(s.peers, infoHash)
+	}
+
+	mathrand.Shuffle(len(found), func(a, b int) { found[a], found[b] = found[b], found[a] })
+	return found[:min(len(found), maxValues)]
+}
+
+// sweep drops the peers that expired, whatever their info hash, along with
+// the info hashes left without any
+func (s *peerStore) sweep(now time.Time) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.expire(now)
+}
+
+// expire does the work of sweep. s.mu is held.
+func (s *peerStore) expire(now time.Time) {
+
+	for infoHash, peers := range s.peers {
+		for peer, announced := range peers {
+			if now.Sub(announced) >= peerTTL {
+				delete(peers, peer)
+			}
+		}
+		if len(peers) == 0 {
+			delete(s.peers, infoHash)
+		}
+	}
+	s.swept = now
+}
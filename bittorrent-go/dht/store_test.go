@@ -0,0 +1,115 @@
+package dht
+
+import (
+	"net/netip"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestTokens(t *testing.T) {
+	now := time.Now()
+	tok := newTokens(now)
+	ip := netip.MustParseAddr("10.0.0.1")
+
+	given := tok.token(ip, now)
+	tests := []struct {
+		name     string
+		token    string
+		ip       netip.Addr
+		at       time.Time
+		expected bool
+	}{
+		{"right away", given, ip, now, true},
+		{"mapped address", given, netip.MustParseAddr("::ffff:10.0.0.1"), now, true},
+		{"another address", given, netip.MustParseAddr("10.0.0.2"), now, false},
+		{"made up", "12345678", ip, now, false},
+		{"after a rotation", given, ip, now.Add(tokenRotation), true},
+		{"after two rotations", given, ip, now.Add(2 * tokenRotation), false},
+	}
+
+	// each case gets tokens of its own, so that rotations don't carry over
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tok2 := &tokens{secret: tok.secret, prev: tok.prev, rotated: tok.rotated}
+			if got := tok2.valid(tt.token, tt.ip, tt.at); got != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+
+	// a token handed out after a rotation is still good after the next one
+	later := tok.token(ip, now.Add(tokenRotation))
+	if later == given {
+		t.Error("expected a new token after the rotation")
+	}
+	if !tok.valid(later, ip, now.Add(2*tokenRotation)) {
+		t.Error("expected the newer token to still be good")
+	}
+}
+
+func TestPeerStore(t *testing.T) {
+	now := time.Now()
+	s := newPeerStore()
+	hash := ID{1}
+
+	old := netip.MustParseAddrPort("10.0.0.1:1")
+	s.add(hash, old, now.Add(-peerTTL))
+	for i := range maxValues + 10 {
+		s.add(hash, netip.MustParseAddrPort("10.0.1."+strconv.Itoa(i)+":6881"), now)
+	}
+
+	peers := s.get(hash, now)
+	if len(peers) != maxValues {
+		t.Errorf("expected %d peers, got %d", maxValues, len(peers))
+	}
+	for _, p := range peers {
+		if p == old {
+			t.Error("expected the expired peer to be left out")
+		}
+	}
+	if got := len(s.peers[hash]); got != maxValues+10 {
+		t.Errorf("expected the expired peer to be dropped, %d left", got)
+	}
+
+	if peers := s.get(hash, now.Add(peerTTL)); len(peers) != 0 {
+		t.Errorf("expected every peer to have expired, got %v", peers)
+	}
+	if _, ok := s.peers[hash]; ok {
+		t.Error("expected the empty torrent to be dropped")
+	}
+	if peers := s.get(ID{2}, now); len(peers) != 0 {
+		t.Errorf("expected no peers for an unknown torrent, got %v", peers)
+	}
+}
+
+func TestPeerStoreSweep(t *testing.T) {
+	now := time.Now()
+	s := newPeerStore()
+	peer := netip.MustParseAddrPort("10.0.0.1:1")
+
+	for i := range maxInfoHashes {
+		s.add(ID{byte(i), byte(i >> 8)}, peer, now)
+	}
+	fresh := ID{0xff, 0xff}
+	s.add(fresh, peer, now)
+	if _, ok := s.peers[fresh]; ok {
+		t.Fatal("expected a full store to turn a new torrent away")
+	}
+
+	// once the others expired, there's room again
+	later := now.Add(peerTTL)
+	s.add(fresh, peer, later)
+	if peers := s.get(fresh, later); len(peers) != 1 {
+		t.Errorf("expected the new torrent to be taken, got %v", peers)
+	}
+	if len(s.peers) != 1 {
+		t.Errorf("expected the expired torrents to be dropped, %d left", len(s.peers))
+	}
+
+	// and the regular sweep clears out the rest
+	s.sweep(later.Add(peerTTL))
+	if len(s.peers) != 0 {
+		t.Errorf("expected an empty store, %d left", len(s.peers))
+	}
+}
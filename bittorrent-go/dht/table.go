@@ -0,0 +1,202 @@
+package dht
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"math/bits"
+	"slices"
+	"sync"
+	"time"
+)
+
+// bucketSize is K, how many nodes each bucket of the routing table holds.
+const bucketSize = 8
+
+// maxFailures is how many queries in a row a node can leave unanswered before
+// it's dropped from the routing table.
+const maxFailures = 2
+
+// ID is the identifier of a node, and of what's looked up on the DHT: info
+// hashes share the same 160-bit space.
+type ID [20]byte
+
+// RandomID returns a random node ID.
+func RandomID() ID {
+	var id ID
+	rand.Read(id[:])
+	return id
+}
+
+func (id ID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// distance is the XOR metric Kademlia uses
+func (id ID) distance(other ID) ID {
+	var d ID
+	for i := range id {
+		d[i] = id[i] ^ other[i]
+	}
+	return d
+}
+
+// compare orders a and b by their distance to id, the way slices.SortFunc
+// wants it
+func (id ID) compare(a, b ID) int {
+	da, db := id.distance(a), id.distance(b)
+	return bytes.Compare(da[:], db[:])
+}
+
+// prefixLen returns how many leading bits id and other have in common, 160
+// when they're the same
+func (id ID) prefixLen(other ID) int {
+	d := id.distance(other)
+	for i, b := range d {
+		if b != 0 {
+			return i*8 + bits.LeadingZeros8(b)
+		}
+	}
+	return len(d) * 8
+}
+
+// tableNode is a node in the routing table
+type tableNode struct {
+	NodeInfo
+	lastSeen time.Time
+	failures int
+}
+
+// table is the routing table: a bucket for each length of the prefix a node
+// ID shares with ours, the nodes furthest away in bucket 0. Buckets hold up to
+// bucketSize nodes, least recently seen first, and a full bucket only takes a
+// new node in place of one that stopped answering, since nodes that have been
+// around for a while tend to stay. It's safe to use from several goroutines.
+type table struct {
+	self ID
+
+	mu      sync.Mutex
+	buckets [160][]*tableNode
+}
+
+func newTable(self ID) *table {
+	return &table{self: self}
+}
+
+// bucket returns the index of the bucket id goes in, or -1 for our own ID
+func (t *table) bucket(id ID) int {
+	i := t.self.prefixLen(id)
+	if i == len(t.buckets) {
+		return -1
+	}
+	return i
+}
+
+// seen records that the node answered, or sent us a query: it's added to its
+// bucket if there's room, or moved to the back of it if it was there already.
+// It reports whether the node is in the table afterwards.
+func (t *table) seen(n NodeInfo, now time.Time) bool {
+
+	i := t.bucket(n.ID)
+	if i < 0 || !n.Addr.IsValid() {
+		return false
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	bucket := t.buckets[i]
+	if j := slices.IndexFunc(bucket, func(tn *tableNode) bool { return tn.ID == n.ID }); j >= 0 {
+		tn := bucket[j]
+		tn.Addr, tn.lastSeen, tn.failures = n.Addr, now, 0
+		t.buckets[i] = append(slices.Delete(bucket, j, j+1), tn)
+		return true
+	}
+
+	tn := &tableNode{NodeInfo: n, lastSeen: now}
+	if len(bucket) < bucketSize {
+		t.buckets[i] = append(bucket, tn)
+		return true
+	}
+	// a full bucket only makes room by dropping a node that went quiet
+	if j := slices.IndexFunc(bucket, func(tn *tableNode) bool { return tn.failures > 0 }); j >= 0 {
+		t.buckets[i] = append(slices.Delete(bucket, j, j+1), tn)
+		return true
+	}
+	return false
+}
+
+// failed records that a query to the node went unanswered, dropping it once
+// it's done so maxFailures times in a row
+func (t *table) failed(id ID) {
+
+	i := t.bucket(id)
+	if i < 0 {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	bucket := t.buckets[i]
+	if j := slices.IndexFunc(bucket, func(tn *tableNode) bool { return tn.ID == id }); j >= 0 {
+		bucket[j].failures++
+		if bucket[j].failures >= maxFailures {
+			t.buckets[i] = slices.Delete(bucket, j, j+1)
+		}
+	}
+}
+
+// closest returns the n nodes closest to target, closest first
+func (t *table) closest(target ID, n int) []NodeInfo {
+
+	nodes := t.nodes()
+	slices.SortFunc(nodes, func(a, b NodeInfo) int { return target.compare(a.ID, b.ID) })
+	return nodes[:min(n, len(nodes))]
+}
+
+// nodes returns every node in the table
+func (t *table) nodes() []NodeInfo {
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var nodes []NodeInfo
+	for _, bucket := range t.buckets {
+		for _, tn := range bucket {
+			nodes = append(nodes, tn.NodeInfo)
+		}
+	}
+	return nodes
+}
+
+// stale returns the nodes not heard from since before, which are worth
+// pinging to check they're still around
+func (t *table) stale(before time.Time) []NodeInfo {
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var nodes []NodeInfo
+	for _, bucket := range t.buckets {
+		for _, tn := range bucket {
+			if tn.lastSeen.Before(before) {
+				nodes = append(nodes, tn.NodeInfo)
+			}
+		}
+	}
+	return nodes
+}
+
+// len returns how many nodes the table holds
+func (t *table) len() int {
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	n := 0
+	for _, bucket := range t.buckets {
+		n += len(bucket)
+	}
+	return n
+}
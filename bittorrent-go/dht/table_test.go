@@ -0,0 +1,141 @@
+package dht
+
+import (
+	"net/netip"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestPrefixLen(t *testing.T) {
+	tests := []struct {
+		name     string
+		a, b     ID
+		expected int
+	}{
+		{"same", ID{1, 2, 3}, ID{1, 2, 3}, 160},
+		{"first bit", ID{0x80}, ID{}, 0},
+		{"eighth bit", ID{0x01}, ID{}, 7},
+		{"second byte", ID{0xff, 0x10}, ID{0xff, 0x00}, 11},
+		{"last bit", ID{19: 1}, ID{}, 159},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.a.prefixLen(tt.b); got != tt.expected {
+				t.Errorf("expected %d, got %d", tt.expected, got)
+			}
+		})
+	}
+}
+
+// testNode is a node whose ID shares exactly prefix bits with the zero ID,
+// told apart from the others in its bucket by i
+func testNode(prefix, i int) NodeInfo {
+	var id ID
+	id[prefix/8] = 0x80 >> (prefix % 8)
+	id[19] |= byte(i)
+	return NodeInfo{ID: id, Addr: netip.MustParseAddrPort("10.0.0." + strconv.Itoa(i+1) + ":6881")}
+}
+
+func TestTableSeen(t *testing.T) {
+	tab := newTable(ID{})
+	now := time.Now()
+
+	if tab.seen(NodeInfo{ID: ID{}, Addr: netip.MustParseAddrPort("10.0.0.1:1")}, now) {
+		t.Error("expected our own ID to be left out")
+	}
+	if tab.seen(NodeInfo{ID: ID{1}}, now) {
+		t.Error("expected a node without an address to be left out")
+	}
+
+	for i := range bucketSize {
+		if !tab.seen(testNode(3, i), now) {
+			t.Fatalf("expected node %d to fit in the bucket", i)
+		}
+	}
+	if tab.seen(testNode(3, bucketSize), now) {
+		t.Error("expected a full bucket to turn down a new node")
+	}
+	if !tab.seen(testNode(4, bucketSize), now) {
+		t.Error("expected another bucket to have room")
+	}
+
+	// seeing a node again moves it to the back, and updates its address
+	moved := testNode(3, 0)
+	moved.Addr = netip.MustParseAddrPort("10.1.1.1:1")
+	tab.seen(moved, now.Add(time.Minute))
+	bucket := tab.buckets[3]
+	if last := bucket[len(bucket)-1]; last.NodeInfo != moved {
+		t.Errorf("expected %v at the back of the bucket, got %v", moved, last.NodeInfo)
+	}
+
+	// a node that failed makes room for a new one
+	tab.failed(testNode(3, 1).ID)
+	if !tab.seen(testNode(3, bucketSize), now) {
+		t.Error("expected the new node to take the place of the failing one")
+	}
+	for _, tn := range tab.buckets[3] {
+		if tn.ID == testNode(3, 1).ID {
+			t.Error("expected the failing node to be gone")
+		}
+	}
+	if tab.len() != bucketSize+1 {
+		t.Errorf("expected %d nodes, got %d", bucketSize+1, tab.len())
+	}
+}
+
+func TestTableFailed(t *testing.T) {
+	tab := newTable(ID{})
+	n := testNode(10, 0)
+	tab.seen(n, time.Now())
+
+	tab.failed(n.ID)
+	if tab.len() != 1 {
+		t.Fatal("expected a single failure to keep the node")
+	}
+	// answering clears the failures
+	tab.seen(n, time.Now())
+	tab.failed(n.ID)
+	if tab.len() != 1 {
+		t.Fatal("expected the failures to have been cleared")
+	}
+	tab.failed(n.ID)
+	if tab.len() != 0 {
+		t.Errorf("expected the node to be dropped after %d failures in a row", maxFailures)
+	}
+
+	tab.failed(ID{1}) // unknown nodes are ignored
+}
+
+func TestTableClosest(t *testing.T) {
+	tab := newTable(ID{})
+	now := time.Now()
+	for prefix := range 20 {
+		tab.seen(testNode(prefix, 0), now)
+	}
+
+	target := testNode(15, 0).ID
+	got := tab.closest(target, 3)
+	// past the target, the longer the prefix the fewer bits are flipped
+	expected := []NodeInfo{testNode(15, 0), testNode(19, 0), testNode(18, 0)}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	if got := tab.closest(target, 100); len(got) != 20 {
+		t.Errorf("expected every node when asking for more, got %d", len(got))
+	}
+}
+
+func TestTableStale(t *testing.T) {
+	tab := newTable(ID{})
+	now := time.Now()
+	tab.seen(testNode(1, 0), now.Add(-time.Hour))
+	tab.seen(testNode(2, 0), now)
+
+	if got := tab.stale(now.Add(-staleAfter)); !reflect.DeepEqual(got, []NodeInfo{testNode(1, 0)}) {
+		t.Errorf("expected only the old node, got %v", got)
+	}
+}
@@ -0,0 +1,202 @@
+package magnet
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Link holds what a magnet link says about a torrent. Only the info hash is
+// required, everything else is a hint on where to find peers and what to call
+// the download.
+type Link struct {
+	// InfoHash is the v1 info hash from a 'urn:btih' topic, or for links that
+	// only have a v2 one, the truncated v2 hash, which is what v2 swarms go
+	// by.
+	InfoHash [20]byte
+	// InfoHashV2 is the v2 info hash from a 'urn:btmh' topic (BEP 52), if
+	// there's one.
+	InfoHashV2 [32]byte
+	HasV1      bool
+	HasV2      bool
+	// Name is the display name ('dn'), which may well not match the name in
+	// the torrent's metadata.
+	Name string
+	// Trackers are the 'tr' URLs, in the order they appear.
+	Trackers []string
+	// Peers are the 'x.pe' addresses, host:port, of peers to try straight
+	// away.
+	Peers []string
+	// Select is the 'so' list of files to download (BEP 53), by index. All
+	// files are wanted when it's empty.
+	Select []FileRange
+}
+
+// FileRange is an inclusive range of file indexes, as in the 'so' parameter.
+type FileRange struct {
+	First, Last int
+}
+
+// Parse reads a magnet URI of the form magnet:?xt=urn:btih:<hash>&dn=... The
+// v1 hash may be 40 hex digits or 32 base32 characters. Parameters this
+// package doesn't know about are ignored.
+func Parse(uri string) (*Link, error) {
+
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("invalid magnet link: %w", err)
+	}
+	if u.Scheme != "magnet" {
+		return nil, fmt.Errorf("not a magnet link: %q", uri)
+	}
+
+	// magnet links are opaque URIs, so the query ends up in u.Opaque when
+	// there's no '?' right after the scheme
+	query := u.RawQuery
+	if query == "" {
+		query = strings.TrimPrefix(u.Opaque, "?")
+	}
+	params, err := url.ParseQuery(query)
+	if err != nil {
+		return nil, fmt.Errorf("invalid magnet link: %w", err)
+	}
+
+	link := &Link{
+		Name:     params.Get("dn"),
+		Trackers: params["tr"],
+		Peers:    params["x.pe"],
+	}
+
+	for _, xt := range params["xt"] {
+		switch {
+		case strings.HasPrefix(xt, "urn:btih:"):
+			if link.InfoHash, err = parseBTIH(strings.TrimPrefix(xt, "urn:btih:")); err != nil {
+				return nil, err
+			}
+			link.HasV1 = true
+		case strings.HasPrefix(xt, "urn:btmh:"):
+			if link.InfoHashV2, err = parseBTMH(strings.TrimPrefix(xt, "urn:btmh:")); err != nil {
+				return nil, err
+			}
+			link.HasV2 = true
+		}
+	}
+	if !link.HasV1 && !link.HasV2 {
+		return nil, errors.New("magnet link has no BitTorrent info hash")
+	}
+	if !link.HasV1 {
+		copy(link.InfoHash[:], link.InfoHashV2[:])
+	}
+
+	if so := params.Get("so"); so != "" {
+		if link.Select, err = parseSelect(so); err != nil {
+			return nil, err
+		}
+	}
+	return link, nil
+}
+
+// parseBTIH decodes a v1 info hash, either hex or base32 encoded
+func parseBTIH(s string) ([20]byte, error) {
+
+	var hash [20]byte
+	var decoded []byte
+	var err error
+	switch len(s) {
+	case 40:
+		decoded, err = hex.DecodeString(s)
+	case 32:
+		decoded, err = base32.StdEncoding.DecodeString(strings.ToUpper(s))
+	default:
+		return hash, fmt.Errorf("info hash %q should be 40 hex or 32 base32 characters", s)
+	}
+	if err != nil {
+		return hash, fmt.Errorf("invalid info hash %q: %w", s, err)
+	}
+	copy(hash[:], decoded)
+	return hash, nil
+}
+
+// parseBTMH decodes a v2 info hash, which is a hex encoded multihash: 0x12
+// for SHA-256, 0x20 for its length, then the hash itself
+func parseBTMH(s string) ([32]byte, error) {
+
+	var hash [32]byte
+	decoded, err := hex.DecodeString(s)
+	if err != nil {
+		return hash, fmt.Errorf("invalid v2 info hash %q: %w", s, err)
+	}
+	if len(decoded) != 34 || decoded[0] != 0x12 || decoded[1] != 0x20 {
+		return hash, fmt.Errorf("v2 info hash %q isn't a SHA-256 multihash", s)
+	}
+	copy(hash[:], decoded[2:])
+	return hash, nil
+}
+
+// parseSelect reads a list of file indexes and ranges, such as "0,2,4-6"
+func parseSelect(s string) ([]FileRange, error) {
+
+	var ranges []FileRange
+	for _, part := range strings.Split(s, ",") {
+		first, last, isRange := strings.Cut(part, "-")
+		r, err := parseIndex(first)
+		if err != nil {
+			return nil, fmt.Errorf("invalid file selection %q: %w", s, err)
+		}
+		fr := FileRange{First: r, Last: r}
+		if isRange {
+			if fr.Last, err = parseIndex(last); err != nil {
+				return nil, fmt.Errorf("invalid file selection %q: %w", s, err)
+			}
+			if fr.Last < fr.First {
+				return nil, fmt.Errorf("invalid file selection %q: range %s is backwards", s, part)
+			}
+		}
+		ranges = append(ranges, fr)
+	}
+	return ranges, nil
+}
+
+func parseIndex(s string) (int, error) {
+	i, err := strconv.Atoi(s)
+	if err != nil || i < 0 {
+		return 0, fmt.Errorf("%q is not a file index", s)
+	}
+	return i, nil
+}
+
+// Selected reports whether the file at index is to be downloaded.
+func (l *Link) Selected(index int) bool {
+
+	if len(l.Select) == 0 {
+		return true
+	}
+	for _, r := range l.Select {
+		if index >= r.First && index <= r.Last {
+			return true
+		}
+	}
+	return false
+}
+
+// VerifyInfo checks that info, a bencoded info dictionary, is the one the
+// link points to. The v1 hash is used when the link has one.
+func (l *Link) VerifyInfo(info []byte) error {
+
+	if l.HasV1 {
+		if sha1.Sum(info) != l.InfoHash {
+			return errors.New("metadata doesn't match the info hash")
+		}
+		return nil
+	}
+	if sha256.Sum256(info) != l.InfoHashV2 {
+		return errors.New("metadata doesn't match the v2 info hash")
+	}
+	return nil
+}
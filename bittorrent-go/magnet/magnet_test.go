@@ -0,0 +1,143 @@
+package magnet
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testHash = "c12fe1c06bba254a9dc9f519b335aa7c1367a88a"
+
+func TestParse(t *testing.T) {
+	hash, _ := hex.DecodeString(testHash)
+	v2Hash := sha256.Sum256([]byte("v2"))
+	btmh := "1220" + hex.EncodeToString(v2Hash[:])
+
+	tests := []struct {
+		name     string
+		uri      string
+		expected Link
+	}{
+		{
+			"hex hash only",
+			"magnet:?xt=urn:btih:" + testHash,
+			Link{InfoHash: [20]byte(hash), HasV1: true},
+		},
+		{
+			"upper case hex hash",
+			"magnet:?xt=urn:btih:" + strings.ToUpper(testHash),
+			Link{InfoHash: [20]byte(hash), HasV1: true},
+		},
+		{
+			"base32 hash",
+			"magnet:?xt=urn:btih:YEX6DQDLXISUVHOJ6UM3GNNKPQJWPKEK",
+			Link{InfoHash: [20]byte(hash), HasV1: true},
+		},
+		{
+			"lower case base32 hash",
+			"magnet:?xt=urn:btih:yex6dqdlxisuvhoj6um3gnnkpqjwpkek",
+			Link{InfoHash: [20]byte(hash), HasV1: true},
+		},
+		{
+			"everything",
+			"magnet:?xt=urn:btih:" + testHash + "&dn=Some+File.iso" +
+				"&tr=http%3A%2F%2Ftracker.example.com%2Fannounce&tr=udp://tracker.example.org:6969" +
+				"&x.pe=10.0.0.1:6881&x.pe=[::1]:6882&so=0,2,4-6&foo=bar",
+			Link{
+				InfoHash: [20]byte(hash),
+				HasV1:    true,
+				Name:     "Some File.iso",
+				Trackers: []string{"http://tracker.example.com/announce", "udp://tracker.example.org:6969"},
+				Peers:    []string{"10.0.0.1:6881", "[::1]:6882"},
+				Select:   []FileRange{{0, 0}, {2, 2}, {4, 6}},
+			},
+		},
+		{
+			"v2 only",
+			"magnet:?xt=urn:btmh:" + btmh,
+			Link{InfoHash: [20]byte(v2Hash[:20]), InfoHashV2: v2Hash, HasV2: true},
+		},
+		{
+			"hybrid",
+			"magnet:?xt=urn:btih:" + testHash + "&xt=urn:btmh:" + btmh,
+			Link{InfoHash: [20]byte(hash), InfoHashV2: v2Hash, HasV1: true, HasV2: true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			link, err := Parse(tt.uri)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(*link, tt.expected) {
+				t.Errorf("expected %+v, got %+v", tt.expected, *link)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		uri  string
+		err  string
+	}{
+		{"not a magnet link", "http://example.com/?xt=urn:btih:" + testHash, "not a magnet link"},
+		{"no info hash", "magnet:?dn=nothing", "no BitTorrent info hash"},
+		{"other kind of hash", "magnet:?xt=urn:sha1:" + testHash, "no BitTorrent info hash"},
+		{"short hash", "magnet:?xt=urn:btih:c12fe1c0", "40 hex or 32 base32"},
+		{"bad hex", "magnet:?xt=urn:btih:" + strings.Repeat("z", 40), "invalid info hash"},
+		{"bad base32", "magnet:?xt=urn:btih:" + strings.Repeat("1", 32), "invalid info hash"},
+		{"not a sha256 multihash", "magnet:?xt=urn:btmh:1120" + strings.Repeat("00", 32), "SHA-256 multihash"},
+		{"bad file index", "magnet:?xt=urn:btih:" + testHash + "&so=a", "not a file index"},
+		{"negative file index", "magnet:?xt=urn:btih:" + testHash + "&so=-1", "not a file index"},
+		{"backwards range", "magnet:?xt=urn:btih:" + testHash + "&so=5-2", "backwards"},
+		{"bad query", "magnet:?xt=urn:btih:" + testHash + "&dn=%zz", "invalid magnet link"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.uri)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("expected error containing %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestSelected(t *testing.T) {
+	all := &Link{}
+	some := &Link{Select: []FileRange{{0, 0}, {4, 6}}}
+
+	for i, expected := range []bool{true, false, false, false, true, true, true, false} {
+		if got := some.Selected(i); got != expected {
+			t.Errorf("file %d: expected %v, got %v", i, expected, got)
+		}
+		if !all.Selected(i) {
+			t.Errorf("file %d: expected every file to be selected without 'so'", i)
+		}
+	}
+}
+
+func TestVerifyInfo(t *testing.T) {
+	info := []byte("d4:name4:teste")
+	v1 := &Link{InfoHash: sha1.Sum(info), HasV1: true}
+	v2 := &Link{InfoHashV2: sha256.Sum256(info), HasV2: true}
+
+	if err := v1.VerifyInfo(info); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := v2.VerifyInfo(info); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := v1.VerifyInfo([]byte("d4:name5:othere")); err == nil {
+		t.Error("expected error for the wrong metadata")
+	}
+	if err := v2.VerifyInfo([]byte("d4:name5:othere")); err == nil {
+		t.Error("expected error for the wrong metadata")
+	}
+}
@@ -0,0 +1,67 @@
+package peer
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/lourencovales/codecrafters/bittorrent-go/bencode"
+)
+
+// extensionBit is the reserved bit, in the sixth reserved byte of the
+// handshake, that says a peer supports the extension protocol (BEP 10).
+const extensionBit = 0x10
+
+// ExtHandshakeID is the extended message ID of the extension handshake. The
+// IDs of everything else are agreed on in it.
+const ExtHandshakeID uint8 = 0
+
+// These are the extended message IDs we ask peers to use when sending us
+// extension messages. Peers pick their own, found in their handshake.
+const (
+	LocalMetadataID uint8 = 1
+	LocalPexID      uint8 = 2
+)
+
+// ExtensionHandshake is the dictionary sent in the extension handshake. M maps
+// the name of each extension the peer supports to the message ID it wants it
+// sent with, zero meaning it's disabled.
+type ExtensionHandshake struct {
+	M            map[string]int `bencode:"m"`
+	MetadataSize int            `bencode:"metadata_size,omitempty"`
+	Version      string         `bencode:"v,omitempty"`
+}
+
+// SendExtended sends an extension message with the given extended message ID.
+func SendExtended(conn net.Conn, extID uint8, payload []byte) error {
+	return SendMsg(conn, MsgExtended, append([]byte{extID}, payload...))
+}
+
+// SendExtensionHandshake sends our side of the extension handshake.
+func SendExtensionHandshake(conn net.Conn, hs ExtensionHandshake) error {
+
+	payload, err := bencode.Marshal(hs)
+	if err != nil {
+		return err
+	}
+	return SendExtended(conn, ExtHandshakeID, payload)
+}
+
+// ParseExtensionHandshake decodes the payload of an extension handshake, the
+// extended message ID left out.
+func ParseExtensionHandshake(payload []byte) (*ExtensionHandshake, error) {
+
+	var hs ExtensionHandshake
+	if err := extensionLimits.Unmarshal(payload, &hs); err != nil {
+		return nil, fmt.Errorf("invalid extension handshake: %w", err)
+	}
+	return &hs, nil
+}
+
+// extensionLimits bounds what we're ready to decode from the dictionaries of
+// extension messages, which are only ever small.
+var extensionLimits = bencode.DecodeOptions{
+	MaxDepth:        4,
+	MaxStringLength: 64 << 10,
+	MaxElements:     1024,
+	MaxInputSize:    1 << 20,
+}
@@ -0,0 +1,119 @@
+package peer
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+)
+
+// loopback returns both ends of a TCP connection on the loopback interface.
+// Unlike net.Pipe, writes are buffered, so both sides can send at once.
+func loopback(t *testing.T) (net.Conn, net.Conn) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer ln.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := ln.Accept()
+		accepted <- conn
+	}()
+
+	local, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	remote := <-accepted
+	if remote == nil {
+		t.Fatal("failed to accept")
+	}
+	t.Cleanup(func() {
+		local.Close()
+		remote.Close()
+	})
+	return local, remote
+}
+
+func TestHandshakeExtended(t *testing.T) {
+	tests := []struct {
+		name     string
+		reserved []byte
+		expected bool
+	}{
+		{"peer supports extensions", []byte{0, 0, 0, 0, 0, 0x10, 0, 0}, true},
+		{"peer doesn't", make([]byte, 8), false},
+		{"peer sets other bits only", []byte{0, 0, 0, 0, 0, 0, 0, 0x05}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			local, remote := loopback(t)
+
+			infoHash := [20]byte{1, 2, 3}
+			peerID := [20]byte{4, 5, 6}
+			received := make(chan []byte, 1)
+			go func() {
+				hs := make([]byte, 68)
+				io.ReadFull(remote, hs)
+				received <- hs
+
+				reply := append([]byte{19}, "BitTorrent protocol"...)
+				reply = append(reply, tt.reserved...)
+				reply = append(reply, infoHash[:]...)
+				reply = append(reply, bytes.Repeat([]byte{9}, 20)...)
+				remote.Write(reply)
+			}()
+
+			gotID, extended, err := HandshakeExtended(local, infoHash, peerID)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if extended != tt.expected {
+				t.Errorf("expected extended %v, got %v", tt.expected, extended)
+			}
+			if gotID != [20]byte(bytes.Repeat([]byte{9}, 20)) {
+				t.Errorf("unexpected peer ID %x", gotID)
+			}
+
+			sent := <-received
+			if sent[25]&0x10 == 0 {
+				t.Error("expected the extension bit in our handshake")
+			}
+			if !bytes.Equal(sent[28:48], infoHash[:]) || !bytes.Equal(sent[48:68], peerID[:]) {
+				t.Error("handshake doesn't carry the info hash and peer ID")
+			}
+		})
+	}
+}
+
+func TestExtensionHandshake(t *testing.T) {
+	local, remote := loopback(t)
+
+	hs := ExtensionHandshake{M: map[string]int{"ut_metadata": 3, "ut_pex": 0}, MetadataSize: 1234, Version: "test 1.0"}
+	if err := SendExtensionHandshake(local, hs); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	msg, err := ReadMsg(remote)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if msg.ID != MsgExtended || msg.Payload[0] != ExtHandshakeID {
+		t.Fatalf("expected an extension handshake, got message %d", msg.ID)
+	}
+	got, err := ParseExtensionHandshake(msg.Payload[1:])
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(*got, hs) {
+		t.Errorf("expected %+v, got %+v", hs, *got)
+	}
+
+	if _, err := ParseExtensionHandshake([]byte("d1:mi1ee")); err == nil {
+		t.Error("expected error for a handshake where 'm' isn't a dictionary")
+	}
+}
@@ -0,0 +1,147 @@
+package peer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/lourencovales/codecrafters/bittorrent-go/bencode"
+)
+
+// MetadataPieceSize is the size of the pieces the info dictionary is sent in
+// by the ut_metadata extension (BEP 9). The last one may be shorter.
+const MetadataPieceSize = 16 * 1024
+
+// MaxMetadataSize is the largest info dictionary FetchMetadata accepts, way
+// more than any sane torrent needs, but not so much that a peer can make us
+// allocate whatever it likes.
+const MaxMetadataSize = 16 << 20
+
+// These are the ut_metadata message types.
+const (
+	metadataRequest = 0
+	metadataData    = 1
+	metadataReject  = 2
+)
+
+// metadataMsg is the dictionary at the start of every ut_metadata message.
+// Data messages have the piece itself right after it.
+type metadataMsg struct {
+	MsgType   int `bencode:"msg_type"`
+	Piece     int `bencode:"piece"`
+	TotalSize int `bencode:"total_size,omitempty"`
+}
+
+// FetchMetadata downloads the info dictionary of the torrent from a peer, over
+// the ut_metadata extension. conn must be right after a handshake where both
+// sides said they support the extension protocol. The caller is expected to
+// check the result against the info hash, and to set a deadline on conn.
+func FetchMetadata(conn net.Conn) ([]byte, error) {
+
+	hs := ExtensionHandshake{M: map[string]int{"ut_metadata": int(LocalMetadataID)}}
+	if err := SendExtensionHandshake(conn, hs); err != nil {
+		return nil, err
+	}
+
+	// peers usually send their bitfield first, which we don't care about yet
+	var peerHS *ExtensionHandshake
+	for peerHS == nil {
+		msg, err := ReadMsg(conn)
+		if err != nil {
+			return nil, err
+		}
+		if msg.ID != MsgExtended || len(msg.Payload) == 0 || msg.Payload[0] != ExtHandshakeID {
+			continue
+		}
+		if peerHS, err = ParseExtensionHandshake(msg.Payload[1:]); err != nil {
+			return nil, err
+		}
+	}
+
+	peerID := peerHS.M["ut_metadata"]
+	if peerID <= 0 || peerID > 255 {
+		return nil, errors.New("peer doesn't support ut_metadata")
+	}
+	size := peerHS.MetadataSize
+	if size <= 0 || size > MaxMetadataSize {
+		return nil, fmt.Errorf("peer gave an invalid metadata size of %d", size)
+	}
+
+	numPieces := (size + MetadataPieceSize - 1) / MetadataPieceSize
+	for i := 0; i < numPieces; i++ {
+		req, err := bencode.Marshal(metadataMsg{MsgType: metadataRequest, Piece: i})
+		if err != nil {
+			return nil, err
+		}
+		if err := SendExtended(conn, uint8(peerID), req); err != nil {
+			return nil, err
+		}
+	}
+
+	metadata := make([]byte, size)
+	received := make([]bool, numPieces)
+	for left := numPieces; left > 0; {
+		msg, err := ReadMsg(conn)
+		if err != nil {
+			return nil, err
+		}
+		if msg.ID != MsgExtended || len(msg.Payload) == 0 || msg.Payload[0] != LocalMetadataID {
+			continue
+		}
+
+		m, data, err := parseMetadataMsg(msg.Payload[1:])
+		if err != nil {
+			return nil, err
+		}
+		switch m.MsgType {
+		case metadataRequest:
+			// we don't have it either, that's why we're asking
+			reject, _ := bencode.Marshal(metadataMsg{MsgType: metadataReject, Piece: m.Piece})
+			if err := SendExtended(conn, uint8(peerID), reject); err != nil {
+				return nil, err
+			}
+		case metadataReject:
+			return nil, fmt.Errorf("peer rejected the request for metadata piece %d", m.Piece)
+		case metadataData:
+			if m.Piece < 0 || m.Piece >= numPieces || received[m.Piece] {
+				return nil, fmt.Errorf("unexpected metadata piece %d", m.Piece)
+			}
+			start := m.Piece * MetadataPieceSize
+			if len(data) != min(MetadataPieceSize, size-start) {
+				return nil, fmt.Errorf("metadata piece %d has the wrong length", m.Piece)
+			}
+			copy(metadata[start:], data)
+			received[m.Piece] = true
+			left--
+		}
+	}
+	return metadata, nil
+}
+
+// parseMetadataMsg splits a ut_metadata message into its dictionary and the
+// data that follows it, if any.
+func parseMetadataMsg(payload []byte) (*metadataMsg, []byte, error) {
+
+	var m metadataMsg
+	dec := extensionLimits.NewDecoder(bytes.NewReader(payload))
+	if err := dec.Decode(&m); err != nil {
+		return nil, nil, fmt.Errorf("invalid ut_metadata message: %w", err)
+	}
+	return &m, payload[dec.InputOffset():], nil
+}
+
+// MetadataPiece builds the ut_metadata data message for the piece at index of
+// metadata, the way a peer that has it answers requests.
+func MetadataPiece(metadata []byte, index int) ([]byte, error) {
+
+	start := index * MetadataPieceSize
+	if index < 0 || start >= len(metadata) {
+		return nil, fmt.Errorf("metadata piece %d out of range", index)
+	}
+	header, err := bencode.Marshal(metadataMsg{MsgType: metadataData, Piece: index, TotalSize: len(metadata)})
+	if err != nil {
+		return nil, err
+	}
+	return append(header, metadata[start:min(start+MetadataPieceSize, len(metadata))]...), nil
+}
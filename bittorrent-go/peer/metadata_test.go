@@ -0,0 +1,140 @@
+package peer
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/lourencovales/codecrafters/bittorrent-go/bencode"
+)
+
+// serveMetadata plays the part of a peer that has the metadata, after the
+// handshake. reject makes it refuse the requests instead.
+func serveMetadata(conn net.Conn, metadata []byte, m map[string]int, reject bool) {
+
+	SendMsg(conn, MsgBitfield, []byte{0xff}) // ignored by FetchMetadata
+	SendExtensionHandshake(conn, ExtensionHandshake{M: m, MetadataSize: len(metadata)})
+
+	// the other side asks for our metadata too, to check it refuses
+	req, _ := bencode.Marshal(metadataMsg{MsgType: metadataRequest, Piece: 0})
+	SendExtended(conn, LocalMetadataID, req)
+
+	for {
+		msg, err := ReadMsg(conn)
+		if err != nil {
+			return
+		}
+		if msg.ID != MsgExtended || msg.Payload[0] != uint8(m["ut_metadata"]) {
+			continue
+		}
+		var r metadataMsg
+		if err := bencode.Unmarshal(msg.Payload[1:], &r); err != nil || r.MsgType != metadataRequest {
+			continue
+		}
+		if reject {
+			payload, _ := bencode.Marshal(metadataMsg{MsgType: metadataReject, Piece: r.Piece})
+			SendExtended(conn, LocalMetadataID, payload)
+			continue
+		}
+		payload, err := MetadataPiece(metadata, r.Piece)
+		if err != nil {
+			return
+		}
+		SendExtended(conn, LocalMetadataID, payload)
+	}
+}
+
+func TestFetchMetadata(t *testing.T) {
+	// a bit over two pieces, so the last one is short
+	metadata := bytes.Repeat([]byte("metadata"), 5000)
+
+	local, remote := loopback(t)
+	go serveMetadata(remote, metadata, map[string]int{"ut_metadata": 7}, false)
+
+	got, err := FetchMetadata(local)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(got, metadata) {
+		t.Error("metadata doesn't match")
+	}
+}
+
+func TestFetchMetadataErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		metadata []byte
+		m        map[string]int
+		reject   bool
+		err      string
+	}{
+		{"no ut_metadata", []byte("d4:name1:xe"), map[string]int{"ut_pex": 1}, false, "doesn't support ut_metadata"},
+		{"ut_metadata disabled", []byte("d4:name1:xe"), map[string]int{"ut_metadata": 0}, false, "doesn't support ut_metadata"},
+		{"no metadata size", nil, map[string]int{"ut_metadata": 2}, false, "invalid metadata size"},
+		{"rejected", []byte("d4:name1:xe"), map[string]int{"ut_metadata": 2}, true, "rejected"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			local, remote := loopback(t)
+			go serveMetadata(remote, tt.metadata, tt.m, tt.reject)
+
+			_, err := FetchMetadata(local)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("expected error containing %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestFetchMetadataBadPiece(t *testing.T) {
+	local, remote := loopback(t)
+	go func() {
+		SendExtensionHandshake(remote, ExtensionHandshake{M: map[string]int{"ut_metadata": 1}, MetadataSize: 100})
+		ReadMsg(remote) // our handshake
+		ReadMsg(remote) // the request
+		payload, _ := bencode.Marshal(metadataMsg{MsgType: metadataData, Piece: 0, TotalSize: 100})
+		SendExtended(remote, LocalMetadataID, append(payload, make([]byte, 50)...))
+	}()
+
+	_, err := FetchMetadata(local)
+	if err == nil || !strings.Contains(err.Error(), "wrong length") {
+		t.Errorf("expected error for a short piece, got %v", err)
+	}
+}
+
+func TestMetadataPiece(t *testing.T) {
+	metadata := bytes.Repeat([]byte{1}, MetadataPieceSize+10)
+
+	tests := []struct {
+		index   int
+		dataLen int
+		wantErr bool
+	}{
+		{0, MetadataPieceSize, false},
+		{1, 10, false},
+		{2, 0, true},
+		{-1, 0, true},
+	}
+
+	for _, tt := range tests {
+		payload, err := MetadataPiece(metadata, tt.index)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("piece %d: expected error", tt.index)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("piece %d: unexpected error: %v", tt.index, err)
+		}
+		m, data, err := parseMetadataMsg(payload)
+		if err != nil {
+			t.Fatalf("piece %d: unexpected error: %v", tt.index, err)
+		}
+		if m.MsgType != metadataData || m.Piece != tt.index || m.TotalSize != len(metadata) || len(data) != tt.dataLen {
+			t.Errorf("piece %d: unexpected message %+v with %d bytes", tt.index, *m, len(data))
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package peer
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/netip"
+	"time"
+
+	"github.com/lourencovales/codecrafters/bittorrent-go/bencode"
+)
+
+// MaxPexPeers is the most peers a ut_pex message adds, and the most it drops
+// (BEP 11). Longer lists are cut short when parsing.
+const MaxPexPeers = 50
+
+// PexInterval is how often at most a ut_pex message is sent to a peer. Peers
+// that send them more often than that are ignored in between.
+const PexInterval = time.Minute
+
+// These are the flags of the peers a ut_pex message adds.
+const (
+	PexEncryption uint8 = 0x01 // prefers encrypted connections
+	PexSeed       uint8 = 0x02 // is a seed, or upload only
+	PexUTP        uint8 = 0x04 // supports uTP
+	PexHolepunch  uint8 = 0x08 // supports ut_holepunch
+	PexOutgoing   uint8 = 0x10 // the sender connected to it, so it's reachable
+)
+
+// PexPeer is a peer added by a ut_pex message.
+type PexPeer struct {
+	Addr  netip.AddrPort
+	Flags uint8
+}
+
+// PexMessage is a ut_pex message: the peers the sender connected to, and
+// those it disconnected from, since its last one.
+type PexMessage struct {
+	Added   []PexPeer
+	Dropped []netip.AddrPort
+}
+
+// pexDict is the dictionary a ut_pex message is sent as. Peers are in their
+// compact form, IPv4 and IPv6 ones apart, and each added peer has a byte of
+// flags.
+type pexDict struct {
+	Added    string `bencode:"added,omitempty"`
+	AddedF   string `bencode:"added.f,omitempty"`
+	Added6   string `bencode:"added6,omitempty"`
+	Added6F  string `bencode:"added6.f,omitempty"`
+	Dropped  string `bencode:"dropped,omitempty"`
+	Dropped6 string `bencode:"dropped6,omitempty"`
+}
+
+// EncodePex builds the payload of a ut_pex message, the extended message ID
+// left out. It fails if m has more than MaxPexPeers of either kind.
+func EncodePex(m PexMessage) ([]byte, error) {
+
+	if len(m.Added) > MaxPexPeers || len(m.Dropped) > MaxPexPeers {
+		return nil, fmt.Errorf("a ut_pex message can't carry more than %d peers of each kind", MaxPexPeers)
+	}
+
+	var added, addedF, added6, added6F []byte
+	for _, p := range m.Added {
+		if p.Addr.Addr().Unmap().Is4() {
+			added = appendCompactPeer(added, p.Addr)
+			addedF = append(addedF, p.Flags)
+		} else {
+			added6 = appendCompactPeer(added6, p.Addr)
+			added6F = append(added6F, p.Flags)
+		}
+	}
+	var dropped, dropped6 []byte
+	for _, addr := range m.Dropped {
+		if addr.Addr().Unmap().Is4() {
+			dropped = appendCompactPeer(dropped, addr)
+		} else {
+			dropped6 = appendCompactPeer(dropped6, addr)
+		}
+	}
+
+	return bencode.Marshal(pexDict{
+		Added:    string(added),
+		AddedF:   string(addedF),
+		Added6:   string(added6),
+		Added6F:  string(added6F),
+		Dropped:  string(dropped),
+		Dropped6: string(dropped6),
+	})
+}
+
+// ParsePex decodes the payload of a ut_pex message, the extended message ID
+// left out. Added peers missing their flags get none.
+func ParsePex(payload []byte) (*PexMessage, error) {
+
+	var d pexDict
+	if err := extensionLimits.Unmarshal(payload, &d); err != nil {
+		return nil, fmt.Errorf("invalid ut_pex message: %w", err)
+	}
+
+	added, err := parseCompactPeers(d.Added, 4)
+	if err != nil {
+		return nil, err
+	}
+	added6, err := parseCompactPeers(d.Added6, 16)
+	if err != nil {
+		return nil, err
+	}
+	dropped, err := parseCompactPeers(d.Dropped, 4)
+	if err != nil {
+		return nil, err
+	}
+	dropped6, err := parseCompactPeers(d.Dropped6, 16)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &PexMessage{}
+	for i, addr := range added {
+		m.Added = append(m.Added, PexPeer{Addr: addr, Flags: flagAt(d.AddedF, i)})
+	}
+	for i, addr := range added6 {
+		m.Added = append(m.Added, PexPeer{Addr: addr, Flags: flagAt(d.Added6F, i)})
+	}
+	m.Dropped = append(dropped, dropped6...)
+
+	// senders that don't stick to the limit don't get to flood us
+	m.Added = m.Added[:min(len(m.Added), MaxPexPeers)]
+	m.Dropped = m.Dropped[:min(len(m.Dropped), MaxPexPeers)]
+	return m, nil
+}
+
+// flagAt returns the flags of the i-th peer, or none if there aren't that
+// many
+func flagAt(flags string, i int) uint8 {
+	if i < len(flags) {
+		return flags[i]
+	}
+	return 0
+}
+
+// appendCompactPeer appends the compact form of addr: the IP address, 4 or
+// 16 bytes, then the port
+func appendCompactPeer(b []byte, addr netip.AddrPort) []byte {
+	b = append(b, addr.Addr().Unmap().AsSlice()...)
+	return binary.BigEndian.AppendUint16(b, addr.Port())
+}
+
+// parseCompactPeers decodes a list of compact peers whose addresses are
+// addrLen bytes long
+func parseCompactPeers(s string, addrLen int) ([]netip.AddrPort, error) {
+
+	size := addrLen + 2
+	if len(s)%size != 0 {
+		return nil, errors.New("invalid ut_pex message: truncated peer list")
+	}
+	var peers []netip.AddrPort
+	for i := 0; i < len(s); i += size {
+		ip, _ := netip.AddrFromSlice([]byte(s[i : i+addrLen]))
+		port := binary.BigEndian.Uint16([]byte(s[i+addrLen : i+size]))
+		peers = append(peers, netip.AddrPortFrom(ip.Unmap(), port))
+	}
+	return peers, nil
+}
@@ -0,0 +1,118 @@
+package peer
+
+import (
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPexRoundTrip(t *testing.T) {
+	m := PexMessage{
+		Added: []PexPeer{
+			{netip.MustParseAddrPort("10.0.0.1:6881"), PexOutgoing},
+			{netip.MustParseAddrPort("[2001:db8::1]:6882"), PexSeed | PexUTP},
+			{netip.MustParseAddrPort("[::ffff:10.0.0.2]:6883"), 0},
+		},
+		Dropped: []netip.AddrPort{
+			netip.MustParseAddrPort("[2001:db8::2]:1"),
+			netip.MustParseAddrPort("10.0.0.3:2"),
+		},
+	}
+	payload, err := EncodePex(m)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got, err := ParsePex(payload)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// IPv4 peers come first, and mapped addresses are unmapped
+	expected := &PexMessage{
+		Added: []PexPeer{
+			{netip.MustParseAddrPort("10.0.0.1:6881"), PexOutgoing},
+			{netip.MustParseAddrPort("10.0.0.2:6883"), 0},
+			{netip.MustParseAddrPort("[2001:db8::1]:6882"), PexSeed | PexUTP},
+		},
+		Dropped: []netip.AddrPort{
+			netip.MustParseAddrPort("10.0.0.3:2"),
+			netip.MustParseAddrPort("[2001:db8::2]:1"),
+		},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %+v, got %+v", expected, got)
+	}
+}
+
+func TestEncodePex(t *testing.T) {
+	payload, err := EncodePex(PexMessage{Added: []PexPeer{{netip.MustParseAddrPort("10.0.0.1:6881"), PexOutgoing}}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := "d5:added6:\x0a\x00\x00\x01\x1a\xe17:added.f1:\x10e"; string(payload) != expected {
+		t.Errorf("expected %q, got %q", expected, payload)
+	}
+
+	if payload, err := EncodePex(PexMessage{}); err != nil || string(payload) != "de" {
+		t.Errorf("expected an empty dictionary, got %q, %v", payload, err)
+	}
+
+	tooMany := PexMessage{Dropped: make([]netip.AddrPort, MaxPexPeers+1)}
+	if _, err := EncodePex(tooMany); err == nil {
+		t.Error("expected error for too many peers")
+	}
+}
+
+func TestParsePex(t *testing.T) {
+	peer := "\x0a\x00\x00\x01\x1a\xe1"
+	tests := []struct {
+		name     string
+		payload  string
+		expected *PexMessage
+		err      bool
+	}{
+		{"missing flags", "d5:added12:" + peer + peer + "7:added.f1:\x02e", &PexMessage{Added: []PexPeer{
+			{netip.MustParseAddrPort("10.0.0.1:6881"), PexSeed},
+			{netip.MustParseAddrPort("10.0.0.1:6881"), 0},
+		}}, false},
+		{"dropped only", "d7:dropped6:" + peer + "e", &PexMessage{Dropped: []netip.AddrPort{netip.MustParseAddrPort("10.0.0.1:6881")}}, false},
+		{"unknown keys", "d1:xi1e5:added0:e", &PexMessage{}, false},
+		{"truncated added", "d5:added5:" + peer[:5] + "e", nil, true},
+		{"truncated added6", "d6:added66:" + peer + "e", nil, true},
+		{"truncated dropped", "d7:dropped7:" + peer + "xe", nil, true},
+		{"not a dictionary", "li1ee", nil, true},
+		{"not bencode", "garbage", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParsePex([]byte(tt.payload))
+			if tt.err {
+				if err == nil {
+					t.Error("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("expected %+v, got %+v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestParsePexCutsShort(t *testing.T) {
+	peers := strings.Repeat("\x0a\x00\x00\x01\x1a\xe1", MaxPexPeers+10)
+	payload := "d5:added360:" + peers + "7:dropped360:" + peers + "e"
+
+	got, err := ParsePex([]byte(payload))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(got.Added) != MaxPexPeers || len(got.Dropped) != MaxPexPeers {
+		t.Errorf("expected %d peers of each kind, got %d and %d", MaxPexPeers, len(got.Added), len(got.Dropped))
+	}
+}
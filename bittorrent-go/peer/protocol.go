@@ -19,6 +19,7 @@ const (
 	MsgRequest       uint8 = 6
 	MsgPiece         uint8 = 7
 	MsgCancel        uint8 = 8
+	MsgExtended      uint8 = 20
 )
 
 // Keeping block sizes constant at 16KiB
@@ -34,31 +35,50 @@ type Message struct {
 
 // Handshake performs the BT handshake with a peer.
 func Handshake(conn net.Conn, infoHash [20]byte, peerID [20]byte) ([20]byte, error) {
+	receivedPeerID, _, err := handshake(conn, infoHash, peerID, [8]byte{})
+	return receivedPeerID, err
+}
+
+// HandshakeExtended performs the BT handshake with a peer, letting it know
+// that we speak the extension protocol (BEP 10). It reports whether the peer
+// does too.
+func HandshakeExtended(conn net.Conn, infoHash [20]byte, peerID [20]byte) ([20]byte, bool, error) {
+	var reserved [8]byte
+	reserved[5] |= extensionBit
+	receivedPeerID, peerReserved, err := handshake(conn, infoHash, peerID, reserved)
+	return receivedPeerID, peerReserved[5]&extensionBit != 0, err
+}
+
+// handshake sends our handshake, with the given reserved bits, and reads the
+// peer's, returning its peer ID and reserved bits.
+func handshake(conn net.Conn, infoHash [20]byte, peerID [20]byte, reserved [8]byte) ([20]byte, [8]byte, error) {
 
 	// Build a new handshake message
 	handshake := new(bytes.Buffer)
 	handshake.WriteByte(19)
 	handshake.WriteString("BitTorrent protocol")
-	handshake.Write(make([]byte, 8))
+	handshake.Write(reserved[:])
 	handshake.Write(infoHash[:])
 	handshake.Write(peerID[:])
 
 	if _, err := conn.Write(handshake.Bytes()); err != nil {
-		return [20]byte{}, err
+		return [20]byte{}, [8]byte{}, err
 	}
 
 	response := make([]byte, 68)
 	if _, err := io.ReadFull(conn, response); err != nil {
-		return [20]byte{}, err
+		return [20]byte{}, [8]byte{}, err
 	}
 
 	if response[0] != 19 || string(response[1:20]) != "BitTorrent protocol" {
-		return [20]byte{}, fmt.Errorf("invalid handshake response")
+		return [20]byte{}, [8]byte{}, fmt.Errorf("invalid handshake response")
 	}
 
 	var receivedPeerID [20]byte
+	var peerReserved [8]byte
+	copy(peerReserved[:], response[20:28])
 	copy(receivedPeerID[:], response[48:68])
-	return receivedPeerID, nil
+	return receivedPeerID, peerReserved, nil
 }
 
 // SendMsg is a function that serializes and send a message to a peer.
@@ -0,0 +1,299 @@
+package torrent
+
+import (
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lourencovales/codecrafters/bittorrent-go/bencode"
+)
+
+// These bound the piece length Create picks on its own. Within them it aims
+// for about targetPieces pieces, which keeps the .torrent file small without
+// making pieces so large that a single bad one costs a lot to download again.
+const (
+	minAutoPieceLength = 16 << 10
+	maxAutoPieceLength = 16 << 20
+	targetPieces       = 1500
+)
+
+// CreateOptions configures Create. Everything is optional, but private
+// torrents need a tracker.
+type CreateOptions struct {
+	// Name of the torrent, by default the base name of the path.
+	Name string
+	// PieceLength in bytes, a power of two. Zero picks one based on the size
+	// of the content.
+	PieceLength int
+	// Trackers are grouped in tiers, as in 'announce-list' (BEP 12). The first
+	// tracker of the first tier also goes in 'announce'. Torrents without any
+	// are left to the DHT to find peers for.
+	Trackers [][]string
+	// WebSeeds are HTTP(S) URLs serving the same content (BEP 19).
+	WebSeeds     []string
+	Comment      string
+	CreatedBy    string
+	CreationDate time.Time // left out when zero
+	// Private torrents only get peers from their trackers (BEP 27), so they
+	// need at least one.
+	Private bool
+	// Source is written into the info dictionary, which gives the torrent a
+	// different info hash from otherwise identical ones.
+	Source string
+	// Workers is how many pieces are hashed in parallel, by default one per
+	// CPU.
+	Workers int
+}
+
+// Create builds a .torrent for the file or directory at path, and returns it
+// bencoded. Directories are walked in lexical order, and anything that isn't
+// a regular file, including symlinks, is skipped.
+func Create(path string, opts CreateOptions) ([]byte, error) {
+
+	hasTracker := false
+	for _, tier := range opts.Trackers {
+		hasTracker = hasTracker || len(tier) > 0
+	}
+	if !hasTracker && opts.Private {
+		return nil, errors.New("private torrents need at least one tracker")
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	name := opts.Name
+	if name == "" {
+		name = filepath.Base(filepath.Clean(path))
+	}
+	if err := checkPathElem(name); err != nil {
+		return nil, fmt.Errorf("invalid torrent name: %w", err)
+	}
+
+	var files []File
+	var diskPaths []string
+	if info.IsDir() {
+		files, diskPaths, err = collectFiles(path)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		files = []File{{Path: []string{name}, Length: int(info.Size())}}
+		diskPaths = []string{path}
+	}
+
+	totalLength := 0
+	for _, f := range files {
+		totalLength += f.Length
+	}
+	if totalLength == 0 {
+		return nil, errors.New("nothing to share, the content is empty")
+	}
+
+	pieceLength := opts.PieceLength
+	if pieceLength == 0 {
+		pieceLength = autoPieceLength(totalLength)
+	}
+	if pieceLength <= 0 || pieceLength&(pieceLength-1) != 0 {
+		return nil, fmt.Errorf("piece length must be a power of two, got %d", pieceLength)
+	}
+
+	layout := &TorrentInfo{
+		PieceLength: pieceLength,
+		TotalLength: totalLength,
+		PieceHashes: make([][20]byte, (totalLength+pieceLength-1)/pieceLength),
+		Files:       files,
+		MultiFile:   info.IsDir(),
+	}
+	if err := hashPieces(layout, diskPaths, opts.Workers); err != nil {
+		return nil, err
+	}
+
+	pieces := make([]byte, 0, len(layout.PieceHashes)*20)
+	for _, h := range layout.PieceHashes {
+		pieces = append(pieces, h[:]...)
+	}
+
+	meta := metaFile{
+		Comment:   opts.Comment,
+		CreatedBy: opts.CreatedBy,
+		URLList:   opts.WebSeeds,
+		Info: infoDict{
+			Name:        name,
+			PieceLength: pieceLength,
+			Pieces:      string(pieces),
+			Source:      opts.Source,
+		},
+	}
+	if !opts.CreationDate.IsZero() {
+		meta.CreationDate = opts.CreationDate.Unix()
+	}
+	if opts.Private {
+		meta.Info.Private = 1
+	}
+
+	if layout.MultiFile {
+		for _, f := range files {
+			meta.Info.Files = append(meta.Info.Files, fileDict{Length: f.Length, Path: f.Path})
+		}
+	} else {
+		meta.Info.Length = totalLength
+	}
+
+	// a lone tracker doesn't need an announce list
+	for _, tier := range opts.Trackers {
+		if len(tier) == 0 {
+			continue
+		}
+		if meta.Announce == "" {
+			meta.Announce = tier[0]
+		}
+		meta.AnnounceList = append(meta.AnnounceList, tier)
+	}
+	if len(meta.AnnounceList) == 1 && len(meta.AnnounceList[0]) == 1 {
+		meta.AnnounceList = nil
+	}
+
+	return bencode.Marshal(meta)
+}
+
+// collectFiles lists the regular files under dir, in lexical order, both as
+// torrent paths and as paths on disk
+func collectFiles(dir string) ([]File, []string, error) {
+
+	var files []File
+	var diskPaths []string
+	offset := 0
+
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		// names ParseFile would refuse are turned away before any hashing
+		path := strings.Split(filepath.ToSlash(rel), "/")
+		for _, elem := range path {
+			if err := checkPathElem(elem); err != nil {
+				return fmt.Errorf("file %s has an invalid path: %w", rel, err)
+			}
+		}
+
+		files = append(files, File{
+			Path:   path,
+			Length: int(fi.Size()),
+			Offset: offset,
+		})
+		diskPaths = append(diskPaths, p)
+		offset += int(fi.Size())
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(files) == 0 {
+		return nil, nil, fmt.Errorf("no files found in %s", dir)
+	}
+	return files, diskPaths, nil
+}
+
+// autoPieceLength picks the smallest power of two piece length that keeps the
+// number of pieces around targetPieces
+func autoPieceLength(totalLength int) int {
+	pieceLength := minAutoPieceLength
+	for pieceLength < maxAutoPieceLength && totalLength/pieceLength > targetPieces {
+		pieceLength *= 2
+	}
+	return pieceLength
+}
+
+// hashPieces fills in layout.PieceHashes, reading the data from diskPaths,
+// which line up with layout.Files. Pieces are handed out to the workers one
+// at a time, each worker reading its piece straight from the files it spans.
+func hashPieces(layout *TorrentInfo, diskPaths []string, workers int) error {
+
+	files := make([]*os.File, len(diskPaths))
+	for i, p := range diskPaths {
+		f, err := os.Open(p)
+		if err != nil {
+			closeFiles(files)
+			return err
+		}
+		files[i] = f
+	}
+	defer closeFiles(files)
+
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	indexes := make(chan int)
+	errs := make(chan error, workers)
+	var wg sync.WaitGroup
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buf := make([]byte, layout.PieceLength)
+			for i := range indexes {
+				piece := buf[:layout.PieceSize(i)]
+				if err := readPiece(layout, files, i, piece); err != nil {
+					if errors.Is(err, errMissingData) {
+						err = errors.New("files changed while they were being hashed")
+					}
+					errs <- err
+					return
+				}
+				layout.PieceHashes[i] = sha1.Sum(piece)
+			}
+		}()
+	}
+
+	// stop handing out pieces as soon as a worker gives up
+	var err error
+feed:
+	for i := range layout.PieceHashes {
+		select {
+		case indexes <- i:
+		case err = <-errs:
+			break feed
+		}
+	}
+	close(indexes)
+	wg.Wait()
+
+	if err == nil {
+		select {
+		case err = <-errs:
+		default:
+		}
+	}
+	return err
+}
+
+// closeFiles closes whatever files got opened
+func closeFiles(files []*os.File) {
+	for _, f := range files {
+		if f != nil {
+			f.Close()
+		}
+	}
+}
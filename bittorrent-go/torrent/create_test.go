@@ -0,0 +1,254 @@
+package torrent
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/lourencovales/codecrafters/bittorrent-go/bencode"
+)
+
+// writeTree creates files under dir, keyed by their slash separated path
+func writeTree(t *testing.T, dir string, files map[string][]byte) {
+	for name, data := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+	}
+}
+
+// testTrackers is what the tests give their torrents as trackers
+var testTrackers = [][]string{{"http://tracker.example.com/announce"}}
+
+// testData returns n bytes that aren't all the same
+func testData(n int, seed byte) []byte {
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte(i*31) ^ seed
+	}
+	return data
+}
+
+// createAndParse runs Create, writes the result out and parses it back
+func createAndParse(t *testing.T, path string, opts CreateOptions) ([]byte, *TorrentInfo) {
+	data, err := Create(path, opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	out := filepath.Join(t.TempDir(), "out.torrent")
+	if err := os.WriteFile(out, data, 0o644); err != nil {
+		t.Fatalf("failed to write torrent: %v", err)
+	}
+	info, err := ParseFile(out)
+	if err != nil {
+		t.Fatalf("created torrent doesn't parse: %v", err)
+	}
+	return data, info
+}
+
+func TestCreateSingleFile(t *testing.T) {
+	dir := t.TempDir()
+	content := testData(50000, 1)
+	writeTree(t, dir, map[string][]byte{"file.bin": content})
+
+	data, info := createAndParse(t, filepath.Join(dir, "file.bin"), CreateOptions{
+		PieceLength: 16384,
+		Trackers:    [][]string{{"http://tracker.example.com/announce"}},
+	})
+
+	rawInfo, _, err := bencode.Get(data, "info")
+	if err != nil {
+		t.Fatalf("failed to find info dict: %v", err)
+	}
+	if info.InfoHash != sha1.Sum(rawInfo) {
+		t.Error("info hash doesn't match the info dict that was written")
+	}
+
+	if info.MultiFile || info.Name != "file.bin" || info.TotalLength != len(content) {
+		t.Errorf("unexpected torrent %+v", info)
+	}
+	if info.AnnounceURL != "http://tracker.example.com/announce" {
+		t.Errorf("unexpected announce URL %s", info.AnnounceURL)
+	}
+	if _, _, err := bencode.Get(data, "announce-list"); err == nil {
+		t.Error("expected no announce-list for a single tracker")
+	}
+
+	if len(info.PieceHashes) != 4 {
+		t.Fatalf("expected 4 pieces, got %d", len(info.PieceHashes))
+	}
+	for i, h := range info.PieceHashes {
+		end := min((i+1)*16384, len(content))
+		if h != sha1.Sum(content[i*16384:end]) {
+			t.Errorf("wrong hash for piece %d", i)
+		}
+	}
+}
+
+func TestCreateDirectory(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "content")
+	files := map[string][]byte{
+		"b.txt":        testData(20000, 2),
+		"a.txt":        testData(10000, 3),
+		"sub/c.txt":    testData(7000, 4),
+		"sub/empty":    {},
+		"sub/deep/d.x": testData(40000, 5),
+	}
+	writeTree(t, dir, files)
+
+	_, info := createAndParse(t, dir, CreateOptions{PieceLength: 32768, Trackers: testTrackers})
+
+	if !info.MultiFile || info.Name != "content" {
+		t.Errorf("expected a multi-file torrent named content, got %+v", info)
+	}
+
+	// lexical order, and the pieces are hashed over the files back to back
+	expectedPaths := [][]string{{"a.txt"}, {"b.txt"}, {"sub", "c.txt"}, {"sub", "deep", "d.x"}, {"sub", "empty"}}
+	var all []byte
+	for i, f := range info.Files {
+		if !reflect.DeepEqual(f.Path, expectedPaths[i]) {
+			t.Errorf("expected file %d to be %v, got %v", i, expectedPaths[i], f.Path)
+		}
+		all = append(all, files[filepath.ToSlash(filepath.Join(f.Path...))]...)
+	}
+	for i, h := range info.PieceHashes {
+		end := min((i+1)*32768, len(all))
+		if h != sha1.Sum(all[i*32768:end]) {
+			t.Errorf("wrong hash for piece %d", i)
+		}
+	}
+}
+
+func TestCreateMetadata(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string][]byte{"f": testData(1000, 6)})
+	path := filepath.Join(dir, "f")
+
+	opts := CreateOptions{
+		Name:         "renamed",
+		Trackers:     [][]string{{"http://a", "http://b"}, {}, {"http://c"}},
+		WebSeeds:     []string{"http://seed/"},
+		Comment:      "a comment",
+		CreatedBy:    "test",
+		CreationDate: time.Unix(1700000000, 0),
+		Private:      true,
+		Source:       "SRC",
+	}
+	data, info := createAndParse(t, path, opts)
+
+	var meta metaFile
+	if err := bencode.Unmarshal(data, &meta); err != nil {
+		t.Fatalf("failed to decode created torrent: %v", err)
+	}
+
+	if meta.Announce != "http://a" || !reflect.DeepEqual(meta.AnnounceList, [][]string{{"http://a", "http://b"}, {"http://c"}}) {
+		t.Errorf("unexpected trackers %q %v", meta.Announce, meta.AnnounceList)
+	}
+	if !reflect.DeepEqual([]string(meta.URLList), opts.WebSeeds) {
+		t.Errorf("expected web seeds %v, got %v", opts.WebSeeds, meta.URLList)
+	}
+	if meta.Comment != "a comment" || meta.CreatedBy != "test" || meta.CreationDate != 1700000000 {
+		t.Errorf("unexpected metadata %+v", meta)
+	}
+	if meta.Info.Private != 1 || meta.Info.Source != "SRC" || meta.Info.Name != "renamed" {
+		t.Errorf("unexpected info dict fields %+v", meta.Info)
+	}
+
+	// private and source are part of the info dict, so they change the hash
+	_, plain := createAndParse(t, path, CreateOptions{Name: "renamed", Trackers: opts.Trackers})
+	if plain.InfoHash == info.InfoHash {
+		t.Error("expected private and source to change the info hash")
+	}
+}
+
+func TestCreateTrackerless(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string][]byte{"f": testData(1000, 7)})
+
+	data, info := createAndParse(t, filepath.Join(dir, "f"), CreateOptions{Trackers: [][]string{{}}})
+	for _, key := range []string{"announce", "announce-list"} {
+		if _, _, err := bencode.Get(data, key); err == nil {
+			t.Errorf("expected no %s", key)
+		}
+	}
+	if info.AnnounceURL != "" || len(info.AnnounceList) != 0 {
+		t.Errorf("expected no trackers, got %q %v", info.AnnounceURL, info.AnnounceList)
+	}
+}
+
+func TestCreateWorkersAgree(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string][]byte{"x/1": testData(100000, 7), "x/2": testData(3, 8), "x/3": testData(77777, 9)})
+
+	var first []byte
+	for _, workers := range []int{1, 2, 8} {
+		data, err := Create(filepath.Join(dir, "x"), CreateOptions{PieceLength: 16384, Workers: workers, Trackers: testTrackers})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if first == nil {
+			first = data
+		} else if !bytes.Equal(first, data) {
+			t.Errorf("expected %d workers to produce the same torrent as 1", workers)
+		}
+	}
+}
+
+func TestAutoPieceLength(t *testing.T) {
+	tests := []struct {
+		totalLength int
+		expected    int
+	}{
+		{1, 16 << 10},
+		{16 << 20, 16 << 10},
+		{100 << 20, 128 << 10},
+		{4 << 30, 4 << 20},
+		{1 << 40, 16 << 20},
+	}
+
+	for _, tt := range tests {
+		if pl := autoPieceLength(tt.totalLength); pl != tt.expected {
+			t.Errorf("expected piece length %d for %d bytes, got %d", tt.expected, tt.totalLength, pl)
+		}
+	}
+}
+
+func TestCreateErrors(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string][]byte{"f": testData(100, 0), "empty": {}})
+	if err := os.Mkdir(filepath.Join(dir, "nothing"), 0o755); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+	writeTree(t, filepath.Join(dir, "bad"), map[string][]byte{"sub/a\\b": testData(100, 1)})
+
+	tests := []struct {
+		name string
+		path string
+		opts CreateOptions
+	}{
+		{"missing path", filepath.Join(dir, "missing"), CreateOptions{Trackers: testTrackers}},
+		{"empty directory", filepath.Join(dir, "nothing"), CreateOptions{Trackers: testTrackers}},
+		{"empty file", filepath.Join(dir, "empty"), CreateOptions{Trackers: testTrackers}},
+		{"piece length not a power of two", filepath.Join(dir, "f"), CreateOptions{PieceLength: 1000, Trackers: testTrackers}},
+		{"negative piece length", filepath.Join(dir, "f"), CreateOptions{PieceLength: -16384, Trackers: testTrackers}},
+		{"bad name", filepath.Join(dir, "f"), CreateOptions{Name: "../f", Trackers: testTrackers}},
+		{"backslash in a file name", filepath.Join(dir, "bad"), CreateOptions{Trackers: testTrackers}},
+		{"private without trackers", filepath.Join(dir, "f"), CreateOptions{Trackers: [][]string{{}}, Private: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Create(tt.path, tt.opts); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
@@ -0,0 +1,202 @@
+package torrent
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/lourencovales/codecrafters/bittorrent-go/bencode"
+)
+
+// EditOptions says what Edit changes. The zero value changes nothing. Tracker
+// edits are applied in the order of the fields: set, replace, remove, add.
+type EditOptions struct {
+	// SetTrackers, when not empty, replaces every tracker with these tiers.
+	SetTrackers [][]string
+	// ReplaceTrackers maps tracker URLs to the ones that take their place,
+	// in the same tier. Each tracker is looked up once, so replacements
+	// don't chain, and a tracker that ends up in the list twice is only
+	// kept where it first appears.
+	ReplaceTrackers map[string]string
+	RemoveTrackers  []string
+	// AddTrackers are put in a tier of their own each, after the others.
+	// Trackers the torrent already has are skipped.
+	AddTrackers []string
+
+	// Comment replaces the comment, and an empty one removes it. nil leaves
+	// it alone.
+	Comment *string
+
+	RemoveWebSeeds []string
+	AddWebSeeds    []string
+}
+
+// Edit applies opts to the bencoded torrent in data, and returns the new
+// torrent. Only the outer dictionary changes: the raw bytes of every other
+// value, the info dictionary included, are copied over verbatim, so the info
+// hash stays the same.
+func Edit(data []byte, opts EditOptions) ([]byte, error) {
+
+	var outer map[string]bencode.RawMessage
+	if err := bencode.Unmarshal(data, &outer); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal bencoded file: %w", err)
+	}
+	if outer["info"] == nil {
+		return nil, errors.New("info dict not found")
+	}
+
+	var meta metaFile
+	if err := bencode.Unmarshal(data, &meta); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal bencoded file: %w", err)
+	}
+
+	// trackerless torrents can still have everything else edited
+	if len(opts.SetTrackers) > 0 || len(opts.ReplaceTrackers) > 0 || len(opts.RemoveTrackers) > 0 || len(opts.AddTrackers) > 0 {
+		tiers, err := editTrackers(buildAnnounceList(meta), opts)
+		if err != nil {
+			return nil, err
+		}
+		if len(tiers) == 0 {
+			return nil, errors.New("the torrent would be left without trackers")
+		}
+
+		// a lone tracker doesn't need an announce list, same as Create
+		if err := setRaw(outer, "announce", tiers[0][0]); err != nil {
+			return nil, err
+		}
+		delete(outer, "announce-list")
+		if len(tiers) > 1 || len(tiers[0]) > 1 {
+			if err := setRaw(outer, "announce-list", tiers); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if opts.Comment != nil {
+		delete(outer, "comment")
+		if *opts.Comment != "" {
+			if err := setRaw(outer, "comment", *opts.Comment); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if len(opts.RemoveWebSeeds) > 0 || len(opts.AddWebSeeds) > 0 {
+		seeds, err := editList(meta.URLList, opts.RemoveWebSeeds, opts.AddWebSeeds, "web seed")
+		if err != nil {
+			return nil, err
+		}
+		delete(outer, "url-list")
+		if len(seeds) > 0 {
+			if err := setRaw(outer, "url-list", seeds); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return bencode.Marshal(outer)
+}
+
+// editTrackers applies the tracker edits of opts to tiers
+func editTrackers(tiers [][]string, opts EditOptions) ([][]string, error) {
+
+	if len(opts.SetTrackers) > 0 {
+		tiers = buildAnnounceList(metaFile{AnnounceList: opts.SetTrackers})
+	}
+
+	has := func(url string) bool {
+		for _, tier := range tiers {
+			if slices.Contains(tier, url) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if len(opts.ReplaceTrackers) > 0 {
+		for _, old := range slices.Sorted(maps.Keys(opts.ReplaceTrackers)) {
+			if !has(old) {
+				return nil, fmt.Errorf("tracker %s not found", old)
+			}
+		}
+		for _, tier := range tiers {
+			for i, url := range tier {
+				if replacement, ok := opts.ReplaceTrackers[url]; ok {
+					tier[i] = replacement
+				}
+			}
+		}
+		tiers = dedupTiers(tiers)
+	}
+
+	for _, url := range opts.RemoveTrackers {
+		if !has(url) {
+			return nil, fmt.Errorf("tracker %s not found", url)
+		}
+		var kept [][]string
+		for _, tier := range tiers {
+			tier = slices.DeleteFunc(tier, func(u string) bool { return u == url })
+			if len(tier) > 0 {
+				kept = append(kept, tier)
+			}
+		}
+		tiers = kept
+	}
+
+	for _, url := range opts.AddTrackers {
+		if !has(url) {
+			tiers = append(tiers, []string{url})
+		}
+	}
+	return tiers, nil
+}
+
+// dedupTiers drops the trackers that already appeared earlier in tiers, and
+// the tiers that are left empty
+func dedupTiers(tiers [][]string) [][]string {
+
+	seen := make(map[string]bool)
+	var kept [][]string
+	for _, tier := range tiers {
+		tier = slices.DeleteFunc(tier, func(url string) bool {
+			dup := seen[url]
+			seen[url] = true
+			return dup
+		})
+		if len(tier) > 0 {
+			kept = append(kept, tier)
+		}
+	}
+	return kept
+}
+
+// editList removes and then adds entries to list, skipping the ones it
+// already has. what names the entries in errors.
+func editList(list []string, remove, add []string, what string) ([]string, error) {
+
+	list = slices.Clone(list)
+	for _, r := range remove {
+		if !slices.Contains(list, r) {
+			return nil, fmt.Errorf("%s %s not found", what, r)
+		}
+		list = slices.DeleteFunc(list, func(s string) bool { return s == r })
+	}
+	for _, a := range add {
+		if !slices.Contains(list, a) {
+			list = append(list, a)
+		}
+	}
+	return list, nil
+}
+
+// setRaw bencodes v and stores it under key
+func setRaw(outer map[string]bencode.RawMessage, key string, v interface{}) error {
+
+	raw, err := bencode.Marshal(v)
+	if err != nil {
+		return err
+	}
+	outer[key] = raw
+	return nil
+}
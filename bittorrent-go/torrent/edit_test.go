@@ -0,0 +1,220 @@
+package torrent
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/lourencovales/codecrafters/bittorrent-go/bencode"
+)
+
+// editInput has an info dictionary with its keys out of order, which would
+// get sorted, and change the info hash, if it were decoded and encoded again
+const editInput = "d8:announce8:http://a" +
+	"13:announce-listll8:http://a8:http://bel8:http://cee" +
+	"7:comment3:old" +
+	"8:url-list13:http://seed1/" +
+	"8:x-custom5:keep!" +
+	"4:infod4:name4:file6:lengthi100e12:piece lengthi16384e6:pieces20:aaaaaaaaaaaaaaaaaaaae" +
+	"e"
+
+func TestEdit(t *testing.T) {
+	str := func(s string) *string { return &s }
+
+	tests := []struct {
+		name     string
+		opts     EditOptions
+		tiers    [][]string
+		comment  string
+		webSeeds []string
+	}{
+		{
+			"nothing",
+			EditOptions{},
+			[][]string{{"http://a", "http://b"}, {"http://c"}},
+			"old",
+			[]string{"http://seed1/"},
+		},
+		{
+			"set trackers",
+			EditOptions{SetTrackers: [][]string{{"http://x", ""}, {}, {"http://y"}}},
+			[][]string{{"http://x"}, {"http://y"}},
+			"old",
+			[]string{"http://seed1/"},
+		},
+		{
+			"replace tracker",
+			EditOptions{ReplaceTrackers: map[string]string{"http://b": "http://new"}},
+			[][]string{{"http://a", "http://new"}, {"http://c"}},
+			"old",
+			[]string{"http://seed1/"},
+		},
+		{
+			"replace trackers without chaining",
+			EditOptions{ReplaceTrackers: map[string]string{"http://a": "http://b", "http://b": "http://c"}},
+			[][]string{{"http://b", "http://c"}},
+			"old",
+			[]string{"http://seed1/"},
+		},
+		{
+			"swap trackers",
+			EditOptions{ReplaceTrackers: map[string]string{"http://a": "http://b", "http://b": "http://a"}},
+			[][]string{{"http://b", "http://a"}, {"http://c"}},
+			"old",
+			[]string{"http://seed1/"},
+		},
+		{
+			"remove trackers",
+			EditOptions{RemoveTrackers: []string{"http://c", "http://a"}},
+			[][]string{{"http://b"}},
+			"old",
+			[]string{"http://seed1/"},
+		},
+		{
+			"add trackers",
+			EditOptions{AddTrackers: []string{"http://b", "http://d"}},
+			[][]string{{"http://a", "http://b"}, {"http://c"}, {"http://d"}},
+			"old",
+			[]string{"http://seed1/"},
+		},
+		{
+			"everything at once",
+			EditOptions{
+				SetTrackers:     [][]string{{"http://x"}},
+				ReplaceTrackers: map[string]string{"http://x": "http://y"},
+				AddTrackers:     []string{"http://z"},
+				Comment:         str("new"),
+				RemoveWebSeeds:  []string{"http://seed1/"},
+				AddWebSeeds:     []string{"http://seed2/", "http://seed3/"},
+			},
+			[][]string{{"http://y"}, {"http://z"}},
+			"new",
+			[]string{"http://seed2/", "http://seed3/"},
+		},
+		{
+			"remove comment and web seeds",
+			EditOptions{Comment: str(""), RemoveWebSeeds: []string{"http://seed1/"}},
+			[][]string{{"http://a", "http://b"}, {"http://c"}},
+			"",
+			nil,
+		},
+	}
+
+	rawInfo, _, err := bencode.Get([]byte(editInput), "info")
+	if err != nil {
+		t.Fatalf("failed to find info dict: %v", err)
+	}
+	before, err := Parse([]byte(editInput))
+	if err != nil {
+		t.Fatalf("failed to parse test torrent: %v", err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := Edit([]byte(editInput), tt.opts)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			gotInfo, _, err := bencode.Get(out, "info")
+			if err != nil || !bytes.Equal(gotInfo, rawInfo) {
+				t.Errorf("info dict changed:\n%s\n%s", rawInfo, gotInfo)
+			}
+			custom, _, err := bencode.Get(out, "x-custom")
+			if err != nil || string(custom) != "5:keep!" {
+				t.Errorf("expected other keys to be kept, got %q", custom)
+			}
+
+			after, err := Parse(out)
+			if err != nil {
+				t.Fatalf("edited torrent doesn't parse: %v", err)
+			}
+			if after.InfoHash != before.InfoHash {
+				t.Error("info hash changed")
+			}
+			if !reflect.DeepEqual(after.AnnounceList, tt.tiers) || after.AnnounceURL != tt.tiers[0][0] {
+				t.Errorf("expected trackers %v, got %s %v", tt.tiers, after.AnnounceURL, after.AnnounceList)
+			}
+			if after.Comment != tt.comment {
+				t.Errorf("expected comment %q, got %q", tt.comment, after.Comment)
+			}
+			if !reflect.DeepEqual(after.WebSeeds, tt.webSeeds) {
+				t.Errorf("expected web seeds %v, got %v", tt.webSeeds, after.WebSeeds)
+			}
+		})
+	}
+}
+
+func TestEditSingleTracker(t *testing.T) {
+	// a single tracker left over doesn't get an announce list
+	out, err := Edit([]byte(editInput), EditOptions{SetTrackers: [][]string{{"http://only"}}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, _, err := bencode.Get(out, "announce-list"); err == nil {
+		t.Error("expected no announce list")
+	}
+	announce, _, _ := bencode.Get(out, "announce")
+	if string(announce) != "11:http://only" {
+		t.Errorf("unexpected announce %s", announce)
+	}
+}
+
+func TestEditTrackerless(t *testing.T) {
+	input := "d7:comment3:old5:nodesll9:127.0.0.1i6881eee" +
+		"4:infod4:name4:file6:lengthi100e12:piece lengthi16384e6:pieces20:aaaaaaaaaaaaaaaaaaaae" +
+		"e"
+	comment := "new"
+
+	out, err := Edit([]byte(input), EditOptions{Comment: &comment, AddWebSeeds: []string{"http://seed/"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, key := range []string{"announce", "announce-list"} {
+		if _, _, err := bencode.Get(out, key); err == nil {
+			t.Errorf("expected no %s", key)
+		}
+	}
+	after, err := Parse(out)
+	if err != nil {
+		t.Fatalf("edited torrent doesn't parse: %v", err)
+	}
+	if after.Comment != "new" || !reflect.DeepEqual(after.WebSeeds, []string{"http://seed/"}) || len(after.Nodes) != 1 {
+		t.Errorf("unexpected result %+v", after)
+	}
+
+	// adding a tracker gives it one
+	out, err = Edit([]byte(input), EditOptions{AddTrackers: []string{"http://a"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if announce, _, _ := bencode.Get(out, "announce"); string(announce) != "8:http://a" {
+		t.Errorf("unexpected announce %s", announce)
+	}
+}
+
+func TestEditErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		opts  EditOptions
+		err   string
+	}{
+		{"not bencode", "nope", EditOptions{}, "failed to unmarshal"},
+		{"no info", "d8:announce8:http://ae", EditOptions{}, "info dict not found"},
+		{"remove every tracker", editInput, EditOptions{RemoveTrackers: []string{"http://a", "http://b", "http://c"}}, "without trackers"},
+		{"remove unknown tracker", editInput, EditOptions{RemoveTrackers: []string{"http://nope"}}, "tracker http://nope not found"},
+		{"replace unknown tracker", editInput, EditOptions{ReplaceTrackers: map[string]string{"http://nope": "http://x"}}, "not found"},
+		{"remove unknown web seed", editInput, EditOptions{RemoveWebSeeds: []string{"http://nope"}}, "web seed http://nope not found"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Edit([]byte(tt.input), tt.opts)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("expected error containing %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
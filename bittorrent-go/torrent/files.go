@@ -0,0 +1,163 @@
+package torrent
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// File is one of the files that make up a torrent.
+type File struct {
+	// Path holds the components of the file's path, relative to the
+	// torrent's directory for multi-file torrents.
+	Path   []string
+	Length int
+	// Offset is where the file's data starts, counting from the start of the
+	// first piece.
+	Offset int
+	// Padding files only exist to line the next file up with a piece
+	// boundary. They're all zeros and never written to disk.
+	Padding bool
+	// PiecesRoot is the root of the file's SHA-256 merkle tree, for v2 and
+	// hybrid torrents (BEP 52).
+	PiecesRoot [32]byte
+	// PieceLayer holds the merkle tree hashes of each of the file's pieces,
+	// for v2 files larger than a piece. Smaller files only have their root.
+	PieceLayer [][32]byte
+}
+
+// FileSection is the part of a piece that belongs to a single file.
+type FileSection struct {
+	File        int // index into TorrentInfo.FileList()
+	FileOffset  int // where the section starts within the file
+	PieceOffset int // where the section starts within the piece
+	Length      int
+}
+
+// buildFiles works out the list of files described by an info dictionary,
+// along with where each one starts in the torrent's data.
+func buildFiles(info infoDict) ([]File, error) {
+
+	if info.Files == nil {
+		if info.Length <= 0 {
+			return nil, fmt.Errorf("file len not found or invalid")
+		}
+		return []File{{Path: []string{info.Name}, Length: info.Length}}, nil
+	}
+
+	if info.Length != 0 {
+		return nil, fmt.Errorf("torrent has both 'length' and 'files'")
+	}
+	if len(info.Files) == 0 {
+		return nil, fmt.Errorf("files list is empty")
+	}
+	if err := checkPathElem(info.Name); err != nil {
+		return nil, fmt.Errorf("invalid torrent name: %w", err)
+	}
+
+	files := make([]File, len(info.Files))
+	offset := 0
+	for i, f := range info.Files {
+		if f.Length < 0 {
+			return nil, fmt.Errorf("file %d has an invalid length", i)
+		}
+		if len(f.Path) == 0 {
+			return nil, fmt.Errorf("file %d has no path", i)
+		}
+		for _, elem := range f.Path {
+			if err := checkPathElem(elem); err != nil {
+				return nil, fmt.Errorf("file %d has an invalid path: %w", i, err)
+			}
+		}
+		files[i] = File{Path: f.Path, Length: f.Length, Offset: offset, Padding: strings.Contains(f.Attr, "p")}
+		offset += f.Length
+	}
+	return files, nil
+}
+
+// checkPathElem makes sure that a path component coming from a torrent can't
+// be used to write outside of the download directory.
+func checkPathElem(elem string) error {
+
+	switch {
+	case elem == "":
+		return fmt.Errorf("empty path component")
+	case elem == "." || elem == "..":
+		return fmt.Errorf("path component %q not allowed", elem)
+	case strings.ContainsAny(elem, "/\\\x00"):
+		return fmt.Errorf("path component %q contains a separator", elem)
+	}
+	return nil
+}
+
+// FileList returns Files, or when there are none, as with a TorrentInfo that
+// was put together by hand, a single file holding all of the data.
+func (ti *TorrentInfo) FileList() []File {
+	if len(ti.Files) == 0 {
+		return []File{{Path: []string{ti.Name}, Length: ti.TotalLength}}
+	}
+	return ti.Files
+}
+
+// PieceCount returns the number of pieces. v2 only torrents don't have a
+// SHA-1 hash for each piece, so it's worked out from the length instead.
+func (ti *TorrentInfo) PieceCount() int {
+	if len(ti.PieceHashes) > 0 || ti.PieceLength <= 0 {
+		return len(ti.PieceHashes)
+	}
+	return (ti.TotalLength + ti.PieceLength - 1) / ti.PieceLength
+}
+
+// PieceSize returns the length of the piece at index, which is PieceLength
+// for all but the last one.
+func (ti *TorrentInfo) PieceSize(index int) int {
+
+	if index == ti.PieceCount()-1 {
+		if rest := ti.TotalLength % ti.PieceLength; rest != 0 {
+			return rest
+		}
+	}
+	return ti.PieceLength
+}
+
+// PieceSpan returns the first and last pieces holding data of the file at
+// index of FileList(). ok is false for empty files, which aren't in any piece.
+func (ti *TorrentInfo) PieceSpan(index int) (first, last int, ok bool) {
+
+	f := ti.FileList()[index]
+	if f.Length == 0 {
+		return 0, 0, false
+	}
+	return f.Offset / ti.PieceLength, (f.Offset + f.Length - 1) / ti.PieceLength, true
+}
+
+// PieceSections maps the piece at index onto the files it holds data for, in
+// order. Empty files never show up, since no piece holds any of their data.
+func (ti *TorrentInfo) PieceSections(index int) []FileSection {
+
+	start := index * ti.PieceLength
+	end := start + ti.PieceSize(index)
+	files := ti.FileList()
+
+	// the first file that ends after the start of the piece
+	first := sort.Search(len(files), func(i int) bool {
+		return files[i].Offset+files[i].Length > start
+	})
+
+	var sections []FileSection
+	for i := first; i < len(files) && files[i].Offset < end; i++ {
+		f := files[i]
+		if f.Length == 0 {
+			continue
+		}
+		from := max(start, f.Offset)
+		to := min(end, f.Offset+f.Length)
+		sections = append(sections, FileSection{
+			File:        i,
+			FileOffset:  from - f.Offset,
+			PieceOffset: from - start,
+			Length:      to - from,
+		})
+	}
+	return sections
+}
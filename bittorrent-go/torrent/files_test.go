@@ -0,0 +1,143 @@
+package torrent
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBuildFiles(t *testing.T) {
+	info := infoDict{
+		Name: "dir",
+		Files: []fileDict{
+			{Length: 5, Path: []string{"a.txt"}},
+			{Length: 0, Path: []string{"empty"}},
+			{Length: 7, Path: []string{"sub", "b.txt"}},
+		},
+	}
+
+	files, err := buildFiles(info)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []File{
+		{Path: []string{"a.txt"}, Length: 5, Offset: 0},
+		{Path: []string{"empty"}, Length: 0, Offset: 5},
+		{Path: []string{"sub", "b.txt"}, Length: 7, Offset: 5},
+	}
+	if !reflect.DeepEqual(files, expected) {
+		t.Errorf("expected %v, got %v", expected, files)
+	}
+}
+
+func TestBuildFilesErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		info infoDict
+		err  string
+	}{
+		{"no length or files", infoDict{Name: "x"}, "file len not found or invalid"},
+		{"both length and files", infoDict{Name: "x", Length: 1, Files: []fileDict{{Length: 1, Path: []string{"a"}}}}, "both"},
+		{"empty files list", infoDict{Name: "x", Files: []fileDict{}}, "files list is empty"},
+		{"negative length", infoDict{Name: "x", Files: []fileDict{{Length: -1, Path: []string{"a"}}}}, "invalid length"},
+		{"no path", infoDict{Name: "x", Files: []fileDict{{Length: 1}}}, "no path"},
+		{"parent directory", infoDict{Name: "x", Files: []fileDict{{Length: 1, Path: []string{"..", "a"}}}}, "not allowed"},
+		{"separator", infoDict{Name: "x", Files: []fileDict{{Length: 1, Path: []string{"a/b"}}}}, "separator"},
+		{"empty component", infoDict{Name: "x", Files: []fileDict{{Length: 1, Path: []string{""}}}}, "empty"},
+		{"bad name", infoDict{Name: "..", Files: []fileDict{{Length: 1, Path: []string{"a"}}}}, "invalid torrent name"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := buildFiles(tt.info)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("expected error containing %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestPieceSize(t *testing.T) {
+	info := &TorrentInfo{PieceHashes: make([][20]byte, 3), PieceLength: 10, TotalLength: 25}
+	for i, expected := range []int{10, 10, 5} {
+		if size := info.PieceSize(i); size != expected {
+			t.Errorf("expected piece %d to be %d bytes, got %d", i, expected, size)
+		}
+	}
+
+	info.TotalLength = 30
+	if size := info.PieceSize(2); size != 10 {
+		t.Errorf("expected last piece to be 10 bytes, got %d", size)
+	}
+}
+
+func TestPieceSpan(t *testing.T) {
+	info := &TorrentInfo{
+		PieceLength: 10,
+		TotalLength: 35,
+		Files: []File{
+			{Path: []string{"a"}, Length: 10, Offset: 0},
+			{Path: []string{"empty"}, Length: 0, Offset: 10},
+			{Path: []string{"b"}, Length: 21, Offset: 10},
+			{Path: []string{"c"}, Length: 4, Offset: 31},
+		},
+	}
+
+	tests := []struct {
+		first, last int
+		ok          bool
+	}{
+		{0, 0, true},
+		{0, 0, false},
+		{1, 3, true},
+		{3, 3, true},
+	}
+
+	for i, tt := range tests {
+		first, last, ok := info.PieceSpan(i)
+		if first != tt.first || last != tt.last || ok != tt.ok {
+			t.Errorf("file %d: expected %d-%d %v, got %d-%d %v", i, tt.first, tt.last, tt.ok, first, last, ok)
+		}
+	}
+}
+
+func TestPieceSections(t *testing.T) {
+	// a (5) | empty (0) | b (12) | c (3), in 8 byte pieces:
+	// piece 0: a[0:5] b[0:3], piece 1: b[3:11], piece 2: b[11:12] c[0:3]
+	info := &TorrentInfo{
+		PieceHashes: make([][20]byte, 3),
+		PieceLength: 8,
+		TotalLength: 20,
+		Files: []File{
+			{Path: []string{"a"}, Length: 5, Offset: 0},
+			{Path: []string{"empty"}, Length: 0, Offset: 5},
+			{Path: []string{"b"}, Length: 12, Offset: 5},
+			{Path: []string{"c"}, Length: 3, Offset: 17},
+		},
+		MultiFile: true,
+	}
+
+	expected := [][]FileSection{
+		{{File: 0, FileOffset: 0, PieceOffset: 0, Length: 5}, {File: 2, FileOffset: 0, PieceOffset: 5, Length: 3}},
+		{{File: 2, FileOffset: 3, PieceOffset: 0, Length: 8}},
+		{{File: 2, FileOffset: 11, PieceOffset: 0, Length: 1}, {File: 3, FileOffset: 0, PieceOffset: 1, Length: 3}},
+	}
+
+	for i := range expected {
+		sections := info.PieceSections(i)
+		if !reflect.DeepEqual(sections, expected[i]) {
+			t.Errorf("piece %d: expected %v, got %v", i, expected[i], sections)
+		}
+	}
+}
+
+func TestPieceSectionsSingleFile(t *testing.T) {
+	// built by hand, without a file list
+	info := &TorrentInfo{PieceHashes: make([][20]byte, 2), PieceLength: 10, TotalLength: 15}
+
+	expected := []FileSection{{File: 0, FileOffset: 10, PieceOffset: 0, Length: 5}}
+	if sections := info.PieceSections(1); !reflect.DeepEqual(sections, expected) {
+		t.Errorf("expected %v, got %v", expected, sections)
+	}
+}
@@ -0,0 +1,60 @@
+package torrent
+
+import (
+	"crypto/sha256"
+)
+
+// MerkleBlockSize is the size of the leaves of the BEP 52 merkle trees: each
+// leaf is the SHA-256 hash of 16 KiB of a file, the last one possibly shorter.
+const MerkleBlockSize = 16 << 10
+
+// blockHashes hashes data in MerkleBlockSize blocks, giving the leaves of its
+// merkle tree
+func blockHashes(data []byte) [][32]byte {
+
+	hashes := make([][32]byte, 0, (len(data)+MerkleBlockSize-1)/MerkleBlockSize)
+	for start := 0; start < len(data); start += MerkleBlockSize {
+		end := min(start+MerkleBlockSize, len(data))
+		hashes = append(hashes, sha256.Sum256(data[start:end]))
+	}
+	return hashes
+}
+
+// merkleRoot computes the root of the tree built on top of leaves, after
+// padding them with pad up to width, which must be a power of two at least as
+// large as len(leaves).
+func merkleRoot(leaves [][32]byte, width int, pad [32]byte) [32]byte {
+
+	layer := make([][32]byte, width)
+	copy(layer, leaves)
+	for i := len(leaves); i < width; i++ {
+		layer[i] = pad
+	}
+
+	var pair [64]byte
+	for len(layer) > 1 {
+		for i := 0; i < len(layer)/2; i++ {
+			copy(pair[:32], layer[2*i][:])
+			copy(pair[32:], layer[2*i+1][:])
+			layer[i] = sha256.Sum256(pair[:])
+		}
+		layer = layer[:len(layer)/2]
+	}
+	return layer[0]
+}
+
+// padHash is the root of a tree of width all zero leaves, which is what the
+// piece layer gets padded with, width being the number of blocks in a piece
+func padHash(width int) [32]byte {
+	var zero [32]byte
+	return merkleRoot(nil, width, zero)
+}
+
+// nextPow2 returns the smallest power of two that's at least n
+func nextPow2(n int) int {
+	p := 1
+	for p < n {
+		p *= 2
+	}
+	return p
+}
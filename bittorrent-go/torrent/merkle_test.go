@@ -0,0 +1,87 @@
+package torrent
+
+import (
+	"crypto/sha256"
+	"testing"
+)
+
+func TestNextPow2(t *testing.T) {
+	tests := []struct {
+		n, expected int
+	}{
+		{0, 1}, {1, 1}, {2, 2}, {3, 4}, {4, 4}, {5, 8}, {1000, 1024},
+	}
+
+	for _, tt := range tests {
+		if got := nextPow2(tt.n); got != tt.expected {
+			t.Errorf("nextPow2(%d): expected %d, got %d", tt.n, tt.expected, got)
+		}
+	}
+}
+
+func TestBlockHashes(t *testing.T) {
+	data := testData(2*MerkleBlockSize+10, 7)
+	hashes := blockHashes(data)
+
+	if len(hashes) != 3 {
+		t.Fatalf("expected 3 blocks, got %d", len(hashes))
+	}
+	if hashes[2] != sha256.Sum256(data[2*MerkleBlockSize:]) {
+		t.Error("expected the last block to be hashed short, not padded")
+	}
+	if len(blockHashes(nil)) != 0 {
+		t.Error("expected no blocks for no data")
+	}
+}
+
+func TestMerkleRoot(t *testing.T) {
+	a := sha256.Sum256([]byte("a"))
+	b := sha256.Sum256([]byte("b"))
+	var zero [32]byte
+
+	pair := func(l, r [32]byte) [32]byte {
+		return sha256.Sum256(append(l[:], r[:]...))
+	}
+
+	tests := []struct {
+		name     string
+		leaves   [][32]byte
+		width    int
+		pad      [32]byte
+		expected [32]byte
+	}{
+		{"single leaf", [][32]byte{a}, 1, zero, a},
+		{"two leaves", [][32]byte{a, b}, 2, zero, pair(a, b)},
+		{"padded with zeros", [][32]byte{a}, 2, zero, pair(a, zero)},
+		{"padded with pad", [][32]byte{a, b, a}, 4, b, pair(pair(a, b), pair(a, b))},
+		{"all padding", nil, 2, zero, pair(zero, zero)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := merkleRoot(tt.leaves, tt.width, tt.pad); got != tt.expected {
+				t.Errorf("expected %x, got %x", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestMerkleRootPieceLayer(t *testing.T) {
+	// hashing up from the piece layer, padded with padHash, has to give the
+	// same root as hashing the whole file's blocks
+	const pieceLength = 4 * MerkleBlockSize
+	data := testData(5*pieceLength+100, 3)
+	var zero [32]byte
+
+	leaves := blockHashes(data)
+	full := merkleRoot(leaves, nextPow2(len(leaves)), zero)
+
+	var layer [][32]byte
+	for start := 0; start < len(data); start += pieceLength {
+		end := min(start+pieceLength, len(data))
+		layer = append(layer, merkleRoot(blockHashes(data[start:end]), pieceLength/MerkleBlockSize, zero))
+	}
+	if got := merkleRoot(layer, nextPow2(len(layer)), padHash(pieceLength/MerkleBlockSize)); got != full {
+		t.Errorf("expected %x, got %x", full, got)
+	}
+}
@@ -1,12 +1,16 @@
 package torrent
 
 import (
-	"bytes"
 	"crypto/sha1"
+	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
+	"net"
 	"os"
+	"path"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/lourencovales/codecrafters/bittorrent-go/bencode"
 )
@@ -14,27 +18,164 @@ import (
 // TorrentInfo holds the metadata parsed from a .torrent file.
 type TorrentInfo struct {
 	AnnounceURL string
-	InfoHash    [20]byte
+	// AnnounceList holds the tiers of trackers from 'announce-list' (BEP 12),
+	// or just AnnounceURL when the torrent doesn't have one.
+	AnnounceList [][]string
+	// InfoHash is what the torrent goes by on trackers and with peers: the
+	// SHA-1 hash of the info dictionary, or for v2 only torrents the
+	// truncated InfoHashV2.
+	InfoHash [20]byte
+	// InfoHashV2 is the SHA-256 hash of the info dictionary, for v2 and
+	// hybrid torrents (BEP 52).
+	InfoHashV2 [32]byte
+	// MetaVersion is 1 for plain torrents and 2 for v2 and hybrid ones.
+	MetaVersion int
+	// PieceHashes holds the SHA-1 hash of each piece. v2 only torrents don't
+	// have them, their pieces are checked against the files' merkle trees.
 	PieceHashes [][20]byte
 	PieceLength int
 	TotalLength int
+	// Name is the suggested name of the file, or of the directory holding
+	// the files for multi-file torrents.
+	Name string
+	// Files lists the files the torrent is made of, in the order their data
+	// is laid out in the pieces. Single file torrents have exactly one, named
+	// after the torrent.
+	Files     []File
+	MultiFile bool
+
+	// The rest is informational, and all of it optional.
+	Comment      string
+	CreatedBy    string
+	CreationDate time.Time // zero when the torrent doesn't say
+	// Private torrents only get peers from their trackers (BEP 27).
+	Private bool
+	// Source is a tag some trackers put in the info dictionary, to make the
+	// info hash unique to them.
+	Source string
+	// WebSeeds are the 'url-list' HTTP(S) URLs serving the same content
+	// (BEP 19).
+	WebSeeds []string
+	// Nodes are the host:port addresses of DHT nodes to join from, which
+	// trackerless torrents carry instead of trackers (BEP 5).
+	Nodes []string
 }
 
 // String provides a human-readable summary of the torrent's metadata.
 func (ti *TorrentInfo) String() string {
 
 	var sb strings.Builder
+	if ti.Name != "" {
+		sb.WriteString(fmt.Sprintf("Name: %s\n", ti.Name))
+	}
 	sb.WriteString(fmt.Sprintf("Tracker URL: %s\n", ti.AnnounceURL))
+	if len(ti.AnnounceList) > 1 || len(ti.AnnounceList) == 1 && len(ti.AnnounceList[0]) > 1 {
+		sb.WriteString("Trackers:\n")
+		for i, tier := range ti.AnnounceList {
+			sb.WriteString(fmt.Sprintf("  Tier %d: %s\n", i+1, strings.Join(tier, ", ")))
+		}
+	}
+	for _, ws := range ti.WebSeeds {
+		sb.WriteString(fmt.Sprintf("Web Seed: %s\n", ws))
+	}
+	if ti.Comment != "" {
+		sb.WriteString(fmt.Sprintf("Comment: %s\n", ti.Comment))
+	}
+	if ti.CreatedBy != "" {
+		sb.WriteString(fmt.Sprintf("Created By: %s\n", ti.CreatedBy))
+	}
+	if !ti.CreationDate.IsZero() {
+		sb.WriteString(fmt.Sprintf("Creation Date: %s\n", ti.CreationDate.Format(time.RFC3339)))
+	}
+	if ti.Private {
+		sb.WriteString("Private: yes\n")
+	}
+	if ti.Source != "" {
+		sb.WriteString(fmt.Sprintf("Source: %s\n", ti.Source))
+	}
 	sb.WriteString(fmt.Sprintf("Length: %d\n", ti.TotalLength))
 	sb.WriteString(fmt.Sprintf("Info Hash: %x\n", ti.InfoHash))
+	if ti.IsV2() {
+		sb.WriteString(fmt.Sprintf("Info Hash v2: %x\n", ti.InfoHashV2))
+	}
 	sb.WriteString(fmt.Sprintf("Piece Length: %d\n", ti.PieceLength))
 	sb.WriteString("Piece Hashes:\n")
 	for _, hash := range ti.PieceHashes {
 		sb.WriteString(fmt.Sprintf("%s\n", hex.EncodeToString(hash[:])))
 	}
+	if ti.MultiFile {
+		sb.WriteString("Files:\n")
+		for _, f := range ti.Files {
+			if f.Padding {
+				continue
+			}
+			sb.WriteString(fmt.Sprintf("%s (%d bytes)\n", path.Join(f.Path...), f.Length))
+		}
+	}
 	return sb.String()
 }
 
+// metaFile mirrors the layout of a .torrent file, so that bencode can fill it
+// in directly, or write it out when creating one.
+type metaFile struct {
+	Announce     string     `bencode:"announce,omitempty"`
+	AnnounceList [][]string `bencode:"announce-list,omitempty"`
+	Comment      string     `bencode:"comment,omitempty"`
+	CreatedBy    string     `bencode:"created by,omitempty"`
+	CreationDate int64      `bencode:"creation date,omitempty"`
+	URLList      urlList    `bencode:"url-list,omitempty"`
+	// Nodes is a list of [host, port] pairs. It's left undecoded so that a
+	// malformed one doesn't make the whole torrent unreadable.
+	Nodes interface{} `bencode:"nodes,omitempty"`
+	Info  infoDict    `bencode:"info"`
+	// PieceLayers maps the pieces root of each v2 file larger than a piece
+	// to the hashes of its pieces, one after the other.
+	PieceLayers map[string]string `bencode:"piece layers,omitempty"`
+}
+
+// infoDict is the 'info' dictionary of a .torrent file. Single file torrents
+// have a length, multi-file ones a list of files instead.
+type infoDict struct {
+	Name        string             `bencode:"name"`
+	Length      int                `bencode:"length,omitempty"`
+	Files       []fileDict         `bencode:"files,omitempty"`
+	PieceLength int                `bencode:"piece length"`
+	Pieces      string             `bencode:"pieces"`
+	Private     int                `bencode:"private,omitempty"`
+	Source      string             `bencode:"source,omitempty"`
+	MetaVersion int                `bencode:"meta version,omitempty"`
+	FileTree    bencode.RawMessage `bencode:"file tree,omitempty"`
+}
+
+// urlList holds the web seeds of a torrent (BEP 19). Torrents with a single
+// web seed often have it as a plain string rather than a list.
+type urlList []string
+
+// UnmarshalBencode accepts either a string or a list of strings.
+func (l *urlList) UnmarshalBencode(data []byte) error {
+
+	var single string
+	if err := bencode.Unmarshal(data, &single); err == nil {
+		*l = urlList{single}
+		return nil
+	}
+
+	var list []string
+	if err := bencode.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("'url-list' is neither a string nor a list of strings")
+	}
+	*l = list
+	return nil
+}
+
+// fileDict is an entry of the 'files' list of a multi-file torrent. Padding
+// files, used by hybrid torrents, have a 'p' in their attributes (BEP 47).
+type fileDict struct {
+	Length int      `bencode:"length"`
+	Path   []string `bencode:"path"`
+	Attr   string   `bencode:"attr,omitempty"`
+}
+
 // ParseFile reads and decodes a .torrent file.
 func ParseFile(filename string) (*TorrentInfo, error) {
 
@@ -42,84 +183,243 @@ func ParseFile(filename string) (*TorrentInfo, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to read torrent file: %w", err)
 	}
+	return Parse(file)
+}
 
-	decodedData, err := bencode.Unmarshal(file)
-	if err != nil {
+// Parse decodes the contents of a .torrent file.
+func Parse(file []byte) (*TorrentInfo, error) {
+
+	var meta metaFile
+	if err := bencode.Unmarshal(file, &meta); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal bencoded file: %w", err)
 	}
 
-	metaDict, ok := decodedData.(map[string]interface{})
-	if !ok {
-		return nil, fmt.Errorf("invalid torrent file format")
+	rawInfo, err := findInfoDict(file)
+	if err != nil {
+		return nil, err
 	}
 
-	announceURL, ok := metaDict["announce"].(string)
-	if !ok {
-		return nil, fmt.Errorf("announce URL not found or invalid")
+	info, err := fromInfoDict(meta.Info, rawInfo, meta.PieceLayers)
+	if err != nil {
+		return nil, err
+	}
+	// torrents without trackers are left to the DHT
+	info.AnnounceList = buildAnnounceList(meta)
+	info.AnnounceURL = meta.Announce
+	if info.AnnounceURL == "" && len(info.AnnounceList) > 0 {
+		info.AnnounceURL = info.AnnounceList[0][0]
+	}
+	info.Nodes = buildNodes(meta.Nodes)
+	info.Comment = meta.Comment
+	info.CreatedBy = meta.CreatedBy
+	if meta.CreationDate > 0 {
+		info.CreationDate = time.Unix(meta.CreationDate, 0).UTC()
 	}
+	info.WebSeeds = meta.URLList
+	return info, nil
+}
+
+// ParseInfo builds a TorrentInfo out of a bare info dictionary, such as one
+// fetched from peers for a magnet link, and the trackers known for it, which
+// may be none. There are no piece layers outside of a .torrent file, so v2
+// only torrents with files larger than a piece can't be checked and are
+// refused, while hybrid ones fall back on their SHA-1 piece hashes.
+func ParseInfo(rawInfo []byte, trackers [][]string) (*TorrentInfo, error) {
 
-	infoDict, ok := metaDict["info"].(map[string]interface{})
-	if !ok {
-		return nil, fmt.Errorf("info dict not found")
+	var dict infoDict
+	if err := bencode.Unmarshal(rawInfo, &dict); err != nil {
+		return nil, fmt.Errorf("failed to decode info dict: %w", err)
 	}
 
-	infoHash, err := hashInfoDict(file)
+	info, err := fromInfoDict(dict, rawInfo, nil)
 	if err != nil {
 		return nil, err
 	}
+	info.AnnounceList = buildAnnounceList(metaFile{AnnounceList: trackers})
+	if len(info.AnnounceList) > 0 {
+		info.AnnounceURL = info.AnnounceList[0][0]
+	}
+	return info, nil
+}
 
-	pieceLength, ok := infoDict["piece length"].(int)
-	if !ok {
+// fromInfoDict does the bulk of the parsing, everything that only depends on
+// the info dictionary, and the piece layers for v2 torrents. The trackers are
+// left for the caller to fill in.
+func fromInfoDict(dict infoDict, rawInfo []byte, pieceLayers map[string]string) (*TorrentInfo, error) {
+
+	if dict.PieceLength <= 0 {
 		return nil, fmt.Errorf("piece len not found or invalid")
 	}
 
-	totalLength, ok := infoDict["length"].(int)
-	if !ok {
-		return nil, fmt.Errorf("file len not found or invalid")
+	version := dict.MetaVersion
+	switch version {
+	case 0:
+		version = 1
+	case 1, 2:
+	default:
+		return nil, fmt.Errorf("unsupported meta version %d", version)
 	}
+	hasV1 := dict.Pieces != "" || version == 1
 
-	piecesStr, ok := infoDict["pieces"].(string)
-	if !ok {
-		return nil, fmt.Errorf("pieces not found or invalid")
+	var err error
+	var v2Files []v2File
+	if version == 2 {
+		if err := checkV2PieceLength(dict.PieceLength); err != nil {
+			return nil, err
+		}
+		if dict.FileTree == nil {
+			return nil, fmt.Errorf("file tree not found")
+		}
+		if v2Files, err = walkFileTree(dict.FileTree, nil, nil); err != nil {
+			return nil, err
+		}
+		if len(v2Files) == 0 {
+			return nil, fmt.Errorf("file tree is empty")
+		}
 	}
 
-	pieceHashes, err := splitPieceHashes([]byte(piecesStr))
-	if err != nil {
-		return nil, err
+	// v1 and hybrid torrents lay their files out in the v1 file list, where
+	// hybrid ones have explicit padding files
+	var files []File
+	var pieceHashes [][20]byte
+	multiFile := dict.Files != nil
+	if hasV1 {
+		if files, err = buildFiles(dict); err != nil {
+			return nil, err
+		}
+
+		if dict.Pieces == "" {
+			return nil, fmt.Errorf("pieces not found or invalid")
+		}
+
+		if pieceHashes, err = splitPieceHashes([]byte(dict.Pieces)); err != nil {
+			return nil, err
+		}
+
+		if version == 2 {
+			if err := matchV2Files(files, v2Files, dict.PieceLength); err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		files = layoutV2(v2Files, dict.PieceLength)
+		multiFile = len(v2Files) != 1 || len(v2Files[0].Path) != 1 || v2Files[0].Path[0] != dict.Name
+	}
+
+	// hybrid torrents without piece layers still have their SHA-1 hashes
+	if version == 2 && (pieceLayers != nil || !hasV1) {
+		if err := loadPieceLayers(files, pieceLayers, dict.PieceLength); err != nil {
+			return nil, err
+		}
+	}
+
+	totalLength := 0
+	for _, f := range files {
+		totalLength += f.Length
+	}
+	if totalLength == 0 {
+		return nil, fmt.Errorf("torrent is empty")
+	}
+
+	// the pieces have to cover the files exactly, or we'd either read past
+	// the last piece or leave data out when mapping pieces onto files
+	numPieces := (totalLength + dict.PieceLength - 1) / dict.PieceLength
+	if hasV1 && len(pieceHashes) != numPieces {
+		return nil, fmt.Errorf("torrent has %d pieces but its files need %d", len(pieceHashes), numPieces)
 	}
 
-	return &TorrentInfo{
-		AnnounceURL: announceURL,
-		InfoHash:    infoHash,
+	info := &TorrentInfo{
+		InfoHash:    sha1.Sum(rawInfo),
+		MetaVersion: version,
 		PieceHashes: pieceHashes,
-		PieceLength: pieceLength,
+		PieceLength: dict.PieceLength,
 		TotalLength: totalLength,
-	}, nil
+		Name:        dict.Name,
+		Files:       files,
+		MultiFile:   multiFile,
+		Private:     dict.Private == 1,
+		Source:      dict.Source,
+	}
+	if version == 2 {
+		info.InfoHashV2 = sha256.Sum256(rawInfo)
+		if !hasV1 {
+			info.InfoHash = info.InfoHashV2Truncated()
+		}
+	}
+	return info, nil
 }
 
-// hashInfoDictionary locates the raw bencoded 'info' dictionary and computes
-// its SHA1 hash.
-func hashInfoDict(fileBytes []byte) ([20]byte, error) {
+// buildAnnounceList returns the tiers of trackers of the torrent. Per BEP 12,
+// when there's an 'announce-list' it's used instead of 'announce' altogether.
+// Empty URLs and tiers are dropped.
+func buildAnnounceList(meta metaFile) [][]string {
 
-	// need to figure out where the info dict is
-	infoStart := bytes.Index(fileBytes, []byte("4:info"))
-	if infoStart == -1 {
-		return [20]byte{}, fmt.Errorf("'info' dict not found")
+	var tiers [][]string
+	for _, tier := range meta.AnnounceList {
+		var urls []string
+		for _, u := range tier {
+			if u != "" {
+				urls = append(urls, u)
+			}
+		}
+		if len(urls) > 0 {
+			tiers = append(tiers, urls)
+		}
 	}
 
-	infoSlice := fileBytes[infoStart+len("4:info"):]
+	if len(tiers) == 0 && meta.Announce != "" {
+		tiers = [][]string{{meta.Announce}}
+	}
+	return tiers
+}
 
-	infoDecoded, err := bencode.Unmarshal(infoSlice)
-	if err != nil {
-		return [20]byte{}, fmt.Errorf("failed to decode info dict: %w", err)
+// buildNodes returns the host:port addresses of the torrent's DHT nodes,
+// skipping the entries that aren't a [host, port] pair.
+func buildNodes(nodes interface{}) []string {
+
+	list, _ := nodes.([]interface{})
+	var addrs []string
+	for _, n := range list {
+		pair, ok := n.([]interface{})
+		if !ok || len(pair) != 2 {
+			continue
+		}
+		host, ok := pair[0].(string)
+		port, ok2 := pair[1].(int)
+		if !ok || !ok2 || host == "" || port <= 0 || port > 65535 {
+			continue
+		}
+		addrs = append(addrs, net.JoinHostPort(host, strconv.Itoa(port)))
 	}
+	return addrs
+}
+
+// hashInfoDict locates the raw bencoded 'info' dictionary and computes its
+// SHA1 hash over the exact bytes found in the file, so that torrents that
+// weren't encoded canonically still hash to the right value.
+func hashInfoDict(fileBytes []byte) ([20]byte, error) {
 
-	infoBytes, err := bencode.Marshal(infoDecoded)
+	rawInfo, err := findInfoDict(fileBytes)
 	if err != nil {
-		return [20]byte{}, fmt.Errorf("failed to re-marshal info dict: %w", err)
+		return [20]byte{}, err
 	}
+	return sha1.Sum(rawInfo), nil
+}
 
-	return sha1.Sum(infoBytes), nil
+// findInfoDict returns the raw bytes of the 'info' dictionary, which is what
+// both the v1 and v2 info hashes are computed over.
+func findInfoDict(fileBytes []byte) (bencode.RawMessage, error) {
+
+	var raw struct {
+		Info bencode.RawMessage `bencode:"info"`
+	}
+	if err := bencode.Unmarshal(fileBytes, &raw); err != nil {
+		return nil, fmt.Errorf("failed to decode info dict: %w", err)
+	}
+	if raw.Info == nil {
+		return nil, fmt.Errorf("'info' dict not found")
+	}
+	return raw.Info, nil
 }
 
 // splitPieceHashes converts the concatenated "pieces" string into a slice of
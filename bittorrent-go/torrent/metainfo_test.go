@@ -1,9 +1,13 @@
 package torrent
 
 import (
+	"crypto/sha1"
 	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/lourencovales/codecrafters/bittorrent-go/bencode"
 )
@@ -145,6 +149,35 @@ func TestHashInfoDict(t *testing.T) {
 	}
 }
 
+func TestHashInfoDictRawBytes(t *testing.T) {
+	// the announce URL contains "4:info" and the info dict isn't sorted, both
+	// of which used to throw off the hash
+	info := "d6:lengthi1000e4:name8:test.txt12:piece lengthi262144e6:pieces20:01234567890123456789e"
+	unsorted := "d4:name8:test.txt6:lengthi1000e12:piece lengthi262144e6:pieces20:01234567890123456789e"
+
+	tests := []struct {
+		name string
+		info string
+	}{
+		{"canonical", info},
+		{"unsorted keys", unsorted},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := "d8:announce27:http://x.example/4:info/ann4:info" + tt.info + "e"
+
+			hash, err := hashInfoDict([]byte(file))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if hash != sha1.Sum([]byte(tt.info)) {
+				t.Errorf("hash does not match the raw info bytes")
+			}
+		})
+	}
+}
+
 func TestHashInfoDictMissingInfo(t *testing.T) {
 	// Create bencoded data without info dict
 	rootDict := map[string]interface{}{
@@ -234,6 +267,165 @@ func TestParseFileValid(t *testing.T) {
 	}
 }
 
+func writeTestTorrent(t *testing.T, torrent map[string]interface{}) string {
+	bencoded, err := bencode.Marshal(torrent)
+	if err != nil {
+		t.Fatalf("failed to marshal test torrent: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "test.torrent")
+	if err := os.WriteFile(path, bencoded, 0o644); err != nil {
+		t.Fatalf("failed to write test torrent: %v", err)
+	}
+	return path
+}
+
+func TestParseFileMultiFile(t *testing.T) {
+	path := writeTestTorrent(t, map[string]interface{}{
+		"announce": "http://tracker.example.com/announce",
+		"info": map[string]interface{}{
+			"name": "dir",
+			"files": []interface{}{
+				map[string]interface{}{"length": 300000, "path": []string{"a.txt"}},
+				map[string]interface{}{"length": 1000, "path": []string{"sub", "b.txt"}},
+			},
+			"piece length": 262144,
+			"pieces":       strings.Repeat("x", 40), // 2 pieces
+		},
+	})
+
+	info, err := ParseFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !info.MultiFile {
+		t.Error("expected a multi-file torrent")
+	}
+	if info.Name != "dir" {
+		t.Errorf("expected name 'dir', got '%s'", info.Name)
+	}
+	if info.TotalLength != 301000 {
+		t.Errorf("expected total length 301000, got %d", info.TotalLength)
+	}
+	if len(info.Files) != 2 {
+		t.Fatalf("expected 2 files, got %d", len(info.Files))
+	}
+	if info.Files[1].Offset != 300000 || strings.Join(info.Files[1].Path, "/") != "sub/b.txt" {
+		t.Errorf("unexpected second file %+v", info.Files[1])
+	}
+	if !strings.Contains(info.String(), "sub/b.txt (1000 bytes)") {
+		t.Errorf("expected file list in summary, got:\n%s", info.String())
+	}
+}
+
+func TestParseFileSingleFileList(t *testing.T) {
+	tmpFile := createTestTorrentFile(t)
+	defer os.Remove(tmpFile)
+
+	info, err := ParseFile(tmpFile)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if info.MultiFile {
+		t.Error("expected a single file torrent")
+	}
+	if len(info.Files) != 1 || info.Files[0].Length != 1000 || info.Files[0].Path[0] != "test.txt" {
+		t.Errorf("unexpected file list %+v", info.Files)
+	}
+}
+
+func TestParseFilePieceCountMismatch(t *testing.T) {
+	path := writeTestTorrent(t, map[string]interface{}{
+		"announce": "http://tracker.example.com/announce",
+		"info": map[string]interface{}{
+			"name":         "test.txt",
+			"length":       1000,
+			"piece length": 262144,
+			"pieces":       strings.Repeat("x", 40), // one piece too many
+		},
+	})
+
+	if _, err := ParseFile(path); err == nil {
+		t.Error("expected error for wrong number of pieces")
+	}
+}
+
+func TestParseFileAnnounceList(t *testing.T) {
+	info := map[string]interface{}{
+		"name":         "test.txt",
+		"length":       1000,
+		"piece length": 262144,
+		"pieces":       "01234567890123456789",
+	}
+
+	tests := []struct {
+		name        string
+		torrent     map[string]interface{}
+		announceURL string
+		expected    [][]string
+	}{
+		{
+			"announce only",
+			map[string]interface{}{"announce": "http://a", "info": info},
+			"http://a",
+			[][]string{{"http://a"}},
+		},
+		{
+			"announce list takes over",
+			map[string]interface{}{
+				"announce":      "http://a",
+				"announce-list": [][]string{{"http://b", "http://c"}, {"http://d"}},
+				"info":          info,
+			},
+			"http://a",
+			[][]string{{"http://b", "http://c"}, {"http://d"}},
+		},
+		{
+			"announce list without announce",
+			map[string]interface{}{
+				"announce-list": [][]string{{}, {"", "http://b"}},
+				"info":          info,
+			},
+			"http://b",
+			[][]string{{"http://b"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := ParseFile(writeTestTorrent(t, tt.torrent))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if info.AnnounceURL != tt.announceURL {
+				t.Errorf("expected announce URL '%s', got '%s'", tt.announceURL, info.AnnounceURL)
+			}
+			if !reflect.DeepEqual(info.AnnounceList, tt.expected) {
+				t.Errorf("expected announce list %v, got %v", tt.expected, info.AnnounceList)
+			}
+		})
+	}
+
+	// trackerless torrents are left to the DHT, with the nodes they carry
+	trackerless := map[string]interface{}{
+		"announce-list": [][]string{{""}},
+		"nodes":         []interface{}{[]interface{}{"router.example.com", 6881}, []interface{}{"::1", 6882}, []interface{}{"bad"}, []interface{}{"10.0.0.1", 0}},
+		"info":          info,
+	}
+	got, err := ParseFile(writeTestTorrent(t, trackerless))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.AnnounceURL != "" || len(got.AnnounceList) != 0 {
+		t.Errorf("expected no trackers, got %q %v", got.AnnounceURL, got.AnnounceList)
+	}
+	if expected := []string{"router.example.com:6881", "[::1]:6882"}; !reflect.DeepEqual(got.Nodes, expected) {
+		t.Errorf("expected nodes %v, got %v", expected, got.Nodes)
+	}
+}
+
 func TestParseFileInvalidBencode(t *testing.T) {
 	tmpFile, err := os.CreateTemp("", "invalid.torrent")
 	if err != nil {
@@ -253,4 +445,106 @@ func TestParseFileInvalidBencode(t *testing.T) {
 	if err == nil {
 		t.Error("expected error for invalid bencode")
 	}
-}
\ No newline at end of file
+}
+func TestParseInfo(t *testing.T) {
+	rawInfo, err := bencode.Marshal(map[string]interface{}{
+		"name":         "file.bin",
+		"length":       1000,
+		"piece length": 16384,
+		"pieces":       strings.Repeat("p", 20),
+	})
+	if err != nil {
+		t.Fatalf("failed to marshal info: %v", err)
+	}
+
+	tests := []struct {
+		name         string
+		trackers     [][]string
+		announceURL  string
+		announceList [][]string
+	}{
+		{"no trackers", nil, "", nil},
+		{"trackers", [][]string{{"http://a/announce"}, {"", "http://b/announce"}}, "http://a/announce", [][]string{{"http://a/announce"}, {"http://b/announce"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := ParseInfo(rawInfo, tt.trackers)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if info.InfoHash != sha1.Sum(rawInfo) {
+				t.Error("info hash doesn't match the info dict")
+			}
+			if info.Name != "file.bin" || info.TotalLength != 1000 || info.PieceCount() != 1 {
+				t.Errorf("unexpected torrent %+v", info)
+			}
+			if info.AnnounceURL != tt.announceURL || !reflect.DeepEqual(info.AnnounceList, tt.announceList) {
+				t.Errorf("expected trackers %q %v, got %q %v", tt.announceURL, tt.announceList, info.AnnounceURL, info.AnnounceList)
+			}
+		})
+	}
+
+	if _, err := ParseInfo([]byte("d4:name1:xe"), nil); err == nil {
+		t.Error("expected error for an info dict without pieces")
+	}
+	if _, err := ParseInfo([]byte("not bencode"), nil); err == nil {
+		t.Error("expected error for invalid bencode")
+	}
+}
+
+func TestParseFileMetadata(t *testing.T) {
+	path := writeTestTorrent(t, map[string]interface{}{
+		"announce":      "http://tracker.example.com/announce",
+		"comment":       "a comment",
+		"created by":    "someone",
+		"creation date": 1714564800,
+		"url-list":      "http://seed.example.com/",
+		"info": map[string]interface{}{
+			"name":         "file.bin",
+			"length":       1000,
+			"piece length": 16384,
+			"pieces":       strings.Repeat("p", 20),
+			"private":      1,
+			"source":       "SRC",
+		},
+	})
+
+	info, err := ParseFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if info.Comment != "a comment" || info.CreatedBy != "someone" || !info.Private || info.Source != "SRC" {
+		t.Errorf("unexpected metadata %+v", info)
+	}
+	if !info.CreationDate.Equal(time.Unix(1714564800, 0)) {
+		t.Errorf("unexpected creation date %v", info.CreationDate)
+	}
+	if !reflect.DeepEqual(info.WebSeeds, []string{"http://seed.example.com/"}) {
+		t.Errorf("unexpected web seeds %v", info.WebSeeds)
+	}
+
+	summary := info.String()
+	for _, expected := range []string{
+		"Name: file.bin", "Comment: a comment", "Created By: someone",
+		"Creation Date: 2024-05-01T12:00:00Z", "Private: yes", "Source: SRC",
+		"Web Seed: http://seed.example.com/",
+	} {
+		if !strings.Contains(summary, expected) {
+			t.Errorf("expected %q in summary:\n%s", expected, summary)
+		}
+	}
+
+	// none of it is required
+	info, err = ParseFile(createTestTorrentFile(t))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if info.Private || !info.CreationDate.IsZero() || info.WebSeeds != nil {
+		t.Errorf("expected no optional metadata, got %+v", info)
+	}
+	if strings.Contains(info.String(), "Private") || strings.Contains(info.String(), "Creation Date") {
+		t.Errorf("expected missing fields to be left out of the summary:\n%s", info.String())
+	}
+}
@@ -0,0 +1,263 @@
+package torrent
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+
+	"github.com/lourencovales/codecrafters/bittorrent-go/bencode"
+)
+
+// v2File is a file found in a BEP 52 'file tree'.
+type v2File struct {
+	Path       []string
+	Length     int
+	PiecesRoot [32]byte
+}
+
+// fileTreeLeaf is what sits under the empty key that marks a file in the
+// 'file tree'. Empty files don't have a pieces root.
+type fileTreeLeaf struct {
+	Length     int      `bencode:"length"`
+	PiecesRoot [32]byte `bencode:"pieces root"`
+}
+
+// walkFileTree lists the files of a 'file tree' dictionary, in the order they
+// appear in it, which for a well formed torrent is sorted by path. A file is a
+// dictionary with a single empty key, anything else is a directory.
+func walkFileTree(raw bencode.RawMessage, prefix []string, files []v2File) ([]v2File, error) {
+
+	var node map[string]bencode.RawMessage
+	if err := bencode.Unmarshal(raw, &node); err != nil {
+		return nil, fmt.Errorf("invalid file tree: %w", err)
+	}
+
+	if leafRaw, ok := node[""]; ok {
+		if len(node) != 1 || len(prefix) == 0 {
+			return nil, fmt.Errorf("invalid file tree: file entry mixed up with a directory")
+		}
+		var leaf fileTreeLeaf
+		if err := bencode.Unmarshal(leafRaw, &leaf); err != nil {
+			return nil, fmt.Errorf("invalid file tree entry for %v: %w", prefix, err)
+		}
+		if leaf.Length < 0 {
+			return nil, fmt.Errorf("file %v has an invalid length", prefix)
+		}
+		if leaf.Length > 0 && leaf.PiecesRoot == [32]byte{} {
+			return nil, fmt.Errorf("file %v has no pieces root", prefix)
+		}
+		path := append([]string(nil), prefix...)
+		return append(files, v2File{Path: path, Length: leaf.Length, PiecesRoot: leaf.PiecesRoot}), nil
+	}
+
+	names := make([]string, 0, len(node))
+	for name := range node {
+		if err := checkPathElem(name); err != nil {
+			return nil, fmt.Errorf("invalid file tree: %w", err)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		var err error
+		if files, err = walkFileTree(node[name], append(prefix, name), files); err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// layoutV2 lays the files of a v2 only torrent out the way BEP 52 numbers its
+// pieces: each file starts on a piece boundary, as if padding files had been
+// put in between, which is exactly what we do.
+func layoutV2(v2Files []v2File, pieceLength int) []File {
+
+	var files []File
+	offset := 0
+	for i, vf := range v2Files {
+		files = append(files, File{Path: vf.Path, Length: vf.Length, Offset: offset, PiecesRoot: vf.PiecesRoot})
+		offset += vf.Length
+
+		if rest := offset % pieceLength; rest != 0 && i < len(v2Files)-1 {
+			padLength := pieceLength - rest
+			files = append(files, File{
+				Path:    []string{".pad", strconv.Itoa(padLength)},
+				Length:  padLength,
+				Offset:  offset,
+				Padding: true,
+			})
+			offset += padLength
+		}
+	}
+	return files
+}
+
+// matchV2Files checks that the v1 and v2 halves of a hybrid torrent describe
+// the same files, and copies the pieces roots over to the v1 file list. Every
+// file has to start on a piece boundary in the v1 layout too, or v2 pieces
+// wouldn't line up with v1 ones; empty files take up no pieces, so they're
+// let off.
+func matchV2Files(files []File, v2Files []v2File, pieceLength int) error {
+
+	j := 0
+	for i := range files {
+		if files[i].Padding {
+			continue
+		}
+		if j >= len(v2Files) {
+			return fmt.Errorf("hybrid torrent has more v1 files than v2 ones")
+		}
+		vf := v2Files[j]
+		if files[i].Length != vf.Length || !slices.Equal(files[i].Path, vf.Path) {
+			return fmt.Errorf("hybrid torrent's v1 and v2 file lists don't match at %v", vf.Path)
+		}
+		if files[i].Length > 0 && files[i].Offset%pieceLength != 0 {
+			return fmt.Errorf("hybrid torrent's file %v doesn't start on a piece boundary, it's missing padding", vf.Path)
+		}
+		files[i].PiecesRoot = vf.PiecesRoot
+		j++
+	}
+	if j != len(v2Files) {
+		return fmt.Errorf("hybrid torrent has more v2 files than v1 ones")
+	}
+	return nil
+}
+
+// loadPieceLayers attaches to each file larger than a piece its piece layer
+// from the 'piece layers' dictionary, making sure that it hashes up to the
+// file's pieces root. Files that fit in one piece don't need one, their pieces
+// root is the hash of that piece.
+func loadPieceLayers(files []File, layers map[string]string, pieceLength int) error {
+
+	blocksPerPiece := pieceLength / MerkleBlockSize
+	pad := padHash(blocksPerPiece)
+
+	for i := range files {
+		f := &files[i]
+		if f.Padding || f.Length <= pieceLength {
+			continue
+		}
+
+		layer, ok := layers[string(f.PiecesRoot[:])]
+		if !ok {
+			return fmt.Errorf("piece layer missing for file %v", f.Path)
+		}
+		numPieces := (f.Length + pieceLength - 1) / pieceLength
+		if len(layer) != numPieces*32 {
+			return fmt.Errorf("piece layer for file %v has the wrong length", f.Path)
+		}
+
+		f.PieceLayer = make([][32]byte, numPieces)
+		for p := range f.PieceLayer {
+			copy(f.PieceLayer[p][:], layer[p*32:])
+		}
+		if merkleRoot(f.PieceLayer, nextPow2(numPieces), pad) != f.PiecesRoot {
+			return fmt.Errorf("piece layer for file %v doesn't match its pieces root", f.Path)
+		}
+	}
+	return nil
+}
+
+// checkV2PieceLength makes sure the piece length is one BEP 52 allows: a power
+// of two of at least 16 KiB
+func checkV2PieceLength(pieceLength int) error {
+	if pieceLength < MerkleBlockSize || pieceLength&(pieceLength-1) != 0 {
+		return fmt.Errorf("v2 piece length must be a power of two of at least 16 KiB, got %d", pieceLength)
+	}
+	return nil
+}
+
+// IsV2 reports whether the torrent has v2 metadata, either on its own or
+// alongside the v1 metadata of a hybrid torrent.
+func (ti *TorrentInfo) IsV2() bool {
+	return ti.MetaVersion >= 2
+}
+
+// IsHybrid reports whether the torrent has both v1 and v2 metadata, and can
+// be shared in both swarms.
+func (ti *TorrentInfo) IsHybrid() bool {
+	return ti.IsV2() && len(ti.PieceHashes) > 0
+}
+
+// InfoHashV2Truncated returns the v2 info hash cut down to 20 bytes, which is
+// what stands in for it in tracker requests and peer handshakes.
+func (ti *TorrentInfo) InfoHashV2Truncated() [20]byte {
+	var h [20]byte
+	copy(h[:], ti.InfoHashV2[:])
+	return h
+}
+
+// SwarmHashes returns the 20 byte info hashes peers of the torrent may know
+// it by: the v1 one, the truncated v2 one, or for hybrid torrents both, v1
+// first.
+func (ti *TorrentInfo) SwarmHashes() [][20]byte {
+	switch {
+	case ti.IsHybrid():
+		return [][20]byte{ti.InfoHash, ti.InfoHashV2Truncated()}
+	case ti.IsV2():
+		return [][20]byte{ti.InfoHashV2Truncated()}
+	}
+	return [][20]byte{ti.InfoHash}
+}
+
+// VerifyPiece checks the data of the piece at index against every hash the
+// torrent has for it: SHA-1 for v1 and hybrid torrents, and the SHA-256 merkle
+// trees for v2 and hybrid ones.
+func (ti *TorrentInfo) VerifyPiece(index int, data []byte) error {
+
+	if index < 0 || index >= ti.PieceCount() {
+		return fmt.Errorf("piece %d out of range", index)
+	}
+	if len(data) != ti.PieceSize(index) {
+		return fmt.Errorf("piece %d should be %d bytes, got %d", index, ti.PieceSize(index), len(data))
+	}
+
+	if len(ti.PieceHashes) > 0 {
+		if sha1.Sum(data) != ti.PieceHashes[index] {
+			return fmt.Errorf("piece %d failed the SHA-1 check", index)
+		}
+	}
+	if !ti.IsV2() {
+		return nil
+	}
+
+	files := ti.FileList()
+	for _, s := range ti.PieceSections(index) {
+		f := files[s.File]
+		section := data[s.PieceOffset : s.PieceOffset+s.Length]
+
+		if f.Padding {
+			if !bytes.Equal(section, make([]byte, len(section))) {
+				return fmt.Errorf("piece %d has non-zero padding", index)
+			}
+			continue
+		}
+
+		// files start on a piece boundary, so this is the whole of the
+		// file's part of the piece
+		leaves := blockHashes(section)
+		var zero [32]byte
+		var expected, actual [32]byte
+		if f.Length <= ti.PieceLength {
+			expected = f.PiecesRoot
+			actual = merkleRoot(leaves, nextPow2(len(leaves)), zero)
+		} else {
+			if f.PieceLayer == nil {
+				if len(ti.PieceHashes) > 0 {
+					continue // a hybrid without piece layers, SHA-1 will do
+				}
+				return fmt.Errorf("no piece layer to check piece %d against", index)
+			}
+			expected = f.PieceLayer[s.FileOffset/ti.PieceLength]
+			actual = merkleRoot(leaves, ti.PieceLength/MerkleBlockSize, zero)
+		}
+		if actual != expected {
+			return fmt.Errorf("piece %d failed the merkle check for file %v", index, f.Path)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,401 @@
+package torrent
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/lourencovales/codecrafters/bittorrent-go/bencode"
+)
+
+// v2TestFile is a file to put in a test v2 torrent
+type v2TestFile struct {
+	path []string
+	data []byte
+}
+
+// v2Root computes the pieces root and piece layer of a file the long way
+// round, the way a torrent creator would
+func v2Root(data []byte, pieceLength int) ([32]byte, []byte) {
+	var zero [32]byte
+	leaves := blockHashes(data)
+	if len(data) <= pieceLength {
+		return merkleRoot(leaves, nextPow2(len(leaves)), zero), nil
+	}
+
+	var layer []byte
+	blocksPerPiece := pieceLength / MerkleBlockSize
+	for start := 0; start < len(data); start += pieceLength {
+		end := min(start+pieceLength, len(data))
+		h := merkleRoot(blockHashes(data[start:end]), blocksPerPiece, zero)
+		layer = append(layer, h[:]...)
+	}
+	return merkleRoot(leaves, nextPow2(len(leaves)), zero), layer
+}
+
+// buildV2Torrent writes out a v2 torrent for files, or a hybrid one with
+// padding files in its v1 file list. It returns the path of the torrent and
+// the torrent's data as laid out in pieces, padding included.
+func buildV2Torrent(t *testing.T, name string, files []v2TestFile, pieceLength int, hybrid bool) (string, []byte) {
+
+	tree := map[string]interface{}{}
+	layers := map[string]interface{}{}
+	var v1Files []interface{}
+	var all []byte
+
+	for i, f := range files {
+		leaf := map[string]interface{}{"length": len(f.data)}
+		if len(f.data) > 0 {
+			root, layer := v2Root(f.data, pieceLength)
+			leaf["pieces root"] = string(root[:])
+			if layer != nil {
+				layers[string(root[:])] = string(layer)
+			}
+		}
+
+		node := tree
+		for _, elem := range f.path[:len(f.path)-1] {
+			if _, ok := node[elem]; !ok {
+				node[elem] = map[string]interface{}{}
+			}
+			node = node[elem].(map[string]interface{})
+		}
+		node[f.path[len(f.path)-1]] = map[string]interface{}{"": leaf}
+
+		all = append(all, f.data...)
+		v1Files = append(v1Files, map[string]interface{}{"length": len(f.data), "path": f.path})
+		if rest := len(all) % pieceLength; rest != 0 && i < len(files)-1 {
+			pad := pieceLength - rest
+			all = append(all, make([]byte, pad)...)
+			v1Files = append(v1Files, map[string]interface{}{
+				"attr": "p", "length": pad, "path": []string{".pad", strconv.Itoa(pad)},
+			})
+		}
+	}
+
+	info := map[string]interface{}{
+		"name":         name,
+		"piece length": pieceLength,
+		"meta version": 2,
+		"file tree":    tree,
+	}
+	if hybrid {
+		var pieces []byte
+		for start := 0; start < len(all); start += pieceLength {
+			h := sha1.Sum(all[start:min(start+pieceLength, len(all))])
+			pieces = append(pieces, h[:]...)
+		}
+		info["pieces"] = string(pieces)
+		if len(files) == 1 && len(files[0].path) == 1 {
+			info["length"] = len(files[0].data)
+		} else {
+			info["files"] = v1Files
+		}
+	}
+
+	torrent := map[string]interface{}{
+		"announce":     "http://tracker.example.com/announce",
+		"info":         info,
+		"piece layers": layers,
+	}
+	return writeTestTorrent(t, torrent), all
+}
+
+// v2TestFiles spans several pieces per file, single piece files, single
+// block files and an empty one, with a piece length of 32 KiB
+var v2TestFiles = []v2TestFile{
+	{[]string{"big.bin"}, testData(100000, 1)},
+	{[]string{"dir", "empty"}, nil},
+	{[]string{"dir", "one-piece"}, testData(20000, 2)},
+	{[]string{"dir", "small"}, testData(100, 3)},
+	{[]string{"zlast"}, testData(40000, 4)},
+}
+
+func TestParseFileV2(t *testing.T) {
+	path, data := buildV2Torrent(t, "v2", v2TestFiles, 32768, false)
+
+	info, err := ParseFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read torrent: %v", err)
+	}
+	rawInfo, _, err := bencode.Get(raw, "info")
+	if err != nil {
+		t.Fatalf("failed to find info dict: %v", err)
+	}
+
+	if !info.IsV2() || info.IsHybrid() || info.MetaVersion != 2 {
+		t.Errorf("expected a v2 only torrent, got version %d", info.MetaVersion)
+	}
+	if info.InfoHashV2 != sha256.Sum256(rawInfo) {
+		t.Error("v2 info hash doesn't match the info dict")
+	}
+	if info.InfoHash != info.InfoHashV2Truncated() || info.InfoHash != [20]byte(info.InfoHashV2[:20]) {
+		t.Error("expected the info hash to be the truncated v2 hash")
+	}
+	if len(info.SwarmHashes()) != 1 {
+		t.Errorf("expected a single swarm, got %d", len(info.SwarmHashes()))
+	}
+	if !info.MultiFile {
+		t.Error("expected a multi-file torrent")
+	}
+	if len(info.PieceHashes) != 0 {
+		t.Errorf("expected no SHA-1 piece hashes, got %d", len(info.PieceHashes))
+	}
+
+	if info.TotalLength != len(data) || info.PieceCount() != (len(data)+32767)/32768 {
+		t.Errorf("expected %d bytes in %d pieces, got %d in %d", len(data), (len(data)+32767)/32768, info.TotalLength, info.PieceCount())
+	}
+
+	// every file but the empty one and the last one gets padded out
+	var names []string
+	for _, f := range info.Files {
+		names = append(names, strings.Join(f.Path, "/"))
+		if f.Offset%32768 != 0 && !f.Padding && f.Length > 0 {
+			t.Errorf("expected %v to start on a piece boundary, got offset %d", f.Path, f.Offset)
+		}
+	}
+	expected := "big.bin .pad/31072 dir/empty dir/one-piece .pad/12768 dir/small .pad/32668 zlast"
+	if strings.Join(names, " ") != expected {
+		t.Errorf("expected files %s, got %s", expected, strings.Join(names, " "))
+	}
+
+	for i := 0; i < info.PieceCount(); i++ {
+		piece := data[i*32768 : i*32768+info.PieceSize(i)]
+		if err := info.VerifyPiece(i, piece); err != nil {
+			t.Errorf("unexpected error verifying piece %d: %v", i, err)
+		}
+
+		corrupted := append([]byte(nil), piece...)
+		corrupted[0] ^= 0xff
+		if err := info.VerifyPiece(i, corrupted); err == nil {
+			t.Errorf("expected corrupted piece %d to fail", i)
+		}
+	}
+}
+
+func TestParseFileHybrid(t *testing.T) {
+	path, data := buildV2Torrent(t, "hybrid", v2TestFiles, 32768, true)
+
+	info, err := ParseFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read torrent: %v", err)
+	}
+	rawInfo, _, _ := bencode.Get(raw, "info")
+
+	if !info.IsHybrid() {
+		t.Fatal("expected a hybrid torrent")
+	}
+	if info.InfoHash != sha1.Sum(rawInfo) || info.InfoHashV2 != sha256.Sum256(rawInfo) {
+		t.Error("expected both the v1 and v2 info hashes")
+	}
+	hashes := info.SwarmHashes()
+	if len(hashes) != 2 || hashes[0] != info.InfoHash || hashes[1] != info.InfoHashV2Truncated() {
+		t.Errorf("expected the v1 and truncated v2 hashes, got %x", hashes)
+	}
+	if !strings.Contains(info.String(), "Info Hash v2: ") {
+		t.Error("expected the v2 info hash in the summary")
+	}
+
+	padding := 0
+	for _, f := range info.Files {
+		if f.Padding {
+			padding++
+		} else if f.Length > 0 && f.PiecesRoot == [32]byte{} {
+			t.Errorf("expected %v to have a pieces root", f.Path)
+		}
+	}
+	if padding != 3 {
+		t.Errorf("expected 3 padding files, got %d", padding)
+	}
+
+	for i := 0; i < info.PieceCount(); i++ {
+		if err := info.VerifyPiece(i, data[i*32768:i*32768+info.PieceSize(i)]); err != nil {
+			t.Errorf("unexpected error verifying piece %d: %v", i, err)
+		}
+	}
+
+	// the padding after big.bin sits at the end of piece 3
+	piece := append([]byte(nil), data[3*32768:4*32768]...)
+	piece[len(piece)-1] = 1
+	if err := info.VerifyPiece(3, piece); err == nil {
+		t.Error("expected non-zero padding to fail")
+	}
+}
+
+func TestParseFileV2SingleFile(t *testing.T) {
+	files := []v2TestFile{{[]string{"single.bin"}, testData(70000, 5)}}
+
+	for _, hybrid := range []bool{false, true} {
+		path, data := buildV2Torrent(t, "single.bin", files, 16384, hybrid)
+		info, err := ParseFile(path)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if info.MultiFile {
+			t.Errorf("expected a single file torrent (hybrid %v)", hybrid)
+		}
+		for i := 0; i < info.PieceCount(); i++ {
+			if err := info.VerifyPiece(i, data[i*16384:i*16384+info.PieceSize(i)]); err != nil {
+				t.Errorf("unexpected error verifying piece %d: %v", i, err)
+			}
+		}
+	}
+}
+
+func TestParseFileV2Errors(t *testing.T) {
+	big := testData(50000, 6)
+	root, layer := v2Root(big, 16384)
+	tree := map[string]interface{}{
+		"big": map[string]interface{}{"": map[string]interface{}{"length": len(big), "pieces root": string(root[:])}},
+	}
+	info := func(extra map[string]interface{}) map[string]interface{} {
+		m := map[string]interface{}{"name": "x", "piece length": 16384, "meta version": 2, "file tree": tree}
+		for k, v := range extra {
+			m[k] = v
+		}
+		return m
+	}
+	layers := map[string]interface{}{string(root[:]): string(layer)}
+	badLayer := append([]byte(nil), layer...)
+	badLayer[0] ^= 1
+
+	tests := []struct {
+		name    string
+		torrent map[string]interface{}
+		err     string
+	}{
+		{
+			"unknown meta version",
+			map[string]interface{}{"announce": "http://a", "info": info(map[string]interface{}{"meta version": 3}), "piece layers": layers},
+			"unsupported meta version",
+		},
+		{
+			"piece length not a power of two",
+			map[string]interface{}{"announce": "http://a", "info": info(map[string]interface{}{"piece length": 20000}), "piece layers": layers},
+			"power of two",
+		},
+		{
+			"no file tree",
+			map[string]interface{}{"announce": "http://a", "info": info(map[string]interface{}{"file tree": map[string]interface{}{}}), "piece layers": layers},
+			"file tree is empty",
+		},
+		{
+			"missing piece layer",
+			map[string]interface{}{"announce": "http://a", "info": info(nil)},
+			"piece layer missing",
+		},
+		{
+			"piece layer doesn't match root",
+			map[string]interface{}{"announce": "http://a", "info": info(nil), "piece layers": map[string]interface{}{string(root[:]): string(badLayer)}},
+			"doesn't match its pieces root",
+		},
+		{
+			"piece layer too short",
+			map[string]interface{}{"announce": "http://a", "info": info(nil), "piece layers": map[string]interface{}{string(root[:]): string(layer[:32])}},
+			"wrong length",
+		},
+		{
+			"path traversal in file tree",
+			map[string]interface{}{"announce": "http://a", "info": info(map[string]interface{}{"file tree": map[string]interface{}{
+				"..": map[string]interface{}{"": map[string]interface{}{"length": 1, "pieces root": string(root[:])}},
+			}})},
+			"not allowed",
+		},
+		{
+			"hybrid with different files",
+			map[string]interface{}{"announce": "http://a", "info": info(map[string]interface{}{
+				"length": len(big) + 1, "pieces": strings.Repeat("x", 80),
+			}), "piece layers": layers},
+			"don't match",
+		},
+		{
+			"hybrid without padding",
+			map[string]interface{}{"announce": "http://a", "info": info(map[string]interface{}{
+				"file tree": map[string]interface{}{
+					"big":   tree["big"],
+					"small": map[string]interface{}{"": map[string]interface{}{"length": 100, "pieces root": string(root[:])}},
+				},
+				"files": []interface{}{
+					map[string]interface{}{"length": len(big), "path": []string{"big"}},
+					map[string]interface{}{"length": 100, "path": []string{"small"}},
+				},
+				"pieces": strings.Repeat("x", 80),
+			}), "piece layers": layers},
+			"piece boundary",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseFile(writeTestTorrent(t, tt.torrent))
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("expected error containing %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestVerifyPieceRange(t *testing.T) {
+	path, data := buildV2Torrent(t, "v2", v2TestFiles, 32768, false)
+	info, err := ParseFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := info.VerifyPiece(-1, nil); err == nil {
+		t.Error("expected error for a negative index")
+	}
+	if err := info.VerifyPiece(info.PieceCount(), nil); err == nil {
+		t.Error("expected error for an index past the end")
+	}
+	if err := info.VerifyPiece(0, data[:100]); err == nil {
+		t.Error("expected error for a short piece")
+	}
+}
+
+func TestParseInfoV2(t *testing.T) {
+	// outside of a .torrent file there are no piece layers: hybrids get by
+	// with their SHA-1 hashes, v2 only torrents with large files can't
+	for _, hybrid := range []bool{true, false} {
+		path, data := buildV2Torrent(t, "v2", v2TestFiles, 32768, hybrid)
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("failed to read torrent: %v", err)
+		}
+		rawInfo, _, _ := bencode.Get(raw, "info")
+
+		info, err := ParseInfo(rawInfo, nil)
+		if !hybrid {
+			if err == nil || !strings.Contains(err.Error(), "piece layer missing") {
+				t.Errorf("expected a missing piece layer error, got %v", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for i := 0; i < info.PieceCount(); i++ {
+			if err := info.VerifyPiece(i, data[i*32768:i*32768+info.PieceSize(i)]); err != nil {
+				t.Errorf("unexpected error verifying piece %d: %v", i, err)
+			}
+		}
+		bad := append([]byte(nil), data[:32768]...)
+		bad[0] ^= 1
+		if err := info.VerifyPiece(0, bad); err == nil {
+			t.Error("expected a corrupted piece to fail")
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package torrent
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+)
+
+// VerifyOptions configures Verify.
+type VerifyOptions struct {
+	// Workers is how many pieces are hashed in parallel, by default one per
+	// CPU.
+	Workers int
+}
+
+// VerifyResult says which pieces of a torrent are intact on disk.
+type VerifyResult struct {
+	// Bitfield has a bit set for each good piece, laid out the way the peer
+	// wire protocol does it: piece 0 is the high bit of the first byte.
+	Bitfield   []byte
+	GoodPieces int
+	PieceCount int
+	// AffectedFiles holds the indexes, into FileList(), of the files that
+	// have data in at least one bad piece. Padding files are left out.
+	AffectedFiles []int
+}
+
+// HasPiece reports whether the piece at index checked out.
+func (r *VerifyResult) HasPiece(index int) bool {
+	if index < 0 || index/8 >= len(r.Bitfield) {
+		return false
+	}
+	return r.Bitfield[index/8]&(0x80>>(index%8)) != 0
+}
+
+// Complete reports whether every piece checked out.
+func (r *VerifyResult) Complete() bool {
+	return r.GoodPieces == r.PieceCount
+}
+
+// Percent returns how much of the torrent is intact, counting in pieces.
+func (r *VerifyResult) Percent() float64 {
+	if r.PieceCount == 0 {
+		return 100
+	}
+	return 100 * float64(r.GoodPieces) / float64(r.PieceCount)
+}
+
+// Verify checks the data at path against the torrent's piece hashes, laid out
+// the way the client writes it: path is the file itself for single file
+// torrents, and the directory holding the files for multi-file ones. Missing
+// or short files just make for bad pieces, only other I/O errors are
+// returned.
+func Verify(ti *TorrentInfo, path string, opts VerifyOptions) (*VerifyResult, error) {
+
+	files, err := openPayload(ti, path)
+	if err != nil {
+		return nil, err
+	}
+	defer closeFiles(files)
+
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	pieceCount := ti.PieceCount()
+	good := make([]bool, pieceCount)
+	indexes := make(chan int)
+	errs := make(chan error, workers)
+	var wg sync.WaitGroup
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buf := make([]byte, ti.PieceLength)
+			for i := range indexes {
+				piece := buf[:ti.PieceSize(i)]
+				err := readPiece(ti, files, i, piece)
+				if errors.Is(err, errMissingData) {
+					continue
+				}
+				if err != nil {
+					errs <- err
+					return
+				}
+				good[i] = ti.VerifyPiece(i, piece) == nil
+			}
+		}()
+	}
+
+	// stop handing out pieces as soon as a worker gives up
+feed:
+	for i := 0; i < pieceCount; i++ {
+		select {
+		case indexes <- i:
+		case err = <-errs:
+			break feed
+		}
+	}
+	close(indexes)
+	wg.Wait()
+
+	if err == nil {
+		select {
+		case err = <-errs:
+		default:
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result := &VerifyResult{Bitfield: make([]byte, (pieceCount+7)/8), PieceCount: pieceCount}
+	affected := make(map[int]bool)
+	for i, ok := range good {
+		if ok {
+			result.Bitfield[i/8] |= 0x80 >> (i % 8)
+			result.GoodPieces++
+			continue
+		}
+		for _, s := range ti.PieceSections(i) {
+			affected[s.File] = true
+		}
+	}
+	for i, f := range ti.FileList() {
+		if affected[i] && !f.Padding {
+			result.AffectedFiles = append(result.AffectedFiles, i)
+		}
+	}
+	return result, nil
+}
+
+// errMissingData is what readPiece returns when part of the piece isn't on
+// disk, either because a file is missing or because it's too short.
+var errMissingData = errors.New("data missing")
+
+// openPayload opens the files of the torrent found at path. Files that don't
+// exist, and padding files, are left as nil.
+func openPayload(ti *TorrentInfo, path string) ([]*os.File, error) {
+
+	var files []*os.File
+	for _, tf := range ti.FileList() {
+		if tf.Padding {
+			files = append(files, nil)
+			continue
+		}
+		p := path
+		if ti.MultiFile {
+			p = filepath.Join(append([]string{path}, tf.Path...)...)
+		}
+
+		f, err := os.Open(p)
+		if errors.Is(err, fs.ErrNotExist) {
+			files = append(files, nil)
+			continue
+		}
+		if err != nil {
+			closeFiles(files)
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+// readPiece reads the piece at index into piece, from files lined up with
+// FileList(). Padding is filled in with zeros.
+func readPiece(ti *TorrentInfo, files []*os.File, index int, piece []byte) error {
+
+	tfs := ti.FileList()
+	for _, s := range ti.PieceSections(index) {
+		section := piece[s.PieceOffset : s.PieceOffset+s.Length]
+		if tfs[s.File].Padding {
+			clear(section)
+			continue
+		}
+		if files[s.File] == nil {
+			return errMissingData
+		}
+		_, err := files[s.File].ReadAt(section, int64(s.FileOffset))
+		if errors.Is(err, io.EOF) {
+			return errMissingData
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", files[s.File].Name(), err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+package torrent
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// verifyTree writes three files that span five 16 KiB pieces, with pieces 1
+// and 3 straddling file boundaries, and returns the parsed torrent for them
+func verifyTree(t *testing.T) (*TorrentInfo, string) {
+	dir := filepath.Join(t.TempDir(), "content")
+	writeTree(t, dir, map[string][]byte{
+		"a.bin":     testData(20000, 1),
+		"b/c.bin":   testData(30000, 2),
+		"b/d.bin":   testData(20000, 3),
+		"b/e.empty": nil,
+	})
+	_, info := createAndParse(t, dir, CreateOptions{PieceLength: 16384, Trackers: testTrackers})
+	return info, dir
+}
+
+func TestVerify(t *testing.T) {
+	tests := []struct {
+		name     string
+		damage   func(t *testing.T, dir string)
+		good     []bool
+		affected []string
+	}{
+		{
+			"intact",
+			func(t *testing.T, dir string) {},
+			[]bool{true, true, true, true, true},
+			nil,
+		},
+		{
+			"corrupted byte at a boundary",
+			func(t *testing.T, dir string) {
+				corrupt(t, filepath.Join(dir, "a.bin"), 19999)
+			},
+			[]bool{true, false, true, true, true},
+			[]string{"a.bin", "b/c.bin"},
+		},
+		{
+			"missing file",
+			func(t *testing.T, dir string) {
+				os.Remove(filepath.Join(dir, "b", "d.bin"))
+			},
+			[]bool{true, true, true, false, false},
+			[]string{"b/c.bin", "b/d.bin"},
+		},
+		{
+			"truncated file",
+			func(t *testing.T, dir string) {
+				os.Truncate(filepath.Join(dir, "b", "c.bin"), 10000)
+			},
+			[]bool{true, false, false, false, true},
+			[]string{"a.bin", "b/c.bin", "b/d.bin"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, dir := verifyTree(t)
+			tt.damage(t, dir)
+
+			for _, workers := range []int{1, 0} {
+				result, err := Verify(info, dir, VerifyOptions{Workers: workers})
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+
+				goodCount := 0
+				for i, good := range tt.good {
+					if result.HasPiece(i) != good {
+						t.Errorf("piece %d: expected good %v", i, good)
+					}
+					if good {
+						goodCount++
+					}
+				}
+				if result.GoodPieces != goodCount || result.PieceCount != 5 || result.Complete() != (goodCount == 5) {
+					t.Errorf("expected %d of 5 good pieces, got %d of %d", goodCount, result.GoodPieces, result.PieceCount)
+				}
+				if want := 100 * float64(goodCount) / 5; result.Percent() != want {
+					t.Errorf("expected %.1f%%, got %.1f%%", want, result.Percent())
+				}
+
+				var affected []string
+				for _, i := range result.AffectedFiles {
+					affected = append(affected, filepath.ToSlash(filepath.Join(info.Files[i].Path...)))
+				}
+				if !reflect.DeepEqual(affected, tt.affected) {
+					t.Errorf("expected affected files %v, got %v", tt.affected, affected)
+				}
+			}
+		})
+	}
+}
+
+// corrupt flips a byte of the file at path
+func corrupt(t *testing.T, path string, offset int64) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("failed to open %s: %v", path, err)
+	}
+	defer f.Close()
+
+	b := make([]byte, 1)
+	if _, err := f.ReadAt(b, offset); err != nil {
+		t.Fatalf("failed to read %s: %v", path, err)
+	}
+	b[0] ^= 0xff
+	if _, err := f.WriteAt(b, offset); err != nil {
+		t.Fatalf("failed to write %s: %v", path, err)
+	}
+}
+
+func TestVerifySingleFile(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string][]byte{"file.bin": testData(40000, 4)})
+	path := filepath.Join(dir, "file.bin")
+	_, info := createAndParse(t, path, CreateOptions{PieceLength: 16384, Trackers: testTrackers})
+
+	corrupt(t, path, 39999)
+	result, err := Verify(info, path, VerifyOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.GoodPieces != 2 || result.HasPiece(2) || !reflect.DeepEqual(result.AffectedFiles, []int{0}) {
+		t.Errorf("expected only the last piece to be bad, got %+v", result)
+	}
+
+	result, err = Verify(info, filepath.Join(dir, "missing"), VerifyOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.GoodPieces != 0 || len(result.Bitfield) != 1 || result.Bitfield[0] != 0 {
+		t.Errorf("expected nothing to be good, got %+v", result)
+	}
+}
+
+func TestVerifyV2(t *testing.T) {
+	// v2 files are checked against their merkle trees, with the padding in
+	// between that never makes it to disk
+	path, data := buildV2Torrent(t, "v2", v2TestFiles, 32768, false)
+	info, err := ParseFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	dir := filepath.Join(t.TempDir(), "v2")
+	files := map[string][]byte{}
+	for _, f := range v2TestFiles {
+		files[filepath.Join(f.path...)] = f.data
+	}
+	writeTree(t, dir, files)
+
+	result, err := Verify(info, dir, VerifyOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !result.Complete() || result.PieceCount != (len(data)+32767)/32768 {
+		t.Errorf("expected all %d pieces to be good, got %d of %d", (len(data)+32767)/32768, result.GoodPieces, result.PieceCount)
+	}
+}
+
+func TestVerifyResult(t *testing.T) {
+	r := &VerifyResult{Bitfield: []byte{0xa0, 0x80}, GoodPieces: 3, PieceCount: 9}
+
+	for i, expected := range []bool{true, false, true, false, false, false, false, false, true} {
+		if r.HasPiece(i) != expected {
+			t.Errorf("piece %d: expected %v", i, expected)
+		}
+	}
+	if r.HasPiece(-1) || r.HasPiece(16) {
+		t.Error("expected pieces out of range not to be there")
+	}
+	if r.Complete() {
+		t.Error("expected an incomplete result")
+	}
+}
@@ -0,0 +1,233 @@
+package tracker
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// defaultInterval is how often we announce to trackers that don't say.
+const defaultInterval = 30 * time.Minute
+
+// retryInterval is how long we wait before trying again after an announce
+// failed, unless the tracker asked for longer.
+const retryInterval = time.Minute
+
+// Stats are the transfer numbers reported to trackers.
+type Stats struct {
+	Uploaded   int64
+	Downloaded int64
+	Left       int64
+}
+
+// AnnouncerOptions configures an Announcer.
+type AnnouncerOptions struct {
+	// Port is the port we take connections from peers on.
+	Port uint16
+	// Stats is called before each announce for the numbers to report. When
+	// nil, nothing is reported as transferred nor left.
+	Stats func() Stats
+	// OnPeers, if set, gets the peers from the announces sent in the
+	// background.
+	OnPeers func([]netip.AddrPort)
+	// OnError, if set, gets the errors of the announces sent in the
+	// background, and the warnings of every announce, as *WarningError.
+	OnError func(error)
+}
+
+// Announcer keeps the trackers up to date for one torrent, for as long as
+// we're in its swarm: a started announce to begin with, then one every
+// interval, a completed announce when the download is done, and a stopped
+// one to leave.
+type Announcer struct {
+	trackers *TierList
+	infoHash [20]byte
+	peerID   [20]byte
+	opts     AnnouncerOptions
+
+	mu       sync.Mutex
+	running  bool
+	stopped  bool
+	complete chan struct{}
+	stop     chan struct{}
+	done     chan struct{}
+	// ctx bounds the background announces, and is only cancelled when Stop
+	// can't wait for the one in flight
+	ctx    context.Context
+	cancel context.CancelFunc
+	// pending is the event of the next announce, only touched by run until
+	// it's done
+	pending Event
+}
+
+// NewAnnouncer returns an Announcer for the swarm of infoHash. Nothing is
+// sent until Start.
+func NewAnnouncer(trackers *TierList, infoHash, peerID [20]byte, opts AnnouncerOptions) *Announcer {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Announcer{
+		trackers: trackers,
+		infoHash: infoHash,
+		peerID:   peerID,
+		opts:     opts,
+		complete: make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+// Start sends the started announce and returns the peers it got back. From
+// then on, announces are sent in the background until Stop. An Announcer
+// can only be started once, but Start can be tried again if it failed. ctx
+// only bounds the started announce.
+func (a *Announcer) Start(ctx context.Context) ([]netip.AddrPort, error) {
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.running || a.stopped {
+		return nil, errors.New("announcer already started")
+	}
+
+	resp, err := a.announce(ctx, EventStarted)
+	if err != nil {
+		return nil, err
+	}
+	a.running = true
+	go a.run(resp)
+	return resp.Peers, nil
+}
+
+// Completed has the completed announce sent, straight away. It's sent again
+// with the next announce if it fails.
+func (a *Announcer) Completed() {
+	select {
+	case a.complete <- struct{}{}:
+	default: // already on its way
+	}
+}
+
+// Stop ends the background announces and sends the stopped announce, after
+// the completed one if that hasn't gone out yet. ctx bounds both the wait for
+// a background announce in flight, which is abandoned when ctx is done, and
+// the announces sent by Stop. Stopping an Announcer that isn't running does
+// nothing.
+func (a *Announcer) Stop(ctx context.Context) error {
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.running {
+		return nil
+	}
+	a.running, a.stopped = false, true
+	close(a.stop)
+	select {
+	case <-a.done:
+	case <-ctx.Done():
+		a.cancel()
+		<-a.done
+	}
+	a.cancel()
+
+	// a completed announce that hasn't gone out yet still goes first
+	select {
+	case <-a.complete:
+		a.pending = EventCompleted
+	default:
+	}
+	if a.pending == EventCompleted {
+		if _, err := a.announce(ctx, EventCompleted); err != nil {
+			a.report(err)
+		}
+	}
+
+	_, err := a.announce(ctx, EventStopped)
+	return err
+}
+
+// run sends the periodic announces, starting from the answer to the started
+// one
+func (a *Announcer) run(resp *AnnounceResponse) {
+
+	defer close(a.done)
+
+	timer := time.NewTimer(nextAnnounce(resp, nil))
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-a.stop:
+			return
+		case <-a.complete:
+			a.pending = EventCompleted
+			timer.Stop()
+		case <-timer.C:
+		}
+
+		r, err := a.announce(a.ctx, a.pending)
+		if a.ctx.Err() != nil {
+			return // stopped halfway through, the answer no longer matters
+		}
+		if err != nil {
+			a.report(err)
+		} else {
+			a.pending = EventNone
+			if r.MinInterval == 0 {
+				r.MinInterval = resp.MinInterval // keep the last one we got
+			}
+			resp = r
+			if a.opts.OnPeers != nil {
+				a.opts.OnPeers(r.Peers)
+			}
+		}
+		timer.Reset(nextAnnounce(resp, err))
+	}
+}
+
+// announce sends a single announce with the current stats
+func (a *Announcer) announce(ctx context.Context, event Event) (*AnnounceResponse, error) {
+
+	var stats Stats
+	if a.opts.Stats != nil {
+		stats = a.opts.Stats()
+	}
+	resp, err := a.trackers.Announce(ctx, AnnounceRequest{
+		InfoHash:   a.infoHash,
+		PeerID:     a.peerID,
+		Port:       a.opts.Port,
+		Uploaded:   stats.Uploaded,
+		Downloaded: stats.Downloaded,
+		Left:       stats.Left,
+		Event:      event,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if resp.Warning != nil {
+		a.report(resp.Warning)
+	}
+	return resp, nil
+}
+
+func (a *Announcer) report(err error) {
+	if a.opts.OnError != nil {
+		a.opts.OnError(err)
+	}
+}
+
+// nextAnnounce works out how long to wait after the last good response, and
+// the error of the announce that came after it, if any. The tracker's min
+// interval is never undercut.
+func nextAnnounce(last *AnnounceResponse, err error) time.Duration {
+
+	wait := last.Interval
+	if wait <= 0 {
+		wait = defaultInterval
+	}
+	if err != nil {
+		wait = min(wait, retryInterval)
+	}
+	return max(wait, last.MinInterval)
+}
@@ -0,0 +1,193 @@
+package tracker
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// newAnnounceTracker starts an HTTP tracker that passes on the query of every
+// announce it gets, and answers them all with response.
+func newAnnounceTracker(t *testing.T, response string) (string, <-chan url.Values) {
+	queries := make(chan url.Values, 16)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries <- r.URL.Query()
+		w.Write([]byte(response))
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL + "/announce", queries
+}
+
+// nextQuery waits for the tracker to get an announce
+func nextQuery(t *testing.T, queries <-chan url.Values) url.Values {
+	t.Helper()
+	select {
+	case q := <-queries:
+		return q
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for an announce")
+		return nil
+	}
+}
+
+func newTestAnnouncer(announceURL string, opts AnnouncerOptions) *Announcer {
+	trackers := &TierList{tiers: [][]string{{announceURL}}, trackerIDs: make(map[trackerKey]string)}
+	return NewAnnouncer(trackers, [20]byte{1}, [20]byte{2}, opts)
+}
+
+func TestAnnouncerLifecycle(t *testing.T) {
+	announceURL, queries := newAnnounceTracker(t, "d8:intervali1800e10:tracker id3:abc5:peers6:\x7f\x00\x00\x01\x1a\xe1e")
+
+	stats := Stats{Uploaded: 5, Downloaded: 10, Left: 20}
+	a := newTestAnnouncer(announceURL, AnnouncerOptions{Port: 6881, Stats: func() Stats { return stats }})
+
+	peers, err := a.Start(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(peers) != 1 || peers[0] != netip.MustParseAddrPort("127.0.0.1:6881") {
+		t.Errorf("expected [127.0.0.1:6881], got %v", peers)
+	}
+	q := nextQuery(t, queries)
+	if q.Get("event") != "started" || q.Has("trackerid") {
+		t.Errorf("expected a started announce without a tracker id, got %v", q)
+	}
+	if q.Get("uploaded") != "5" || q.Get("downloaded") != "10" || q.Get("left") != "20" || q.Get("port") != "6881" {
+		t.Errorf("expected the stats to be reported, got %v", q)
+	}
+
+	if _, err := a.Start(context.Background()); err == nil {
+		t.Error("expected error when starting twice")
+	}
+
+	stats = Stats{Uploaded: 5, Downloaded: 30, Left: 0}
+	a.Completed()
+	q = nextQuery(t, queries)
+	if q.Get("event") != "completed" || q.Get("trackerid") != "abc" || q.Get("left") != "0" {
+		t.Errorf("expected a completed announce with the tracker id, got %v", q)
+	}
+
+	if err := a.Stop(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	q = nextQuery(t, queries)
+	if q.Get("event") != "stopped" || q.Get("trackerid") != "abc" {
+		t.Errorf("expected a stopped announce with the tracker id, got %v", q)
+	}
+
+	if err := a.Stop(context.Background()); err != nil {
+		t.Errorf("expected stopping twice to do nothing, got %v", err)
+	}
+	if _, err := a.Start(context.Background()); err == nil {
+		t.Error("expected error when starting after stopping")
+	}
+}
+
+func TestAnnouncerCompletedOnStop(t *testing.T) {
+	announceURL, queries := newAnnounceTracker(t, "d5:peers0:e")
+	a := newTestAnnouncer(announceURL, AnnouncerOptions{})
+	if _, err := a.Start(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	nextQuery(t, queries) // started
+
+	// stopping straight after completing must not lose the completed event
+	a.Completed()
+	if err := a.Stop(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if q := nextQuery(t, queries); q.Get("event") != "completed" {
+		t.Errorf("expected the completed announce first, got event %q", q.Get("event"))
+	}
+	if q := nextQuery(t, queries); q.Get("event") != "stopped" {
+		t.Errorf("expected the stopped announce last, got event %q", q.Get("event"))
+	}
+}
+
+func TestAnnouncerPeriodic(t *testing.T) {
+	announceURL, queries := newAnnounceTracker(t, "d8:intervali1e5:peers6:\x7f\x00\x00\x01\x1a\xe2e")
+
+	found := make(chan []netip.AddrPort, 1)
+	a := newTestAnnouncer(announceURL, AnnouncerOptions{OnPeers: func(p []netip.AddrPort) { found <- p }})
+	if _, err := a.Start(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer a.Stop(context.Background())
+	nextQuery(t, queries) // started
+
+	q := nextQuery(t, queries)
+	if q.Has("event") {
+		t.Errorf("expected a regular announce, got event %q", q.Get("event"))
+	}
+	select {
+	case p := <-found:
+		if len(p) != 1 || p[0].Port() != 6882 {
+			t.Errorf("unexpected peers %v", p)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("expected the peers of the periodic announce to be passed on")
+	}
+}
+
+func TestAnnouncerFailure(t *testing.T) {
+	announceURL, _ := newAnnounceTracker(t, "d14:failure reason12:unregisterede")
+	a := newTestAnnouncer(announceURL, AnnouncerOptions{})
+
+	_, err := a.Start(context.Background())
+	var failure *FailureError
+	if !errors.As(err, &failure) || failure.Reason != "unregistered" {
+		t.Fatalf("expected a FailureError, got %v", err)
+	}
+	if err := a.Stop(context.Background()); err != nil {
+		t.Errorf("expected stopping an announcer that never started to do nothing, got %v", err)
+	}
+}
+
+func TestAnnouncerWarning(t *testing.T) {
+	announceURL, _ := newAnnounceTracker(t, "d15:warning message9:slow down5:peers0:e")
+
+	var reported []error
+	a := newTestAnnouncer(announceURL, AnnouncerOptions{OnError: func(err error) { reported = append(reported, err) }})
+	if _, err := a.Start(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	a.Stop(context.Background())
+
+	if len(reported) != 2 {
+		t.Fatalf("expected the warning of both announces to be reported, got %v", reported)
+	}
+	var warning *WarningError
+	if !errors.As(reported[0], &warning) || warning.Message != "slow down" {
+		t.Errorf("expected a WarningError, got %v", reported[0])
+	}
+}
+
+func TestNextAnnounce(t *testing.T) {
+	failed := errors.New("no answer")
+	tests := []struct {
+		name     string
+		last     AnnounceResponse
+		err      error
+		expected time.Duration
+	}{
+		{"interval", AnnounceResponse{Interval: 10 * time.Minute}, nil, 10 * time.Minute},
+		{"no interval", AnnounceResponse{}, nil, defaultInterval},
+		{"min interval above interval", AnnounceResponse{Interval: time.Minute, MinInterval: 5 * time.Minute}, nil, 5 * time.Minute},
+		{"retry", AnnounceResponse{Interval: 10 * time.Minute}, failed, retryInterval},
+		{"retry before interval", AnnounceResponse{Interval: 30 * time.Second}, failed, 30 * time.Second},
+		{"retry after min interval", AnnounceResponse{Interval: 10 * time.Minute, MinInterval: 2 * time.Minute}, failed, 2 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := nextAnnounce(&tt.last, tt.err); got != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,195 @@
+package tracker
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// These are the defaults of a Client whose fields are left at zero.
+const (
+	defaultTimeout   = 30 * time.Second
+	defaultBackoff   = time.Second
+	defaultUserAgent = "bittorrent-go"
+)
+
+// DefaultClient is the Client used by New, Scrape, GetPeers and the tier
+// lists that don't have one of their own. It doesn't retry, since tier lists
+// move on to the next tracker instead.
+var DefaultClient = &Client{}
+
+// Client holds the settings used to talk to trackers. The zero value is
+// ready to use, and a Client is safe to use from several goroutines, but it
+// shouldn't be copied once used.
+type Client struct {
+	// HTTPClient makes the requests to HTTP trackers, http.DefaultClient
+	// when nil. Either way, each attempt is bounded by Timeout.
+	HTTPClient *http.Client
+	// Timeout bounds each attempt at an HTTP request, on top of whatever
+	// deadline the context has. UDP trackers have their own retransmission
+	// schedule (BEP 15) instead, which Timeout bounds as a whole. Announces
+	// sent through a TierList are bounded by it too, retries included, so
+	// that a tracker that doesn't answer can't hold up the ones after it.
+	Timeout time.Duration
+	// Retries is how many more times a failed HTTP request is tried. Only
+	// network errors and 5xx answers are retried, not refusals.
+	Retries int
+	// Backoff is how long to wait before the first retry, doubling for each
+	// one after that.
+	Backoff time.Duration
+	// UserAgent is sent with HTTP requests.
+	UserAgent string
+
+	// NumWant is how many peers to ask for, zero leaving it up to the
+	// tracker.
+	NumWant int
+	// Key identifies us to trackers across IP address changes. When zero,
+	// a random one is picked, and kept for the life of the Client.
+	Key uint32
+	// IP, when set, is the address trackers should hand out to peers
+	// instead of the one our requests come from. UDP trackers only take
+	// IPv4 addresses.
+	IP netip.Addr
+	// NoPeerID asks HTTP trackers to leave out peer IDs, which only matters
+	// for the dictionary form of the peer list.
+	NoPeerID bool
+
+	keyOnce   sync.Once
+	randomKey uint32
+	// connIDs are the connection IDs UDP trackers handed out to us
+	connIDs connIDCache
+}
+
+// Tracker returns the Tracker for announceURL, picked by its scheme: http and
+// https trackers speak the HTTP protocol, udp ones BEP 15.
+func (c *Client) Tracker(announceURL string) (Tracker, error) {
+
+	u, err := url.Parse(announceURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse announce URL: %w", err)
+	}
+
+	switch u.Scheme {
+	case "http", "https":
+		return &httpTracker{client: c, announceURL: announceURL}, nil
+	case "udp":
+		return newUDPTracker(c, u)
+	}
+	return nil, fmt.Errorf("unsupported tracker protocol %q", u.Scheme)
+}
+
+// Announce sends req to the tracker at announceURL.
+func (c *Client) Announce(ctx context.Context, announceURL string, req AnnounceRequest) (*AnnounceResponse, error) {
+
+	tr, err := c.Tracker(announceURL)
+	if err != nil {
+		return nil, err
+	}
+	return tr.Announce(ctx, req)
+}
+
+// Scrape asks the tracker at announceURL for the stats of each of
+// infoHashes, which come back in the same order.
+func (c *Client) Scrape(ctx context.Context, announceURL string, infoHashes [][20]byte) ([]ScrapeStats, error) {
+
+	tr, err := c.Tracker(announceURL)
+	if err != nil {
+		return nil, err
+	}
+	return tr.Scrape(ctx, infoHashes)
+}
+
+// key returns Key, or the random key standing in for it
+func (c *Client) key() uint32 {
+
+	if c.Key != 0 {
+		return c.Key
+	}
+	c.keyOnce.Do(func() {
+		for c.randomKey == 0 {
+			c.randomKey = randomUint32()
+		}
+	})
+	return c.randomKey
+}
+
+// timeout returns Timeout, or the default standing in for it
+func (c *Client) timeout() time.Duration {
+	if c.Timeout > 0 {
+		return c.Timeout
+	}
+	return defaultTimeout
+}
+
+// get fetches trackerURL, retrying with exponential backoff, and returns the
+// body of the answer. At most limit bytes are read, plus one so that the
+// caller can tell the body was too long.
+func (c *Client) get(ctx context.Context, trackerURL string, limit int64) ([]byte, error) {
+
+	backoff := c.Backoff
+	if backoff <= 0 {
+		backoff = defaultBackoff
+	}
+
+	var err error
+	for attempt := 0; attempt <= c.Retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(backoff << (attempt - 1)):
+			case <-ctx.Done():
+				return nil, fmt.Errorf("failed to contact tracker: %w", ctx.Err())
+			}
+		}
+
+		var body []byte
+		var retry bool
+		body, retry, err = c.getOnce(ctx, trackerURL, limit)
+		if err == nil || !retry || ctx.Err() != nil {
+			return body, err
+		}
+	}
+	return nil, err
+}
+
+// getOnce makes a single attempt at fetching trackerURL, and says whether
+// it's worth trying again if it failed
+func (c *Client) getOnce(ctx context.Context, trackerURL string, limit int64) ([]byte, bool, error) {
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout())
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, trackerURL, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	userAgent := c.UserAgent
+	if userAgent == "" {
+		userAgent = defaultUserAgent
+	}
+	req.Header.Set("User-Agent", userAgent)
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient // the timeout is on the context
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, true, fmt.Errorf("failed to contact tracker: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK { // sanity check
+		return nil, resp.StatusCode >= 500, fmt.Errorf("tracker returned non-200 status: %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, true, fmt.Errorf("failed to read tracker response: %w", err)
+	}
+	return body, false, nil
+}
@@ -0,0 +1,254 @@
+package tracker
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newFlakyTracker starts an HTTP tracker that answers the first failures
+// requests with status, and the rest with a single peer. hits counts the
+// requests it got.
+func newFlakyTracker(t *testing.T, failures int32, status int, hits *atomic.Int32) string {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hits.Add(1) <= failures {
+			http.Error(w, "try again", status)
+			return
+		}
+		w.Write([]byte("d5:peers6:\x7f\x00\x00\x01\x1a\xe1e"))
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL + "/announce"
+}
+
+func TestClientRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures int32
+		status   int
+		retries  int
+		hits     int32
+		fails    bool
+	}{
+		{"no failures", 0, 0, 2, 1, false},
+		{"recovers", 2, http.StatusServiceUnavailable, 2, 3, false},
+		{"out of retries", 3, http.StatusServiceUnavailable, 2, 3, true},
+		{"no retries", 1, http.StatusBadGateway, 0, 1, true},
+		{"refusals aren't retried", 1, http.StatusNotFound, 2, 1, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var hits atomic.Int32
+			announceURL := newFlakyTracker(t, tt.failures, tt.status, &hits)
+			c := &Client{Retries: tt.retries, Backoff: time.Millisecond}
+
+			resp, err := c.Announce(context.Background(), announceURL, AnnounceRequest{})
+			if tt.fails {
+				if err == nil {
+					t.Error("expected error")
+				}
+			} else if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			} else if len(resp.Peers) != 1 {
+				t.Errorf("expected a single peer, got %v", resp.Peers)
+			}
+			if got := hits.Load(); got != tt.hits {
+				t.Errorf("expected %d requests, got %d", tt.hits, got)
+			}
+		})
+	}
+}
+
+func TestClientBackoff(t *testing.T) {
+	var hits atomic.Int32
+	announceURL := newFlakyTracker(t, 2, http.StatusServiceUnavailable, &hits)
+	c := &Client{Retries: 2, Backoff: 50 * time.Millisecond}
+
+	start := time.Now()
+	if _, err := c.Announce(context.Background(), announceURL, AnnounceRequest{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// 50ms before the first retry, 100ms before the second
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("expected the retries to back off, took %v", elapsed)
+	}
+}
+
+func TestClientTimeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	c := &Client{Timeout: 50 * time.Millisecond}
+	_, err := c.Announce(context.Background(), srv.URL+"/announce", AnnounceRequest{})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the request to time out, got %v", err)
+	}
+
+	// cancelling the context gives up straight away, retries and all
+	c = &Client{Retries: 5, Backoff: time.Hour}
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	_, err = c.Announce(ctx, srv.URL+"/announce", AnnounceRequest{})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the request to be cancelled, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected cancelling to be prompt, took %v", elapsed)
+	}
+}
+
+// roundTripFunc lets a function stand in for an http.RoundTripper
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestClientHTTPClient(t *testing.T) {
+	var got *http.Request
+	c := &Client{
+		HTTPClient: &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			got = r
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       http.NoBody,
+				Header:     http.Header{},
+			}, nil
+		})},
+		UserAgent: "test-agent/1.0",
+	}
+
+	// the empty body doesn't decode, but the request must have gone through
+	// the transport
+	if _, err := c.Announce(context.Background(), "http://tracker.invalid/announce", AnnounceRequest{}); err == nil {
+		t.Error("expected error for an empty response")
+	}
+	if got == nil {
+		t.Fatal("expected the request to go through the injected client")
+	}
+	if ua := got.Header.Get("User-Agent"); ua != "test-agent/1.0" {
+		t.Errorf("expected the configured user agent, got %q", ua)
+	}
+
+	got = nil
+	(&Client{HTTPClient: c.HTTPClient}).Announce(context.Background(), "http://tracker.invalid/announce", AnnounceRequest{})
+	if ua := got.Header.Get("User-Agent"); ua != defaultUserAgent {
+		t.Errorf("expected the default user agent, got %q", ua)
+	}
+}
+
+func TestClientAnnounceParams(t *testing.T) {
+	tests := []struct {
+		name     string
+		client   *Client
+		expected map[string]string
+	}{
+		{"defaults", &Client{}, map[string]string{"numwant": "", "ip": "", "no_peer_id": ""}},
+		{"numwant", &Client{NumWant: 80}, map[string]string{"numwant": "80"}},
+		{"key", &Client{Key: 0xdeadbeef}, map[string]string{"key": "deadbeef"}},
+		{"ip", &Client{IP: netip.MustParseAddr("2001:db8::1")}, map[string]string{"ip": "2001:db8::1"}},
+		{"no peer id", &Client{NoPeerID: true}, map[string]string{"no_peer_id": "1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			announceURL, queries := newAnnounceTracker(t, "d5:peers0:e")
+			if _, err := tt.client.Announce(context.Background(), announceURL, AnnounceRequest{}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			q := nextQuery(t, queries)
+			for key, expected := range tt.expected {
+				if got := q.Get(key); got != expected {
+					t.Errorf("expected %s %q, got %q", key, expected, got)
+				}
+			}
+		})
+	}
+}
+
+func TestClientKeyIsStable(t *testing.T) {
+	announceURL, queries := newAnnounceTracker(t, "d5:peers0:e")
+	c := &Client{}
+
+	var keys []string
+	for range 2 {
+		if _, err := c.Announce(context.Background(), announceURL, AnnounceRequest{}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		keys = append(keys, nextQuery(t, queries).Get("key"))
+	}
+	if keys[0] == "" || keys[0] != keys[1] {
+		t.Errorf("expected the same key in every announce, got %v", keys)
+	}
+	if other := (&Client{}).key(); other == c.key() {
+		t.Errorf("expected clients to pick their own keys, both got %08x", other)
+	}
+}
+
+func TestClientUDPParams(t *testing.T) {
+	srv := newUDPTestTracker(t, 0, "")
+
+	c := &Client{NumWant: 30, Key: 7, IP: netip.MustParseAddr("192.0.2.1")}
+	if _, err := c.Announce(context.Background(), srv.url(""), AnnounceRequest{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	packet := srv.lastAnnounce()
+	ip, _ := netip.AddrFromSlice(packet[84:88])
+	key := binary.BigEndian.Uint32(packet[88:92])
+	numWant := int32(binary.BigEndian.Uint32(packet[92:96]))
+	if ip != netip.MustParseAddr("192.0.2.1") || key != 7 || numWant != 30 {
+		t.Errorf("expected ip 192.0.2.1, key 7 and numwant 30, got %v, %d and %d", ip, key, numWant)
+	}
+
+	// left at zero, the tracker picks the address and how many peers to send
+	if _, err := (&Client{}).Announce(context.Background(), srv.url(""), AnnounceRequest{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	packet = srv.lastAnnounce()
+	ip, _ = netip.AddrFromSlice(packet[84:88])
+	if numWant := int32(binary.BigEndian.Uint32(packet[92:96])); !ip.IsUnspecified() || numWant != -1 {
+		t.Errorf("expected ip 0.0.0.0 and numwant -1, got %v and %d", ip, numWant)
+	}
+}
+
+func TestClientUDPCancel(t *testing.T) {
+	// a tracker that never answers
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = (&Client{}).Announce(ctx, "udp://"+conn.LocalAddr().String(), AnnounceRequest{})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the announce to time out, got %v", err)
+	}
+	// without the context, the first retransmission alone is 15 seconds away
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected the context to cut the announce short, took %v", elapsed)
+	}
+
+	if _, err := (&Client{}).Announce(context.Background(), "wss://tracker.invalid", AnnounceRequest{}); err == nil || !strings.Contains(err.Error(), "unsupported") {
+		t.Errorf("expected an unsupported protocol error, got %v", err)
+	}
+}
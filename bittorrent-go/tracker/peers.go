@@ -0,0 +1,119 @@
+package tracker
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/netip"
+
+	"github.com/lourencovales/codecrafters/bittorrent-go/bencode"
+)
+
+// peerList is the 'peers' value of a tracker response. It comes in one of two
+// forms: the compact one, a string where each peer takes up 6 bytes, the IPv4
+// address followed by the port in network byte order, or the original one, a
+// list of dictionaries.
+type peerList []netip.AddrPort
+
+// dictPeer is a peer in the dictionary form of the peer list. IP can be an
+// IPv4 or IPv6 address, or a host name.
+type dictPeer struct {
+	PeerID string `bencode:"peer id"`
+	IP     string `bencode:"ip"`
+	Port   int    `bencode:"port"`
+}
+
+// UnmarshalBencode turns either form of the peer list into addresses.
+func (p *peerList) UnmarshalBencode(data []byte) error {
+
+	if len(data) > 0 && data[0] == 'l' {
+		var dicts []dictPeer
+		if err := bencode.Unmarshal(data, &dicts); err != nil {
+			return fmt.Errorf("malformed peers list: %w", err)
+		}
+		peers, err := parseDictPeers(dicts)
+		if err != nil {
+			return err
+		}
+		*p = peers
+		return nil
+	}
+
+	var peersBytes []byte
+	if err := bencode.Unmarshal(data, &peersBytes); err != nil {
+		return errors.New("'peers' key is neither a string nor a list")
+	}
+
+	peers, err := parseCompactPeers(peersBytes)
+	if err != nil {
+		return err
+	}
+	*p = peers
+	return nil
+}
+
+// compactPeers6 is the 'peers6' value of a tracker response (BEP 7), the
+// compact form of the peer list for IPv6 peers, 18 bytes each.
+type compactPeers6 []netip.AddrPort
+
+// UnmarshalBencode turns the compact peer string into addresses.
+func (p *compactPeers6) UnmarshalBencode(data []byte) error {
+
+	var peersBytes []byte
+	if err := bencode.Unmarshal(data, &peersBytes); err != nil {
+		return errors.New("'peers6' key is not a string")
+	}
+
+	peers, err := parseCompactPeers6(peersBytes)
+	if err != nil {
+		return err
+	}
+	*p = peers
+	return nil
+}
+
+// parseDictPeers turns the dictionary form of the peer list into addresses.
+// Peers given by host name are skipped rather than looked up.
+func parseDictPeers(dicts []dictPeer) ([]netip.AddrPort, error) {
+
+	peers := make([]netip.AddrPort, 0, len(dicts))
+	for _, d := range dicts {
+		addr, err := netip.ParseAddr(d.IP)
+		if err != nil {
+			continue
+		}
+		if d.Port <= 0 || d.Port > 65535 {
+			return nil, fmt.Errorf("peer %s has invalid port %d", d.IP, d.Port)
+		}
+		peers = append(peers, netip.AddrPortFrom(addr.Unmap(), uint16(d.Port)))
+	}
+	return peers, nil
+}
+
+// parseCompactPeers turns a compact IPv4 peer list, as found in both HTTP and
+// UDP announce responses, into addresses.
+func parseCompactPeers(b []byte) ([]netip.AddrPort, error) {
+	return parseCompact(b, 4)
+}
+
+// parseCompactPeers6 turns a compact IPv6 peer list into addresses.
+func parseCompactPeers6(b []byte) ([]netip.AddrPort, error) {
+	return parseCompact(b, 16)
+}
+
+// parseCompact splits b into addresses of addrLen bytes, each followed by a
+// 2 byte port
+func parseCompact(b []byte, addrLen int) ([]netip.AddrPort, error) {
+
+	size := addrLen + 2
+	if len(b)%size != 0 {
+		return nil, errors.New("malformed peers list")
+	}
+	peers := make([]netip.AddrPort, 0, len(b)/size)
+	for i := 0; i < len(b); i += size {
+		addr, _ := netip.AddrFromSlice(b[i : i+addrLen])
+		port := binary.BigEndian.Uint16(b[i+addrLen : i+size])
+		peers = append(peers, netip.AddrPortFrom(addr.Unmap(), port))
+	}
+	return peers, nil
+}
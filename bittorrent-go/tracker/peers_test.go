@@ -0,0 +1,88 @@
+package tracker
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+func TestParseCompact(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    []byte
+		addrLen  int
+		expected []netip.AddrPort
+		hasError bool
+	}{
+		{"empty", nil, 4, []netip.AddrPort{}, false},
+		{"IPv4", []byte{10, 0, 0, 1, 0x1A, 0xE1}, 4, []netip.AddrPort{netip.MustParseAddrPort("10.0.0.1:6881")}, false},
+		{
+			"IPv6",
+			[]byte{0xfe, 0x80, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 2, 0x1A, 0xE1},
+			16,
+			[]netip.AddrPort{netip.MustParseAddrPort("[fe80::2]:6881")},
+			false,
+		},
+		{
+			"IPv4-mapped IPv6",
+			[]byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xff, 0xff, 10, 0, 0, 1, 0x1A, 0xE1},
+			16,
+			[]netip.AddrPort{netip.MustParseAddrPort("10.0.0.1:6881")},
+			false,
+		},
+		{"short IPv4 peer", make([]byte, 7), 4, nil, true},
+		{"IPv6 list with IPv4 peers", make([]byte, 12), 16, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			peers, err := parseCompact(tt.input, tt.addrLen)
+			if tt.hasError {
+				if err == nil {
+					t.Error("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(peers, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, peers)
+			}
+		})
+	}
+}
+
+func TestParseDictPeers(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    []dictPeer
+		expected []netip.AddrPort
+		hasError bool
+	}{
+		{"IPv4", []dictPeer{{IP: "10.0.0.1", Port: 6881}}, []netip.AddrPort{netip.MustParseAddrPort("10.0.0.1:6881")}, false},
+		{"IPv6", []dictPeer{{IP: "2001:db8::1", Port: 6881}}, []netip.AddrPort{netip.MustParseAddrPort("[2001:db8::1]:6881")}, false},
+		{"IPv4-mapped", []dictPeer{{IP: "::ffff:10.0.0.1", Port: 6881}}, []netip.AddrPort{netip.MustParseAddrPort("10.0.0.1:6881")}, false},
+		{"host name", []dictPeer{{IP: "peer.example.com", Port: 6881}}, []netip.AddrPort{}, false},
+		{"port zero", []dictPeer{{IP: "10.0.0.1"}}, nil, true},
+		{"port too large", []dictPeer{{IP: "10.0.0.1", Port: 65536}}, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			peers, err := parseDictPeers(tt.input)
+			if tt.hasError {
+				if err == nil {
+					t.Error("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(peers, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, peers)
+			}
+		})
+	}
+}
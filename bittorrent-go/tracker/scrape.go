@@ -0,0 +1,118 @@
+package tracker
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+)
+
+// ScrapeStats are the numbers a tracker keeps for a torrent.
+type ScrapeStats struct {
+	// Seeders is 'complete', the peers that have all of the torrent.
+	Seeders int
+	// Completed is 'downloaded', how many times the torrent was downloaded
+	// in full.
+	Completed int
+	// Leechers is 'incomplete', the peers that are still downloading.
+	Leechers int
+}
+
+// ErrScrapeUnsupported is returned for HTTP trackers whose announce URL
+// doesn't follow the convention scrape URLs are derived from.
+var ErrScrapeUnsupported = errors.New("tracker doesn't support scraping")
+
+// Scrape asks the tracker at announceURL for the stats of each of
+// infoHashes, which come back in the same order, with DefaultClient.
+func Scrape(announceURL string, infoHashes [][20]byte) ([]ScrapeStats, error) {
+	return DefaultClient.Scrape(context.Background(), announceURL, infoHashes)
+}
+
+// ScrapeURL derives the scrape URL of an HTTP tracker from its announce URL:
+// by convention, the last part of the path has to start with "announce",
+// which is swapped for "scrape". Trackers whose URL doesn't get
+// ErrScrapeUnsupported.
+func ScrapeURL(announceURL string) (string, error) {
+
+	u, err := url.Parse(announceURL)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse announce URL: %w", err)
+	}
+
+	i := strings.LastIndex(u.Path, "/")
+	last := u.Path[i+1:]
+	if !strings.HasPrefix(last, "announce") {
+		return "", ErrScrapeUnsupported
+	}
+	u.Path = u.Path[:i+1] + "scrape" + strings.TrimPrefix(last, "announce")
+	u.RawPath = ""
+	return u.String(), nil
+}
+
+// Scrape sends the scrape to the tracker, with an info_hash for each torrent.
+// The query of the announce URL, passkeys and such, is kept.
+func (t *httpTracker) Scrape(ctx context.Context, infoHashes [][20]byte) ([]ScrapeStats, error) {
+
+	if len(infoHashes) == 0 {
+		return nil, errors.New("nothing to scrape")
+	}
+
+	scrapeURL, err := ScrapeURL(t.announceURL)
+	if err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(scrapeURL)
+	if err != nil {
+		return nil, err
+	}
+	params := u.Query()
+	for _, h := range infoHashes {
+		params.Add("info_hash", string(h[:]))
+	}
+	u.RawQuery = params.Encode()
+
+	body, err := t.client.get(ctx, u.String(), int64(responseLimits.MaxInputSize))
+	if err != nil {
+		return nil, err
+	}
+	return parseScrapeResponse(bytes.NewReader(body), infoHashes)
+}
+
+// scrapeResponse is the bencoded dictionary sent back for a scrape, with the
+// stats under the raw info hash of each torrent.
+type scrapeResponse struct {
+	FailureReason string                `bencode:"failure reason"`
+	Files         map[string]scrapeFile `bencode:"files"`
+}
+
+type scrapeFile struct {
+	Complete   int `bencode:"complete"`
+	Downloaded int `bencode:"downloaded"`
+	Incomplete int `bencode:"incomplete"`
+}
+
+// parseScrapeResponse decodes the tracker's answer to a scrape. Torrents the
+// tracker left out, because it doesn't know them, get zeros.
+func parseScrapeResponse(body io.Reader, infoHashes [][20]byte) ([]ScrapeStats, error) {
+
+	var resp scrapeResponse
+	if err := responseLimits.NewDecoder(body).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal scrape response: %w", err)
+	}
+	if resp.FailureReason != "" {
+		return nil, &FailureError{Reason: resp.FailureReason}
+	}
+	if resp.Files == nil {
+		return nil, errors.New("scrape response missing 'files' key")
+	}
+
+	stats := make([]ScrapeStats, len(infoHashes))
+	for i, h := range infoHashes {
+		f := resp.Files[string(h[:])]
+		stats[i] = ScrapeStats{Seeders: f.Complete, Completed: f.Downloaded, Leechers: f.Incomplete}
+	}
+	return stats, nil
+}
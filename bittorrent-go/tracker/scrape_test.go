@@ -0,0 +1,113 @@
+package tracker
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestScrapeURL(t *testing.T) {
+	tests := []struct {
+		announceURL string
+		expected    string
+		err         error
+	}{
+		{"http://example.com/announce", "http://example.com/scrape", nil},
+		{"http://example.com/x/announce", "http://example.com/x/scrape", nil},
+		{"http://example.com/announce.php", "http://example.com/scrape.php", nil},
+		{"http://example.com/announce?x2%0644", "http://example.com/scrape?x2%0644", nil},
+		{"http://example.com/announce?x=y%3dz", "http://example.com/scrape?x=y%3dz", nil},
+		{"http://example.com/a", "", ErrScrapeUnsupported},
+		{"http://example.com/announce?x=2/4", "http://example.com/scrape?x=2/4", nil},
+		{"http://example.com/announce/x", "", ErrScrapeUnsupported},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.announceURL, func(t *testing.T) {
+			got, err := ScrapeURL(tt.announceURL)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expected error %v, got %v", tt.err, err)
+			}
+			if got != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestHTTPScrape(t *testing.T) {
+	hashA := [20]byte{'a'}
+	hashB := [20]byte{'b'}
+
+	var gotPath string
+	var gotHashes []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotHashes = r.URL.Query()["info_hash"]
+		if r.URL.Query().Get("passkey") != "abc" {
+			w.Write([]byte("d14:failure reason11:bad passkeye"))
+			return
+		}
+		w.Write([]byte("d5:filesd20:" + string(hashA[:]) + "d8:completei5e10:downloadedi50e10:incompletei10eeee"))
+	}))
+	defer srv.Close()
+
+	stats, err := Scrape(srv.URL+"/announce?passkey=abc", [][20]byte{hashA, hashB})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []ScrapeStats{{Seeders: 5, Completed: 50, Leechers: 10}, {}}
+	if !reflect.DeepEqual(stats, expected) {
+		t.Errorf("expected %+v, got %+v", expected, stats)
+	}
+	if gotPath != "/scrape" || !reflect.DeepEqual(gotHashes, []string{string(hashA[:]), string(hashB[:])}) {
+		t.Errorf("unexpected scrape of %s for %q", gotPath, gotHashes)
+	}
+
+	_, err = Scrape(srv.URL+"/announce", [][20]byte{hashA})
+	var failure *FailureError
+	if !errors.As(err, &failure) || failure.Reason != "bad passkey" {
+		t.Errorf("expected a FailureError, got %v", err)
+	}
+
+	if _, err := Scrape(srv.URL+"/tracker", [][20]byte{hashA}); !errors.Is(err, ErrScrapeUnsupported) {
+		t.Errorf("expected ErrScrapeUnsupported, got %v", err)
+	}
+	if _, err := Scrape(srv.URL+"/announce", nil); err == nil {
+		t.Error("expected error when scraping nothing")
+	}
+}
+
+func TestParseScrapeResponse(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		hasError bool
+	}{
+		{"no torrents", "d5:filesdee", false},
+		{"missing files", "de", true},
+		{"files not a dictionary", "d5:filesi1ee", true},
+		{"invalid bencode", "d5:files", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stats, err := parseScrapeResponse(strings.NewReader(tt.input), [][20]byte{{1}})
+			if tt.hasError {
+				if err == nil {
+					t.Error("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(stats, []ScrapeStats{{}}) {
+				t.Errorf("expected zeros for an unknown torrent, got %+v", stats)
+			}
+		})
+	}
+}
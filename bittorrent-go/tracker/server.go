@@ -0,0 +1,446 @@
+package tracker
+
+import (
+	"encoding/binary"
+	"math/rand"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lourencovales/codecrafters/bittorrent-go/bencode"
+)
+
+// These are the defaults of a Server whose options are left at zero.
+const (
+	defaultServerInterval = 30 * time.Minute
+	defaultNumWant        = 50
+	defaultMaxNumWant     = 200
+)
+
+// ServerOptions configures a Server.
+type ServerOptions struct {
+	// Interval is how often peers are asked to announce, 30 minutes when
+	// zero.
+	Interval time.Duration
+	// MinInterval, when set, is how often at most peers may announce.
+	MinInterval time.Duration
+	// PeerTTL is how long a peer stays in its swarm without announcing,
+	// twice Interval when zero.
+	PeerTTL time.Duration
+	// NumWant is how many peers are handed out to announces that don't ask
+	// for a number, and MaxNumWant the most that are handed out at all.
+	NumWant    int
+	MaxNumWant int
+	// Allowlist, when not empty, is the only torrents the server tracks.
+	// Anything else is refused.
+	Allowlist [][20]byte
+	// TrustIP has the server take the 'ip' parameter of announces as the
+	// peer's address, instead of the one the request came from. It's off by
+	// default, since anyone could then add someone else's address to a
+	// swarm.
+	TrustIP bool
+}
+
+// ServerStats are the numbers a Server keeps about itself.
+type ServerStats struct {
+	Swarms    int
+	Seeders   int
+	Leechers  int
+	Announces int64
+	Scrapes   int64
+	// Failures counts the requests that were turned down.
+	Failures int64
+}
+
+// Server is an HTTP tracker. It serves announces under /announce and scrapes
+// under /scrape, keeping the swarms in memory, so they're gone once it stops.
+// Peers that stop announcing are dropped after ServerOptions.PeerTTL.
+type Server struct {
+	opts    ServerOptions
+	allowed map[[20]byte]bool
+	mux     *http.ServeMux
+	// now is time.Now, swapped out in the tests
+	now func() time.Time
+
+	mu        sync.Mutex
+	swarms    map[[20]byte]*swarm
+	lastSweep time.Time
+	stats     ServerStats
+}
+
+// swarm is the peers of a single torrent, keyed by peer ID
+type swarm struct {
+	peers map[[20]byte]*swarmPeer
+	// downloaded is how many completed events the swarm got
+	downloaded int
+}
+
+type swarmPeer struct {
+	addr     netip.AddrPort
+	seeder   bool
+	lastSeen time.Time
+}
+
+// NewServer returns a Server with opts. It's an http.Handler, to be served
+// however the caller likes.
+func NewServer(opts ServerOptions) *Server {
+
+	if opts.Interval <= 0 {
+		opts.Interval = defaultServerInterval
+	}
+	if opts.PeerTTL <= 0 {
+		opts.PeerTTL = 2 * opts.Interval
+	}
+	if opts.MaxNumWant <= 0 {
+		opts.MaxNumWant = defaultMaxNumWant
+	}
+	if opts.NumWant <= 0 {
+		opts.NumWant = min(defaultNumWant, opts.MaxNumWant)
+	}
+
+	s := &Server{
+		opts:   opts,
+		mux:    http.NewServeMux(),
+		now:    time.Now,
+		swarms: make(map[[20]byte]*swarm),
+	}
+	if len(opts.Allowlist) > 0 {
+		s.allowed = make(map[[20]byte]bool, len(opts.Allowlist))
+		for _, h := range opts.Allowlist {
+			s.allowed[h] = true
+		}
+	}
+	s.mux.HandleFunc("GET /announce", s.handleAnnounce)
+	s.mux.HandleFunc("GET /scrape", s.handleScrape)
+	return s
+}
+
+// ServeHTTP answers announces and scrapes.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Stats returns the server's numbers so far.
+func (s *Server) Stats() ServerStats {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep()
+
+	stats := s.stats
+	stats.Swarms = len(s.swarms)
+	for _, sw := range s.swarms {
+		seeders, leechers := sw.count()
+		stats.Seeders += seeders
+		stats.Leechers += leechers
+	}
+	return stats
+}
+
+// announceParams are the parts of an announce the server cares about
+type announceParams struct {
+	infoHash [20]byte
+	peerID   [20]byte
+	addr     netip.AddrPort
+	left     int64
+	event    string
+	compact  bool
+	noPeerID bool
+	numWant  int
+}
+
+// serverResponse is the bencoded answer to an announce. Peers is either the
+// compact string or a list of serverPeer, depending on what was asked for.
+type serverResponse struct {
+	Interval    int         `bencode:"interval,omitempty"`
+	MinInterval int         `bencode:"min interval,omitempty"`
+	Complete    int         `bencode:"complete"`
+	Incomplete  int         `bencode:"incomplete"`
+	Peers       interface{} `bencode:"peers"`
+	Peers6      []byte      `bencode:"peers6,omitempty"`
+}
+
+// serverPeer is a peer in the dictionary form of the peer list
+type serverPeer struct {
+	PeerID []byte `bencode:"peer id,omitempty"`
+	IP     string `bencode:"ip"`
+	Port   uint16 `bencode:"port"`
+}
+
+func (s *Server) handleAnnounce(w http.ResponseWriter, r *http.Request) {
+
+	p, reason := s.parseAnnounce(r)
+	if reason == "" && !s.isAllowed(p.infoHash) {
+		reason = "torrent not allowed on this tracker"
+	}
+	if reason != "" {
+		s.fail(w, reason)
+		return
+	}
+
+	s.mu.Lock()
+	s.stats.Announces++
+	s.sweep()
+	resp := s.announce(p)
+	s.mu.Unlock()
+
+	writeBencoded(w, resp)
+}
+
+// parseAnnounce reads the announce out of the query string, or says what's
+// wrong with it
+func (s *Server) parseAnnounce(r *http.Request) (announceParams, string) {
+
+	q := r.URL.Query()
+	p := announceParams{
+		event:    q.Get("event"),
+		compact:  q.Get("compact") != "0",
+		noPeerID: q.Get("no_peer_id") == "1",
+		numWant:  s.opts.NumWant,
+	}
+
+	if len(q.Get("info_hash")) != 20 {
+		return p, "invalid info_hash"
+	}
+	copy(p.infoHash[:], q.Get("info_hash"))
+	if len(q.Get("peer_id")) != 20 {
+		return p, "invalid peer_id"
+	}
+	copy(p.peerID[:], q.Get("peer_id"))
+	port, err := strconv.ParseUint(q.Get("port"), 10, 16)
+	if err != nil || port == 0 {
+		return p, "invalid port"
+	}
+	if p.left, err = strconv.ParseInt(q.Get("left"), 10, 64); err != nil || p.left < 0 {
+		return p, "invalid left"
+	}
+	switch p.event {
+	case "", "started", "completed", "stopped":
+	default:
+		return p, "invalid event"
+	}
+	if q.Has("numwant") {
+		n, err := strconv.Atoi(q.Get("numwant"))
+		if err != nil || n < 0 {
+			return p, "invalid numwant"
+		}
+		p.numWant = n
+	}
+	p.numWant = min(p.numWant, s.opts.MaxNumWant)
+
+	remote, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return p, "unknown peer address"
+	}
+	ip := remote.Addr().Unmap()
+	if s.opts.TrustIP && q.Has("ip") {
+		given, err := netip.ParseAddr(q.Get("ip"))
+		if err != nil {
+			return p, "invalid ip"
+		}
+		ip = given.Unmap()
+	}
+	p.addr = netip.AddrPortFrom(ip, uint16(port))
+	return p, ""
+}
+
+// announce updates the swarm with the announce and picks the peers to hand
+// out. s.mu is held.
+func (s *Server) announce(p announceParams) *serverResponse {
+
+	now := s.now()
+	sw := s.swarms[p.infoHash]
+	if sw == nil {
+		sw = &swarm{peers: make(map[[20]byte]*swarmPeer)}
+		s.swarms[p.infoHash] = sw
+	}
+	sw.expire(now.Add(-s.opts.PeerTTL))
+
+	known := sw.peers[p.peerID]
+	if p.event == "stopped" {
+		delete(sw.peers, p.peerID)
+		if sw.empty() {
+			delete(s.swarms, p.infoHash)
+		}
+	} else {
+		// only the first completed event of a peer counts
+		if p.event == "completed" && (known == nil || !known.seeder) {
+			sw.downloaded++
+		}
+		sw.peers[p.peerID] = &swarmPeer{addr: p.addr, seeder: p.left == 0, lastSeen: now}
+	}
+
+	seeders, leechers := sw.count()
+	resp := &serverResponse{
+		Interval:    int(s.opts.Interval / time.Second),
+		MinInterval: int(s.opts.MinInterval / time.Second),
+		Complete:    seeders,
+		Incomplete:  leechers,
+	}
+	if p.event == "stopped" {
+		p.numWant = 0 // on its way out, it has no use for peers
+	}
+
+	// a random pick of the others, leaving out seeders for seeders, which
+	// have no use for them
+	var ids [][20]byte
+	for id, peer := range sw.peers {
+		if id != p.peerID && !(p.left == 0 && peer.seeder) {
+			ids = append(ids, id)
+		}
+	}
+	rand.Shuffle(len(ids), func(a, b int) { ids[a], ids[b] = ids[b], ids[a] })
+	ids = ids[:min(len(ids), p.numWant)]
+
+	if !p.compact {
+		peers := []serverPeer{}
+		for _, id := range ids {
+			addr := sw.peers[id].addr
+			sp := serverPeer{IP: addr.Addr().String(), Port: addr.Port()}
+			if !p.noPeerID {
+				sp.PeerID = id[:]
+			}
+			peers = append(peers, sp)
+		}
+		resp.Peers = peers
+		return resp
+	}
+
+	// IPv4 peers go in 'peers' and IPv6 ones in 'peers6' (BEP 7)
+	compact := []byte{}
+	for _, id := range ids {
+		if addr := sw.peers[id].addr; addr.Addr().Is4() {
+			compact = appendCompact(compact, addr)
+		} else {
+			resp.Peers6 = appendCompact(resp.Peers6, addr)
+		}
+	}
+	resp.Peers = compact
+	return resp
+}
+
+// appendCompact appends the compact form of addr, the address followed by the
+// port in network byte order
+func appendCompact(b []byte, addr netip.AddrPort) []byte {
+	b = append(b, addr.Addr().AsSlice()...)
+	return binary.BigEndian.AppendUint16(b, addr.Port())
+}
+
+// serverScrapeResponse is the bencoded answer to a scrape
+type serverScrapeResponse struct {
+	Files map[string]scrapeFile `bencode:"files"`
+}
+
+func (s *Server) handleScrape(w http.ResponseWriter, r *http.Request) {
+
+	var hashes [][20]byte
+	for _, raw := range r.URL.Query()["info_hash"] {
+		var h [20]byte
+		if len(raw) != 20 {
+			s.fail(w, "invalid info_hash")
+			return
+		}
+		copy(h[:], raw)
+		hashes = append(hashes, h)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stats.Scrapes++
+	s.sweep()
+
+	// with no info_hash, every torrent is scraped
+	if len(hashes) == 0 {
+		for h := range s.swarms {
+			hashes = append(hashes, h)
+		}
+	}
+
+	resp := serverScrapeResponse{Files: make(map[string]scrapeFile)}
+	for _, h := range hashes {
+		if !s.isAllowed(h) {
+			continue
+		}
+		var f scrapeFile
+		if sw := s.swarms[h]; sw != nil {
+			f.Complete, f.Incomplete = sw.count()
+			f.Downloaded = sw.downloaded
+		}
+		resp.Files[string(h[:])] = f
+	}
+	writeBencoded(w, resp)
+}
+
+// isAllowed says whether the server tracks the torrent
+func (s *Server) isAllowed(infoHash [20]byte) bool {
+	return s.allowed == nil || s.allowed[infoHash]
+}
+
+// fail turns a request down. Trackers answer with a failure reason, and a 200
+// status so that clients read it.
+func (s *Server) fail(w http.ResponseWriter, reason string) {
+
+	s.mu.Lock()
+	s.stats.Failures++
+	s.mu.Unlock()
+
+	writeBencoded(w, map[string]string{"failure reason": reason})
+}
+
+func writeBencoded(w http.ResponseWriter, v interface{}) {
+
+	data, err := bencode.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write(data)
+}
+
+// sweep drops expired peers from every swarm, and the swarms left empty, at
+// most once per PeerTTL. s.mu is held.
+func (s *Server) sweep() {
+
+	now := s.now()
+	if now.Sub(s.lastSweep) < s.opts.PeerTTL {
+		return
+	}
+	s.lastSweep = now
+
+	for h, sw := range s.swarms {
+		sw.expire(now.Add(-s.opts.PeerTTL))
+		if sw.empty() {
+			delete(s.swarms, h)
+		}
+	}
+}
+
+// expire drops the peers last seen before deadline
+func (sw *swarm) expire(deadline time.Time) {
+	for id, peer := range sw.peers {
+		if peer.lastSeen.Before(deadline) {
+			delete(sw.peers, id)
+		}
+	}
+}
+
+// empty says whether the swarm has nothing worth keeping: no peers, and no
+// downloads to report in scrapes
+func (sw *swarm) empty() bool {
+	return len(sw.peers) == 0 && sw.downloaded == 0
+}
+
+// count returns how many seeders and leechers the swarm has
+func (sw *swarm) count() (seeders, leechers int) {
+	for _, peer := range sw.peers {
+		if peer.seeder {
+			seeders++
+		} else {
+			leechers++
+		}
+	}
+	return seeders, leechers
+}
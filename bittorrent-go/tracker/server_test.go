@@ -0,0 +1,335 @@
+package tracker
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestServer starts a Server, and returns it along with its announce URL
+func newTestServer(t *testing.T, opts ServerOptions) (*Server, string) {
+	s := NewServer(opts)
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+	return s, srv.URL + "/announce"
+}
+
+// serverAnnounce sends req to the server, which has to answer it
+func serverAnnounce(t *testing.T, c *Client, announceURL string, req AnnounceRequest) *AnnounceResponse {
+	t.Helper()
+	resp, err := c.Announce(context.Background(), announceURL, req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return resp
+}
+
+func TestServerAnnounce(t *testing.T) {
+	s, announceURL := newTestServer(t, ServerOptions{Interval: 10 * time.Minute, MinInterval: time.Minute})
+	c := &Client{}
+	hash := [20]byte{1}
+
+	resp := serverAnnounce(t, c, announceURL, AnnounceRequest{InfoHash: hash, PeerID: [20]byte{'a'}, Port: 1001, Left: 10, Event: EventStarted})
+	if len(resp.Peers) != 0 {
+		t.Errorf("expected the first peer to get no peers, got %v", resp.Peers)
+	}
+	if resp.Interval != 10*time.Minute || resp.MinInterval != time.Minute {
+		t.Errorf("expected the configured intervals, got %v and %v", resp.Interval, resp.MinInterval)
+	}
+
+	serverAnnounce(t, c, announceURL, AnnounceRequest{InfoHash: hash, PeerID: [20]byte{'b'}, Port: 1002, Left: 0, Event: EventStarted})
+	resp = serverAnnounce(t, c, announceURL, AnnounceRequest{InfoHash: hash, PeerID: [20]byte{'c'}, Port: 1003, Left: 10, Event: EventStarted})
+	if resp.Seeders != 1 || resp.Leechers != 2 {
+		t.Errorf("expected 1 seeder and 2 leechers, got %d and %d", resp.Seeders, resp.Leechers)
+	}
+	ports := map[uint16]bool{}
+	for _, p := range resp.Peers {
+		ports[p.Port()] = true
+	}
+	if !reflect.DeepEqual(ports, map[uint16]bool{1001: true, 1002: true}) {
+		t.Errorf("expected the other two peers, got %v", resp.Peers)
+	}
+
+	// seeders only get leechers
+	resp = serverAnnounce(t, c, announceURL, AnnounceRequest{InfoHash: hash, PeerID: [20]byte{'b'}, Port: 1002, Left: 0})
+	for _, p := range resp.Peers {
+		if p.Port() == 1002 {
+			t.Errorf("expected the seeder not to get itself, got %v", resp.Peers)
+		}
+	}
+	if len(resp.Peers) != 2 {
+		t.Errorf("expected both leechers, got %v", resp.Peers)
+	}
+
+	// other swarms are kept apart
+	resp = serverAnnounce(t, c, announceURL, AnnounceRequest{InfoHash: [20]byte{2}, PeerID: [20]byte{'a'}, Port: 1001, Left: 10})
+	if len(resp.Peers) != 0 || resp.Seeders != 0 || resp.Leechers != 1 {
+		t.Errorf("expected a swarm of its own, got %+v", resp)
+	}
+
+	if stats := s.Stats(); stats.Swarms != 2 || stats.Seeders != 1 || stats.Leechers != 3 || stats.Announces != 5 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestServerNumWant(t *testing.T) {
+	_, announceURL := newTestServer(t, ServerOptions{NumWant: 2, MaxNumWant: 3})
+	for i := range 5 {
+		serverAnnounce(t, &Client{}, announceURL, AnnounceRequest{PeerID: [20]byte{byte(i)}, Port: uint16(1000 + i), Left: 1})
+	}
+
+	tests := []struct {
+		name     string
+		numWant  int
+		expected int
+	}{
+		{"default", 0, 2},
+		{"fewer", 1, 1},
+		{"capped", 10, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Client{NumWant: tt.numWant}
+			resp := serverAnnounce(t, c, announceURL, AnnounceRequest{PeerID: [20]byte{'z'}, Port: 2000, Left: 1})
+			if len(resp.Peers) != tt.expected {
+				t.Errorf("expected %d peers, got %v", tt.expected, resp.Peers)
+			}
+		})
+	}
+}
+
+func TestServerIPv6(t *testing.T) {
+	_, announceURL := newTestServer(t, ServerOptions{TrustIP: true})
+
+	serverAnnounce(t, &Client{IP: netip.MustParseAddr("2001:db8::1")}, announceURL, AnnounceRequest{PeerID: [20]byte{'a'}, Port: 1001, Left: 1})
+	serverAnnounce(t, &Client{IP: netip.MustParseAddr("192.0.2.1")}, announceURL, AnnounceRequest{PeerID: [20]byte{'b'}, Port: 1002, Left: 1})
+
+	resp, err := http.Get(announceURL + "?" + testAnnounceQuery("c", nil).Encode())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	// the IPv4 peer in 'peers', the IPv6 one in 'peers6'
+	for _, expected := range []string{
+		"5:peers6:\xc0\x00\x02\x01\x03\xea",
+		"6:peers618:\x20\x01\x0d\xb8\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01\x03\xe9",
+	} {
+		if !strings.Contains(string(body), expected) {
+			t.Errorf("expected %q in %q", expected, body)
+		}
+	}
+}
+
+func TestServerIgnoresIP(t *testing.T) {
+	_, announceURL := newTestServer(t, ServerOptions{})
+
+	serverAnnounce(t, &Client{IP: netip.MustParseAddr("192.0.2.1")}, announceURL, AnnounceRequest{PeerID: [20]byte{'a'}, Port: 1001, Left: 1})
+	resp := serverAnnounce(t, &Client{}, announceURL, AnnounceRequest{PeerID: [20]byte{'b'}, Port: 1002, Left: 1})
+	if expected := netip.MustParseAddrPort("127.0.0.1:1001"); len(resp.Peers) != 1 || resp.Peers[0] != expected {
+		t.Errorf("expected the address the announce came from, %v, got %v", expected, resp.Peers)
+	}
+}
+
+// testAnnounceQuery is a valid announce from peerID, with extra on top
+func testAnnounceQuery(peerID string, extra url.Values) url.Values {
+	q := url.Values{
+		"info_hash": {strings.Repeat("\x00", 20)},
+		"peer_id":   {peerID + strings.Repeat("-", 20-len(peerID))},
+		"port":      {"6881"},
+		"left":      {"1"},
+	}
+	for k, v := range extra {
+		q[k] = v
+	}
+	return q
+}
+
+func TestServerDictPeers(t *testing.T) {
+	_, announceURL := newTestServer(t, ServerOptions{})
+	serverAnnounce(t, &Client{}, announceURL, AnnounceRequest{PeerID: [20]byte{'a'}, Port: 1001, Left: 1})
+
+	tests := []struct {
+		name     string
+		extra    url.Values
+		expected string
+	}{
+		{"with peer ids", url.Values{"compact": {"0"}}, "5:peersld2:ip9:127.0.0.17:peer id20:a\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x004:porti1001eee"},
+		{"without peer ids", url.Values{"compact": {"0"}, "no_peer_id": {"1"}}, "5:peersld2:ip9:127.0.0.14:porti1001eee"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := http.Get(announceURL + "?" + testAnnounceQuery("b", tt.extra).Encode())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			if !strings.Contains(string(body), tt.expected) {
+				t.Errorf("expected %q in %q", tt.expected, body)
+			}
+
+			parsed, err := parseResponse(strings.NewReader(string(body)))
+			if err != nil {
+				t.Fatalf("expected the client to read the response, got %v", err)
+			}
+			if len(parsed.Peers) != 1 || parsed.Peers[0].Port() != 1001 {
+				t.Errorf("expected the client to read back the peer, got %v", parsed.Peers)
+			}
+		})
+	}
+}
+
+func TestServerInvalidAnnounce(t *testing.T) {
+	_, announceURL := newTestServer(t, ServerOptions{})
+
+	tests := []struct {
+		name   string
+		query  url.Values
+		reason string
+	}{
+		{"short info_hash", testAnnounceQuery("a", url.Values{"info_hash": {"abc"}}), "invalid info_hash"},
+		{"no peer_id", testAnnounceQuery("a", url.Values{"peer_id": nil}), "invalid peer_id"},
+		{"bad port", testAnnounceQuery("a", url.Values{"port": {"70000"}}), "invalid port"},
+		{"zero port", testAnnounceQuery("a", url.Values{"port": {"0"}}), "invalid port"},
+		{"no left", testAnnounceQuery("a", url.Values{"left": nil}), "invalid left"},
+		{"negative left", testAnnounceQuery("a", url.Values{"left": {"-1"}}), "invalid left"},
+		{"bad event", testAnnounceQuery("a", url.Values{"event": {"paused"}}), "invalid event"},
+		{"bad numwant", testAnnounceQuery("a", url.Values{"numwant": {"lots"}}), "invalid numwant"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := http.Get(announceURL + "?" + tt.query.Encode())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			defer resp.Body.Close()
+			_, err = parseResponse(resp.Body)
+			var failure *FailureError
+			if !errors.As(err, &failure) || failure.Reason != tt.reason {
+				t.Errorf("expected failure %q, got %v", tt.reason, err)
+			}
+		})
+	}
+}
+
+func TestServerStoppedAndCompleted(t *testing.T) {
+	s, announceURL := newTestServer(t, ServerOptions{})
+	c := &Client{}
+	hash := [20]byte{3}
+
+	serverAnnounce(t, c, announceURL, AnnounceRequest{InfoHash: hash, PeerID: [20]byte{'a'}, Port: 1001, Left: 5, Event: EventStarted})
+	serverAnnounce(t, c, announceURL, AnnounceRequest{InfoHash: hash, PeerID: [20]byte{'b'}, Port: 1002, Left: 5, Event: EventStarted})
+	serverAnnounce(t, c, announceURL, AnnounceRequest{InfoHash: hash, PeerID: [20]byte{'a'}, Port: 1001, Left: 0, Event: EventCompleted})
+	// a second completed event from the same peer doesn't count
+	serverAnnounce(t, c, announceURL, AnnounceRequest{InfoHash: hash, PeerID: [20]byte{'a'}, Port: 1001, Left: 0, Event: EventCompleted})
+
+	stats, err := c.Scrape(context.Background(), announceURL, [][20]byte{hash, {4}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := []ScrapeStats{{Seeders: 1, Completed: 1, Leechers: 1}, {}}; !reflect.DeepEqual(stats, expected) {
+		t.Errorf("expected %v, got %v", expected, stats)
+	}
+
+	resp := serverAnnounce(t, c, announceURL, AnnounceRequest{InfoHash: hash, PeerID: [20]byte{'b'}, Port: 1002, Left: 5, Event: EventStopped})
+	if len(resp.Peers) != 0 {
+		t.Errorf("expected no peers for a stopped announce, got %v", resp.Peers)
+	}
+	resp = serverAnnounce(t, c, announceURL, AnnounceRequest{InfoHash: hash, PeerID: [20]byte{'a'}, Port: 1001, Left: 0, Event: EventStopped})
+	if resp.Seeders != 0 || resp.Leechers != 0 {
+		t.Errorf("expected the swarm to be empty, got %d seeders and %d leechers", resp.Seeders, resp.Leechers)
+	}
+
+	// the download count outlives the peers
+	stats, err = c.Scrape(context.Background(), announceURL, [][20]byte{hash})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := []ScrapeStats{{Completed: 1}}; !reflect.DeepEqual(stats, expected) {
+		t.Errorf("expected %v, got %v", expected, stats)
+	}
+	if got := s.Stats(); got.Swarms != 1 || got.Scrapes != 2 {
+		t.Errorf("unexpected stats %+v", got)
+	}
+}
+
+func TestServerExpiry(t *testing.T) {
+	s, announceURL := newTestServer(t, ServerOptions{Interval: time.Minute, PeerTTL: 5 * time.Minute})
+	now := time.Now()
+	s.now = func() time.Time { return now }
+
+	c := &Client{}
+	serverAnnounce(t, c, announceURL, AnnounceRequest{InfoHash: [20]byte{1}, PeerID: [20]byte{'a'}, Port: 1001, Left: 1})
+	serverAnnounce(t, c, announceURL, AnnounceRequest{InfoHash: [20]byte{2}, PeerID: [20]byte{'a'}, Port: 1001, Left: 1})
+
+	now = now.Add(3 * time.Minute)
+	resp := serverAnnounce(t, c, announceURL, AnnounceRequest{InfoHash: [20]byte{1}, PeerID: [20]byte{'b'}, Port: 1002, Left: 1})
+	if len(resp.Peers) != 1 {
+		t.Errorf("expected the peer to still be around, got %v", resp.Peers)
+	}
+
+	now = now.Add(3 * time.Minute)
+	resp = serverAnnounce(t, c, announceURL, AnnounceRequest{InfoHash: [20]byte{1}, PeerID: [20]byte{'c'}, Port: 1003, Left: 1})
+	if len(resp.Peers) != 1 || resp.Peers[0].Port() != 1002 {
+		t.Errorf("expected only the peer that announced since, got %v", resp.Peers)
+	}
+
+	// the other swarm went quiet altogether, and is dropped too
+	if stats := s.Stats(); stats.Swarms != 1 || stats.Leechers != 2 {
+		t.Errorf("expected a single swarm with 2 leechers, got %+v", stats)
+	}
+}
+
+func TestServerAllowlist(t *testing.T) {
+	allowed := [20]byte{7}
+	s, announceURL := newTestServer(t, ServerOptions{Allowlist: [][20]byte{allowed}})
+	c := &Client{}
+
+	serverAnnounce(t, c, announceURL, AnnounceRequest{InfoHash: allowed, PeerID: [20]byte{'a'}, Port: 1001, Left: 1})
+
+	_, err := c.Announce(context.Background(), announceURL, AnnounceRequest{InfoHash: [20]byte{8}, PeerID: [20]byte{'a'}, Port: 1001, Left: 1})
+	var failure *FailureError
+	if !errors.As(err, &failure) || !strings.Contains(failure.Reason, "not allowed") {
+		t.Errorf("expected the torrent to be refused, got %v", err)
+	}
+
+	// scraping everything only shows the allowed torrent
+	resp, err := http.Get(strings.TrimSuffix(announceURL, "announce") + "scrape")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if expected := "d5:filesd20:\x07" + strings.Repeat("\x00", 19) + "d8:completei0e10:downloadedi0e10:incompletei1eeee"; string(body) != expected {
+		t.Errorf("expected %q, got %q", expected, body)
+	}
+
+	if stats := s.Stats(); stats.Failures != 1 || stats.Swarms != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestServerNotFound(t *testing.T) {
+	_, announceURL := newTestServer(t, ServerOptions{})
+	resp, err := http.Get(strings.TrimSuffix(announceURL, "announce") + "elsewhere")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404, got %s", resp.Status)
+	}
+}
@@ -0,0 +1,157 @@
+package tracker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/netip"
+	"sync"
+
+	"github.com/lourencovales/codecrafters/bittorrent-go/torrent"
+)
+
+// TierList holds a torrent's trackers grouped into tiers, as described in BEP
+// 12. Trackers are tried a tier at a time, in order, and the ones that answer
+// are moved to the front of their tier so that they're tried first next time.
+// It's safe to use from several goroutines.
+type TierList struct {
+	// Client talks to the trackers, DefaultClient when nil.
+	Client *Client
+
+	mu    sync.Mutex
+	tiers [][]string
+	// trackerIDs holds the 'tracker id' each tracker gave us, per swarm
+	trackerIDs map[trackerKey]string
+}
+
+type trackerKey struct {
+	announceURL string
+	infoHash    [20]byte
+}
+
+// NewTierList builds the tier list for a torrent. Each tier is shuffled, so
+// that the load is spread across the trackers within it.
+func NewTierList(metaInfo *torrent.TorrentInfo) *TierList {
+
+	tiers := metaInfo.AnnounceList
+	if len(tiers) == 0 && metaInfo.AnnounceURL != "" {
+		tiers = [][]string{{metaInfo.AnnounceURL}}
+	}
+
+	tl := &TierList{tiers: make([][]string, len(tiers)), trackerIDs: make(map[trackerKey]string)}
+	for i, tier := range tiers {
+		shuffled := append([]string(nil), tier...)
+		rand.Shuffle(len(shuffled), func(a, b int) {
+			shuffled[a], shuffled[b] = shuffled[b], shuffled[a]
+		})
+		tl.tiers[i] = shuffled
+	}
+	return tl
+}
+
+// Tiers returns a copy of the tiers in the order they'll be tried.
+func (tl *TierList) Tiers() [][]string {
+
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+
+	tiers := make([][]string, len(tl.tiers))
+	for i, tier := range tl.tiers {
+		tiers[i] = append([]string(nil), tier...)
+	}
+	return tiers
+}
+
+// GetPeers goes through the trackers until one of them hands out a peer list,
+// and promotes that tracker to the front of its tier. It only fails once every
+// tracker in every tier has, with all of their errors.
+func (tl *TierList) GetPeers(metaInfo *torrent.TorrentInfo, peerID [20]byte, port uint16) ([]netip.AddrPort, error) {
+
+	resp, err := tl.Announce(context.Background(), AnnounceRequest{
+		InfoHash: metaInfo.InfoHash,
+		PeerID:   peerID,
+		Port:     port,
+		Left:     int64(metaInfo.TotalLength),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Peers, nil
+}
+
+// Announce sends req to the trackers, the same way GetPeers does, and returns
+// the first answer. The 'tracker id' of each tracker is remembered and sent
+// back to it in later announces, in place of req.TrackerID.
+func (tl *TierList) Announce(ctx context.Context, req AnnounceRequest) (*AnnounceResponse, error) {
+
+	var errs []error
+	for i, tier := range tl.Tiers() {
+		for _, announceURL := range tier {
+			resp, err := tl.announceTo(ctx, announceURL, req)
+			if ctx.Err() != nil {
+				return nil, ctx.Err() // no point asking the others
+			}
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", announceURL, err))
+				continue
+			}
+			tl.promote(i, announceURL)
+			return resp, nil
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil, errors.New("torrent has no trackers")
+	}
+	return nil, fmt.Errorf("all trackers failed: %w", errors.Join(errs...))
+}
+
+// announceTo sends req to a single tracker, with its tracker id. The tracker
+// gets the client's Timeout to answer in, however long ctx has left.
+func (tl *TierList) announceTo(ctx context.Context, announceURL string, req AnnounceRequest) (*AnnounceResponse, error) {
+
+	c := tl.Client
+	if c == nil {
+		c = DefaultClient
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.timeout())
+	defer cancel()
+	tr, err := c.Tracker(announceURL)
+	if err != nil {
+		return nil, err
+	}
+
+	key := trackerKey{announceURL: announceURL, infoHash: req.InfoHash}
+	tl.mu.Lock()
+	req.TrackerID = tl.trackerIDs[key]
+	tl.mu.Unlock()
+
+	resp, err := tr.Announce(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.TrackerID != "" {
+		tl.mu.Lock()
+		tl.trackerIDs[key] = resp.TrackerID
+		tl.mu.Unlock()
+	}
+	return resp, nil
+}
+
+// promote moves announceURL to the front of tier i, keeping the others in the
+// same order
+func (tl *TierList) promote(i int, announceURL string) {
+
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+
+	tier := tl.tiers[i]
+	for j, u := range tier {
+		if u == announceURL {
+			copy(tier[1:j+1], tier[:j])
+			tier[0] = announceURL
+			return
+		}
+	}
+}
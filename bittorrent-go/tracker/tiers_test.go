@@ -0,0 +1,175 @@
+package tracker
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lourencovales/codecrafters/bittorrent-go/torrent"
+)
+
+// newTestTracker starts an HTTP tracker that either hands out a single peer or,
+// when broken, fails every request. hits counts the requests it got.
+func newTestTracker(t *testing.T, broken bool, hits *int) string {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*hits++
+		if broken {
+			http.Error(w, "down for maintenance", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("d5:peers6:\x7f\x00\x00\x01\x1a\xe1e"))
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL + "/announce"
+}
+
+func TestNewTierList(t *testing.T) {
+	info := &torrent.TorrentInfo{AnnounceURL: "http://a/announce"}
+	if tiers := NewTierList(info).Tiers(); !reflect.DeepEqual(tiers, [][]string{{"http://a/announce"}}) {
+		t.Errorf("expected the announce URL as the only tier, got %v", tiers)
+	}
+
+	info.AnnounceList = [][]string{{"http://b", "http://c", "http://d"}, {"http://e"}}
+	tiers := NewTierList(info).Tiers()
+	if len(tiers) != 2 || len(tiers[0]) != 3 || tiers[1][0] != "http://e" {
+		t.Fatalf("unexpected tiers %v", tiers)
+	}
+	for _, u := range info.AnnounceList[0] {
+		found := false
+		for _, v := range tiers[0] {
+			found = found || u == v
+		}
+		if !found {
+			t.Errorf("expected %s in the first tier, got %v", u, tiers[0])
+		}
+	}
+
+	// shuffling must not touch the torrent's own list
+	if info.AnnounceList[0][0] != "http://b" {
+		t.Errorf("expected the torrent's announce list to be left alone, got %v", info.AnnounceList)
+	}
+}
+
+func TestTierListFallsBackAcrossTiers(t *testing.T) {
+	var brokenHits, goodHits int
+	broken1 := newTestTracker(t, true, &brokenHits)
+	broken2 := newTestTracker(t, true, &brokenHits)
+	good := newTestTracker(t, false, &goodHits)
+
+	info := &torrent.TorrentInfo{
+		AnnounceURL:  broken1,
+		AnnounceList: [][]string{{broken1, broken2}, {good}},
+		TotalLength:  1000,
+	}
+
+	peers, err := NewTierList(info).GetPeers(info, [20]byte{}, 6881)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(peers, []netip.AddrPort{netip.MustParseAddrPort("127.0.0.1:6881")}) {
+		t.Errorf("expected [127.0.0.1:6881], got %v", peers)
+	}
+	if brokenHits != 2 || goodHits != 1 {
+		t.Errorf("expected both broken trackers to be tried before the good one, got %d and %d hits", brokenHits, goodHits)
+	}
+}
+
+func TestTierListTimeout(t *testing.T) {
+	// a UDP tracker that never answers, and an HTTP one that takes too long
+	silent, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { silent.Close() })
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	t.Cleanup(slow.Close)
+	var hits int
+	good := newTestTracker(t, false, &hits)
+
+	info := &torrent.TorrentInfo{
+		AnnounceList: [][]string{{"udp://" + silent.LocalAddr().String()}, {slow.URL + "/announce"}, {good}},
+		TotalLength:  1000,
+	}
+	tl := NewTierList(info)
+	tl.Client = &Client{Timeout: 100 * time.Millisecond, Retries: 1, Backoff: time.Millisecond}
+
+	start := time.Now()
+	peers, err := tl.GetPeers(info, [20]byte{}, 6881)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(peers) != 1 || hits != 1 {
+		t.Errorf("expected the last tracker to answer, got %v", peers)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("expected each tracker to get 100ms, took %v", elapsed)
+	}
+}
+
+func TestTierListPromotesWorkingTracker(t *testing.T) {
+	var brokenHits, goodHits int
+	urls := []string{
+		newTestTracker(t, true, &brokenHits),
+		newTestTracker(t, true, &brokenHits),
+		newTestTracker(t, false, &goodHits),
+	}
+
+	info := &torrent.TorrentInfo{AnnounceList: [][]string{urls}, TotalLength: 1000}
+	tl := NewTierList(info)
+
+	if _, err := tl.GetPeers(info, [20]byte{}, 6881); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if tier := tl.Tiers()[0]; tier[0] != urls[2] {
+		t.Errorf("expected %s to be promoted to the front, got %v", urls[2], tier)
+	}
+
+	// the second time around the working tracker is asked straight away
+	brokenHits = 0
+	if _, err := tl.GetPeers(info, [20]byte{}, 6881); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if brokenHits != 0 || goodHits != 2 {
+		t.Errorf("expected only the promoted tracker to be asked, got %d broken hits", brokenHits)
+	}
+}
+
+func TestTierListPromote(t *testing.T) {
+	tl := &TierList{tiers: [][]string{{"a", "b", "c", "d"}}}
+	tl.promote(0, "c")
+	if expected := []string{"c", "a", "b", "d"}; !reflect.DeepEqual(tl.tiers[0], expected) {
+		t.Errorf("expected %v, got %v", expected, tl.tiers[0])
+	}
+}
+
+func TestTierListAllFail(t *testing.T) {
+	var hits int
+	info := &torrent.TorrentInfo{
+		AnnounceList: [][]string{{newTestTracker(t, true, &hits)}, {newTestTracker(t, true, &hits)}},
+	}
+
+	_, err := NewTierList(info).GetPeers(info, [20]byte{}, 6881)
+	if err == nil {
+		t.Fatal("expected error when every tracker fails")
+	}
+	if hits != 2 {
+		t.Errorf("expected every tracker to be tried, got %d hits", hits)
+	}
+	if !strings.Contains(err.Error(), "all trackers failed") {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	if _, err := NewTierList(&torrent.TorrentInfo{}).GetPeers(info, [20]byte{}, 6881); err == nil {
+		t.Error("expected error for a torrent without trackers")
+	}
+}
@@ -1,105 +1,258 @@
 package tracker
 
 import (
-	"encoding/binary"
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
-	"net/http"
+	"net/netip"
 	"net/url"
 	"strconv"
+	"time"
 
 	"github.com/lourencovales/codecrafters/bittorrent-go/bencode"
 	"github.com/lourencovales/codecrafters/bittorrent-go/torrent"
 )
 
-// GetPeers contacts the tracker and retrieves a list of peers for the torrent.
-func GetPeers(metaInfo *torrent.TorrentInfo, peerID [20]byte, port uint16) ([]string, error) {
+// GetPeers contacts the tracker and retrieves a list of peers for the torrent,
+// with DefaultClient. Only the main announce URL is tried, TierList.GetPeers
+// falls back on the rest of the torrent's trackers.
+func GetPeers(metaInfo *torrent.TorrentInfo, peerID [20]byte, port uint16) ([]netip.AddrPort, error) {
+	return announce(context.Background(), DefaultClient, metaInfo.AnnounceURL, metaInfo, peerID, port)
+}
 
-	// Build the tracker URL with necessary query parameters
-	trackerURL, err := buildTrackerUrl(metaInfo, peerID, port)
-	if err != nil {
-		return nil, err
+// Tracker is a single tracker, whatever protocol it speaks.
+type Tracker interface {
+	// Announce tells the tracker about us and asks it for peers.
+	Announce(ctx context.Context, req AnnounceRequest) (*AnnounceResponse, error)
+	// Scrape asks the tracker for the stats of each of infoHashes, which
+	// come back in the same order.
+	Scrape(ctx context.Context, infoHashes [][20]byte) ([]ScrapeStats, error)
+}
+
+// Event says why an announce is being sent. The values are the ones the UDP
+// protocol uses.
+type Event uint32
+
+const (
+	// EventNone is a regular announce, sent every interval.
+	EventNone Event = iota
+	// EventCompleted is sent once, when the download finishes.
+	EventCompleted
+	// EventStarted is the first announce of a download.
+	EventStarted
+	// EventStopped is sent when we leave the swarm.
+	EventStopped
+)
+
+// String returns the name of the event, as sent to HTTP trackers. EventNone
+// has none.
+func (e Event) String() string {
+	switch e {
+	case EventCompleted:
+		return "completed"
+	case EventStarted:
+		return "started"
+	case EventStopped:
+		return "stopped"
 	}
+	return ""
+}
 
-	// GET request to the tracker with the final query
-	resp, err := http.Get(trackerURL)
+// AnnounceRequest holds what we tell a tracker when announcing.
+type AnnounceRequest struct {
+	InfoHash   [20]byte
+	PeerID     [20]byte
+	Port       uint16
+	Uploaded   int64
+	Downloaded int64
+	Left       int64
+	Event      Event
+	// TrackerID is the 'tracker id' the tracker gave us in an earlier
+	// response, if any, which it wants back.
+	TrackerID string
+}
+
+// AnnounceResponse is what a tracker answers an announce with. Trackers that
+// don't say how many seeders and leechers there are leave them at zero.
+type AnnounceResponse struct {
+	Interval time.Duration
+	// MinInterval is how often, at most, the tracker wants to hear from us.
+	// Zero when it doesn't say.
+	MinInterval time.Duration
+	TrackerID   string
+	Seeders     int
+	Leechers    int
+	Peers       []netip.AddrPort
+	// Warning is set when the tracker answered, but with a 'warning
+	// message'.
+	Warning *WarningError
+}
+
+// FailureError is returned when the tracker turns down an announce, with
+// the 'failure reason' it gave.
+type FailureError struct {
+	Reason string
+}
+
+func (e *FailureError) Error() string {
+	return "tracker failure: " + e.Reason
+}
+
+// WarningError is a 'warning message' sent along with an otherwise good
+// response.
+type WarningError struct {
+	Message string
+}
+
+func (e *WarningError) Error() string {
+	return "tracker warning: " + e.Message
+}
+
+// New returns the Tracker for announceURL, with DefaultClient.
+func New(announceURL string) (Tracker, error) {
+	return DefaultClient.Tracker(announceURL)
+}
+
+// announce asks the tracker at announceURL for peers for the torrent
+func announce(ctx context.Context, c *Client, announceURL string, metaInfo *torrent.TorrentInfo, peerID [20]byte, port uint16) ([]netip.AddrPort, error) {
+
+	resp, err := c.Announce(ctx, announceURL, AnnounceRequest{
+		InfoHash: metaInfo.InfoHash,
+		PeerID:   peerID,
+		Port:     port,
+		Left:     int64(metaInfo.TotalLength),
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to contact tracker: %w", err)
+		return nil, err
 	}
-	defer resp.Body.Close()
+	return resp.Peers, nil
+}
 
-	if resp.StatusCode != http.StatusOK { // sanity check
-		return nil, fmt.Errorf("tracker returned non-200 status: %s", resp.Status)
-	}
+// httpTracker speaks the original HTTP tracker protocol, a GET request with
+// the announce in its query string and a bencoded dictionary back.
+type httpTracker struct {
+	client      *Client
+	announceURL string
+}
 
-	// parsing the answer
-	body, err := io.ReadAll(resp.Body)
+// Announce sends the announce to the tracker.
+func (t *httpTracker) Announce(ctx context.Context, req AnnounceRequest) (*AnnounceResponse, error) {
+
+	// Build the tracker URL with necessary query parameters
+	trackerURL, err := t.client.buildRequestURL(t.announceURL, req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read tracker response: %w", err)
+		return nil, err
 	}
 
-	// and unmarshal the bencoded response
-	trackerResponse, err := bencode.Unmarshal(body)
+	// GET request to the tracker with the final query
+	body, err := t.client.get(ctx, trackerURL, int64(responseLimits.MaxInputSize))
 	if err != nil {
-		return nil, fmt.Errorf("failed to unmarshal tracker response: %w", err)
+		return nil, err
 	}
-
-	return parsePeers(trackerResponse)
+	return parseResponse(bytes.NewReader(body))
 }
 
-// buildTrackerURL constructs the full URL to query the tracker.
-func buildTrackerUrl(metaInfo *torrent.TorrentInfo, peerID [20]byte, port uint16) (string, error) {
+// buildRequestURL puts req, and the announce parameters set on the client, in
+// the query string of announceURL
+func (c *Client) buildRequestURL(announceURL string, req AnnounceRequest) (string, error) {
 
-	base, err := url.Parse(metaInfo.AnnounceURL)
+	base, err := url.Parse(announceURL)
 	if err != nil {
 		return "", fmt.Errorf("failed to parse announce URL: %w", err)
 	}
 
 	params := url.Values{
-		"info_hash":  []string{string(metaInfo.InfoHash[:])},
-		"peer_id":    []string{string(peerID[:])},
-		"port":       []string{strconv.Itoa(int(port))},
-		"uploaded":   []string{"0"},
-		"downloaded": []string{"0"},
-		"left":       []string{strconv.Itoa(metaInfo.TotalLength)},
+		"info_hash":  []string{string(req.InfoHash[:])},
+		"peer_id":    []string{string(req.PeerID[:])},
+		"port":       []string{strconv.Itoa(int(req.Port))},
+		"uploaded":   []string{strconv.FormatInt(req.Uploaded, 10)},
+		"downloaded": []string{strconv.FormatInt(req.Downloaded, 10)},
+		"left":       []string{strconv.FormatInt(req.Left, 10)},
 		"compact":    []string{"1"},
 	}
+	if req.Event != EventNone {
+		params.Set("event", req.Event.String())
+	}
+	if req.TrackerID != "" {
+		params.Set("trackerid", req.TrackerID)
+	}
+	params.Set("key", fmt.Sprintf("%08x", c.key()))
+	if c.NumWant > 0 {
+		params.Set("numwant", strconv.Itoa(c.NumWant))
+	}
+	if c.IP.IsValid() {
+		params.Set("ip", c.IP.String())
+	}
+	if c.NoPeerID {
+		params.Set("no_peer_id", "1")
+	}
 	base.RawQuery = params.Encode()
 
 	return base.String(), nil
 }
 
-// parsePeers extracts the peer list from the tracker's Bencoded response.
-func parsePeers(trackerResponse interface{}) ([]string, error) {
+// responseLimits keeps a misbehaving tracker from making us decode arbitrarily
+// large or deeply nested responses. A compact peer list for a few thousand
+// peers is still well within these.
+var responseLimits = bencode.DecodeOptions{
+	MaxDepth:        8,
+	MaxStringLength: 256 << 10,
+	MaxElements:     4096,
+	MaxInputSize:    1 << 20,
+}
+
+// trackerResponse is the bencoded dictionary sent back by the tracker. The
+// peer lists are pointers so that a missing key can be told apart from an
+// empty list.
+type trackerResponse struct {
+	FailureReason  string         `bencode:"failure reason"`
+	WarningMessage string         `bencode:"warning message"`
+	Interval       int            `bencode:"interval"`
+	MinInterval    int            `bencode:"min interval"`
+	TrackerID      string         `bencode:"tracker id"`
+	Complete       int            `bencode:"complete"`
+	Incomplete     int            `bencode:"incomplete"`
+	Peers          *peerList      `bencode:"peers"`
+	Peers6         *compactPeers6 `bencode:"peers6"`
+}
+
+// parseResponse decodes the tracker's Bencoded response.
+func parseResponse(body io.Reader) (*AnnounceResponse, error) {
 
-	respDict, ok := trackerResponse.(map[string]interface{})
-	if !ok {
-		return nil, errors.New("invalid tracker response format")
+	var resp trackerResponse
+	if err := responseLimits.NewDecoder(body).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal tracker response: %w", err)
 	}
 
-	peersValue, ok := respDict["peers"]
-	if !ok {
+	// a failure comes on its own, with none of the other keys
+	if resp.FailureReason != "" {
+		return nil, &FailureError{Reason: resp.FailureReason}
+	}
+	if resp.Peers == nil && resp.Peers6 == nil {
 		return nil, errors.New("tracker response missing 'peers' key")
 	}
 
-	peersString, ok := peersValue.(string)
-	if !ok {
-		return nil, errors.New("'peers' key is not a string")
+	// IPv4 peers first, then IPv6 ones (BEP 7)
+	peers := []netip.AddrPort{}
+	if resp.Peers != nil {
+		peers = append(peers, *resp.Peers...)
 	}
-
-	peersBytes := []byte(peersString)
-	if len(peersBytes)%6 != 0 {
-		return nil, errors.New("malformed peers list")
+	if resp.Peers6 != nil {
+		peers = append(peers, *resp.Peers6...)
 	}
 
-	var peerList []string
-	for i := 0; i < len(peersBytes); i += 6 {
-		ip := peersBytes[i : i+4]
-		portBytes := peersBytes[i+4 : i+6]
-		port := binary.BigEndian.Uint16(portBytes)
-		peerList = append(peerList, fmt.Sprintf("%d.%d.%d.%d:%d", ip[0], ip[1], ip[2], ip[3], port))
+	out := &AnnounceResponse{
+		Interval:    time.Duration(resp.Interval) * time.Second,
+		MinInterval: time.Duration(resp.MinInterval) * time.Second,
+		TrackerID:   resp.TrackerID,
+		Seeders:     resp.Complete,
+		Leechers:    resp.Incomplete,
+		Peers:       peers,
 	}
-
-	return peerList, nil
+	if resp.WarningMessage != "" {
+		out.Warning = &WarningError{Message: resp.WarningMessage}
+	}
+	return out, nil
 }
@@ -1,23 +1,23 @@
 package tracker
 
 import (
+	"errors"
 	"net/url"
+	"reflect"
+	"strings"
 	"testing"
-
-	"github.com/lourencovales/codecrafters/bittorrent-go/torrent"
+	"time"
 )
 
-func TestBuildTrackerUrl(t *testing.T) {
-	metaInfo := &torrent.TorrentInfo{
-		AnnounceURL: "http://tracker.example.com:8080/announce",
-		InfoHash:    [20]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20},
-		TotalLength: 1000000,
+func TestBuildRequestURL(t *testing.T) {
+	req := AnnounceRequest{
+		InfoHash: [20]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20},
+		PeerID:   [20]byte{21, 22, 23, 24, 25, 26, 27, 28, 29, 30, 31, 32, 33, 34, 35, 36, 37, 38, 39, 40},
+		Port:     6881,
+		Left:     1000000,
 	}
-	
-	peerID := [20]byte{21, 22, 23, 24, 25, 26, 27, 28, 29, 30, 31, 32, 33, 34, 35, 36, 37, 38, 39, 40}
-	port := uint16(6881)
 
-	result, err := buildTrackerUrl(metaInfo, peerID, port)
+	result, err := (&Client{}).buildRequestURL("http://tracker.example.com:8080/announce", req)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -70,73 +70,106 @@ func TestBuildTrackerUrl(t *testing.T) {
 	}
 }
 
-func TestBuildTrackerUrlInvalidURL(t *testing.T) {
-	metaInfo := &torrent.TorrentInfo{
-		AnnounceURL: "://invalid-url",
-		InfoHash:    [20]byte{},
-		TotalLength: 1000000,
-	}
-	
-	peerID := [20]byte{}
-	port := uint16(6881)
-
-	_, err := buildTrackerUrl(metaInfo, peerID, port)
+func TestBuildRequestURLInvalidURL(t *testing.T) {
+	_, err := (&Client{}).buildRequestURL("://invalid-url", AnnounceRequest{Port: 6881, Left: 1000000})
 	if err == nil {
 		t.Error("expected error for invalid URL, got nil")
 	}
 }
 
-func TestParsePeers(t *testing.T) {
+func TestParseResponsePeers(t *testing.T) {
 	tests := []struct {
 		name     string
-		input    interface{}
+		input    string
 		expected []string
 		hasError bool
 	}{
 		{
 			name: "valid peers",
-			input: map[string]interface{}{
-				"peers": string([]byte{
-					192, 168, 1, 1, 0x1A, 0xE1, // 192.168.1.1:6881
-					10, 0, 0, 1, 0x1A, 0xE2,     // 10.0.0.1:6882
-				}),
-			},
+			input: "d5:peers12:" + string([]byte{
+				192, 168, 1, 1, 0x1A, 0xE1, // 192.168.1.1:6881
+				10, 0, 0, 1, 0x1A, 0xE2, // 10.0.0.1:6882
+			}) + "e",
 			expected: []string{"192.168.1.1:6881", "10.0.0.1:6882"},
 			hasError: false,
 		},
 		{
-			name: "empty peers",
-			input: map[string]interface{}{
-				"peers": "",
-			},
+			name:     "empty peers",
+			input:    "d5:peers0:e",
 			expected: []string{},
 			hasError: false,
 		},
+		{
+			name: "dictionary peers",
+			input: "d5:peersl" +
+				"d2:ip11:192.168.1.17:peer id20:-XX0001-0123456789ab4:porti6881ee" +
+				"d2:ip3:::14:porti6882ee" +
+				"d2:ip19:tracker.example.com4:porti6883ee" + // host names are skipped
+				"ee",
+			expected: []string{"192.168.1.1:6881", "[::1]:6882"},
+			hasError: false,
+		},
+		{
+			name: "IPv6 peers only",
+			input: "d6:peers636:" + string([]byte{
+				0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0x1A, 0xE1, // [2001:db8::1]:6881
+				0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xff, 0xff, 10, 0, 0, 1, 0x1A, 0xE2, // IPv4-mapped 10.0.0.1:6882
+			}) + "e",
+			expected: []string{"[2001:db8::1]:6881", "10.0.0.1:6882"},
+			hasError: false,
+		},
+		{
+			name: "IPv4 and IPv6 peers",
+			input: "d5:peers6:" + string([]byte{192, 168, 1, 1, 0x1A, 0xE1}) +
+				"6:peers618:" + string([]byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0x1A, 0xE2}) + "e",
+			expected: []string{"192.168.1.1:6881", "[::1]:6882"},
+			hasError: false,
+		},
+		{
+			name:     "malformed IPv6 peers list",
+			input:    "d5:peers0:6:peers65:abcdee",
+			expected: nil,
+			hasError: true,
+		},
+		{
+			name:     "dictionary peer with bad port",
+			input:    "d5:peersld2:ip9:127.0.0.14:porti70000eeee",
+			expected: nil,
+			hasError: true,
+		},
 		{
 			name:     "invalid response format",
-			input:    "not a map",
+			input:    "9:not a map",
 			expected: nil,
 			hasError: true,
 		},
 		{
 			name:     "missing peers key",
-			input:    map[string]interface{}{},
+			input:    "de",
 			expected: nil,
 			hasError: true,
 		},
 		{
-			name: "peers not a string",
-			input: map[string]interface{}{
-				"peers": 123,
-			},
+			name:     "peers not a string",
+			input:    "d5:peersi123ee",
 			expected: nil,
 			hasError: true,
 		},
 		{
-			name: "malformed peers list",
-			input: map[string]interface{}{
-				"peers": string([]byte{192, 168, 1, 1, 0x1A}), // Missing one byte
-			},
+			name:     "malformed peers list",
+			input:    "d5:peers5:" + string([]byte{192, 168, 1, 1, 0x1A}) + "e", // Missing one byte
+			expected: nil,
+			hasError: true,
+		},
+		{
+			name:     "deeply nested response",
+			input:    "d5:peers" + strings.Repeat("l", 100) + strings.Repeat("e", 100) + "e",
+			expected: nil,
+			hasError: true,
+		},
+		{
+			name:     "invalid bencode",
+			input:    "d5:peers",
 			expected: nil,
 			hasError: true,
 		},
@@ -144,7 +177,7 @@ func TestParsePeers(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result, err := parsePeers(tt.input)
+			resp, err := parseResponse(strings.NewReader(tt.input))
 			
 			if tt.hasError {
 				if err == nil {
@@ -156,16 +189,69 @@ func TestParsePeers(t *testing.T) {
 			if err != nil {
 				t.Fatalf("unexpected error: %v", err)
 			}
+			result := resp.Peers
 
 			if len(result) != len(tt.expected) {
 				t.Errorf("expected %d peers, got %d", len(tt.expected), len(result))
 			}
 
 			for i, expectedPeer := range tt.expected {
-				if i < len(result) && result[i] != expectedPeer {
+				if i < len(result) && result[i].String() != expectedPeer {
 					t.Errorf("at index %d: expected %s, got %s", i, expectedPeer, result[i])
 				}
 			}
 		})
 	}
-}
\ No newline at end of file
+}
+func TestParseResponse(t *testing.T) {
+	input := "d8:completei3e10:incompletei4e8:intervali900e12:min intervali60e" +
+		"5:peers0:10:tracker id2:id15:warning message5:hello" + "e"
+
+	resp, err := parseResponse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.Interval != 15*time.Minute || resp.MinInterval != time.Minute {
+		t.Errorf("expected intervals of 15m and 1m, got %v and %v", resp.Interval, resp.MinInterval)
+	}
+	if resp.Seeders != 3 || resp.Leechers != 4 || resp.TrackerID != "id" {
+		t.Errorf("unexpected response %+v", resp)
+	}
+	if resp.Warning == nil || resp.Warning.Message != "hello" {
+		t.Errorf("expected the warning message, got %v", resp.Warning)
+	}
+
+	_, err = parseResponse(strings.NewReader("d14:failure reason6:bannede"))
+	var failure *FailureError
+	if !errors.As(err, &failure) || failure.Reason != "banned" {
+		t.Errorf("expected a FailureError, got %v", err)
+	}
+}
+
+func TestBuildRequestURLEvent(t *testing.T) {
+	tests := []struct {
+		event     Event
+		trackerID string
+		expected  url.Values
+	}{
+		{EventNone, "", url.Values{}},
+		{EventStarted, "", url.Values{"event": {"started"}}},
+		{EventCompleted, "xyz", url.Values{"event": {"completed"}, "trackerid": {"xyz"}}},
+		{EventStopped, "", url.Values{"event": {"stopped"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.event.String(), func(t *testing.T) {
+			result, err := (&Client{}).buildRequestURL("http://tracker.example.com/announce", AnnounceRequest{Event: tt.event, TrackerID: tt.trackerID})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			u, _ := url.Parse(result)
+			for _, key := range []string{"event", "trackerid"} {
+				if got := u.Query()[key]; !reflect.DeepEqual(got, tt.expected[key]) {
+					t.Errorf("expected %s %v, got %v", key, tt.expected[key], got)
+				}
+			}
+		})
+	}
+}
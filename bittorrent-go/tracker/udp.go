@@ -0,0 +1,347 @@
+package tracker
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// These are the actions of the UDP tracker protocol (BEP 15).
+const (
+	actionConnect  uint32 = 0
+	actionAnnounce uint32 = 1
+	actionScrape   uint32 = 2
+	actionError    uint32 = 3
+)
+
+// udpProtocolID is the magic number that stands in for the connection ID in
+// connect requests.
+const udpProtocolID uint64 = 0x41727101980
+
+// connIDLifetime is how long a connection ID can be used for. Trackers accept
+// them for two minutes, clients are meant to stop after one.
+const connIDLifetime = time.Minute
+
+// BEP 15 has requests sent again after 15·2^n seconds without an answer, n
+// going from 0 up to 8, which is over an hour in all. maxUDPRetries bounds n,
+// and the Client's Timeout bounds the whole schedule, so that one tracker
+// that doesn't answer can't hold up the rest of its tier list for that long.
+const (
+	udpBaseTimeout = 15 * time.Second
+	maxUDPRetries  = 8
+)
+
+// udpTracker speaks the UDP tracker protocol: a connect exchange to get a
+// connection ID, then announces and scrapes with it.
+type udpTracker struct {
+	client *Client
+	host   string
+	// urlData is the path and query of the announce URL, sent along with
+	// announces for trackers that want it (BEP 41)
+	urlData     string
+	baseTimeout time.Duration
+	maxRetries  int
+	// timeout is how long to wait for an answer in all, over every try
+	timeout time.Duration
+}
+
+func newUDPTracker(c *Client, u *url.URL) (*udpTracker, error) {
+
+	if u.Port() == "" {
+		return nil, fmt.Errorf("UDP tracker %s has no port", u.Host)
+	}
+	urlData := u.EscapedPath()
+	if u.RawQuery != "" {
+		urlData += "?" + u.RawQuery
+	}
+	return &udpTracker{
+		client:      c,
+		host:        u.Host,
+		urlData:     urlData,
+		baseTimeout: udpBaseTimeout,
+		maxRetries:  maxUDPRetries,
+		timeout:     c.timeout(),
+	}, nil
+}
+
+// Announce sends the announce to the tracker.
+func (t *udpTracker) Announce(ctx context.Context, req AnnounceRequest) (*AnnounceResponse, error) {
+
+	body := make([]byte, 82)
+	copy(body[0:20], req.InfoHash[:])
+	copy(body[20:40], req.PeerID[:])
+	binary.BigEndian.PutUint64(body[40:48], uint64(req.Downloaded))
+	binary.BigEndian.PutUint64(body[48:56], uint64(req.Left))
+	binary.BigEndian.PutUint64(body[56:64], uint64(req.Uploaded))
+	binary.BigEndian.PutUint32(body[64:68], uint32(req.Event))
+	// a zero IP address stands for the one the request came from
+	if ip := t.client.IP; ip.Is4() {
+		copy(body[68:72], ip.AsSlice())
+	}
+	binary.BigEndian.PutUint32(body[72:76], t.client.key())
+	numWant := int32(-1) // the tracker's default
+	if t.client.NumWant > 0 {
+		numWant = int32(t.client.NumWant)
+	}
+	binary.BigEndian.PutUint32(body[76:80], uint32(numWant))
+	binary.BigEndian.PutUint16(body[80:82], req.Port)
+	body = append(body, urlDataOptions(t.urlData)...)
+
+	resp, from, err := t.request(ctx, actionAnnounce, body)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp) < 12 {
+		return nil, errors.New("announce response too short")
+	}
+
+	// trackers reached over IPv6 hand out IPv6 peers
+	parse := parseCompactPeers
+	if from.Addr().Is6() {
+		parse = parseCompactPeers6
+	}
+	peers, err := parse(resp[12:])
+	if err != nil {
+		return nil, err
+	}
+	return &AnnounceResponse{
+		Interval: time.Duration(binary.BigEndian.Uint32(resp[0:4])) * time.Second,
+		Leechers: int(binary.BigEndian.Uint32(resp[4:8])),
+		Seeders:  int(binary.BigEndian.Uint32(resp[8:12])),
+		Peers:    peers,
+	}, nil
+}
+
+// maxScrapeHashes is how many info hashes fit in a single UDP scrape.
+const maxScrapeHashes = 74
+
+// Scrape asks the tracker for the stats of each of infoHashes, which come
+// back in the same order. More than fit in one scrape are split across
+// several.
+func (t *udpTracker) Scrape(ctx context.Context, infoHashes [][20]byte) ([]ScrapeStats, error) {
+
+	if len(infoHashes) == 0 {
+		return nil, errors.New("nothing to scrape")
+	}
+
+	var stats []ScrapeStats
+	for chunk := range slices.Chunk(infoHashes, maxScrapeHashes) {
+		body := make([]byte, 0, 20*len(chunk))
+		for _, h := range chunk {
+			body = append(body, h[:]...)
+		}
+
+		resp, _, err := t.request(ctx, actionScrape, body)
+		if err != nil {
+			return nil, err
+		}
+		if len(resp) < 12*len(chunk) {
+			return nil, errors.New("scrape response too short")
+		}
+
+		for i := range chunk {
+			entry := resp[12*i:]
+			stats = append(stats, ScrapeStats{
+				Seeders:   int(binary.BigEndian.Uint32(entry[0:4])),
+				Completed: int(binary.BigEndian.Uint32(entry[4:8])),
+				Leechers:  int(binary.BigEndian.Uint32(entry[8:12])),
+			})
+		}
+	}
+	return stats, nil
+}
+
+// request sends a request for action, getting a connection ID first if there
+// isn't a fresh one cached, and returns the response past its header along
+// with the address it came from. Both exchanges are retried on the BEP 15
+// schedule, with n shared between them, until ctx is done.
+func (t *udpTracker) request(ctx context.Context, action uint32, body []byte) ([]byte, netip.AddrPort, error) {
+
+	conn, err := (&net.Dialer{}).DialContext(ctx, "udp", t.host)
+	if err != nil {
+		return nil, netip.AddrPort{}, fmt.Errorf("failed to contact tracker: %w", err)
+	}
+	defer conn.Close()
+	from := conn.RemoteAddr().(*net.UDPAddr).AddrPort()
+	from = netip.AddrPortFrom(from.Addr().Unmap(), from.Port())
+
+	// a read in progress when ctx is done is cut short
+	stop := context.AfterFunc(ctx, func() { conn.SetReadDeadline(time.Now()) })
+	defer stop()
+
+	// the last try is cut short when t.timeout runs out
+	deadline := time.Now().Add(t.timeout)
+	tries := 0
+	for n := 0; n <= t.maxRetries && time.Now().Before(deadline); n++ {
+		timeout := func() time.Duration { return min(t.baseTimeout<<n, time.Until(deadline)) }
+		tries++
+
+		connID, ok := t.client.connIDs.get(t.host)
+		if !ok {
+			resp, err := exchange(ctx, conn, udpProtocolID, actionConnect, nil, timeout())
+			if err := ctxDone(ctx, err); err != nil {
+				return nil, from, fmt.Errorf("failed to contact tracker: %w", err)
+			}
+			if isTimeout(err) {
+				continue
+			}
+			if err != nil {
+				return nil, from, err
+			}
+			if len(resp) < 8 {
+				return nil, from, errors.New("connect response too short")
+			}
+			connID = binary.BigEndian.Uint64(resp[0:8])
+			t.client.connIDs.put(t.host, connID)
+		}
+
+		resp, err := exchange(ctx, conn, connID, action, body, timeout())
+		if err := ctxDone(ctx, err); err != nil {
+			return nil, from, fmt.Errorf("failed to contact tracker: %w", err)
+		}
+		if isTimeout(err) {
+			continue
+		}
+		if err != nil {
+			// the connection ID may be what the tracker didn't like
+			t.client.connIDs.forget(t.host)
+			return nil, from, err
+		}
+		return resp, from, nil
+	}
+	return nil, from, fmt.Errorf("tracker didn't answer after %d tries", tries)
+}
+
+// exchange sends a single request and waits up to timeout, or until ctx is
+// done or its deadline passes, for the answer to it. Answers to anything
+// else, such as requests sent before that timed out, are ignored.
+func exchange(ctx context.Context, conn net.Conn, connID uint64, action uint32, body []byte, timeout time.Duration) ([]byte, error) {
+
+	transactionID := randomUint32()
+	packet := make([]byte, 16, 16+len(body))
+	binary.BigEndian.PutUint64(packet[0:8], connID)
+	binary.BigEndian.PutUint32(packet[8:12], action)
+	binary.BigEndian.PutUint32(packet[12:16], transactionID)
+	packet = append(packet, body...)
+
+	if _, err := conn.Write(packet); err != nil {
+		return nil, fmt.Errorf("failed to contact tracker: %w", err)
+	}
+	readDeadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(readDeadline) {
+		readDeadline = d
+	}
+	if err := conn.SetReadDeadline(readDeadline); err != nil {
+		return nil, err
+	}
+	// ctx may have been done before the deadline above replaced the one it
+	// set
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 64<<10)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		if n < 8 || binary.BigEndian.Uint32(buf[4:8]) != transactionID {
+			continue
+		}
+
+		switch got := binary.BigEndian.Uint32(buf[0:4]); got {
+		case action:
+			return append([]byte(nil), buf[8:n]...), nil
+		case actionError:
+			return nil, &FailureError{Reason: string(buf[8:n])}
+		default:
+			return nil, fmt.Errorf("tracker answered with action %d instead of %d", got, action)
+		}
+	}
+}
+
+// urlDataOptions encodes the path and query of the announce URL as URLData
+// options (BEP 41), in chunks of at most 255 bytes
+func urlDataOptions(urlData string) []byte {
+
+	const optionURLData = 0x2
+	var options []byte
+	for len(urlData) > 0 {
+		chunk := urlData[:min(255, len(urlData))]
+		options = append(options, optionURLData, byte(len(chunk)))
+		options = append(options, chunk...)
+		urlData = urlData[len(chunk):]
+	}
+	return options
+}
+
+// ctxDone returns ctx's error if it's done, or if err is the read deadline
+// taken from ctx's deadline running out, which can happen just before ctx
+// notices
+func ctxDone(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if d, ok := ctx.Deadline(); ok && isTimeout(err) && !time.Now().Before(d) {
+		return context.DeadlineExceeded
+	}
+	return nil
+}
+
+func isTimeout(err error) bool {
+	return errors.Is(err, os.ErrDeadlineExceeded)
+}
+
+func randomUint32() uint32 {
+	var b [4]byte
+	rand.Read(b[:])
+	return binary.BigEndian.Uint32(b[:])
+}
+
+// connIDCache keeps the connection IDs handed out by UDP trackers, by host,
+// so that they can be reused for as long as they're valid. Each Client has
+// its own, and the zero value is ready to use.
+type connIDCache struct {
+	mu      sync.Mutex
+	entries map[string]connIDEntry
+}
+
+type connIDEntry struct {
+	id      uint64
+	expires time.Time
+}
+
+func (c *connIDCache) get(host string) (uint64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[host]
+	if !ok || time.Now().After(e.expires) {
+		return 0, false
+	}
+	return e.id, true
+}
+
+func (c *connIDCache) put(host string, id uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[string]connIDEntry)
+	}
+	c.entries[host] = connIDEntry{id: id, expires: time.Now().Add(connIDLifetime)}
+}
+
+func (c *connIDCache) forget(host string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, host)
+}
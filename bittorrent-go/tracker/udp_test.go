@@ -0,0 +1,413 @@
+package tracker
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lourencovales/codecrafters/bittorrent-go/torrent"
+)
+
+// udpTestTracker stands in for a UDP tracker. It hands out testConnID, answers
+// announces with a single peer, of the same address family as the tracker,
+// and scrapes with fixed numbers, and can be told to drop packets or to fail
+// announces.
+type udpTestTracker struct {
+	conn net.PacketConn
+
+	mu        sync.Mutex
+	drop      int    // how many of the next packets to ignore
+	fail      string // error message to answer announces with
+	connects  int
+	announces int
+	urlData   string // URLData options of the last announce
+	announce  []byte // the last announce
+}
+
+const testConnID uint64 = 0x1122334455667788
+
+func newUDPTestTracker(t *testing.T, drop int, fail string) *udpTestTracker {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	return startUDPTestTracker(t, conn, drop, fail)
+}
+
+func startUDPTestTracker(t *testing.T, conn net.PacketConn, drop int, fail string) *udpTestTracker {
+	t.Cleanup(func() { conn.Close() })
+
+	s := &udpTestTracker{conn: conn, drop: drop, fail: fail}
+	go s.serve()
+	return s
+}
+
+func (s *udpTestTracker) url(path string) string {
+	return "udp://" + s.conn.LocalAddr().String() + path
+}
+
+// stats returns what the tracker has seen so far
+func (s *udpTestTracker) stats() (connects, announces int, urlData string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.connects, s.announces, s.urlData
+}
+
+// lastAnnounce returns the last announce the tracker got
+func (s *udpTestTracker) lastAnnounce() []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.announce
+}
+
+func (s *udpTestTracker) serve() {
+
+	buf := make([]byte, 2048)
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if n < 16 {
+			continue
+		}
+		packet := buf[:n]
+		connID := binary.BigEndian.Uint64(packet[0:8])
+		action := binary.BigEndian.Uint32(packet[8:12])
+
+		s.mu.Lock()
+		if s.drop > 0 {
+			s.drop--
+			s.mu.Unlock()
+			continue
+		}
+
+		reply := binary.BigEndian.AppendUint32(nil, action)
+		reply = append(reply, packet[12:16]...) // transaction ID
+		switch {
+		case action == actionConnect && connID == udpProtocolID:
+			s.connects++
+			reply = binary.BigEndian.AppendUint64(reply, testConnID)
+		case connID != testConnID:
+			reply = udpErrorReply(packet, "bad connection ID")
+		case action == actionAnnounce && s.fail != "":
+			reply = udpErrorReply(packet, s.fail)
+		case action == actionAnnounce && n >= 98:
+			s.announces++
+			s.urlData = parseURLData(packet[98:])
+			s.announce = append([]byte(nil), packet...)
+			reply = binary.BigEndian.AppendUint32(reply, 1800) // interval
+			reply = binary.BigEndian.AppendUint32(reply, 2)    // leechers
+			reply = binary.BigEndian.AppendUint32(reply, 3)    // seeders
+			if s.conn.LocalAddr().(*net.UDPAddr).IP.To4() == nil {
+				reply = append(reply, net.IPv6loopback...)
+			} else {
+				reply = append(reply, 127, 0, 0, 1)
+			}
+			reply = append(reply, 0x1a, 0xe1)
+		case action == actionScrape:
+			for i := 16; i+20 <= n; i += 20 {
+				reply = binary.BigEndian.AppendUint32(reply, uint32(packet[i])) // seeders
+				reply = binary.BigEndian.AppendUint32(reply, 10)                // completed
+				reply = binary.BigEndian.AppendUint32(reply, 1)                 // leechers
+			}
+		default:
+			reply = udpErrorReply(packet, "bad request")
+		}
+		s.mu.Unlock()
+
+		s.conn.WriteTo(reply, addr)
+	}
+}
+
+func udpErrorReply(packet []byte, msg string) []byte {
+	reply := binary.BigEndian.AppendUint32(nil, actionError)
+	reply = append(reply, packet[12:16]...)
+	return append(reply, msg...)
+}
+
+// parseURLData puts the URLData options back together, the way a tracker
+// would
+func parseURLData(options []byte) string {
+	var urlData string
+	for len(options) > 0 {
+		switch options[0] {
+		case 0x0: // end of options
+			return urlData
+		case 0x1: // no-op
+			options = options[1:]
+		case 0x2:
+			if len(options) < 2 || len(options) < 2+int(options[1]) {
+				return urlData
+			}
+			end := 2 + int(options[1])
+			urlData += string(options[2:end])
+			options = options[end:]
+		default:
+			return urlData
+		}
+	}
+	return urlData
+}
+
+// newFastUDPTracker returns the tracker for announceURL, with timeouts short
+// enough for tests
+func newFastUDPTracker(t *testing.T, announceURL string) *udpTracker {
+	tr, err := (&Client{}).Tracker(announceURL) // with a cache of its own
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ut, ok := tr.(*udpTracker)
+	if !ok {
+		t.Fatalf("expected a UDP tracker for %s, got %T", announceURL, tr)
+	}
+	ut.baseTimeout = 50 * time.Millisecond
+	return ut
+}
+
+func TestUDPAnnounce(t *testing.T) {
+	srv := newUDPTestTracker(t, 0, "")
+	tr := newFastUDPTracker(t, srv.url("/announce?passkey=abc"))
+
+	resp, err := tr.Announce(context.Background(), AnnounceRequest{InfoHash: [20]byte{1}, Port: 6881, Left: 1000})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := &AnnounceResponse{
+		Interval: 30 * time.Minute,
+		Seeders:  3,
+		Leechers: 2,
+		Peers:    []netip.AddrPort{netip.MustParseAddrPort("127.0.0.1:6881")},
+	}
+	if !reflect.DeepEqual(resp, expected) {
+		t.Errorf("expected %+v, got %+v", expected, resp)
+	}
+	if _, _, urlData := srv.stats(); urlData != "/announce?passkey=abc" {
+		t.Errorf("expected the URL path and query to be sent along, got %q", urlData)
+	}
+}
+
+func TestUDPAnnounceIPv6(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "[::1]:0")
+	if err != nil {
+		t.Skipf("no IPv6 loopback: %v", err)
+	}
+	srv := startUDPTestTracker(t, conn, 0, "")
+	tr := newFastUDPTracker(t, srv.url("/announce"))
+
+	resp, err := tr.Announce(context.Background(), AnnounceRequest{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := []netip.AddrPort{netip.MustParseAddrPort("[::1]:6881")}; !reflect.DeepEqual(resp.Peers, expected) {
+		t.Errorf("expected %v, got %v", expected, resp.Peers)
+	}
+}
+
+func TestUDPConnectionIDCached(t *testing.T) {
+	srv := newUDPTestTracker(t, 0, "")
+	tr := newFastUDPTracker(t, srv.url("/announce"))
+
+	for i := 0; i < 3; i++ {
+		if _, err := tr.Announce(context.Background(), AnnounceRequest{}); err != nil {
+			t.Fatalf("announce %d: unexpected error: %v", i, err)
+		}
+	}
+	if connects, announces, _ := srv.stats(); connects != 1 || announces != 3 {
+		t.Errorf("expected 1 connect and 3 announces, got %d and %d", connects, announces)
+	}
+
+	// an expired connection ID makes for a new connect
+	tr.client.connIDs.mu.Lock()
+	tr.client.connIDs.entries[tr.host] = connIDEntry{id: testConnID, expires: time.Now().Add(-time.Second)}
+	tr.client.connIDs.mu.Unlock()
+	if _, err := tr.Announce(context.Background(), AnnounceRequest{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if connects, _, _ := srv.stats(); connects != 2 {
+		t.Errorf("expected a new connect once the ID expired, got %d connects", connects)
+	}
+}
+
+func TestUDPConnectionIDPerClient(t *testing.T) {
+	srv := newUDPTestTracker(t, 0, "")
+
+	for i := 0; i < 2; i++ {
+		tr := newFastUDPTracker(t, srv.url("/announce"))
+		if _, err := tr.Announce(context.Background(), AnnounceRequest{}); err != nil {
+			t.Fatalf("announce %d: unexpected error: %v", i, err)
+		}
+	}
+	if connects, _, _ := srv.stats(); connects != 2 {
+		t.Errorf("expected each client to connect on its own, got %d connects", connects)
+	}
+}
+
+func TestUDPRetransmit(t *testing.T) {
+	srv := newUDPTestTracker(t, 2, "") // drops the first connect and the first announce
+	tr := newFastUDPTracker(t, srv.url(""))
+
+	start := time.Now()
+	resp, err := tr.Announce(context.Background(), AnnounceRequest{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(resp.Peers) != 1 {
+		t.Errorf("expected 1 peer, got %v", resp.Peers)
+	}
+	// 50ms for the first try, then 100ms for the second, where the connect
+	// went through but the announce didn't
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("expected the timeout to double between tries, took only %v", elapsed)
+	}
+}
+
+func TestUDPGivesUp(t *testing.T) {
+	srv := newUDPTestTracker(t, 100, "")
+	tr := newFastUDPTracker(t, srv.url(""))
+	tr.maxRetries = 2
+
+	_, err := tr.Announce(context.Background(), AnnounceRequest{})
+	if err == nil || !strings.Contains(err.Error(), "after 3 tries") {
+		t.Errorf("expected the tracker to be given up on, got %v", err)
+	}
+}
+
+func TestUDPGivesUpAfterTimeout(t *testing.T) {
+	srv := newUDPTestTracker(t, 100, "")
+	tr, err := (&Client{Timeout: 120 * time.Millisecond}).Tracker(srv.url(""))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tr.(*udpTracker).baseTimeout = 50 * time.Millisecond
+
+	// 50ms for the first try, and what's left of the 120ms for the second
+	start := time.Now()
+	_, err = tr.Announce(context.Background(), AnnounceRequest{})
+	if err == nil || !strings.Contains(err.Error(), "after 2 tries") {
+		t.Errorf("expected the tracker to be given up on, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected the timeout to bound the retries, took %v", elapsed)
+	}
+}
+
+func TestUDPTrackerError(t *testing.T) {
+	srv := newUDPTestTracker(t, 0, "torrent not registered")
+	tr := newFastUDPTracker(t, srv.url(""))
+
+	_, err := tr.Announce(context.Background(), AnnounceRequest{})
+	if err == nil || !strings.Contains(err.Error(), "torrent not registered") {
+		t.Errorf("expected the tracker's error, got %v", err)
+	}
+	if _, ok := tr.client.connIDs.get(tr.host); ok {
+		t.Error("expected the connection ID to be forgotten after an error")
+	}
+}
+
+func TestUDPScrape(t *testing.T) {
+	srv := newUDPTestTracker(t, 0, "")
+	tr := newFastUDPTracker(t, srv.url(""))
+
+	stats, err := tr.Scrape(context.Background(), [][20]byte{{4}, {7}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []ScrapeStats{{Seeders: 4, Completed: 10, Leechers: 1}, {Seeders: 7, Completed: 10, Leechers: 1}}
+	if !reflect.DeepEqual(stats, expected) {
+		t.Errorf("expected %+v, got %+v", expected, stats)
+	}
+
+	if _, err := tr.Scrape(context.Background(), nil); err == nil {
+		t.Error("expected error when scraping nothing")
+	}
+
+	// too many for one packet, so it takes two
+	many := make([][20]byte, maxScrapeHashes+2)
+	many[maxScrapeHashes+1][0] = 9
+	stats, err = tr.Scrape(context.Background(), many)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(stats) != len(many) || stats[maxScrapeHashes+1].Seeders != 9 {
+		t.Errorf("expected stats for all %d torrents, got %+v", len(many), stats)
+	}
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		url      string
+		expected string
+		hasError bool
+	}{
+		{"http://tracker.example.com/announce", "*tracker.httpTracker", false},
+		{"https://tracker.example.com/announce", "*tracker.httpTracker", false},
+		{"udp://tracker.example.com:1337/announce", "*tracker.udpTracker", false},
+		{"udp://tracker.example.com", "", true},
+		{"wss://tracker.example.com", "", true},
+		{"://", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			tr, err := New(tt.url)
+			if tt.hasError {
+				if err == nil {
+					t.Error("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := reflect.TypeOf(tr).String(); got != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestURLDataOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		urlData string
+		length  int
+	}{
+		{"none", "", 0},
+		{"short", "/announce", 2 + 9},
+		{"split", "/" + strings.Repeat("a", 300), 2 + 255 + 2 + 46},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := urlDataOptions(tt.urlData)
+			if len(options) != tt.length {
+				t.Errorf("expected %d bytes of options, got %d", tt.length, len(options))
+			}
+			if got := parseURLData(options); got != tt.urlData {
+				t.Errorf("expected %q back, got %q", tt.urlData, got)
+			}
+		})
+	}
+}
+
+func TestTierListFallsBackFromUDP(t *testing.T) {
+	srv := newUDPTestTracker(t, 0, "go away")
+	var hits int
+	good := newTestTracker(t, false, &hits)
+
+	info := &torrent.TorrentInfo{AnnounceList: [][]string{{srv.url("/announce")}, {good}}}
+	peers, err := NewTierList(info).GetPeers(info, [20]byte{}, 6881)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(peers, []netip.AddrPort{netip.MustParseAddrPort("127.0.0.1:6881")}) || hits != 1 {
+		t.Errorf("expected the HTTP tracker to answer after the UDP one failed, got %v", peers)
+	}
+}